@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	gourl "net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// accessLogFormats match the lines of the access log formats -replay
+// reads, as written by nginx and Apache: the time, the request line and,
+// in the combined format, the referer and the user agent.
+var accessLogFormats = map[string]*regexp.Regexp{
+	"common":   regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "([A-Z]+) (\S+)[^"]*" \d{3} \S+`),
+	"combined": regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "([A-Z]+) (\S+)[^"]*" \d{3} \S+ "([^"]*)" "([^"]*)"`),
+}
+
+// accessLogTime is the layout of the times of access logs.
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogEntry is a request read from an access log.
+type accessLogEntry struct {
+	time      time.Time
+	method    string
+	uri       string
+	referer   string
+	userAgent string
+}
+
+// readAccessLog reads the requests of the access log name in the given
+// format, ordered by time. It also returns the number of lines it
+// skipped as they were not requests in the format.
+func readAccessLog(name, format string) ([]accessLogEntry, int, error) {
+	re, ok := accessLogFormats[format]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown format %q, want combined or common", format)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var entries []accessLogEntry
+	skipped := 0
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		m := re.FindStringSubmatch(s.Text())
+		if m == nil {
+			skipped++
+			continue
+		}
+		t, err := time.Parse(accessLogTime, m[1])
+		if err != nil || !strings.HasPrefix(m[3], "/") {
+			skipped++
+			continue
+		}
+		e := accessLogEntry{time: t, method: m[2], uri: m[3]}
+		if len(m) > 5 {
+			e.referer, e.userAgent = m[4], m[5]
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(entries) == 0 {
+		return nil, 0, fmt.Errorf("%s has no requests in the %s format", name, format)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+	return entries, skipped, nil
+}
+
+// replayGroups returns the requests of entries against the base url of
+// req, with the other fields of req and body, and the offsets from the
+// start of the run to start them at, the logged ones divided by speed.
+// The logged referer and user agent are sent along.
+func replayGroups(req *http.Request, body []byte, entries []accessLogEntry, speed float64) ([]*requester.RequestGroup, []time.Duration, error) {
+	groups := make([]*requester.RequestGroup, 0, len(entries))
+	schedule := make([]time.Duration, 0, len(entries))
+	for _, e := range entries {
+		uri, err := gourl.ParseRequestURI(e.uri)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", e.uri, err)
+		}
+		u := *req.URL
+		u.Path = strings.TrimSuffix(u.Path, "/") + uri.Path
+		u.RawPath = ""
+		if uri.RawPath != "" {
+			u.RawPath = strings.TrimSuffix(req.URL.EscapedPath(), "/") + uri.RawPath
+		}
+		u.RawQuery = uri.RawQuery
+		r := *req
+		r.URL = &u
+		r.Method = e.method
+		r.Header = req.Header.Clone()
+		if e.userAgent != "" && e.userAgent != "-" {
+			r.Header.Set("User-Agent", e.userAgent)
+		}
+		if e.referer != "" && e.referer != "-" {
+			r.Header.Set("Referer", e.referer)
+		}
+		groups = append(groups, &requester.RequestGroup{Request: &r, RequestBody: body})
+		schedule = append(schedule, time.Duration(float64(e.time.Sub(entries[0].time))/speed))
+	}
+	return groups, schedule, nil
+}
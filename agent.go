@@ -0,0 +1,581 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const agentUsage = `Usage: hey agent [options...]
+
+Runs an agent of a distributed run: it makes the runs a "hey controller"
+sends it and returns their results to the controller, which merges the
+results of all its agents into a single report.
+
+Options:
+  -addr    Address to listen on. Default is :7070.
+  -token   Secret the controller must send along, see -token of hey
+           controller. Required unless -addr is a loopback address.
+  -region  Region of the agent, e.g. eu-west-1. The merged report of
+           the controller reports the latencies of every region.
+
+Runs may only set the options of the requests and of the load, e.g. -m,
+-H, -d, -c, -n or -z, none of which reads files or secrets on the agent
+machine; other options, and @file:, @env: and @vault: references to
+secrets, are refused. The rows of -data are sent along with the run
+instead.
+
+Schedulers other than hey controller drive an agent the same way: a
+POST to /run with a JSON body {"args": [<flags and urls>]}, and an
+"Authorization: Bearer <token>" header if -token is set, makes the run
+and responds with its record file, see hey report -h. The body may set
+"data" to the CSV of the -data of the run, its header and rows. A GET
+of /health responds with the state and the capacity of the agent as
+JSON: whether it is busy with a run, its region, number of CPUs, load
+average and limit on open files. A GET of /stats streams the
+progress of the runs, a line of JSON every second, see -progress-json
+of hey, until the request is closed. A POST to /stop stops the run, which
+responds with the results until then, and a POST to /adjust with a JSON
+body {"q": <rate>, "c": <workers>, "headers": ["name: value", ...]}
+changes the -q, -c or -H of the run while it runs, as hey does on SIGHUP
+with -config; runs that hey cannot adjust, e.g. with -rps, keep their
+load. Closing the request of a run also stops the run, but without its
+results. The agent responds 400 to a run with options it refuses or of
+a "version" of the protocol other than 1, 401 to a wrong token, 409
+while it makes another run and to /stop and /adjust while it makes
+none, and 501 to /stop and /adjust if the system cannot signal the run.
+`
+
+// agentPath is the path of the agent a controller POSTs runs to.
+const agentPath = "/run"
+
+// agentHealthPath is the path of the health of an agent, see
+// agentHealth.
+const agentHealthPath = "/health"
+
+// agentStatsPath is the path of the stream of the progress of the runs
+// of an agent.
+const agentStatsPath = "/stats"
+
+// agentStopPath and agentAdjustPath are the paths a controller POSTs to
+// to stop the run of an agent and to change its load, see agentAdjust.
+const (
+	agentStopPath   = "/stop"
+	agentAdjustPath = "/adjust"
+)
+
+// agentMethods are the methods of the paths of an agent.
+var agentMethods = map[string]string{
+	agentPath:       "POST",
+	agentHealthPath: "GET",
+	agentStatsPath:  "GET",
+	agentStopPath:   "POST",
+	agentAdjustPath: "POST",
+}
+
+// agentProtocol is the version of the protocol between a controller and
+// its agents. Runs of another version are refused.
+const agentProtocol = 1
+
+// agentRun is the request of a controller to run hey.
+type agentRun struct {
+	// Version is the version of the protocol of the run, 1 if unset.
+	Version int `json:"version,omitempty"`
+
+	// Args are the flags and urls of the run.
+	Args []string `json:"args"`
+
+	// Data, if set, is the CSV of the -data of the run: the header and
+	// the rows of the agent.
+	Data string `json:"data,omitempty"`
+}
+
+// agentHealth is the state and the capacity of an agent.
+type agentHealth struct {
+	Protocol int    `json:"protocol"`
+	Busy     bool   `json:"busy"`
+	Region   string `json:"region,omitempty"`
+	CPUs     int    `json:"cpus"`
+
+	// Load is the load average of the last minute, if known.
+	Load float64 `json:"load,omitempty"`
+
+	// FileLimit is the limit on open files of the runs, zero if
+	// unknown.
+	FileLimit uint64 `json:"file_limit,omitempty"`
+}
+
+// agentAllowed are the flags a controller may set: those of the requests
+// and of the load. None of them reads files or secrets on the agent
+// machine, runs commands or writes files there, or sends anything but
+// the requests of the run.
+var agentAllowed = map[string]bool{
+	"m": true, "d": true, "A": true, "T": true, "a": true, "H": true,
+	"host": true, "x": true, "rw-ratio": true, "think": true,
+	"no-cache": true, "assert-status": true, "expect-header": true,
+	"assert-body-contains": true, "assert-body-regex": true,
+	"raw-headers": true, "c": true, "n": true, "q": true, "rps": true,
+	"t": true, "z": true, "h2": true, "pipeline": true, "ws": true,
+	"cpus": true, "disable-compression": true, "verify-compression": true,
+	"disable-keepalive": true, "enable-cookies": true,
+	"emulate-network": true, "disable-redirects": true,
+	"disable-tls-resumption": true, "redirect-chain": true,
+	"respect-retry-after": true, "stream-stats": true, "stall": true,
+	"cb-failures": true, "cb-cooldown": true, "cancel-rate": true,
+	"cancel-after": true, "fuzz-rate": true, "dns-chaos": true,
+	"resolve": true, "k": true, "insecure": true, "servername": true,
+	"tls-info": true, "tls-expiry-warn": true, "tls-verify-timing": true,
+	"ech-config": true, "save-partial": true, "data-random": true,
+}
+
+// checkAgentArgs returns an error if args set a flag that is not in
+// agentAllowed or refer to a secret, see expandSecrets. Every argument
+// that looks like a flag is checked, even if it is the value of
+// another flag.
+func checkAgentArgs(args []string) error {
+	for _, arg := range args {
+		if secretRegexp.MatchString(arg) {
+			return fmt.Errorf("%q refers to a secret of the agent machine", arg)
+		}
+		if arg == "--" {
+			continue
+		}
+		if name := strings.TrimLeft(arg, "-"); name != arg {
+			if i := strings.Index(name, "="); i >= 0 {
+				name = name[:i]
+			}
+			if !agentAllowed[name] {
+				return fmt.Errorf("-%s cannot be set on an agent", name)
+			}
+		}
+	}
+	return nil
+}
+
+// agent is the handler of "hey agent". It makes one run at a time.
+type agent struct {
+	exe    string
+	token  string
+	region string
+
+	mu      sync.Mutex
+	running bool
+	// load is the load of the run, once it started.
+	load *agentLoad
+	// stats are the channels of the streams of /stats, see broadcast.
+	stats map[chan []byte]bool
+}
+
+// broadcast sends the progress line to every stream of /stats, but
+// drops it for a stream that falls behind rather than hold up the run.
+func (a *agent) broadcast(line []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ch := range a.stats {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// serveStats streams the progress of the runs to w until the request is
+// closed.
+func (a *agent) serveStats(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan []byte, 16)
+	a.mu.Lock()
+	if a.stats == nil {
+		a.stats = make(map[chan []byte]bool)
+	}
+	a.stats[ch] = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.stats, ch)
+		a.mu.Unlock()
+	}()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	f, _ := w.(http.Flusher)
+	if f != nil {
+		f.Flush()
+	}
+	for {
+		select {
+		case line := <-ch:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			if f != nil {
+				f.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// agentAdjust is the request of a controller to change the load of the
+// run of an agent. Unset fields keep their value.
+type agentAdjust struct {
+	Q       *float64 `json:"q,omitempty"`
+	C       int      `json:"c,omitempty"`
+	Headers []string `json:"headers,omitempty"`
+}
+
+// agentLoad is the -q, -c and -H of the run of an agent, which the run
+// reads from a -config file so that /adjust can change them.
+type agentLoad struct {
+	config  string
+	q       *float64
+	c       int
+	headers []string
+	proc    *os.Process
+}
+
+// splitLoad moves the -q, -c and -H of args to a new agentLoad. It
+// returns the remaining args.
+func splitLoad(args []string) (*agentLoad, []string, error) {
+	l := &agentLoad{}
+	v, args, ok := splitFlag(args, "q")
+	if ok {
+		q, err := strconv.ParseFloat(v, 64)
+		if err != nil || q < 0 {
+			return nil, nil, fmt.Errorf("invalid -q %q", v)
+		}
+		l.q = &q
+	}
+	if v, args, ok = splitFlag(args, "c"); ok {
+		c, err := strconv.Atoi(v)
+		if err != nil || c < 1 {
+			return nil, nil, fmt.Errorf("invalid -c %q", v)
+		}
+		l.c = c
+	}
+	for {
+		if v, args, ok = splitFlag(args, "H"); !ok {
+			break
+		}
+		l.headers = append(l.headers, v)
+	}
+	return l, args, nil
+}
+
+// adjust applies adj to l.
+func (l *agentLoad) adjust(adj agentAdjust) error {
+	if adj.Q != nil && *adj.Q < 0 {
+		return fmt.Errorf("invalid q %v", *adj.Q)
+	}
+	if adj.C < 0 {
+		return fmt.Errorf("invalid c %d", adj.C)
+	}
+	if err := checkAgentArgs(adj.Headers); err != nil {
+		return err
+	}
+	for _, h := range adj.Headers {
+		if _, err := parseInputWithRegexp(h, headerRegexp); err != nil {
+			return err
+		}
+	}
+	if adj.Q != nil {
+		l.q = adj.Q
+	}
+	if adj.C > 0 {
+		l.c = adj.C
+	}
+	if adj.Headers != nil {
+		l.headers = adj.Headers
+	}
+	return nil
+}
+
+// write writes the -config file of l.
+func (l *agentLoad) write() error {
+	var b bytes.Buffer
+	if l.q != nil {
+		fmt.Fprintf(&b, "q = %s\n", strconv.FormatFloat(*l.q, 'f', -1, 64))
+	}
+	if l.c > 0 {
+		fmt.Fprintf(&b, "c = %d\n", l.c)
+	}
+	if len(l.headers) > 0 {
+		quoted := make([]string, len(l.headers))
+		for i, h := range l.headers {
+			quoted[i] = strconv.Quote(h)
+		}
+		fmt.Fprintf(&b, "H = [%s]\n", strings.Join(quoted, ", "))
+	}
+	return ioutil.WriteFile(l.config, b.Bytes(), 0600)
+}
+
+func (a *agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := agentMethods[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case agentStatsPath:
+		a.serveStats(w, r)
+	case agentHealthPath:
+		a.serveHealth(w)
+	case agentStopPath, agentAdjustPath:
+		a.serveControl(w, r)
+	default:
+		a.serveRun(w, r)
+	}
+}
+
+// serveHealth responds with the agentHealth of a.
+func (a *agent) serveHealth(w http.ResponseWriter) {
+	a.mu.Lock()
+	h := agentHealth{Protocol: agentProtocol, Busy: a.running, Region: a.region, CPUs: runtime.NumCPU()}
+	a.mu.Unlock()
+	h.Load, _ = loadAverage()
+	// Runs raise their limit to the hard limit as needed.
+	h.FileLimit = fileLimit(math.MaxInt32)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// serveControl stops the run of a or changes its load.
+func (a *agent) serveControl(w http.ResponseWriter, r *http.Request) {
+	var adj agentAdjust
+	if r.URL.Path == agentAdjustPath {
+		if err := json.NewDecoder(r.Body).Decode(&adj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := a.load
+	if l == nil {
+		http.Error(w, "the agent makes no run", http.StatusConflict)
+		return
+	}
+	var err error
+	if r.URL.Path == agentStopPath {
+		// Like Ctrl-C, which ends the run with its results until then.
+		err = l.proc.Signal(os.Interrupt)
+	} else {
+		if err := l.adjust(adj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = l.write(); err == nil {
+			err = reloadProcess(l.proc)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	}
+}
+
+// serveRun makes the run of the request and responds with its record
+// file.
+func (a *agent) serveRun(w http.ResponseWriter, r *http.Request) {
+	var run agentRun
+	if err := json.NewDecoder(r.Body).Decode(&run); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if run.Version != 0 && run.Version != agentProtocol {
+		http.Error(w, fmt.Sprintf("protocol version %d is not supported, the agent speaks version %d", run.Version, agentProtocol), http.StatusBadRequest)
+		return
+	}
+	if err := checkAgentArgs(run.Args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	load, runArgs, err := splitLoad(run.Args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		http.Error(w, "the agent is busy with another run", http.StatusConflict)
+		return
+	}
+	a.running = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.running = false
+		a.load = nil
+		a.mu.Unlock()
+	}()
+
+	f, err := ioutil.TempFile("", "hey-agent")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	config, err := ioutil.TempFile("", "hey-agent-config*.toml")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	config.Close()
+	defer os.Remove(config.Name())
+	load.config = config.Name()
+	if err := load.write(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	args := append([]string{"-progress-json", "-record", f.Name(), "-config", load.config}, runArgs...)
+	if a.region != "" {
+		args = append([]string{"-region", a.region}, args...)
+	}
+	if run.Data != "" {
+		data, err := ioutil.TempFile("", "hey-agent-data")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(data.Name())
+		_, err = io.WriteString(data, run.Data)
+		if cerr := data.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		args = append([]string{"-data", data.Name()}, args...)
+	}
+	// The run stops when the controller goes away.
+	cmd := exec.CommandContext(r.Context(), a.exe, args...)
+	pipe, err := cmd.StderrPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	load.proc = cmd.Process
+	// The progress lines of the run go to the streams of /stats, the
+	// rest of its stderr to the error of a failed run.
+	var stderr bytes.Buffer
+	sc := bufio.NewScanner(pipe)
+	for sc.Scan() {
+		if line := sc.Bytes(); bytes.HasPrefix(line, []byte("{")) {
+			// Once the run prints its progress, it handles the signals
+			// of /stop and /adjust.
+			a.mu.Lock()
+			a.load = load
+			a.mu.Unlock()
+			a.broadcast(append(append([]byte{}, line...), '\n'))
+		} else {
+			stderr.Write(line)
+			stderr.WriteByte('\n')
+		}
+	}
+	// Drain the rest if a line is too long to scan.
+	io.Copy(&stderr, pipe)
+	// Thresholds are checked by the controller on the merged results,
+	// so any exit status but 0 is an error.
+	if err := cmd.Wait(); err != nil {
+		http.Error(w, fmt.Sprintf("%v: %s", err, strings.TrimSpace(stderr.String())), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, f)
+}
+
+// agentMain implements the "hey agent" command.
+func agentMain(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, agentUsage)
+	}
+	addr := fs.String("addr", ":7070", "")
+	token := fs.String("token", "", "")
+	region := fs.String("region", "", "")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *token == "" && !isLoopback(*addr) {
+		errAndExit("-token is required unless -addr is a loopback address.")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "Waiting for runs on %s...\n", *addr)
+	errAndExit(http.ListenAndServe(*addr, &agent{exe: exe, token: *token, region: *region}).Error())
+}
+
+// loadAverage returns the load average of the last minute, if the
+// system reports it in /proc/loadavg.
+func loadAverage() (float64, bool) {
+	b, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	f := strings.Fields(string(b))
+	if len(f) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(f[0], 64)
+	return load, err == nil
+}
+
+// isLoopback reports whether addr, a "host:port", only listens on a
+// loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
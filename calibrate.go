@@ -0,0 +1,178 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+const calibrateUsage = `Usage: hey calibrate [options...]
+
+Load tests a built-in null HTTP server on the loopback interface to
+measure the maximum request rate and the latency floor of this machine.
+The result is saved, and later runs warn when they come close to it,
+because their numbers are then limited by the client, not the server.
+
+Options:
+  -c  Number of requests to run concurrently. Default is 50.
+  -z  Duration of the calibration run. Default is 5s.
+`
+
+// calibration is the capacity of the machine as measured by
+// "hey calibrate".
+type calibration struct {
+	Time       time.Time `json:"time"`
+	C          int       `json:"c"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+
+	// Rps is the maximum attainable number of requests per second.
+	Rps float64 `json:"rps"`
+
+	// Fastest and Average are the latency floor, in seconds.
+	Fastest float64 `json:"fastest"`
+	Average float64 `json:"average"`
+}
+
+// clientLimitedRatio is the fraction of the calibrated rate above which
+// a run is considered limited by the client.
+const clientLimitedRatio = 0.8
+
+func calibrationPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hey", "calibration.json"), nil
+}
+
+// loadCalibration returns the saved calibration, or nil if there is none.
+func loadCalibration() *calibration {
+	path, err := calibrationPath()
+	if err != nil {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cal calibration
+	if err := json.Unmarshal(b, &cal); err != nil || cal.Rps <= 0 {
+		return nil
+	}
+	return &cal
+}
+
+func (cal *calibration) save() (string, error) {
+	path, err := calibrationPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(cal, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// clientLimited reports whether a run that achieved rps came close to
+// the calibrated capacity of the machine.
+func (cal *calibration) clientLimited(rps float64) bool {
+	return rps >= cal.Rps*clientLimitedRatio
+}
+
+// calibrateMain implements the "hey calibrate" command.
+func calibrateMain(args []string) {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, calibrateUsage)
+	}
+	conc := fs.Int("c", 50, "")
+	dur := fs.Duration("z", 5*time.Second, "")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *conc <= 0 || *dur <= 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Calibrating for %v with %d workers...\n", *dur, *conc)
+	cal, err := calibrate(*conc, *dur)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	fmt.Printf("\nCalibration:\n")
+	fmt.Printf("  Requests/sec:\t%4.4f\n", cal.Rps)
+	fmt.Printf("  Fastest:\t%4.4f ms\n", cal.Fastest*1000)
+	fmt.Printf("  Average:\t%4.4f ms\n", cal.Average*1000)
+	path, err := cal.save()
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	fmt.Printf("\nSaved to %s.\n", path)
+}
+
+// calibrate load tests a null HTTP server on the loopback interface
+// with conc workers for dur and returns the measured capacity.
+func calibrate(conc int, dur time.Duration) (*calibration, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req, err := http.NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", heyUA)
+	w := &requester.Work{
+		Request: req,
+		N:       math.MaxInt32,
+		C:       conc,
+		Timeout: 20,
+		Writer:  ioutil.Discard,
+	}
+	w.Init()
+	go func() {
+		time.Sleep(dur)
+		w.Stop()
+	}()
+	w.Run()
+
+	rep := w.Report()
+	return &calibration{
+		Time:       time.Now(),
+		C:          conc,
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Rps:        rep.Rps,
+		Fastest:    rep.Fastest,
+		Average:    rep.Average,
+	}, nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	gourl "net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const cleanupUsage = `Usage: hey cleanup [options...] <file>
+
+Deletes the resources created by a run, listed one URL per line in the
+file written by -cleanup-file.
+
+Options:
+  -c  Number of DELETE requests to run concurrently. Default is 10.
+  -t  Timeout for each request in seconds. Default is 20.
+  -H  Custom HTTP header, e.g. for authorization. You can specify as
+      many as needed by repeating the flag.
+  -k  Do not verify the server certificates.
+`
+
+// scenarioExtract extracts a value, e.g. the identifier of the resource
+// a request created, from its response: from a header, from a field of
+// a JSON body given as a dotted path, e.g. "data.id" or "items.0.id",
+// optionally prefixed with "$." like a JSONPath, or from the body with
+// a regular expression, whose first group is the value if it has one.
+type scenarioExtract struct {
+	Header string `json:"header"`
+	JSON   string `json:"json"`
+	Regex  string `json:"regex"`
+
+	// re is Regex compiled by compile.
+	re *regexp.Regexp
+}
+
+// sources returns the number of places e extracts from.
+func (e *scenarioExtract) sources() int {
+	n := 0
+	for _, s := range []string{e.Header, e.JSON, e.Regex} {
+		if s != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// compile compiles the regular expression of e, if any. It must be
+// called before value.
+func (e *scenarioExtract) compile() error {
+	if e.Regex == "" {
+		return nil
+	}
+	var err error
+	e.re, err = regexp.Compile(e.Regex)
+	return err
+}
+
+// value returns the value extracted from res, if any.
+func (e *scenarioExtract) value(res *http.Response, body []byte) (string, bool) {
+	if e.Header != "" {
+		v := res.Header.Get(e.Header)
+		return v, v != ""
+	}
+	if e.re != nil {
+		m := e.re.FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		v := m[0]
+		if len(m) > 1 {
+			v = m[1]
+		}
+		return string(v), len(v) > 0
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return "", false
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(e.JSON, "$"), ".")
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return "", false
+			}
+			v = t[i]
+		default:
+			return "", false
+		}
+	}
+	switch t := v.(type) {
+	case string:
+		return t, t != ""
+	case json.Number:
+		return t.String(), true
+	}
+	return "", false
+}
+
+// cleanupURL returns the URL to delete the resource with the identifier
+// v, created by a request to reqURL. The "{id}" placeholder of
+// cleanup_url is replaced by v. Without cleanup_url, v itself is the
+// URL, e.g. a Location header, resolved relative to reqURL.
+func (g *scenarioGroup) cleanupURL(reqURL *gourl.URL, v string) (string, error) {
+	if g.CleanupURL != "" {
+		return strings.Replace(g.CleanupURL, "{id}", gourl.PathEscape(v), -1), nil
+	}
+	u, err := reqURL.Parse(v)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// cleanupWriter writes the URLs of created resources to a file, one per
+// line. It is safe for concurrent use.
+type cleanupWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// extractor returns the Extract function of the request group g with
+// the request URL reqURL.
+func (cw *cleanupWriter) extractor(g scenarioGroup, reqURL *gourl.URL) func(*http.Response, []byte) {
+	return func(res *http.Response, body []byte) {
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return
+		}
+		v, ok := g.Extract.value(res, body)
+		if !ok {
+			return
+		}
+		u, err := g.cleanupURL(reqURL, v)
+		cw.mu.Lock()
+		defer cw.mu.Unlock()
+		if cw.err != nil {
+			return
+		}
+		if err == nil {
+			_, err = fmt.Fprintln(cw.w, u)
+		}
+		cw.err = err
+	}
+}
+
+func cleanupMain(args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, cleanupUsage)
+	}
+	conc := fs.Int("c", 10, "")
+	timeout := fs.Int("t", 20, "")
+	insecure := fs.Bool("k", false, "")
+	var hs headerSlice
+	fs.Var(&hs, "H", "")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *conc < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	header := make(http.Header)
+	for _, h := range hs {
+		match, err := parseInputWithRegexp(h, headerRegexp)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		v, err := expandSecrets(match[2])
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		header.Set(match[1], v)
+	}
+	urls, err := readLines(fs.Arg(0))
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	client := &http.Client{
+		Timeout: time.Duration(*timeout) * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+		},
+	}
+	failed := cleanup(client, urls, header, *conc, os.Stderr)
+	fmt.Printf("Deleted %d of %d resources.\n", len(urls)-failed, len(urls))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// cleanup deletes urls with c concurrent requests and returns the
+// number of failed deletions, which are reported to errw. A resource
+// that is already gone counts as deleted.
+func cleanup(client *http.Client, urls []string, header http.Header, c int, errw io.Writer) int {
+	var failed int64
+	var mu sync.Mutex
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < c; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range ch {
+				err := deleteResource(client, u, header)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					mu.Lock()
+					fmt.Fprintf(errw, "%s: %v\n", u, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, u := range urls {
+		ch <- u
+	}
+	close(ch)
+	wg.Wait()
+	return int(failed)
+}
+
+func deleteResource(client *http.Client, url string, header http.Header) error {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if (res.StatusCode < 200 || res.StatusCode > 299) && res.StatusCode != http.StatusNotFound && res.StatusCode != http.StatusGone {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
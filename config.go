@@ -0,0 +1,308 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// configOption is an option set in a -config file.
+type configOption struct {
+	line   int
+	name   string
+	values []string
+
+	// list is set for a YAML option whose values follow as a list.
+	list bool
+}
+
+// readConfig reads the options of a -config file. Files ending in .yaml
+// or .yml are read as YAML, all others as TOML. Both are read as a flat
+// list of options whose values are strings, numbers, booleans or lists
+// of them; TOML tables and nested YAML mappings are not supported.
+func readConfig(name string) ([]configOption, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	yaml := isYAML(name)
+	sep := "="
+	if yaml {
+		sep = ":"
+	}
+	lines := strings.Split(string(b), "\n")
+	var opts []configOption
+	for i := 0; i < len(lines); i++ {
+		l := strings.TrimSpace(stripConfigComment(lines[i]))
+		errorf := func(format string, v ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, i+1, fmt.Sprintf(format, v...))
+		}
+		switch {
+		case l == "" || yaml && l == "---":
+			continue
+		case yaml && strings.HasPrefix(l, "-"):
+			// An item of the list of the previous option.
+			if len(opts) == 0 || !opts[len(opts)-1].list {
+				return nil, errorf("list item without an option")
+			}
+			v, err := parseConfigScalar(strings.TrimSpace(l[1:]))
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			o := &opts[len(opts)-1]
+			o.values = append(o.values, v)
+			continue
+		case !yaml && strings.HasPrefix(l, "["):
+			return nil, errorf("tables are not supported")
+		}
+		i0 := i
+		k := strings.Index(l, sep)
+		if k < 0 {
+			return nil, errorf("want option %s value; got %q", sep, l)
+		}
+		key, value := strings.TrimSpace(l[:k]), strings.TrimSpace(l[k+1:])
+		if key, err = parseConfigScalar(key); err != nil || key == "" {
+			return nil, errorf("invalid option name %q", l[:k])
+		}
+		// TOML lists may span lines.
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripConfigComment(lines[i]))
+		}
+		o := configOption{line: i0 + 1, name: strings.TrimLeft(key, "-")}
+		if value == "" && yaml {
+			o.list = true
+		} else if o.values, err = parseConfigValue(value); err != nil {
+			return nil, errorf("%s: %v", o.name, err)
+		}
+		opts = append(opts, o)
+	}
+	for _, o := range opts {
+		if len(o.values) == 0 && !o.list {
+			return nil, fmt.Errorf("%s:%d: %s has no value", name, o.line, o.name)
+		}
+	}
+	return opts, nil
+}
+
+// isYAML reports whether the file name is read as YAML.
+func isYAML(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// stripConfigComment removes a comment, starting with a # at the start of
+// l or after a space and outside quotes, from l.
+func stripConfigComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		switch c := l[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// parseConfigValue parses a scalar or a [list, of, scalars].
+func parseConfigValue(v string) ([]string, error) {
+	if v == "" {
+		return nil, fmt.Errorf("no value")
+	}
+	if !strings.HasPrefix(v, "[") {
+		s, err := parseConfigScalar(v)
+		return []string{s}, err
+	}
+	items, err := splitConfigList(v)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		if items[i], err = parseConfigScalar(item); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// splitConfigList splits a [list, of, scalars] into its items, which are
+// left quoted.
+func splitConfigList(v string) ([]string, error) {
+	if !strings.HasSuffix(v, "]") {
+		return nil, fmt.Errorf("unterminated list %s", v)
+	}
+	var items []string
+	var quote byte
+	start := 1
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		if quote != 0 {
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',' || i == len(v)-1:
+			item := strings.TrimSpace(v[start:i])
+			start = i + 1
+			if item == "" {
+				// A trailing comma or an empty list.
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string in %s", v)
+	}
+	return items, nil
+}
+
+// parseConfigScalar unquotes a "basic" or 'literal' string; other scalars,
+// e.g. numbers, booleans or plain YAML strings, are returned as they are.
+func parseConfigScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	return s, nil
+}
+
+// applyConfig sets the flags of fs to the options of the -config file
+// name, except the flags set on the command line, which override the
+// file. It returns the urls the file lists as urls.
+func applyConfig(fs *flag.FlagSet, name string) ([]string, error) {
+	opts, err := readConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	seen := make(map[string]bool)
+	var urls []string
+	for _, o := range opts {
+		if seen[o.name] {
+			return nil, fmt.Errorf("%s:%d: %s is set more than once", name, o.line, o.name)
+		}
+		seen[o.name] = true
+		if o.name == "urls" || o.name == "url" {
+			urls = append(urls, o.values...)
+			continue
+		}
+		f := fs.Lookup(o.name)
+		if f == nil || o.name == "config" {
+			return nil, fmt.Errorf("%s:%d: unknown option %s", name, o.line, o.name)
+		}
+		if set[o.name] {
+			continue
+		}
+		if _, ok := f.Value.(*headerSlice); !ok && len(o.values) != 1 {
+			return nil, fmt.Errorf("%s:%d: %s takes a single value", name, o.line, o.name)
+		}
+		for _, v := range o.values {
+			if err := fs.Set(o.name, v); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid value %q for %s: %v", name, o.line, v, o.name, err)
+			}
+		}
+	}
+	return urls, nil
+}
+
+// loadAdjustment reads the options of the -config file name a running
+// load is adjusted to on SIGHUP: q, c and H, see requester.Adjustment.
+// The options in set, given on the command line, keep their value, as
+// do the options the file does not set. The headers of prev, the
+// headers of the file before, that it no longer sets are removed.
+func loadAdjustment(name string, set map[string]bool, prev http.Header) (requester.Adjustment, error) {
+	var a requester.Adjustment
+	opts, err := readConfig(name)
+	if err != nil {
+		return a, err
+	}
+	for _, o := range opts {
+		if set[o.name] {
+			continue
+		}
+		errorf := func(format string, v ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, o.line, fmt.Sprintf(format, v...))
+		}
+		switch o.name {
+		case "q":
+			q, err := strconv.ParseFloat(o.values[0], 64)
+			if err != nil || q < 0 || len(o.values) != 1 {
+				return a, errorf("invalid value %q for q", strings.Join(o.values, ", "))
+			}
+			a.QPS = &q
+		case "c":
+			c, err := strconv.Atoi(o.values[0])
+			if err != nil || c < 1 || len(o.values) != 1 {
+				return a, errorf("invalid value %q for c", strings.Join(o.values, ", "))
+			}
+			a.C = c
+		case "H":
+			a.Header = make(http.Header)
+			for _, h := range o.values {
+				match, err := parseInputWithRegexp(h, headerRegexp)
+				if err != nil {
+					return a, errorf("%v", err)
+				}
+				v, err := expandSecrets(match[2])
+				if err != nil {
+					return a, errorf("%v", err)
+				}
+				a.Header.Set(match[1], v)
+			}
+		}
+	}
+	if len(prev) > 0 && !set["H"] {
+		if a.Header == nil {
+			a.Header = make(http.Header)
+		}
+		for k := range prev {
+			if _, ok := a.Header[k]; !ok {
+				a.Header[k] = nil
+			}
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,610 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+	"github.com/rakyll/hey/requester/report"
+)
+
+const controllerUsage = `Usage: hey controller -agents <host:port,...> [options...] <url> [<url>...]
+
+Fans a run out to the "hey agent"s at the given addresses and merges
+their results into a single report, as if a single hey made the run.
+If the agents have a -region, the report also states the latencies of
+every region.
+
+Before the run, the controller checks the health of every agent: agents
+that do not respond or are busy with another run are left out, with a
+warning, and the load is split across the others. It warns about agents
+whose limit on open files is too low for their share of -c or that are
+already loaded. During the run, it checks every agent every 2s and gives
+up on an agent that misses 3 checks in a row. The merged report covers
+the agents that completed their run; it states those that did not, and
+the controller warns about them.
+
+While the run is made, the controller prints the progress of the run as
+a whole, merged from the progress the agents stream every second, like
+hey does, unless -quiet is set. Ctrl-C stops the runs of the agents,
+which still return their results until then, and a second Ctrl-C gives
+up on them.
+
+Options:
+  -agents  Comma separated list of the addresses of the agents.
+  -token   Secret sent to the agents, see -token of hey agent.
+  -o       Output type of the merged report, see -o of hey.
+  -quiet   Do not print the progress of the run.
+
+All the other options are those of hey, and are passed on to every
+agent. -n, -c and -rps are split across the agents, so that the run as
+a whole makes -n requests with -c workers at -rps. The rows of -data are
+split across the agents, so that no two agents use the same row, and the
+merged report states how many of them the run used. Only the options an
+agent allows can be used, see hey agent -h. The thresholds, -fail-if-p95,
+-fail-if-p99 and -fail-if-error-rate, are checked against the merged
+results, and the controller exits with status 3 if one is violated.
+`
+
+// splitFlag removes the flag name, given as -name value, -name=value or
+// with two dashes, from args. It returns its value, the remaining args
+// and whether the flag was set.
+func splitFlag(args []string, name string) (string, []string, bool) {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		k := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if k == name && i+1 < len(args) {
+			rest := append(append([]string{}, args[:i]...), args[i+2:]...)
+			return args[i+1], rest, true
+		}
+		if strings.HasPrefix(k, name+"=") {
+			rest := append(append([]string{}, args[:i]...), args[i+1:]...)
+			return k[len(name)+1:], rest, true
+		}
+	}
+	return "", args, false
+}
+
+// share returns the part of total the i-th of k agents gets; the first
+// agents get the remainder.
+func share(total, k, i int) int {
+	s := total / k
+	if i < total%k {
+		s++
+	}
+	return s
+}
+
+// dataShare returns the CSV of the columns of data and of the rows the
+// i-th of k agents uses, see share, and the number of those rows.
+func dataShare(data *requester.Data, k, i int) (string, int) {
+	start := 0
+	for j := 0; j < i; j++ {
+		start += share(len(data.Rows), k, j)
+	}
+	rows := data.Rows[start : start+share(len(data.Rows), k, i)]
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(data.Columns)
+	w.WriteAll(rows)
+	return buf.String(), len(rows)
+}
+
+// dataCoverage describes how many of the rows of the -data file name
+// a run used, given the rows every agent got and the requests it made.
+func dataCoverage(name string, random bool, rows []int, requests []int64) string {
+	total, used := 0, 0
+	for i, n := range rows {
+		total += n
+		if requests[i] < int64(n) {
+			// Rows are used in turn, so every request used another.
+			used += int(requests[i])
+		} else {
+			used += n
+		}
+	}
+	if random {
+		return fmt.Sprintf("the %d rows of %s were split across %d agents, none used by two of them, and picked at random", total, name, len(rows))
+	}
+	// No percent sign, the summary is a format string.
+	return fmt.Sprintf("%d of the %d rows of %s were used, none by two agents", used, total, name)
+}
+
+// boolFlag reports whether args set the boolean flag name.
+func boolFlag(args []string, name string) bool {
+	for _, arg := range args {
+		k := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if k == name {
+			return true
+		}
+		if strings.HasPrefix(k, name+"=") {
+			v, _ := strconv.ParseBool(k[len(name)+1:])
+			return v
+		}
+	}
+	return false
+}
+
+// splitBoolFlag removes the boolean flag name, given as -name or
+// -name=value, with one or two dashes, from args. It returns its value
+// and the remaining args.
+func splitBoolFlag(args []string, name string) (bool, []string) {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		k := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		v, ok := true, k == name
+		if strings.HasPrefix(k, name+"=") {
+			v, _ = strconv.ParseBool(k[len(name)+1:])
+			ok = true
+		}
+		if ok {
+			return v, append(append([]string{}, args[:i]...), args[i+1:]...)
+		}
+	}
+	return false, args
+}
+
+// recordResults returns the number of results of the record file f.
+func recordResults(f io.ReadSeeker) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	rep, _, err := report.Merge([]io.Reader{f}, ioutil.Discard, report.Options{})
+	if err != nil {
+		return 0, err
+	}
+	return rep.Snapshot().NumRes, nil
+}
+
+// agentHeartbeat is the interval of the health checks of the agents
+// during a run, and the timeout of every health check.
+const agentHeartbeat = 2 * time.Second
+
+// agentMaxMissed is the number of health checks in a row an agent may
+// fail during a run before the controller gives up on it.
+const agentMaxMissed = 3
+
+// agentRequest makes a request to the agent at addr and returns its
+// response, or an error if its status is not 200 OK.
+func agentRequest(ctx context.Context, method, addr, path, token string, body io.Reader) (*http.Response, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// runAgent makes run on the agent at addr and writes the record file it
+// returns to w.
+func runAgent(ctx context.Context, addr, token string, run agentRun, w io.Writer) error {
+	body, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	res, err := agentRequest(ctx, "POST", addr, agentPath, token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// followAgent reads the progress the agent at addr streams, see
+// agentStatsPath, and calls tick with every Tick until ctx is done.
+func followAgent(ctx context.Context, addr, token string, tick func(report.Tick)) error {
+	res, err := agentRequest(ctx, "GET", addr, agentStatsPath, token, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	for {
+		var t report.Tick
+		if err := dec.Decode(&t); err != nil {
+			return err
+		}
+		tick(t)
+	}
+}
+
+// liveProgress is the progress of a distributed run, merged from the
+// latest Tick of every agent. It is safe for concurrent use.
+type liveProgress struct {
+	mu    sync.Mutex
+	ticks []report.Tick
+}
+
+func newLiveProgress(agents int) *liveProgress {
+	return &liveProgress{ticks: make([]report.Tick, agents)}
+}
+
+// set sets the latest Tick of the i-th agent.
+func (p *liveProgress) set(i int, t report.Tick) {
+	p.mu.Lock()
+	p.ticks[i] = t
+	p.mu.Unlock()
+}
+
+// tick returns the merged progress, see report.MergeTicks.
+func (p *liveProgress) tick() report.Tick {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return report.MergeTicks(p.ticks)
+}
+
+// stopAgents stops the runs of the agents at addrs, see agentStopPath. It
+// writes a warning about every agent it could not stop to w.
+func stopAgents(ctx context.Context, addrs []string, token string, w io.Writer) {
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			res, err := agentRequest(ctx, "POST", addr, agentStopPath, token, nil)
+			if err == nil {
+				res.Body.Close()
+			}
+			errs[i] = err
+		}(i, addr)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(w, "Warning: stopping agent %s failed: %v.\n", addrs[i], err)
+		}
+	}
+}
+
+// getHealth returns the health of the agent at addr.
+func getHealth(ctx context.Context, addr, token string) (*agentHealth, error) {
+	ctx, cancel := context.WithTimeout(ctx, agentHeartbeat)
+	defer cancel()
+	res, err := agentRequest(ctx, "GET", addr, agentHealthPath, token, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var h agentHealth
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// checkAgents returns the agents of addrs that are ready for a run and
+// their health. It writes a warning about every other agent to w.
+func checkAgents(ctx context.Context, addrs []string, token string, w io.Writer) ([]string, []*agentHealth) {
+	healths := make([]*agentHealth, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			healths[i], errs[i] = getHealth(ctx, addr, token)
+		}(i, addr)
+	}
+	wg.Wait()
+	var ready []string
+	var readyHealths []*agentHealth
+	for i, addr := range addrs {
+		switch {
+		case errs[i] != nil:
+			fmt.Fprintf(w, "Warning: agent %s is left out of the run: %v.\n", addr, errs[i])
+		case healths[i].Protocol != agentProtocol:
+			fmt.Fprintf(w, "Warning: agent %s is left out of the run: it speaks version %d of the protocol, not %d.\n", addr, healths[i].Protocol, agentProtocol)
+		case healths[i].Busy:
+			fmt.Fprintf(w, "Warning: agent %s is left out of the run: it is busy with another run.\n", addr)
+		default:
+			ready = append(ready, addr)
+			readyHealths = append(readyHealths, healths[i])
+		}
+	}
+	return ready, readyHealths
+}
+
+// capacityWarnings returns the warnings about the capacity of an agent
+// with health h for a run with c workers, made over HTTP/2 if h2 is set.
+func capacityWarnings(h *agentHealth, c int, h2 bool) []string {
+	var warnings []string
+	// Every worker holds a connection of its own, unless over HTTP/2,
+	// and hey a few files besides, see main.
+	if need := c + 64; !h2 && h.FileLimit > 0 && uint64(need) > h.FileLimit {
+		warnings = append(warnings, fmt.Sprintf("its %d workers need about %d open files, but its limit is %d", c, need, h.FileLimit))
+	}
+	if h.CPUs > 0 && h.Load >= float64(h.CPUs) {
+		warnings = append(warnings, fmt.Sprintf("it is already loaded, with a load average of %.1f on %d CPUs", h.Load, h.CPUs))
+	}
+	return warnings
+}
+
+// heartbeat checks the health of the agent at addr every interval until
+// ctx is done. Once it failed agentMaxMissed checks in a row, it calls
+// lost with the last error and returns.
+func heartbeat(ctx context.Context, addr, token string, interval time.Duration, lost func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	missed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if _, err := getHealth(ctx, addr, token); err == nil {
+			missed = 0
+		} else if missed++; missed >= agentMaxMissed && ctx.Err() == nil {
+			lost(fmt.Errorf("it missed %d health checks: %v", missed, err))
+			return
+		}
+	}
+}
+
+// controllerMain implements the "hey controller" command.
+func controllerMain(args []string) {
+	for _, arg := range args {
+		if arg == "-h" || arg == "-help" || arg == "--help" {
+			fmt.Fprint(os.Stderr, controllerUsage)
+			os.Exit(0)
+		}
+	}
+	agentList, args, _ := splitFlag(args, "agents")
+	token, args, _ := splitFlag(args, "token")
+	output, args, _ := splitFlag(args, "o")
+	quiet, args := splitBoolFlag(args, "quiet")
+	var agents []string
+	for _, a := range strings.Split(agentList, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			agents = append(agents, a)
+		}
+	}
+	if len(agents) == 0 {
+		fmt.Fprint(os.Stderr, controllerUsage)
+		fmt.Fprintln(os.Stderr, "\n-agents is required.")
+		os.Exit(1)
+	}
+	num, c, rate := 200, 50, 0.0
+	limits := thresholds{errorRate: -1}
+	var err error
+	v, args, ok := splitFlag(args, "n")
+	if ok {
+		if num, err = strconv.Atoi(v); err != nil {
+			errAndExit(fmt.Sprintf("invalid -n %q", v))
+		}
+	}
+	if v, args, ok = splitFlag(args, "c"); ok {
+		if c, err = strconv.Atoi(v); err != nil {
+			errAndExit(fmt.Sprintf("invalid -c %q", v))
+		}
+	}
+	if v, args, ok = splitFlag(args, "rps"); ok {
+		if rate, err = strconv.ParseFloat(v, 64); err != nil {
+			errAndExit(fmt.Sprintf("invalid -rps %q", v))
+		}
+	}
+	if v, args, ok = splitFlag(args, "fail-if-p95"); ok {
+		if limits.p95, err = time.ParseDuration(v); err != nil {
+			errAndExit(fmt.Sprintf("invalid -fail-if-p95 %q", v))
+		}
+	}
+	if v, args, ok = splitFlag(args, "fail-if-p99"); ok {
+		if limits.p99, err = time.ParseDuration(v); err != nil {
+			errAndExit(fmt.Sprintf("invalid -fail-if-p99 %q", v))
+		}
+	}
+	if v, args, ok = splitFlag(args, "fail-if-error-rate"); ok {
+		if limits.errorRate, err = parseRate(v); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	var data *requester.Data
+	dataFile, args, _ := splitFlag(args, "data")
+	if dataFile != "" {
+		if data, err = readData(dataFile); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	if err := checkAgentArgs(args); err != nil {
+		errAndExit(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all := agents
+	agents, healths := checkAgents(ctx, all, token, os.Stderr)
+	if len(agents) == 0 {
+		errAndExit("No agent is ready for the run.")
+	}
+	if c < len(agents) {
+		errAndExit("-c cannot be smaller than the number of agents.")
+	}
+	if data != nil && len(data.Rows) < len(agents) {
+		errAndExit("-data has fewer rows than there are agents.")
+	}
+	for i, h := range healths {
+		for _, w := range capacityWarnings(h, share(c, len(agents), i), boolFlag(args, "h2")) {
+			fmt.Fprintf(os.Stderr, "Warning: agent %s: %s.\n", agents[i], w)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		// Like hey on Ctrl-C, the agents end their runs and still
+		// return their results; a second Ctrl-C gives up on them.
+		stopAgents(ctx, agents, token, os.Stderr)
+		<-sig
+		cancel()
+	}()
+
+	live := newLiveProgress(len(agents))
+	var progress *report.Progress
+	if !quiet {
+		if isTerminal(os.Stderr) {
+			progress = report.NewProgress(os.Stderr, time.Second, true)
+		} else {
+			progress = report.NewProgress(os.Stderr, 10*time.Second, false)
+		}
+		progress.Follow(live.tick)
+		progress.Start()
+	}
+
+	files := make([]*os.File, len(agents))
+	errs := make([]error, len(agents))
+	rows := make([]int, len(agents))
+	var wg sync.WaitGroup
+	for i, addr := range agents {
+		f, err := ioutil.TempFile("", "hey-controller")
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		files[i] = f
+
+		a := append([]string{
+			"-n", strconv.Itoa(share(num, len(agents), i)),
+			"-c", strconv.Itoa(share(c, len(agents), i)),
+		}, args...)
+		if rate > 0 {
+			a = append([]string{"-rps", strconv.FormatFloat(rate/float64(len(agents)), 'f', -1, 64)}, a...)
+		}
+		run := agentRun{Version: agentProtocol, Args: a}
+		if data != nil {
+			run.Data, rows[i] = dataShare(data, len(agents), i)
+		}
+		wg.Add(1)
+		go func(i int, addr string, run agentRun) {
+			defer wg.Done()
+			actx, acancel := context.WithCancel(ctx)
+			defer acancel()
+			lost := make(chan error, 1)
+			go heartbeat(actx, addr, token, agentHeartbeat, func(err error) {
+				lost <- err
+				acancel()
+			})
+			// The progress is best effort: agents that do not stream it
+			// only leave it out.
+			go followAgent(actx, addr, token, func(t report.Tick) { live.set(i, t) })
+			errs[i] = runAgent(actx, addr, token, run, files[i])
+			select {
+			case err := <-lost:
+				errs[i] = err
+			default:
+			}
+		}(i, addr, run)
+	}
+	wg.Wait()
+	if progress != nil {
+		progress.Stop()
+	}
+
+	var rs []io.Reader
+	var failed []string
+	requests := make([]int64, len(agents))
+	for i, err := range errs {
+		if err == nil && data != nil {
+			requests[i], err = recordResults(files[i])
+		}
+		if err == nil {
+			_, err = files[i].Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: agent %s failed, the report leaves out its results: %v.\n", agents[i], err)
+			failed = append(failed, agents[i])
+			requests[i] = 0
+			continue
+		}
+		rs = append(rs, files[i])
+	}
+	if len(rs) == 0 {
+		errAndExit("All agents failed.")
+	}
+	rep, total, err := report.Merge(rs, os.Stdout, report.Options{Output: output})
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	if len(rs) < len(all) {
+		detail := fmt.Sprintf("%d of the %d agents completed the run", len(rs), len(all))
+		ready := make(map[string]bool)
+		for _, addr := range agents {
+			ready[addr] = true
+		}
+		var out []string
+		for _, addr := range all {
+			if !ready[addr] {
+				out = append(out, addr)
+			}
+		}
+		if len(out) > 0 {
+			detail += "; left out: " + strings.Join(out, ", ")
+		}
+		if len(failed) > 0 {
+			detail += "; failed: " + strings.Join(failed, ", ")
+		}
+		rep.AddEvent(report.Event{Offset: total, Name: "agents", Detail: detail})
+	}
+	if data != nil {
+		rep.AddEvent(report.Event{Offset: total, Name: "data", Detail: dataCoverage(dataFile, boolFlag(args, "data-random"), rows, requests)})
+	}
+	rep.Finalize(total)
+	if violations := limits.check(rep.Snapshot()); len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, "Threshold violated: %s.\n", v)
+		}
+		os.Exit(exitThresholds)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// scenarioMetric is a custom metric recorded for the responses of a
+// group of a scenario, e.g. {"name": "checkouts", "type": "counter",
+// "status": "2xx"}. A counter adds the number found at header or json,
+// or 1 without them. A timer records the duration in milliseconds found
+// at header or json, or the latency of the request without them.
+type scenarioMetric struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Status restricts the metric to responses with the given status,
+	// e.g. "201", or class of status, e.g. "2xx".
+	Status string `json:"status"`
+
+	scenarioExtract
+}
+
+// check validates m.
+func (m *scenarioMetric) check() error {
+	if m.Name == "" {
+		return fmt.Errorf("metric has no name")
+	}
+	if m.Type != "counter" && m.Type != "timer" {
+		return fmt.Errorf("metric %s: type must be counter or timer", m.Name)
+	}
+	if m.sources() > 1 {
+		return fmt.Errorf("metric %s: sets more than one of header, json and regex", m.Name)
+	}
+	if err := m.compile(); err != nil {
+		return fmt.Errorf("metric %s: %v", m.Name, err)
+	}
+	if m.Status != "" {
+		s := strings.Replace(strings.ToLower(m.Status), "xx", "00", 1)
+		if code, err := strconv.Atoi(s); err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("metric %s: invalid status %q", m.Name, m.Status)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a response with the status code counts
+// towards m.
+func (m *scenarioMetric) matches(code int) bool {
+	s := strings.ToLower(m.Status)
+	switch {
+	case s == "":
+		return true
+	case strings.HasSuffix(s, "xx"):
+		return s[:1] == strconv.Itoa(code/100)
+	}
+	return s == strconv.Itoa(code)
+}
+
+// record returns the value of m for a response, if it has one.
+func (m *scenarioMetric) record(res *http.Response, body []byte, latency time.Duration) (report.CustomMetric, bool) {
+	cm := report.CustomMetric{Name: m.Name, Timer: m.Type == "timer"}
+	if !m.matches(res.StatusCode) {
+		return cm, false
+	}
+	if m.sources() == 0 {
+		cm.Value = 1
+		if cm.Timer {
+			cm.Value = latency.Seconds()
+		}
+		return cm, true
+	}
+	v, ok := m.value(res, body)
+	if !ok {
+		return cm, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return cm, false
+	}
+	cm.Value = f
+	if cm.Timer {
+		cm.Value = f / 1000
+	}
+	return cm, true
+}
+
+// metricsFunc returns the Metrics function of a request group that
+// records ms.
+func metricsFunc(ms []scenarioMetric) func(*http.Response, []byte, time.Duration) []report.CustomMetric {
+	return func(res *http.Response, body []byte, latency time.Duration) []report.CustomMetric {
+		var out []report.CustomMetric
+		for i := range ms {
+			if cm, ok := ms[i].record(res, body, latency); ok {
+				out = append(out, cm)
+			}
+		}
+		return out
+	}
+}
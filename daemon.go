@@ -0,0 +1,349 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const daemonUsage = `Usage: hey daemon -config <file> [options...]
+
+Makes the run of a -config file continuously in the background, e.g. to
+keep a staging environment warm: every -every it ends the run, writes its
+report to -dir and starts the next one, until it is interrupted.
+
+Options:
+  -config  Config file of the runs, see -config of hey. It sets the urls
+           and the load; -z is set by -every. Every run reads it anew.
+  -every   Duration of a run and of the period of its report. Default
+           is 1h.
+  -dir     Directory the reports are written to, named by the start and
+           the number of their run, e.g. hey-20060102-150405-1.txt, or
+           .csv, .json, .jsonl or .html for the -o of the config.
+           Default is the current directory.
+  -keep    Number of reports kept in -dir, older ones are removed.
+           Default is 24.
+  -addr    Address of the control endpoint. Default is 127.0.0.1:7071.
+  -token   Secret the control requests must send as an
+           "Authorization: Bearer <token>" header. Required unless
+           -addr is a loopback address.
+
+The control endpoint serves:
+  GET  /status  the state of the daemon and its reports as JSON.
+  GET  /report  the latest report.
+  POST /pause   ends the run, with its report, and makes no more runs
+                until /resume.
+  POST /resume  starts a run again.
+  POST /reload  adjusts the running load to the q, c and H of the
+                config, like SIGHUP to hey; the next runs read all of
+                it. SIGHUP to the daemon does the same.
+
+A run that fails, e.g. for an invalid config, is retried after 10s; a
+run that violates a threshold of the config is not a failure.
+`
+
+// daemonRetry is the pause after a failed run of the daemon.
+const daemonRetry = 10 * time.Second
+
+// daemon is "hey daemon": it makes the runs of a config one after the
+// other and serves its control endpoint.
+type daemon struct {
+	exe    string
+	config string
+	dir    string
+	ext    string
+	every  time.Duration
+	keep   int
+	token  string
+	retry  time.Duration
+
+	mu sync.Mutex
+	// cmd is the current run, if any, started at start.
+	cmd   *exec.Cmd
+	start time.Time
+	// paused is set by /pause; resumed is closed by /resume.
+	paused  bool
+	resumed chan struct{}
+	// stopped is set once the daemon is interrupted.
+	stopped bool
+	runs    int
+	failed  int
+	lastErr string
+	// reports are the reports kept, the oldest first.
+	reports []string
+}
+
+// daemonStatus is the response to /status.
+type daemonStatus struct {
+	State     string    `json:"state"`
+	Config    string    `json:"config"`
+	RunStart  time.Time `json:"run_start,omitempty"`
+	Runs      int       `json:"runs"`
+	Failed    int       `json:"failed"`
+	LastError string    `json:"last_error,omitempty"`
+	Reports   []string  `json:"reports"`
+}
+
+// reportExt returns the extension of the reports of the config name,
+// by its -o option.
+func reportExt(name string) (string, error) {
+	opts, err := readConfig(name)
+	if err != nil {
+		return "", err
+	}
+	for _, o := range opts {
+		if o.name == "o" && len(o.values) == 1 {
+			switch o.values[0] {
+			case "csv", "json", "jsonl", "html":
+				return "." + o.values[0], nil
+			}
+		}
+	}
+	return ".txt", nil
+}
+
+// run makes the runs of d until it is stopped.
+func (d *daemon) run() {
+	for {
+		d.mu.Lock()
+		for d.paused && !d.stopped {
+			resumed := d.resumed
+			d.mu.Unlock()
+			<-resumed
+			d.mu.Lock()
+		}
+		if d.stopped {
+			d.mu.Unlock()
+			return
+		}
+		start := time.Now()
+		name := filepath.Join(d.dir, fmt.Sprintf("hey-%s-%d%s", start.Format("20060102-150405"), d.runs+1, d.ext))
+		f, err := os.Create(name)
+		if err != nil {
+			d.mu.Unlock()
+			errAndExit(err.Error())
+		}
+		cmd := exec.Command(d.exe, "-config", d.config, "-z", d.every.String())
+		cmd.Stdout = f
+		cmd.Stderr = os.Stderr
+		if err = cmd.Start(); err == nil {
+			d.cmd, d.start = cmd, start
+		}
+		d.mu.Unlock()
+		if err == nil {
+			err = cmd.Wait()
+		}
+		f.Close()
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == exitThresholds {
+			err = nil
+		}
+
+		d.mu.Lock()
+		d.cmd = nil
+		d.runs++
+		if fi, serr := os.Stat(name); serr == nil && fi.Size() > 0 {
+			d.reports = append(d.reports, name)
+		} else {
+			os.Remove(name)
+		}
+		for len(d.reports) > d.keep {
+			os.Remove(d.reports[0])
+			d.reports = d.reports[1:]
+		}
+		// A run ended by /pause or an interrupt did not fail.
+		failed := err != nil && !d.paused && !d.stopped
+		if failed {
+			d.failed++
+			d.lastErr = err.Error()
+		}
+		d.mu.Unlock()
+		if failed {
+			fmt.Fprintf(os.Stderr, "Warning: the run failed: %v; retrying in %v.\n", err, d.retry)
+			time.Sleep(d.retry)
+		}
+	}
+}
+
+// endRun ends the current run, if any, with its report. It must be
+// called with d.mu held.
+func (d *daemon) endRun() {
+	if d.cmd == nil {
+		return
+	}
+	if err := d.cmd.Process.Signal(os.Interrupt); err != nil {
+		d.cmd.Process.Kill()
+	}
+}
+
+// stop ends the current run and makes no more.
+func (d *daemon) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	d.endRun()
+	if d.paused {
+		d.paused = false
+		close(d.resumed)
+	}
+}
+
+// reload tells the current run to reload the config.
+func (d *daemon) reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cmd == nil {
+		return fmt.Errorf("no run is running")
+	}
+	return reloadProcess(d.cmd.Process)
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+d.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	method := "POST"
+	if r.URL.Path == "/status" || r.URL.Path == "/report" {
+		method = "GET"
+	}
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch r.URL.Path {
+	case "/status":
+		s := daemonStatus{
+			State:     "running",
+			Config:    d.config,
+			Runs:      d.runs,
+			Failed:    d.failed,
+			LastError: d.lastErr,
+			Reports:   append([]string{}, d.reports...),
+		}
+		switch {
+		case d.paused:
+			s.State = "paused"
+		case d.cmd == nil:
+			s.State = "waiting"
+		default:
+			s.RunStart = d.start
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	case "/report":
+		if len(d.reports) == 0 {
+			http.Error(w, "no report yet", http.StatusNotFound)
+			return
+		}
+		http.ServeFile(w, r, d.reports[len(d.reports)-1])
+	case "/pause":
+		if !d.paused {
+			d.paused = true
+			d.resumed = make(chan struct{})
+			d.endRun()
+		}
+	case "/resume":
+		if d.paused {
+			d.paused = false
+			close(d.resumed)
+		}
+	case "/reload":
+		if d.cmd == nil {
+			http.Error(w, "no run is running", http.StatusConflict)
+			return
+		}
+		if err := reloadProcess(d.cmd.Process); err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// daemonMain implements the "hey daemon" command.
+func daemonMain(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, daemonUsage)
+	}
+	config := fs.String("config", "", "")
+	every := fs.Duration("every", time.Hour, "")
+	dir := fs.String("dir", ".", "")
+	keep := fs.Int("keep", 24, "")
+	addr := fs.String("addr", "127.0.0.1:7071", "")
+	token := fs.String("token", "", "")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *config == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *every <= 0 || *keep < 1 {
+		errAndExit("-every and -keep must be positive.")
+	}
+	if *token == "" && !isLoopback(*addr) {
+		errAndExit("-token is required unless -addr is a loopback address.")
+	}
+	ext, err := reportExt(*config)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	d := &daemon{
+		exe:    exe,
+		config: *config,
+		dir:    *dir,
+		ext:    ext,
+		every:  *every,
+		keep:   *keep,
+		token:  *token,
+		retry:  daemonRetry,
+	}
+	go func() {
+		errAndExit(http.ListenAndServe(*addr, d).Error())
+	}()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		d.stop()
+	}()
+	reload := make(chan os.Signal, 1)
+	notifyReload(reload)
+	go func() {
+		for range reload {
+			if err := d.reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: reload: %v\n", err)
+			}
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "Running %s every %v, control endpoint on %s...\n", *config, *every, *addr)
+	d.run()
+}
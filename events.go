@@ -0,0 +1,219 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// eventQueue is the number of lifecycle records a webhook or Kafka
+// sink buffers while it is slower than the run.
+const eventQueue = 1000
+
+// eventFlushTimeout is how long the records still queued for webhooks
+// and Kafka are sent for at the end of the run.
+const eventFlushTimeout = 5 * time.Second
+
+// eventSinks are the destinations of the lifecycle records of a run.
+type eventSinks struct {
+	sinks   []func(report.LifecycleRecord)
+	senders []*eventSender
+}
+
+// parseEventSinks returns the sinks of the -events flags: stdout or
+// stderr for JSON lines, an http(s) URL to POST every record to or a
+// kafka://host:port/topic URL to produce every record to.
+func parseEventSinks(targets []string) (*eventSinks, error) {
+	s := &eventSinks{}
+	for _, t := range targets {
+		switch {
+		case t == "stdout":
+			s.sinks = append(s.sinks, jsonLines(os.Stdout))
+		case t == "stderr":
+			s.sinks = append(s.sinks, jsonLines(os.Stderr))
+		case strings.HasPrefix(t, "kafka://"):
+			p, err := newKafkaProducer(t)
+			if err != nil {
+				return nil, err
+			}
+			s.add(newEventSender(t, func(rec report.LifecycleRecord, b []byte) error {
+				// Keyed by run, the records of a run stay in order.
+				return p.produce([]byte(rec.RunID), b)
+			}, p.close))
+		default:
+			u, err := url.Parse(t)
+			if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+				return nil, fmt.Errorf("invalid sink %q, want stdout, stderr, an http(s) URL or a kafka:// URL", t)
+			}
+			s.add(newEventSender(t, webhookPoster(t), nil))
+		}
+	}
+	return s, nil
+}
+
+func (s *eventSinks) add(h *eventSender) {
+	s.senders = append(s.senders, h)
+	s.sinks = append(s.sinks, h.send)
+}
+
+// close sends the records still queued for the webhooks and Kafka and
+// returns the first error of every sink that failed.
+func (s *eventSinks) close() []error {
+	var errs []error
+	deadline := time.Now().Add(eventFlushTimeout)
+	for _, h := range s.senders {
+		if err := h.close(deadline); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", h.target, err))
+		}
+	}
+	return errs
+}
+
+// abortEvents sends the record of a run that ended before it started
+// for the given reason, if l is set.
+func abortEvents(l *report.Lifecycle, s *eventSinks, reason string) {
+	if l == nil {
+		return
+	}
+	l.Emit(report.LifecycleRunFinished, reason)
+	for _, err := range s.close() {
+		fmt.Fprintf(os.Stderr, "Warning: sending the lifecycle events to %v\n", err)
+	}
+}
+
+// jsonLines returns a sink that writes every record to w as a line of
+// JSON.
+func jsonLines(w io.Writer) func(report.LifecycleRecord) {
+	var mu sync.Mutex
+	return func(rec report.LifecycleRecord) {
+		b, _ := json.Marshal(rec)
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(b, '\n'))
+	}
+}
+
+// eventSender delivers every record as JSON to a webhook or Kafka in
+// the background, in order. Records are dropped rather than slowing the
+// run down if the sink does not keep up.
+type eventSender struct {
+	target  string
+	deliver func(rec report.LifecycleRecord, b []byte) error
+	done    func() // called once the records are delivered, if set
+
+	mu      sync.Mutex
+	closed  bool
+	records chan report.LifecycleRecord
+	sent    chan struct{}
+	err     error
+	dropped int
+}
+
+func newEventSender(target string, deliver func(report.LifecycleRecord, []byte) error, done func()) *eventSender {
+	h := &eventSender{
+		target:  target,
+		deliver: deliver,
+		done:    done,
+		records: make(chan report.LifecycleRecord, eventQueue),
+		sent:    make(chan struct{}),
+	}
+	go h.loop()
+	return h
+}
+
+func (h *eventSender) send(rec report.LifecycleRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.records <- rec:
+	default:
+		h.dropped++
+	}
+}
+
+func (h *eventSender) loop() {
+	defer close(h.sent)
+	for rec := range h.records {
+		b, err := json.Marshal(rec)
+		if err == nil {
+			err = h.deliver(rec, b)
+		}
+		if err != nil && h.err == nil {
+			h.err = err
+		}
+	}
+	if h.done != nil {
+		h.done()
+	}
+}
+
+// webhookPoster returns a function that POSTs a record to url.
+func webhookPoster(url string) func(report.LifecycleRecord, []byte) error {
+	client := &http.Client{Timeout: eventFlushTimeout}
+	return func(_ report.LifecycleRecord, b []byte) error {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// close waits until deadline for the queued records to be sent.
+func (h *eventSender) close(deadline time.Time) error {
+	h.mu.Lock()
+	h.closed = true
+	close(h.records)
+	dropped := h.dropped
+	h.mu.Unlock()
+	select {
+	case <-h.sent:
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("timed out sending the records")
+	}
+	if h.err != nil {
+		return h.err
+	}
+	if dropped > 0 {
+		return fmt.Errorf("%d records dropped, the sink fell behind", dropped)
+	}
+	return nil
+}
+
+// newRunID returns a random identifier of a run.
+func newRunID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return fmt.Sprintf("%x", id)
+}
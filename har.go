@@ -0,0 +1,144 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// harArchive is the part of an HTTP Archive, as saved by the developer
+// tools of browsers, that describes the requests.
+type harArchive struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the duration of the request in milliseconds.
+	Time    float64 `json:"time"`
+	Request struct {
+		Method   string    `json:"method"`
+		URL      string    `json:"url"`
+		Headers  []harPair `json:"headers"`
+		PostData *struct {
+			MimeType string    `json:"mimeType"`
+			Text     string    `json:"text"`
+			Encoding string    `json:"encoding"`
+			Params   []harPair `json:"params"`
+		} `json:"postData"`
+	} `json:"request"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harSkipHeaders are the headers of recorded requests that are not
+// replayed, as they describe the recorded connection.
+var harSkipHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// loadHAR returns a scenario that replays the HTTP(S) requests recorded
+// in the HAR file name in order, with their headers and bodies. If
+// timing is set, every worker pauses after a request for as long as
+// the recorded session was idle before the next one started.
+func loadHAR(name string, timing bool) (*scenario, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var har harArchive
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, fmt.Errorf("har %s: %v", name, err)
+	}
+	s := &scenario{Sequential: true}
+	var entries []harEntry
+	for _, e := range har.Log.Entries {
+		if u, err := url.Parse(e.Request.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("har %s: no http or https requests", name)
+	}
+	for i, e := range entries {
+		u, _ := url.Parse(e.Request.URL)
+		g := scenarioGroup{
+			Name:    fmt.Sprintf("%d %s %s", i+1, e.Request.Method, u.Path),
+			Method:  e.Request.Method,
+			URL:     e.Request.URL,
+			Headers: make(map[string]string),
+		}
+		for _, h := range e.Request.Headers {
+			k := http.CanonicalHeaderKey(h.Name)
+			if strings.HasPrefix(k, ":") || harSkipHeaders[k] {
+				continue
+			}
+			if v, ok := g.Headers[k]; ok {
+				sep := ", "
+				if k == "Cookie" {
+					sep = "; "
+				}
+				h.Value = v + sep + h.Value
+			}
+			g.Headers[k] = h.Value
+		}
+		if p := e.Request.PostData; p != nil {
+			g.Body = p.Text
+			switch {
+			case p.Encoding == "base64":
+				body, err := base64.StdEncoding.DecodeString(p.Text)
+				if err != nil {
+					return nil, fmt.Errorf("har %s: request %d: %v", name, i+1, err)
+				}
+				g.Body = string(body)
+			case p.Text == "" && len(p.Params) > 0:
+				form := make(url.Values)
+				for _, param := range p.Params {
+					form.Add(param.Name, param.Value)
+				}
+				g.Body = form.Encode()
+			}
+			if _, ok := g.Headers["Content-Type"]; !ok && p.MimeType != "" {
+				g.Headers["Content-Type"] = p.MimeType
+			}
+		}
+		if timing && i+1 < len(entries) {
+			end := e.StartedDateTime.Add(time.Duration(e.Time * float64(time.Millisecond)))
+			if gap := entries[i+1].StartedDateTime.Sub(end); gap > 0 {
+				g.ThinkTime = gap.String()
+			}
+		}
+		s.Groups = append(s.Groups, g)
+	}
+	return s, nil
+}
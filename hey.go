@@ -16,72 +16,326 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
 	gourl "net/url"
 	"os"
 	"os/signal"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rakyll/hey/requester"
+	"github.com/rakyll/hey/requester/report"
 )
 
 const (
 	headerRegexp = `^([\w-]+):\s*(.+)`
 	authRegexp   = `^(.+):([^\s].+)`
-	heyUA        = "hey/0.0.1"
+	version      = "0.0.1"
+	heyUA        = "hey/" + version
 )
 
 var (
-	m           = flag.String("m", "GET", "")
-	headers     = flag.String("h", "", "")
-	body        = flag.String("d", "", "")
-	bodyFile    = flag.String("D", "", "")
-	accept      = flag.String("A", "", "")
-	contentType = flag.String("T", "text/html", "")
-	authHeader  = flag.String("a", "", "")
-	hostHeader  = flag.String("host", "", "")
-
-	output = flag.String("o", "", "")
-
-	c = flag.Int("c", 50, "")
-	n = flag.Int("n", 200, "")
-	q = flag.Float64("q", 0, "")
-	t = flag.Int("t", 20, "")
-	z = flag.Duration("z", 0, "")
-
-	h2   = flag.Bool("h2", false, "")
-	cpus = flag.Int("cpus", runtime.GOMAXPROCS(-1), "")
+	m            = flag.String("m", "GET", "")
+	headers      = flag.String("h", "", "")
+	body         = flag.String("d", "", "")
+	bodyFile     = flag.String("D", "", "")
+	accept       = flag.String("A", "", "")
+	contentType  = flag.String("T", "text/html", "")
+	authHeader   = flag.String("a", "", "")
+	hostHeader   = flag.String("host", "", "")
+	uaFile       = flag.String("ua-file", "", "")
+	signCmd      = flag.String("sign-cmd", "", "")
+	scenarioFile = flag.String("scenario", "", "")
+	harFile      = flag.String("har", "", "")
+	harTiming    = flag.Bool("har-timing", false, "")
+	replayFile   = flag.String("replay", "", "")
+	replayFormat = flag.String("replay-format", "combined", "")
+	replaySpeed  = flag.Float64("replay-speed", 1, "")
+	isolate      = flag.Bool("isolate-groups", false, "")
+	cleanupFile  = flag.String("cleanup-file", "", "")
+	setupURL     = flag.String("setup", "", "")
+	setupBody    = flag.String("setup-body", "", "")
+	rwRatio      = flag.String("rw-ratio", "", "")
+	thinkTime    = flag.String("think", "", "")
+	urlFile      = flag.String("url-file", "", "")
+	dataFile     = flag.String("data", "", "")
+	dataRandom   = flag.Bool("data-random", false, "")
+	noCache      = flag.Bool("no-cache", false, "")
+	assertStatus = flag.String("assert-status", "", "")
+	saveErrors   = flag.String("save-errors", "", "")
+	maxSaved     = flag.Int("max-saved", 100, "")
+	rawHeaders   = flag.Bool("raw-headers", false, "")
+
+	output         = flag.String("o", "", "")
+	csvFields      = flag.String("csv-fields", "", "")
+	latencyUnit    = flag.String("latency-unit", "auto", "")
+	quantileEngine = flag.String("quantile-engine", "exact", "")
+	workerStats    = flag.Bool("worker-stats", false, "")
+	tdigestComp    = flag.Float64("tdigest-compression", report.DefaultTDigestCompression, "")
+	hdrPrecision   = flag.Int("hdr-precision", report.DefaultHDRPrecision, "")
+	histBuckets    = flag.String("histogram-buckets", "10", "")
+	configFile     = flag.String("config", "", "")
+	spoolDir       = flag.String("spool", "", "")
+	outputFile     = flag.String("output-file", "", "")
+	rotateSize     = flag.String("rotate-size", "", "")
+	rotateInterval = flag.Duration("rotate-interval", 0, "")
+	gzipOutput     = flag.Bool("gzip", false, "")
+	manifestFile   = flag.String("manifest", "", "")
+	region         = flag.String("region", "", "")
+	hookSSH        = flag.String("hook-ssh", "", "")
+	recordFile     = flag.String("record", "", "")
+	failP95        = flag.Duration("fail-if-p95", 0, "")
+	failP99        = flag.Duration("fail-if-p99", 0, "")
+	failErrorRate  = flag.String("fail-if-error-rate", "", "")
+	metricsAddr    = flag.String("metrics-addr", "", "")
+	streamResults  = flag.String("stream-results", "", "")
+	scrapeURL      = flag.String("scrape-url", "", "")
+	scrapeMetrics  = flag.String("scrape-metrics", "", "")
+	scrapeInterval = flag.Duration("scrape-interval", 5*time.Second, "")
+	quiet          = flag.Bool("quiet", false, "")
+	progressJSON   = flag.Bool("progress-json", false, "")
+	savePartial    = flag.Bool("save-partial", false, "")
+
+	c   = flag.Int("c", 50, "")
+	n   = flag.Int("n", 200, "")
+	q   = flag.Float64("q", 0, "")
+	rps = flag.Float64("rps", 0, "")
+	t   = flag.Int("t", 20, "")
+	z   = flag.Duration("z", 0, "")
+
+	h2              = flag.Bool("h2", false, "")
+	grpcMethod      = flag.String("grpc", "", "")
+	protoFile       = flag.String("proto", "", "")
+	tlsInfo         = flag.Bool("tls-info", false, "")
+	tlsWarn         = flag.Duration("tls-expiry-warn", 30*24*time.Hour, "")
+	tlsVerifyTiming = flag.Bool("tls-verify-timing", false, "")
+	echConfig       = flag.String("ech-config", "", "")
+	certFile        = flag.String("cert", "", "")
+	keyFile         = flag.String("key", "", "")
+	caCertFile      = flag.String("cacert", "", "")
+	insecure        = flag.Bool("k", false, "")
+	serverName      = flag.String("servername", "", "")
+	pipeline        = flag.Int("pipeline", 0, "")
+	websocket       = flag.Bool("ws", false, "")
+	cpus            = flag.Int("cpus", runtime.GOMAXPROCS(-1), "")
 
 	disableCompression = flag.Bool("disable-compression", false, "")
+	verifyCompression  = flag.Bool("verify-compression", false, "")
+	securityHeaders    = flag.Bool("security-headers", false, "")
 	disableKeepAlives  = flag.Bool("disable-keepalive", false, "")
+	enableCookies      = flag.Bool("enable-cookies", false, "")
+	emulateNetwork     = flag.String("emulate-network", "", "")
 	disableRedirects   = flag.Bool("disable-redirects", false, "")
+	disableTLSResume   = flag.Bool("disable-tls-resumption", false, "")
+	redirectChain      = flag.Bool("redirect-chain", false, "")
+	respectRetryAfter  = flag.Bool("respect-retry-after", false, "")
+	streamStats        = flag.Bool("stream-stats", false, "")
+	stallThreshold     = flag.Duration("stall", time.Second, "")
+	cbFailures         = flag.Int("cb-failures", 0, "")
+	cbCooldown         = flag.Duration("cb-cooldown", 5*time.Second, "")
+	cancelRate         = flag.String("cancel-rate", "", "")
+	cancelAfter        = flag.Duration("cancel-after", 0, "")
+	fuzzRate           = flag.String("fuzz-rate", "", "")
 	proxyAddr          = flag.String("x", "", "")
+	dnsChaos           = flag.String("dns-chaos", "", "")
 )
 
-var usage = `Usage: hey [options...] <url>
+var usage = `Usage: hey [options...] <url> [<url>...]
+       hey -scenario <file> [options...]
+       hey -grpc <method> -proto <file> [options...] <host:port>
+       hey validate [options...] [<url>]
+       hey report [options...] <record file>
+       hey calibrate [-c 50] [-z 5s]
+       hey cleanup [-c 10] [-H header] <cleanup file>
+       hey matrix -config <file> [-cooldown 30s] [-o csv]
+       hey server [-addr :8080] [-latency 10ms] [-body-size 1KB] [-error-rate 1%%]
+       hey agent [-addr :7070] [-token secret] [-region name]
+       hey controller -agents <host:port,...> [options...] <url>
+       hey daemon -config <file> [-every 1h] [-dir reports] [-keep 24]
+
+hey validate takes the options of a run and checks them, the scenario,
+the config and the URLs and headers of every request, then prints the
+requests, with the first data row and <name> for captured values in
+their placeholders, instead of making them.
 
 Options:
+  -config  TOML or YAML file (by its .toml, .yaml or .yml extension) with
+           options of the run, named like the flags without the dash,
+           and the urls, e.g. n = 1000, H = ["Accept: text/html"] and
+           urls = ["https://example.com/"]. Flags given on the command
+           line override the file, as do urls. On SIGHUP, hey re-reads
+           q, c and H of the file and adjusts the running load to them:
+           fewer workers pause, more are started, and the headers, which
+           are then not templates, replace those the file set before.
+           The load of -ws, HTTP/1.1 -pipeline and -rps runs is fixed.
   -n  Number of requests to run. Default is 200.
   -c  Number of requests to run concurrently. Total number of requests cannot
-      be smaller than the concurrency level. Default is 50.
+      be smaller than the concurrency level. Default is 50. Every
+      worker holds a connection of its own, so hey raises the limit on
+      open files to its hard limit if needed and warns if -c exceeds it.
   -q  Rate limit, in queries per second (QPS). Default is no rate limit.
+      When set, the summary also reports the generator lag, the delay
+      between a request's scheduled and actual start, compares the
+      achieved rate over time with the requested one and corrects the
+      latency distribution for coordinated omission: the requests a
+      worker could not start on schedule while it waited for a slow
+      response are counted with the latency they would have had.
+  -rps  Arrival rate, in requests per second of the whole run. Requests
+        are started on schedule in their own goroutine, however many
+        are still waiting for a response (an open model), so that a slow
+        server does not lower the load. -c only sets the number of
+        workers the requests are attributed to. Cannot be used with -q.
+  -think  Pause of a worker after every request, like a user reading a
+          page before the next click: a duration, e.g. 2s, uniform:1s-3s
+          for pauses between 1s and 3s, all equally likely, or exp:2s
+          for exponentially distributed pauses with a mean of 2s. It is
+          the default of the think_time of -scenario groups, which
+          takes the same values. Cannot be used with -rps.
   -z  Duration of application to send requests. When duration is reached,
       application stops and exits. If duration is specified, n is ignored.
       Examples: -z 10s -z 3m.
-  -o  Output type. If none provided, a summary is printed.
-      "csv" is the only supported alternative. Dumps the response
-      metrics in comma-separated values format.
+  -o  Output type. If none provided, a summary is printed. "csv" dumps
+      the response metrics in comma-separated values format. "jsonl"
+      writes every response as a line of JSON with the fields of
+      -csv-fields, under the same names, as soon as it completes, e.g.
+      to tail the run. "json" prints the summary as JSON, including the
+      manifest of the run (see -manifest). "html" writes a
+      self-contained HTML page with latency, histogram and status code
+      charts, e.g. -o html -output-file report.html.
+  -latency-unit  Unit of the latencies in the summary output, one of
+                 s, ms, us or auto. Default is auto.
+  -quantile-engine  Estimator for the latency percentiles, "exact",
+                    "tdigest" or "hdr". exact uses up to the first million
+                    results, tdigest estimates from all results in
+                    constant memory. hdr records all results in an HDR
+                    histogram, which also backs the response time
+                    histogram and keeps p99.9 and p99.99 accurate on long
+                    runs. Default is exact.
+  -tdigest-compression  Compression of the t-digest estimator. Higher is
+                        more accurate. Default is 100.
+  -hdr-precision  Significant digits of the latencies the HDR histogram
+                  keeps, from 1 to 5. Default is 3.
+  -histogram-buckets  Number of buckets of the response time histogram,
+                      e.g. 20, or their upper bounds, e.g. 5ms,10ms,50ms,1s.
+                      Default is 10.
+  -spool  Spool the results to memory-mapped files in the given directory
+          instead of keeping them in memory, and compute the statistics
+          from all results rather than the first million, e.g.
+          -spool /tmp for runs of 100M requests on a single machine.
+          Needs about 100 bytes of disk per request.
+  -worker-stats  Add a section to the summary on how requests and
+                 latencies were spread across workers, to detect starved
+                 workers, and with keep-alives a histogram of requests
+                 per connection, to detect hot connections.
+  -csv-fields  Comma-separated list of columns for the csv output, e.g.
+               ts,latency,status,dns,conn,tls,ttfb,size,error. Available
+               fields: ts, offset, latency, dns, conn, tls, tls_verify,
+               ocsp, ech, write, ttfb, read, status, proto, redirects,
+               size, bytes, truncated, worker, error, error_class, label,
+               attempt, grpc_status. The output starts with the line
+               "# schema=1", the version of the field names, before the
+               header row.
+  -output-file  Write the output to the given file instead of stdout.
+  -manifest  Write a JSON manifest of the run to the given file: the hey
+             version, Go runtime, GOMAXPROCS, every effective flag value
+             and a hash of the request body, so the run can be reproduced.
+  -region  Region the run is made from, e.g. eu-west-1, recorded with
+           every result. The summary of results of several regions,
+           merged by hey report or hey controller, reports the
+           latencies of every region.
+  -hook  Command to run at a point of the run, "pre:<command>" before
+         it, "post:<command>" after it, "at=<duration>:<command>" once
+         at that time into the run or "every=<duration>:<command>"
+         periodically, e.g. -hook "pre:redis-cli flushall" or
+         -hook "at=30s:curl -o cpu.pprof host:6060/debug/pprof/profile".
+         Commands run in a shell. The run is aborted if a pre hook
+         fails. Can be repeated. The outcome of every hook, with its
+         exit code and output, is recorded in the manifest.
+  -hook-ssh  Run the hooks on the given host over ssh, e.g. user@host.
+  -fail-if-p95  Exit with status 3 if the 95th percentile latency exceeds
+                the given duration, e.g. 200ms, to gate CI pipelines.
+  -fail-if-p99  Same for the 99th percentile latency.
+  -fail-if-error-rate  Exit with status 3 if more than the given share of
+                       the requests failed with an error or a 5xx status,
+                       e.g. 1%%. 0 fails the run on any failed request.
+  -record  Record the raw results to the given file. "hey report <file>"
+           prints the report of a recorded run again, in any output type.
+           The file is rotated and gzipped like -output-file, see
+           -rotate-size, and hey report reads all its files.
+  -save-partial  When the run is interrupted with Ctrl-C, still write its
+                 report in the -o format. By default only the summary
+                 of an interrupted run is printed, to stderr, if -o is
+                 set. The summary of an interrupted run is marked as
+                 aborted and covers the requests completed until then.
+  -quiet  Do not print the progress of the run. By default a line with
+          the requests done, the current requests/sec, the p95 latency
+          and the number of errors is printed to stderr every second,
+          or every 10 seconds if stderr is not a terminal.
+  -progress-json  Print the progress to stderr every second as a line
+                  of JSON instead: the requests and errors done, the
+                  current requests/sec and up to 100 latencies of the
+                  second, evenly spaced over their distribution. hey
+                  agent streams it to the controller.
+  -metrics-addr  Serve live metrics of the run (in-flight requests, completed
+                 requests, errors and a latency histogram) in the Prometheus
+                 format on the /metrics path of the given address, e.g. :9090.
+  -stream-results  Stream every result as it completes to an external
+                   collector over gRPC, grpc://host:port or, with TLS,
+                   grpcs://host:port, which uses -cacert, -k and -cert
+                   like the targets. The collector implements the
+                   ResultCollector service of requester/report/
+                   collector.proto. Results are dropped, and counted,
+                   rather than slowing the run down if it falls behind.
+  -events  Send lifecycle events of the run as JSON records as they
+           happen: run_started, phase_changed (setup, run, teardown),
+           threshold_breached when the results so far exceed a
+           -fail-if-* limit (checked every second from 100 results
+           on), event (e.g. circuit breaker transitions) and
+           run_finished with a summary. The sink is stdout or stderr
+           for JSON lines, an http(s) URL every record is POSTed to,
+           or kafka://host:port/topic to produce every record to
+           partition 0 of the topic, or another with ?partition=N,
+           keyed by the run ID. Kafka is reached without TLS or SASL.
+           Can be repeated.
+  -scrape-url  Prometheus metrics endpoint of the system under test, e.g.
+               http://host:9100/metrics, to scrape during the run. The
+               report lists every scrape with the requests/sec and p95
+               latency of the client since the previous one, to show
+               cause and effect side by side.
+  -scrape-metrics  Comma-separated names of the metrics to keep, e.g.
+                   process_cpu_seconds_total,queue_depth. All series of
+                   a metric are kept, counters as their rate per second.
+  -scrape-interval  Time between two scrapes. Default is 5s.
+  -rotate-size  Start a new file of -output-file and -record once the
+                current one reaches the given size, e.g. 100MB. Files
+                are numbered file.1, file.2...
+  -rotate-interval  Start a new file of -output-file and -record after
+                    the given duration, e.g. 1h.
+  -gzip         Compress the files of -output-file and -record with
+                gzip, adding .gz to their names.
 
-  -m  HTTP method, one of GET, POST, PUT, DELETE, HEAD, OPTIONS.
+  -m  HTTP method, e.g. GET, POST, PATCH or any other method token such
+      as PURGE. Standard methods may be given in any case, other
+      methods are sent exactly as given.
   -H  Custom HTTP header. You can specify as many as needed by repeating the flag.
       For example, -H "Accept: text/html" -H "Content-Type: application/xml" .
+  -raw-headers  Send the -H headers with their names spelled exactly as
+                given and in the given order, before the other headers,
+                for servers and WAF rules that are sensitive to them. Go
+                canonicalizes and sorts header names otherwise. Requires
+                HTTP/1.1 and cannot be used with -x, -ws or -pipeline.
   -t  Timeout for each request in seconds. Default is 20, use 0 for infinite.
   -A  HTTP Accept header.
   -d  HTTP request body.
@@ -89,34 +343,421 @@ Options:
   -T  Content-type, defaults to "text/html".
   -a  Basic authentication, username:password.
   -x  HTTP Proxy address as host:port.
-  -h2 Enable HTTP/2.
+  -resolve  Connect to the given IP address instead of resolving the
+            host with DNS, e.g. example.com:443:10.0.0.1 for
+            https://example.com. Can be repeated for several hosts.
+  -dns-chaos  Make a share of the host name lookups fail or return
+              other addresses on purpose, to test how clients cope with
+              an unstable DNS, e.g. fail=5%%,flap=10%%,to=10.0.0.2|10.0.0.3
+              fails 5%% of the lookups and connects 10%% to one of the
+              given addresses instead. A lookup is made for every new
+              connection, so use -disable-keepalive for it to affect
+              every request. Failed lookups are errors of the class
+              "dns". The number of injected faults is printed after the
+              run.
+  -h2 Enable HTTP/2. The summary shows the protocols that were actually
+      negotiated, since servers may fall back to HTTP/1.1.
+  -grpc   Make gRPC calls of the given method, e.g.
+          helloworld.Greeter/SayHello, instead of HTTP requests. The url
+          is the host:port of the server, called without TLS, or
+          grpcs://host:port for TLS. The request message is the JSON of
+          -d or -D, {} by default, encoded as the input type of the
+          method. The summary shows the distribution of the gRPC
+          statuses of the responses.
+  -proto  The .proto file that defines the method of -grpc. The files it
+          imports are read from its directory if they exist there.
+  -tls-info  Before an HTTPS run, print the negotiated TLS version and
+             protocol and the served certificate chain.
+  -tls-expiry-warn  With -tls-info, warn about certificates that expire
+                    within the given duration. Default is 720h.
+  -tls-verify-timing  Verify the certificate chain of every full TLS
+                      handshake against the system roots and report the
+                      time it takes, e.g. to compare chain configurations.
+  -ech-config  Enable Encrypted Client Hello with the given base64 encoded
+               ECHConfigList, as published in the "ech" parameter of the
+               HTTPS DNS record of the host. The summary reports how many
+               handshakes the server accepted or rejected it in. Requires
+               TLS 1.3 and hey built with Go 1.23 or later.
+  -cert  Client certificate file (PEM) presented to servers that ask for
+         one, for mutual TLS. Requires -key. The certificate and key
+         are reloaded when their files change during the run, for
+         short-lived certificates, and the summary counts the
+         handshakes that presented each generation.
+  -key   Private key file (PEM) of -cert.
+  -cacert  File with the PEM encoded certificate authorities to verify
+           the server certificates against instead of the system roots.
+  -k, -insecure  Do not verify the server certificates, e.g. self-signed
+                 certificates of staging hosts.
+  -servername  Server name sent in the TLS handshake (SNI) and verified
+               against the server certificates, e.g. a production host
+               name when the url is an IP address. Defaults to the host
+               of -host or the url.
+  -pipeline  Number of requests a worker sends before waiting for their
+             responses. Requests are pipelined on one connection over
+             HTTP/1.1 and multiplexed over HTTP/2. -q limits the
+             rate of the requests, not of the batches. Experimental.
+  -ws  Benchmark a WebSocket endpoint, given as a ws:// or wss:// url.
+       Every worker opens a WebSocket and sends the body of -d or -D as
+       a message, -n messages in total at the rate of -q. The latency of
+       a message is the time until the next message of the server, e.g.
+       its echo. Failed connections are reported as errors and reopened.
 
   -host	HTTP Host header.
+  -expect-header  Header every response must have, e.g. "X-Cache: HIT".
+                  The value is a regular expression that must match the
+                  whole value, e.g. "Cache-Control: .*max-age=\d+.*".
+                  Responses that lack the header are errors of the class
+                  "expectation". You can specify as many as needed by
+                  repeating the flag.
+  -assert-status  Comma-separated list of the status codes every response
+                  must have, e.g. 200,201.
+  -assert-body-contains  Text every response body must contain, e.g.
+                         '"ok":true'. Can be repeated.
+  -assert-body-regex  Regular expression every response body must match,
+                      e.g. '"items":\[.+\]'. Can be repeated. Responses
+                      that fail an assertion, like those that lack an
+                      -expect-header, are errors of the class
+                      "expectation". The summary counts them per kind of
+                      assertion.
+  -save-errors  Directory to write the failing responses to, those with a
+                non-2xx status or that failed an assertion, one file per
+                response with its request line and headers and the
+                response status line, headers and body. The directory is
+                created if needed.
+  -max-saved  Maximum number of responses -save-errors writes. 0 writes
+              all of them. Default is 100.
+  -no-cache  Ask caches to pass every request to the origin, with the
+             headers "Cache-Control: no-cache", "Pragma: no-cache" and
+             "X-Cache-Bust: {{uuid}}", a different value for every
+             request. -H overrides them.
+
+  The url, the body and the values of -H may contain placeholders that
+  are expanded for every request: {{uuid}} a random UUID, {{seq}} the
+  number of the request, {{rand 1 100}} a random integer between 1 and
+  100 and {{timestamp}} the Unix time in seconds, e.g.
+  -d '{"id": "{{uuid}}", "n": {{seq}}}'.
+
+  -data  CSV file with values for the requests, e.g. test accounts. The
+         first line names the columns, every request uses the next line
+         and refers to its columns as {{.name}}, e.g. -data users.csv
+         -H "X-Token: {{.token}}" https://example.com/users/{{.id}}.
+         A request whose line has no value for a column it refers to
+         is not sent but fails with a generation error, which the
+         summary counts with samples.
+  -data-random  Use a random line of -data for every request instead of
+                the lines in turn.
+
+  Values of -H and -a may refer to secrets instead of containing them:
+  @file:PATH reads the file, @env:NAME the environment variable and
+  @vault:PATH#FIELD a field of a Vault KV secret, using VAULT_ADDR and
+  VAULT_TOKEN. E.g. -H "Authorization: Bearer @file:/run/secrets/token".
+
+  -ua-file  File with one User-Agent per line, used in turn for the
+            requests. Lines starting with # are ignored.
+  -sign-cmd  Command run for every request to sign it. The method, URL,
+             headers and body of the request are written to its standard
+             input; the "Name: value" header lines it prints are added
+             to the request.
+  -url-file  File with one url per line and an optional weight, e.g.
+             "https://example.com/search 3". The requests are split
+             between the urls of the file and of the command line, which
+             weigh 1, in the ratio of their weights. Results are labeled
+             with the url.
+  -rw-ratio  Split the requests between reads and writes in the given
+             ratio, e.g. 90:10. Reads are GET requests to the url, writes
+             use the -m method, POST by default, and the -d or -D body.
+             Results are labeled read or write.
+  -scenario  JSON file, or YAML if its name ends in .yaml or .yml,
+             defining groups of requests that are made in turn, each
+             with a name, method, url, headers, body or body_file and
+             think_time, the pause of a worker after a request of the
+             group, e.g. {"groups": [{"name": "home", "url":
+             "https://example.com/", "think_time": "1s"}]}. Results are
+             labeled with the group name. The other request flags set
+             the defaults of the groups. Requests listed in "setup" and
+             "teardown", with the same fields, are made once before and
+             after the run, e.g. to create and delete test data, and are
+             not part of the results. The run is aborted if a setup
+             request fails or gets a status other than 2xx. Groups
+             record custom metrics with "metrics", e.g. [{"name":
+             "checkouts", "type": "counter", "status": "2xx"}]. A
+             counter adds 1 or the number at "header" or "json", a
+             timer records the latency or the milliseconds at "header"
+             or "json". They are reported with the other metrics. Groups
+             chain requests with "capture", which keeps values of their
+             2xx responses for the next requests of the worker to use as
+             {{capture name}}, e.g. {"id": {"json": "$.data.id"}} or
+             {"csrf": {"regex": "csrf=([a-z0-9]+)"}}, from the body.
+             Workers then make the groups in order, each a flow from the
+             first group to the last. Cannot be used with -rps. Groups
+             with a "weight" are picked at random by weight instead of
+             in turn, e.g. 9 and 1 for 90%% reads and 10%% writes; groups
+             without one weigh 1. With "sequential": true, workers make
+             the groups in order as well. Groups with a "class", e.g.
+             critical or best-effort, are reported on per class, and
+             "slos" sets thresholds per class, e.g. {"critical":
+             {"p95": "200ms", "p99": "1s", "error_rate": "0.1%%"}}, each
+             evaluated on the requests of its class alone; a violation
+             makes hey exit with status 3 like the -fail-if-* flags.
+             "service_slos" lists the published SLOs of the service,
+             e.g. [{"name": "checkout", "class": "critical", "metric":
+             "p99", "target": "300ms"}], with a metric of p50, p90, p95,
+             p99 or error_rate, over all requests or those of a class.
+             The summary states the target, actual value and margin of
+             each with PASS or FAIL, and a missed SLO makes hey exit
+             with status 3 as well.
+  -har  Replay the requests recorded in a HAR file, e.g. saved from the
+        network tab of the developer tools of a browser, as the groups
+        of a scenario: every worker makes them in order, with their
+        recorded method, url, headers and body, labeled with their
+        number, method and path. -H and the other request flags apply
+        as with -scenario. Cannot be used with -scenario or a url.
+  -har-timing  Keep the pacing of the recorded session: after a request,
+               a worker pauses for as long as the session was idle
+               before the next request started. Requests the browser
+               made in parallel follow each other without a pause.
+  -replay  Replay the requests of an nginx or Apache access log against
+           the url, which replaces the scheme and host of the logged
+           requests and prefixes their paths. Every logged request is
+           made once with its method, path and query, at the time it
+           was logged relative to the first one, in an open model like
+           -rps. In the combined format the logged referer and user
+           agent are sent as well. Logs have no bodies, -d and -D apply
+           to all requests. -n is ignored.
+  -replay-format  Format of the -replay log, combined or common. Default
+                  is combined.
+  -replay-speed  Speed of the replay relative to the logged traffic, e.g.
+                 2 for twice the rate in half the time. Default is 1.
+  -cleanup-file  Write the URLs of the resources created by the run to the
+                 given file, for "hey cleanup <file>" to delete them.
+                 Groups of the scenario name the identifier of a created
+                 resource with "extract", {"header": "Location"} or
+                 {"json": "data.id"}, taken from 2xx responses. The URL
+                 is "cleanup_url" with {id} replaced by the identifier,
+                 or the identifier itself, e.g. a Location header.
+  -setup  URL every worker requests once before the run, e.g. to log
+          in, with the -H headers. It is a POST of -setup-body if that
+          is set and a GET otherwise. Add headers to it with the
+          repeatable -setup-header and keep values of its response with
+          the repeatable -setup-capture name=header:<name>,
+          name=json:<path> or name=regex:<regexp>, which the requests of
+          the worker use as {{setup name}}, e.g.
+          -setup-capture token=json:data.token
+          -H "Authorization: Bearer {{setup token}}".
+          With -enable-cookies the workers also keep the cookies it
+          sets. The run is aborted if a setup request fails or gets a
+          status other than 2xx. Scenarios list such steps in
+          "worker_setup", with the fields of the groups and "capture",
+          e.g. {"token": {"json": "data.token"}}. Cannot be used with
+          -ws or -pipeline over HTTP/1.1.
+  -isolate-groups  Give every group of the scenario its own workers, the
+                   -c workers are assigned to the groups in turn. By
+                   default the groups share the workers, so that a slow
+                   group holds up the requests of the others. Groups can
+                   set their own number of workers and rate limit per
+                   worker with "concurrency" and "qps", which implies
+                   -isolate-groups. If every group sets its concurrency,
+                   -c is their sum.
 
   -disable-compression  Disable compression.
+  -verify-compression   Ask for gzip or deflate compressed responses and
+                        verify that their bodies decode as their
+                        Content-Encoding claims. Responses that do not,
+                        or that use another encoding such as br, which
+                        hey cannot decode, are errors of the class
+                        "decompression".
   -disable-keepalive    Disable keep-alive, prevents re-use of TCP
                         connections between different HTTP requests.
+  -enable-cookies       Give every worker its own cookie jar, which keeps the
+                        cookies the server sets across the requests of the
+                        worker, e.g. to log in once and browse as that
+                        session. Cannot be used with -ws or -pipeline
+                        over HTTP/1.1.
+  -emulate-network      Emulate the conditions of a slower network on the
+                        client connections: 3g (300ms RTT, 80ms jitter,
+                        1%% loss), lte (70ms RTT, 20ms jitter, 0.2%% loss)
+                        or custom:rtt=80ms,jitter=20ms,loss=1%%. Connecting
+                        and every request take a round trip more and lost
+                        packets a retransmission timeout. The delays are
+                        part of the measured latencies.
+  -disable-tls-resumption  Do a full TLS handshake on every new connection
+                        instead of resuming an earlier session. The summary
+                        reports full and resumed handshakes separately.
   -disable-redirects    Disable following of HTTP redirects
+  -respect-retry-after  Back off a worker when the server throttles it with
+                        a 429 or 503 response, for as long as Retry-After
+                        asks or exponentially if it is missing.
+  -cb-failures          Open a client side circuit breaker after the given
+                        number of consecutive failures (errors or 5xx).
+                        Requests are rejected while it is open.
+  -cb-cooldown          Time the circuit breaker stays open before a probe
+                        request is let through. Default is 5s.
+  -cancel-rate          Cancel the given share of the requests on purpose,
+                        e.g. 5%%, to test how the server copes with clients
+                        that disconnect. Requires -cancel-after.
+  -cancel-after         Time after the start of a request at which it is
+                        canceled if it is still in flight, e.g. 50ms.
+                        Canceled requests are errors of the class
+                        "canceled".
+  -fuzz-rate            Malform the given share of the requests on purpose,
+                        e.g. 1%%, to test the robustness of the server under
+                        load. They get an oversized or invalid header, an
+                        invalid or conflicting Content-Length or an unknown
+                        or invalid method, are sent over their own HTTP/1.1
+                        connection and are reported separately.
+  -stream-stats         Record the arrival of each response body chunk and
+                        report the time between chunks and the number of
+                        stalled streams.
+  -stall                Time between two chunks from which on a stream
+                        counts as stalled. Default is 1s.
+  -security-headers     Report how many responses had each of the
+                        security headers Strict-Transport-Security,
+                        Content-Security-Policy, X-Content-Type-Options,
+                        X-Frame-Options, Referrer-Policy and
+                        Permissions-Policy.
+  -redirect-chain       Record the redirect hops of every request and
+                        report the distribution of chain lengths.
   -cpus                 Number of used cpu cores.
                         (default for current machine is %d cores)
 `
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			reportMain(os.Args[2:])
+			return
+		case "calibrate":
+			calibrateMain(os.Args[2:])
+			return
+		case "server":
+			serverMain(os.Args[2:])
+			return
+		case "cleanup":
+			cleanupMain(os.Args[2:])
+			return
+		case "matrix":
+			matrixMain(os.Args[2:])
+			return
+		case "agent":
+			agentMain(os.Args[2:])
+			return
+		case "controller":
+			controllerMain(os.Args[2:])
+			return
+		case "daemon":
+			daemonMain(os.Args[2:])
+			return
+		case "validate":
+			// The options are those of a run.
+			validateOnly = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, fmt.Sprintf(usage, runtime.NumCPU()))
 	}
 
-	var hs headerSlice
+	var hs, expectHs, resolveFlags, bodyContains, bodyRegexps, hookFlags, setupHs, setupCaptures, eventTargets headerSlice
 	flag.Var(&hs, "H", "")
+	flag.Var(&expectHs, "expect-header", "")
+	flag.Var(&bodyContains, "assert-body-contains", "")
+	flag.Var(&bodyRegexps, "assert-body-regex", "")
+	flag.Var(&hookFlags, "hook", "")
+	flag.Var(&setupHs, "setup-header", "")
+	flag.Var(&setupCaptures, "setup-capture", "")
+	flag.Var(&eventTargets, "events", "")
+	flag.Var(&resolveFlags, "resolve", "")
+	flag.BoolVar(insecure, "insecure", false, "")
 
 	flag.Parse()
-	if flag.NArg() < 1 {
+	urls := flag.Args()
+	// cmdline holds the flags set on the command line, which -config
+	// does not override.
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+	if *configFile != "" {
+		cfgURLs, err := applyConfig(flag.CommandLine, *configFile)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		if len(urls) == 0 {
+			urls = cfgURLs
+		}
+	}
+	var scen *scenario
+	if *scenarioFile != "" {
+		var err error
+		if scen, err = loadScenario(*scenarioFile); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	if *harFile != "" {
+		if scen != nil {
+			usageAndExit("-har cannot be used with -scenario.")
+		}
+		if len(urls) > 0 || *urlFile != "" {
+			usageAndExit("-har cannot be used with a url or -url-file.")
+		}
+		var err error
+		if scen, err = loadHAR(*harFile, *harTiming); err != nil {
+			usageAndExit(err.Error())
+		}
+	} else if *harTiming {
+		usageAndExit("-har-timing requires -har.")
+	}
+	if *harTiming && (*rps > 0 || *thinkTime != "") {
+		usageAndExit("-har-timing cannot be used with -rps or -think.")
+	}
+	var targets []target
+	if *urlFile != "" {
+		var err error
+		if targets, err = readTargets(*urlFile); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	for _, u := range urls {
+		targets = append(targets, target{url: u, weight: 1})
+	}
+	if len(targets) == 0 && scen == nil {
 		usageAndExit("")
 	}
+	if len(targets) > 0 && scen != nil {
+		usageAndExit("-scenario cannot be used with a url or -url-file.")
+	}
+	var replay []accessLogEntry
+	if *replayFile != "" {
+		if len(targets) != 1 || *urlFile != "" {
+			usageAndExit("-replay requires a single url, the base url of the requests.")
+		}
+		if *replaySpeed <= 0 {
+			usageAndExit("-replay-speed must be positive.")
+		}
+		if *rps > 0 || *q > 0 || *rwRatio != "" || *thinkTime != "" || *pipeline > 1 || *websocket {
+			usageAndExit("-replay cannot be used with -rps, -q, -rw-ratio, -think, -pipeline or -ws.")
+		}
+		var skipped int
+		var err error
+		if replay, skipped, err = readAccessLog(*replayFile, *replayFormat); err != nil {
+			usageAndExit("-replay: " + err.Error())
+		}
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped %d lines of %s that are not requests in the %s format.\n", skipped, *replayFile, *replayFormat)
+		}
+	}
 
 	runtime.GOMAXPROCS(*cpus)
 	num := *n
 	conc := *c
+	if scen != nil && scen.isolated() {
+		*isolate = true
+		if workers, all := scen.workers(); all {
+			conc = workers
+		}
+	}
 	q := *q
 	dur := *z
 
@@ -134,24 +775,205 @@ func main() {
 			usageAndExit("-n cannot be less than -c.")
 		}
 	}
+	if replay != nil {
+		// Every logged request is made once, -z may stop the run early.
+		num = len(replay)
+	}
+	if !*h2 && *grpcMethod == "" {
+		// Every worker holds a connection of its own, and hey a few
+		// files besides.
+		if need, limit := conc+64, fileLimit(conc+64); limit > 0 && uint64(need) > limit {
+			fmt.Fprintf(os.Stderr, "Warning: -c %d needs about %d open files, but the limit is %d. Connections beyond it fail with \"too many open files\"; raise it with ulimit -n.\n", conc, need, limit)
+		}
+	}
 
-	url := flag.Args()[0]
-	method := strings.ToUpper(*m)
+	if *isolate {
+		if scen == nil {
+			usageAndExit("-isolate-groups requires -scenario.")
+		}
+		if workers, _ := scen.workers(); conc < workers {
+			usageAndExit("-c cannot be less than the number of workers the groups need with -isolate-groups.")
+		}
+		if scen.weighted() {
+			usageAndExit("-isolate-groups cannot be used with groups that have a weight.")
+		}
+	}
+	if *rps < 0 {
+		usageAndExit("-rps cannot be negative.")
+	}
+	if *rps > 0 && (q > 0 || *pipeline > 1 || *websocket) {
+		usageAndExit("-rps cannot be used with -q, -pipeline or -ws.")
+	}
+	if *websocket && (*pipeline > 1 || *h2 || *proxyAddr != "" || scen != nil || *cbFailures > 0) {
+		usageAndExit("-ws cannot be used with -pipeline, -h2, -x, -scenario or -cb-failures.")
+	}
+	if len(targets) > 1 && (*rwRatio != "" || *websocket || *pipeline > 1) {
+		usageAndExit("Several urls cannot be used with -rw-ratio, -ws or -pipeline.")
+	}
+	if *rwRatio != "" && (scen != nil || *websocket || *pipeline > 1) {
+		usageAndExit("-rw-ratio cannot be used with -scenario, -ws or -pipeline.")
+	}
+	if *grpcMethod != "" {
+		if *protoFile == "" {
+			usageAndExit("-grpc requires -proto.")
+		}
+		if len(targets) != 1 || scen != nil || replay != nil || *rwRatio != "" || *websocket || *pipeline > 1 || *proxyAddr != "" || *rawHeaders || *fuzzRate != "" {
+			usageAndExit("-grpc requires a single host:port and cannot be used with -scenario, -har, -replay, -rw-ratio, -ws, -pipeline, -x, -raw-headers or -fuzz-rate.")
+		}
+		if isFlagSet("m") && canonicalMethod(*m) != "POST" {
+			usageAndExit("-grpc calls are POST requests, -m cannot be set to another method.")
+		}
+	} else if *protoFile != "" {
+		usageAndExit("-proto requires -grpc.")
+	}
+	if *cleanupFile != "" && (scen == nil || !scen.extracts()) {
+		usageAndExit("-cleanup-file requires a -scenario with a group that extracts created resources.")
+	}
+	if *verifyCompression && (*disableCompression || *websocket || *pipeline > 1 && !*h2) {
+		usageAndExit("-verify-compression cannot be used with -disable-compression, -ws or -pipeline over HTTP/1.1.")
+	}
+	if scen != nil && *pipeline > 1 {
+		usageAndExit("-scenario cannot be used with -pipeline.")
+	}
+	if *cbFailures > 0 && *pipeline > 1 {
+		usageAndExit("-cb-failures cannot be used with -pipeline.")
+	}
+	if *enableCookies && (*websocket || *pipeline > 1 && !*h2) {
+		usageAndExit("-enable-cookies cannot be used with -ws or -pipeline over HTTP/1.1.")
+	}
+	if *setupURL == "" && (*setupBody != "" || len(setupHs) > 0 || len(setupCaptures) > 0) {
+		usageAndExit("-setup-body, -setup-header and -setup-capture require -setup.")
+	}
+	if (*setupURL != "" || scen != nil && len(scen.WorkerSetup) > 0) && (*websocket || *pipeline > 1 && !*h2) {
+		usageAndExit("-setup cannot be used with -ws or -pipeline over HTTP/1.1.")
+	}
+	if *pipeline > 1 && *proxyAddr != "" && !*h2 {
+		usageAndExit("-pipeline cannot be used with -x over HTTP/1.1.")
+	}
+	var cancels float64
+	if *cancelRate != "" {
+		var err error
+		if cancels, err = parseRate(*cancelRate); err != nil {
+			usageAndExit("-cancel-rate: " + err.Error())
+		}
+		if *cancelAfter <= 0 {
+			usageAndExit("-cancel-rate requires a positive -cancel-after.")
+		}
+		if *websocket || *pipeline > 1 && !*h2 {
+			usageAndExit("-cancel-rate cannot be used with -ws or -pipeline over HTTP/1.1.")
+		}
+	}
+	if *rawHeaders && (len(hs) == 0 || *h2 || *proxyAddr != "" || *websocket || *pipeline > 1) {
+		usageAndExit("-raw-headers requires -H and cannot be used with -h2, -x, -ws or -pipeline.")
+	}
+	var fuzz float64
+	if *fuzzRate != "" {
+		var err error
+		if fuzz, err = parseRate(*fuzzRate); err != nil {
+			usageAndExit("-fuzz-rate: " + err.Error())
+		}
+		if *websocket || *pipeline > 1 && !*h2 || *proxyAddr != "" {
+			usageAndExit("-fuzz-rate cannot be used with -ws, -x or -pipeline over HTTP/1.1.")
+		}
+	}
+
+	if !report.ValidLatencyUnit(*latencyUnit) {
+		usageAndExit("-latency-unit must be one of s, ms, us or auto.")
+	}
+
+	if *quantileEngine != "exact" && *quantileEngine != "tdigest" && *quantileEngine != "hdr" {
+		usageAndExit("-quantile-engine must be exact, tdigest or hdr.")
+	}
+	if *tdigestComp <= 0 {
+		usageAndExit("-tdigest-compression must be positive.")
+	}
+	if *hdrPrecision < 1 || *hdrPrecision > 5 {
+		usageAndExit("-hdr-precision must be between 1 and 5.")
+	}
+	if *spoolDir != "" {
+		if err := report.CheckSpoolDir(*spoolDir); err != nil {
+			usageAndExit("-spool: " + err.Error())
+		}
+	}
+	buckets, marks, err := parseHistogramBuckets(*histBuckets)
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+
+	var fields []string
+	if *csvFields != "" {
+		if *output != "csv" {
+			usageAndExit("-csv-fields requires -o csv.")
+		}
+		var err error
+		if fields, err = report.ParseCSVFields(*csvFields); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
+	limits := thresholds{p95: *failP95, p99: *failP99, errorRate: -1}
+	if *failErrorRate != "" {
+		var err error
+		if limits.errorRate, err = parseRate(*failErrorRate); err != nil {
+			usageAndExit("-fail-if-error-rate: " + err.Error())
+		}
+	}
+	var events *eventSinks
+	if len(eventTargets) > 0 {
+		var err error
+		if events, err = parseEventSinks(eventTargets); err != nil {
+			usageAndExit("-events: " + err.Error())
+		}
+	}
+
+	var maxSize int64
+	if *rotateSize != "" {
+		var err error
+		if maxSize, err = parseSize(*rotateSize); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	if *outputFile == "" && *recordFile == "" && (maxSize > 0 || *rotateInterval > 0 || *gzipOutput) {
+		usageAndExit("-rotate-size, -rotate-interval and -gzip require -output-file or -record.")
+	}
+
+	var url string
+	if scen != nil {
+		url = scen.Groups[0].URL
+	} else {
+		url = targets[0].url
+	}
+	method := canonicalMethod(*m)
 
 	// set content-type
 	header := make(http.Header)
-	header.Set("Content-Type", *contentType)
+	if *harFile == "" || isFlagSet("T") {
+		// Recorded requests keep their own content type.
+		header.Set("Content-Type", *contentType)
+	}
 	// set any other additional headers
 	if *headers != "" {
 		usageAndExit("Flag '-h' is deprecated, please use '-H' instead.")
 	}
+	if *noCache {
+		setNoCache(header)
+	}
 	// set any other additional repeatable headers
+	var headerOrder []string
 	for _, h := range hs {
 		match, err := parseInputWithRegexp(h, headerRegexp)
 		if err != nil {
 			usageAndExit(err.Error())
 		}
-		header.Set(match[1], match[2])
+		v, err := expandSecrets(match[2])
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		header.Set(match[1], v)
+		headerOrder = append(headerOrder, match[1])
+	}
+	if !*rawHeaders {
+		headerOrder = nil
 	}
 
 	if *accept != "" {
@@ -165,7 +987,12 @@ func main() {
 		if err != nil {
 			usageAndExit(err.Error())
 		}
-		username, password = match[1], match[2]
+		if username, err = expandSecrets(match[1]); err != nil {
+			errAndExit(err.Error())
+		}
+		if password, err = expandSecrets(match[2]); err != nil {
+			errAndExit(err.Error())
+		}
 	}
 
 	var bodyAll []byte
@@ -180,6 +1007,61 @@ func main() {
 		bodyAll = slurp
 	}
 
+	var echConfigList []byte
+	if *echConfig != "" {
+		if !requester.ECHSupported {
+			usageAndExit("-ech-config requires hey built with Go 1.23 or later.")
+		}
+		var err error
+		if echConfigList, err = base64.StdEncoding.DecodeString(*echConfig); err != nil {
+			usageAndExit("-ech-config is not valid base64: " + err.Error())
+		}
+	}
+
+	// clientTLS holds the client certificate, the trusted certificate
+	// authorities and the server name of all connections to the targets.
+	clientTLS := &tls.Config{InsecureSkipVerify: *insecure, ServerName: *serverName}
+	if *insecure && *caCertFile != "" {
+		usageAndExit("-k and -cacert cannot be used together.")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		usageAndExit("-cert and -key must be used together.")
+	}
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		clientTLS.Certificates = []tls.Certificate{cert}
+	}
+	if *caCertFile != "" {
+		pem, err := ioutil.ReadFile(*caCertFile)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		clientTLS.RootCAs = x509.NewCertPool()
+		if !clientTLS.RootCAs.AppendCertsFromPEM(pem) {
+			usageAndExit("-cacert contains no PEM encoded certificates.")
+		}
+	}
+
+	resolve, err := parseResolve(resolveFlags)
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+	var chaos *requester.DNSChaos
+	if *dnsChaos != "" {
+		if chaos, err = parseDNSChaos(*dnsChaos); err != nil {
+			usageAndExit("-dns-chaos: " + err.Error())
+		}
+	}
+	var network *requester.NetworkProfile
+	if *emulateNetwork != "" {
+		if network, err = parseNetwork(*emulateNetwork); err != nil {
+			usageAndExit("-emulate-network: " + err.Error())
+		}
+	}
+
 	var proxyURL *gourl.URL
 	if *proxyAddr != "" {
 		var err error
@@ -189,11 +1071,23 @@ func main() {
 		}
 	}
 
+	if *grpcMethod != "" {
+		if url, bodyAll, err = grpcCall(url, *grpcMethod, *protoFile, bodyAll); err != nil {
+			usageAndExit("-grpc: " + err.Error())
+		}
+		method = "POST"
+		header.Set("Content-Type", "application/grpc")
+		header.Set("TE", "trailers")
+	}
+
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
 		usageAndExit(err.Error())
 	}
 	req.ContentLength = int64(len(bodyAll))
+	if *websocket != (req.URL.Scheme == "ws" || req.URL.Scheme == "wss") {
+		usageAndExit("-ws requires a ws:// or wss:// url and such urls require -ws.")
+	}
 	if username != "" || password != "" {
 		req.SetBasicAuth(username, password)
 	}
@@ -212,35 +1106,530 @@ func main() {
 	header.Set("User-Agent", ua)
 	req.Header = header
 
+	if *tlsInfo && req.URL.Scheme == "https" && !validateOnly {
+		name := req.Host
+		if *serverName != "" {
+			name = *serverName
+		}
+		if err := tlsPreflight(os.Stderr, req.URL, name, *h2 || *grpcMethod != "", *tlsWarn, clientTLS, resolve); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+
+	var groups []*requester.RequestGroup
+	var stepClient *http.Client
+	if scen != nil {
+		if groups, err = scen.requestGroups(req, bodyAll); err != nil {
+			usageAndExit(err.Error())
+		}
+		stepClient = &http.Client{
+			Timeout: time.Duration(*t) * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: clientTLS,
+				Proxy:           http.ProxyURL(proxyURL),
+				DialContext:     resolvedDialer(resolve),
+			},
+		}
+	}
+
+	if len(targets) > 1 {
+		if groups, err = targetGroups(req, bodyAll, targets); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
+	if *rwRatio != "" {
+		if groups, err = rwGroups(req, bodyAll, method, *rwRatio); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	var schedule []time.Duration
+	if replay != nil {
+		if groups, schedule, err = replayGroups(req, bodyAll, replay, *replaySpeed); err != nil {
+			usageAndExit("-replay: " + err.Error())
+		}
+	}
+
+	// think holds the think time of -think, for the requests and the
+	// groups without one of their own.
+	var think requester.RequestGroup
+	if *thinkTime != "" {
+		if *rps > 0 {
+			usageAndExit("-think cannot be used with -rps.")
+		}
+		if err := parseThinkTime(*thinkTime, &think); err != nil {
+			usageAndExit("-think: " + err.Error())
+		}
+		for _, g := range groups {
+			if g.ThinkTime == 0 && g.ThinkDist == requester.ThinkFixed {
+				g.ThinkTime, g.ThinkDist, g.ThinkMax = think.ThinkTime, think.ThinkDist, think.ThinkMax
+			}
+		}
+	}
+
+	var expectHeaders []requester.HeaderExpectation
+	for _, h := range expectHs {
+		match, err := parseInputWithRegexp(h, headerRegexp)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		re, err := regexp.Compile("^(?:" + match[2] + ")$")
+		if err != nil {
+			usageAndExit("-expect-header: " + err.Error())
+		}
+		expectHeaders = append(expectHeaders, requester.HeaderExpectation{Name: match[1], Value: re, Text: h})
+	}
+	var scrape *requester.Scrape
+	if *scrapeURL != "" {
+		if *scrapeMetrics == "" {
+			usageAndExit("-scrape-url requires -scrape-metrics.")
+		}
+		if *scrapeInterval <= 0 {
+			usageAndExit("-scrape-interval must be positive.")
+		}
+		scrape = &requester.Scrape{URL: *scrapeURL, Interval: *scrapeInterval}
+		for _, name := range strings.Split(*scrapeMetrics, ",") {
+			scrape.Metrics = append(scrape.Metrics, strings.TrimSpace(name))
+		}
+	}
+	if *saveErrors != "" {
+		if err := os.MkdirAll(*saveErrors, 0755); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	if *maxSaved < 0 {
+		usageAndExit("-max-saved cannot be negative.")
+	}
+
+	var expectStatus []int
+	if *assertStatus != "" {
+		for _, s := range strings.Split(*assertStatus, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || code < 100 || code > 999 {
+				usageAndExit(fmt.Sprintf("-assert-status: invalid status code %q", s))
+			}
+			expectStatus = append(expectStatus, code)
+		}
+	}
+	var expectBody []*regexp.Regexp
+	for _, s := range bodyRegexps {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			usageAndExit("-assert-body-regex: " + err.Error())
+		}
+		expectBody = append(expectBody, re)
+	}
+
+	var data *requester.Data
+	if *dataFile != "" {
+		if data, err = readData(*dataFile); err != nil {
+			errAndExit(err.Error())
+		}
+		data.Random = *dataRandom
+	}
+
+	var setup []requester.SetupStep
+	if scen != nil {
+		if setup, err = workerSetup(scen.WorkerSetup, req, bodyAll); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	if *setupURL != "" {
+		g, err := flagSetupStep(*setupURL, *setupBody, setupHs, setupCaptures)
+		if err != nil {
+			usageAndExit("-setup: " + err.Error())
+		}
+		steps, err := workerSetup([]scenarioGroup{g}, req, nil)
+		if err != nil {
+			usageAndExit("-setup: " + err.Error())
+		}
+		setup = append(setup, steps...)
+	}
+	// URLs, bodies and header values with placeholders are templates.
+	templates := false
+	names := requester.TemplateNames{Setup: captureNames(setup)}
+	if data != nil {
+		names.Columns = data.Columns
+	}
+	for _, g := range groups {
+		for name := range g.Capture {
+			names.Captures = append(names.Captures, name)
+		}
+	}
+	if len(names.Captures) > 0 && *rps > 0 {
+		usageAndExit("Groups that capture values cannot be used with -rps.")
+	}
+	checkGroups := groups
+	if checkGroups == nil {
+		checkGroups = []*requester.RequestGroup{{Request: req, RequestBody: bodyAll}}
+	}
+	for _, g := range checkGroups {
+		texts := []string{g.Request.URL.Path, g.Request.URL.RawPath, g.Request.URL.RawQuery}
+		if *grpcMethod == "" {
+			// gRPC messages are binary.
+			texts = append(texts, string(g.RequestBody))
+		}
+		for _, vs := range g.Request.Header {
+			texts = append(texts, vs...)
+		}
+		for _, s := range texts {
+			if !strings.Contains(s, "{{") {
+				continue
+			}
+			if err := requester.CheckTemplate(s, names); err != nil {
+				usageAndExit(err.Error())
+			}
+			templates = true
+		}
+	}
+	if validateOnly {
+		if err := validateRun(os.Stdout, scen, req, bodyAll, setup, checkGroups, names, data); err != nil {
+			errAndExit(err.Error())
+		}
+		return
+	}
+
+	var cleanupOut *os.File
+	var cleanupW *cleanupWriter
+	if *cleanupFile != "" {
+		if cleanupOut, err = os.Create(*cleanupFile); err != nil {
+			errAndExit(err.Error())
+		}
+		cleanupW = &cleanupWriter{w: cleanupOut}
+		for i, g := range scen.Groups {
+			if g.Extract != nil {
+				groups[i].Extract = cleanupW.extractor(g, groups[i].Request.URL)
+			}
+		}
+	}
+
+	var hooks *hookRunner
+	if len(hookFlags) > 0 {
+		hooks = &hookRunner{host: *hookSSH}
+		for _, s := range hookFlags {
+			h, err := parseHook(s)
+			if err != nil {
+				usageAndExit("-hook: " + err.Error())
+			}
+			hooks.hooks = append(hooks.hooks, h)
+		}
+	} else if *hookSSH != "" {
+		usageAndExit("-hook-ssh requires -hook.")
+	}
+
+	var slos []report.SLO
+	if scen != nil {
+		slos = scen.serviceSLOs
+	}
+	man := newManifest(url, bodyAll, hs)
+	if *manifestFile != "" {
+		if err := man.writeFile(*manifestFile); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+
+	var userAgents []string
+	if *uaFile != "" {
+		if userAgents, err = readLines(*uaFile); err != nil {
+			errAndExit(err.Error())
+		}
+		if len(userAgents) == 0 {
+			usageAndExit("-ua-file contains no user agents.")
+		}
+	}
+
+	var sign func(*http.Request, []byte) (http.Header, error)
+	if *signCmd != "" {
+		if sign, err = requester.CommandSigner(*signCmd); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+
 	w := &requester.Work{
-		Request:            req,
-		RequestBody:        bodyAll,
-		N:                  num,
-		C:                  conc,
-		QPS:                q,
-		Timeout:            *t,
-		DisableCompression: *disableCompression,
-		DisableKeepAlives:  *disableKeepAlives,
-		DisableRedirects:   *disableRedirects,
-		H2:                 *h2,
-		ProxyAddr:          proxyURL,
-		Output:             *output,
-	}
-	w.Init()
+		Request:                req,
+		Groups:                 groups,
+		Templates:              templates,
+		Data:                   data,
+		Setup:                  setup,
+		IsolateGroups:          *isolate,
+		Sequential:             scen != nil && scen.Sequential,
+		SLOs:                   slos,
+		WebSocket:              *websocket,
+		Sign:                   sign,
+		UserAgents:             userAgents,
+		RequestBody:            bodyAll,
+		N:                      num,
+		C:                      conc,
+		Adjustable:             *configFile != "",
+		Region:                 *region,
+		QPS:                    q,
+		Rate:                   *rps,
+		Schedule:               schedule,
+		ThinkTime:              think.ThinkTime,
+		ThinkDist:              think.ThinkDist,
+		ThinkMax:               think.ThinkMax,
+		Timeout:                *t,
+		DisableCompression:     *disableCompression,
+		VerifyCompression:      *verifyCompression,
+		ExpectHeaders:          expectHeaders,
+		ExpectStatus:           expectStatus,
+		ExpectBodyContains:     bodyContains,
+		ExpectBodyRegexps:      expectBody,
+		SaveErrors:             *saveErrors,
+		Scrape:                 scrape,
+		MaxSaved:               *maxSaved,
+		DisableKeepAlives:      *disableKeepAlives,
+		EnableCookies:          *enableCookies,
+		DisableRedirects:       *disableRedirects,
+		DisableTLSResumption:   *disableTLSResume,
+		TLSVerifyTiming:        *tlsVerifyTiming,
+		ECHConfigList:          echConfigList,
+		Certificates:           clientTLS.Certificates,
+		CertFile:               *certFile,
+		KeyFile:                *keyFile,
+		RootCAs:                clientTLS.RootCAs,
+		InsecureSkipVerify:     *insecure,
+		ServerName:             *serverName,
+		Resolve:                resolve,
+		Network:                network,
+		DNSChaos:               chaos,
+		RedirectChain:          *redirectChain,
+		RespectRetryAfter:      *respectRetryAfter,
+		StreamStats:            *streamStats,
+		SecurityHeaders:        *securityHeaders,
+		StallThreshold:         *stallThreshold,
+		CircuitBreakerFailures: *cbFailures,
+		CircuitBreakerCooldown: *cbCooldown,
+		CancelRate:             cancels,
+		CancelAfter:            *cancelAfter,
+		FuzzRate:               fuzz,
+		HeaderOrder:            headerOrder,
+		H2:                     *h2,
+		GRPC:                   *grpcMethod != "",
+		Pipeline:               *pipeline,
+		ProxyAddr:              proxyURL,
+		Output:                 *output,
+		SavePartial:            *savePartial,
+		Manifest:               man,
+		CSVFields:              fields,
+		LatencyUnit:            *latencyUnit,
+		QuantileEngine:         *quantileEngine,
+		TDigestCompression:     *tdigestComp,
+		HDRPrecision:           *hdrPrecision,
+		HistogramBuckets:       buckets,
+		HistogramMarks:         marks,
+		SpoolDir:               *spoolDir,
+		WorkerStats:            *workerStats,
+	}
+	var out *requester.RotatingFile
+	if *outputFile != "" {
+		out = &requester.RotatingFile{
+			Path:       *outputFile,
+			MaxSize:    maxSize,
+			MaxAge:     *rotateInterval,
+			Compress:   *gzipOutput,
+			KeepHeader: *output == "csv",
+		}
+		w.Writer = out
+	}
+	if *metricsAddr != "" {
+		w.Metrics = report.NewMetrics()
+		if err := serveMetrics(*metricsAddr, w.Metrics); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+
+	if *progressJSON {
+		w.Progress = report.NewJSONProgress(os.Stderr, time.Second)
+	} else if !*quiet {
+		if isTerminal(os.Stderr) {
+			w.Progress = report.NewProgress(os.Stderr, time.Second, true)
+		} else {
+			w.Progress = report.NewProgress(os.Stderr, 10*time.Second, false)
+		}
+	}
+
+	if *streamResults != "" {
+		// TLS to the collector uses -cacert, -k and -cert like the
+		// targets, but its own server name.
+		conf := clientTLS.Clone()
+		conf.ServerName = ""
+		if w.Collector, err = report.DialCollector(*streamResults, conf); err != nil {
+			usageAndExit("-stream-results: " + err.Error())
+		}
+	}
+	if events != nil {
+		runID := newRunID()
+		if w.Collector != nil {
+			runID = w.Collector.RunID
+		}
+		w.Lifecycle = report.NewLifecycle(runID, report.Limits{P95: limits.p95, P99: limits.p99, ErrorRate: limits.errorRate}, events.sinks...)
+		w.Lifecycle.Emit(report.LifecycleRunStarted, url)
+	}
+
+	var record *requester.RotatingFile
+	if *recordFile != "" {
+		record = &requester.RotatingFile{
+			Path:     *recordFile,
+			MaxSize:  maxSize,
+			MaxAge:   *rotateInterval,
+			Compress: *gzipOutput,
+			// Every file starts with the header line of the record.
+			KeepHeader: true,
+		}
+		if err := record.Open(); err != nil {
+			errAndExit(err.Error())
+		}
+		// The lines of a rotated or gzipped record are left
+		// uncompressed, so that files end at a line and are
+		// compressed as a whole.
+		compress := maxSize == 0 && *rotateInterval == 0 && !*gzipOutput
+		var err error
+		if w.Record, err = report.NewRecordWriter(record, compress); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	if hooks != nil {
+		err := hooks.runAll("pre")
+		man.Hooks = hooks.results()
+		if err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	if w.Lifecycle != nil {
+		w.Lifecycle.Phase("setup")
+	}
+	if scen != nil {
+		if err := runSteps(stepClient, scen.Setup, req, bodyAll); err != nil {
+			// Undo what the setup did so far.
+			runSteps(stepClient, scen.Teardown, req, bodyAll)
+			abortEvents(w.Lifecycle, events, "setup failed: "+err.Error())
+			errAndExit("Setup failed: " + err.Error())
+		}
+	}
+	if err := w.RunSetup(); err != nil {
+		if scen != nil {
+			runSteps(stepClient, scen.Teardown, req, bodyAll)
+		}
+		abortEvents(w.Lifecycle, events, "worker setup failed: "+err.Error())
+		errAndExit("Worker setup failed: " + err.Error())
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		<-c
-		w.Stop()
+		w.Abort()
 	}()
+	if *configFile != "" {
+		go reloadOnSignal(w, *configFile, cmdline)
+	}
 	if dur > 0 {
 		go func() {
 			time.Sleep(dur)
 			w.Stop()
 		}()
 	}
+	if hooks != nil {
+		hooks.start()
+	}
+	if w.Lifecycle != nil {
+		w.Lifecycle.Phase("run")
+	}
 	w.Run()
+	if w.Collector != nil {
+		if dropped, err := w.Collector.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: streaming the results to %s failed: %v\n", *streamResults, err)
+		} else if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %d results were not streamed to %s, it fell behind.\n", dropped, *streamResults)
+		}
+	}
+	if scen != nil {
+		if w.Lifecycle != nil {
+			w.Lifecycle.Phase("teardown")
+		}
+		if err := runSteps(stepClient, scen.Teardown, req, bodyAll); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: teardown failed: %v\n", err)
+		}
+	}
+	if hooks != nil {
+		hooks.wait()
+		for _, run := range hooks.results() {
+			if run.Error != "" && run.When != "pre" {
+				fmt.Fprintf(os.Stderr, "Warning: %s hook %q failed: %s\n", run.When, run.Command, run.Error)
+			}
+		}
+		if err := hooks.runAll("post"); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		man.Hooks = hooks.results()
+		if *manifestFile != "" {
+			if err := man.writeFile(*manifestFile); err != nil {
+				errAndExit(err.Error())
+			}
+		}
+	}
+	if n, err := w.SavedErrors(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save all failing responses to %s: %v\n", *saveErrors, err)
+	} else if n > 0 {
+		fmt.Fprintf(os.Stderr, "Saved %d failing responses to %s.\n", n, *saveErrors)
+	}
+	if chaos != nil {
+		failed, flapped := w.DNSFaults()
+		fmt.Fprintf(os.Stderr, "Injected %d DNS lookup failures and %d flaps.\n", failed, flapped)
+	}
+	if rate := w.Report().Rate; rate != nil && !rate.Sustained && *output != "" {
+		fmt.Fprintf(os.Stderr, "Warning: achieved %4.4f of the requested %4.4f requests/sec.\n", rate.Achieved, rate.Target)
+	}
+	if cal := loadCalibration(); cal != nil && q == 0 {
+		if rps := w.Report().Rps; cal.clientLimited(rps) {
+			fmt.Fprintf(os.Stderr, "Warning: %4.4f requests/sec is %.0f%% of the calibrated maximum of this machine (%4.4f, see hey calibrate); the results are likely limited by the client.\n", rps, 100*rps/cal.Rps, cal.Rps)
+		}
+	}
+	if cleanupOut != nil {
+		if err := cleanupOut.Close(); cleanupW.err == nil {
+			cleanupW.err = err
+		}
+		if cleanupW.err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write all created resources to %s: %v\n", *cleanupFile, cleanupW.err)
+		}
+	}
+	if record != nil {
+		if err := record.Close(); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	if out != nil {
+		if err := out.Close(); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	violations := limits.check(w.Report())
+	if scen != nil {
+		violations = append(violations, checkClasses(scen.classLimits, w.Report())...)
+	}
+	violations = append(violations, checkSLOs(w.Report())...)
+	if w.Lifecycle != nil {
+		rep := w.Report()
+		w.Lifecycle.Finish(report.LifecycleSummary{
+			Requests:   int(rep.NumRes),
+			Errors:     rep.Failures(),
+			Rps:        rep.Rps,
+			P95:        rep.Percentile(95),
+			P99:        rep.Percentile(99),
+			Aborted:    rep.Aborted,
+			Violations: violations,
+		})
+		for _, err := range events.close() {
+			fmt.Fprintf(os.Stderr, "Warning: sending the lifecycle events to %v\n", err)
+		}
+	}
+	if len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, "Threshold violated: %s.\n", v)
+		}
+		os.Exit(exitThresholds)
+	}
 }
 
 func errAndExit(msg string) {
@@ -268,6 +1657,167 @@ func parseInputWithRegexp(input, regx string) ([]string, error) {
 	return matches, nil
 }
 
+// reloadOnSignal adjusts the load of w to the q, c and H options of the
+// -config file name every time hey receives SIGHUP, see loadAdjustment.
+func reloadOnSignal(w *requester.Work, name string, cmdline map[string]bool) {
+	c := make(chan os.Signal, 1)
+	notifyReload(c)
+	// The headers of the file the run started with.
+	base, _ := loadAdjustment(name, cmdline, nil)
+	prev := base.Header
+	for range c {
+		a, err := loadAdjustment(name, cmdline, prev)
+		if err == nil {
+			err = w.Adjust(a)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: reloading %s failed: %v\n", name, err)
+			continue
+		}
+		if a.Header != nil {
+			prev = a.Header
+		}
+		fmt.Fprintf(os.Stderr, "Reloaded q, c and H of %s.\n", name)
+	}
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// serveMetrics serves m on the /metrics path of addr in the background.
+func serveMetrics(addr string, m http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// readLines returns the non-empty lines of the named file that are not
+// comments starting with "#".
+func readLines(name string) ([]string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+// readData reads the named CSV file. The first record holds the names of
+// the columns, every other record is a row.
+func readData(name string) (*requester.Data, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	// Short rows fail the requests that use them, see requester.Data.
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s: want a header record and at least one row", name)
+	}
+	return &requester.Data{Columns: records[0], Rows: records[1:]}, nil
+}
+
+// parseHistogramBuckets parses -histogram-buckets, either a number of
+// buckets or a comma-separated list of their upper bounds.
+func parseHistogramBuckets(s string) (n int, marks []float64, err error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return 0, nil, fmt.Errorf("-histogram-buckets must be positive")
+		}
+		return n, nil, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(f))
+		if err != nil || d <= 0 {
+			return 0, nil, fmt.Errorf("-histogram-buckets: invalid bound %q", f)
+		}
+		if len(marks) > 0 && d.Seconds() <= marks[len(marks)-1] {
+			return 0, nil, fmt.Errorf("-histogram-buckets: bounds must increase")
+		}
+		marks = append(marks, d.Seconds())
+	}
+	return 0, marks, nil
+}
+
+// setNoCache sets the headers of -no-cache in h. The X-Cache-Bust
+// template makes every request distinct to caches that ignore the
+// others.
+func setNoCache(h http.Header) {
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Pragma", "no-cache")
+	h.Set("X-Cache-Bust", "{{uuid}}")
+}
+
+// canonicalMethod returns the standard HTTP method matching s in any
+// case, like GET for get, or s itself. Methods are case-sensitive, so
+// other methods, such as PURGE or a custom verb, are sent as given.
+func canonicalMethod(s string) string {
+	for _, m := range []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect,
+		http.MethodOptions, http.MethodTrace,
+	} {
+		if strings.EqualFold(s, m) {
+			return m
+		}
+	}
+	return s
+}
+
+// parseSize parses a byte size such as "512", "10KB", "100MB" or "2GB".
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"B", 1},
+	}
+	num, mult := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("could not parse the provided size; input = %v", s)
+	}
+	return v * mult, nil
+}
+
+// isFlagSet reports whether the flag name is set on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
 type headerSlice []string
 
 func (h *headerSlice) String() string {
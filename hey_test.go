@@ -15,7 +15,34 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+	"github.com/rakyll/hey/requester/report"
 )
 
 func TestParseValidHeaderFlag(t *testing.T) {
@@ -64,3 +91,1949 @@ func TestParseAuthMetaCharacters(t *testing.T) {
 		t.Errorf("Auth header with a plus sign in the user name errored: %v", err)
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"10KB", 10 << 10},
+		{"100mb", 100 << 20},
+		{"2 GB", 2 << 30},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil {
+			t.Errorf("parseSize(%q) errored: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+	if _, err := parseSize("lots"); err == nil {
+		t.Errorf("parseSize(lots) did not error")
+	}
+}
+
+func TestTLSPreflight(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	var buf bytes.Buffer
+	if err := tlsPreflight(&buf, u, "example.com", false, 100*365*24*time.Hour, &tls.Config{InsecureSkipVerify: true}, nil); err != nil {
+		t.Fatalf("tlsPreflight errored: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"TLS 1.3", "Certificate chain:", "expires in less than"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("tlsPreflight output does not contain %q:\n%v", want, out)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	defer flag.Set("a", "")
+	flag.Set("a", "user:secret")
+	m := newManifest("http://example.com", []byte("body"), []string{"X-A: 1"})
+	if got, want := m.Flags["a"], "user:REDACTED"; got != want {
+		t.Errorf("a = %q; want %q", got, want)
+	}
+	if got, want := m.Flags["c"], "50"; got != want {
+		t.Errorf("c = %q; want %q", got, want)
+	}
+	if got, want := m.BodySHA256, "230d8358dc8e8890b4c58deeb62912ee2f20357ae92a5cc861b98e68fe31acb5"; got != want {
+		t.Errorf("body hash = %q; want %q", got, want)
+	}
+	if len(m.Headers) != 1 || m.Version != version {
+		t.Errorf("manifest = %+v", m)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		err  bool
+	}{
+		{"1%", 0.01, false},
+		{"0.25", 0.25, false},
+		{"100%", 1, false},
+		{"0", 0, false},
+		{"150%", 0, true},
+		{"-1", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseRate(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestTestServer(t *testing.T) {
+	var next float64
+	s := &testServer{
+		body:        []byte("hello"),
+		errorRate:   0.5,
+		errorStatus: http.StatusServiceUnavailable,
+		rand:        func() float64 { return next },
+	}
+	for _, tt := range []struct {
+		rand float64
+		code int
+		body string
+	}{
+		{0.9, http.StatusOK, "hello"},
+		{0.1, http.StatusServiceUnavailable, ""},
+	} {
+		next = tt.rand
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != tt.code {
+			t.Errorf("rand %v: status = %d; want %d", tt.rand, rec.Code, tt.code)
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("rand %v: body = %q; want %q", tt.rand, rec.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestExpandSecrets(t *testing.T) {
+	f, err := ioutil.TempFile("", "hey-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("filetoken\n")
+	f.Close()
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/app" || r.Header.Get("X-Vault-Token") != "root" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"token":"vaulttoken"},"metadata":{}}}`))
+	}))
+	defer vault.Close()
+
+	for k, v := range map[string]string{"HEY_SECRET": "envtoken", "VAULT_ADDR": vault.URL, "VAULT_TOKEN": "root"} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"Bearer plain", "Bearer plain", false},
+		{"Bearer @file:" + f.Name(), "Bearer filetoken", false},
+		{"Bearer @env:HEY_SECRET", "Bearer envtoken", false},
+		{"Bearer @vault:secret/data/app#token", "Bearer vaulttoken", false},
+		{"@env:HEY_SECRET:@env:HEY_SECRET", "envtoken:envtoken", false},
+		{"Bearer @env:HEY_UNSET_SECRET", "", true},
+		{"Bearer @vault:secret/data/app#missing", "", true},
+		{"Bearer @vault:secret/data/other#token", "", true},
+	}
+	for _, tt := range tests {
+		got, err := expandSecrets(tt.in)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("expandSecrets(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestScenario(t *testing.T) {
+	f, err := ioutil.TempFile("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"groups": [
+		{"name": "browse", "url": "http://example.com/items", "think_time": "2s"},
+		{"method": "post", "url": "http://example.com/cart", "headers": {"Content-Type": "application/json"}, "body": "{}"}
+	]}`)
+	f.Close()
+
+	s, err := loadScenario(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := http.NewRequest("GET", "http://example.com", nil)
+	base.Header.Set("Content-Type", "text/html")
+	base.Header.Set("X-Token", "t")
+	groups, err := s.requestGroups(base, []byte("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups; want 2", len(groups))
+	}
+	browse, buy := groups[0], groups[1]
+	if browse.Name != "browse" || browse.Request.Method != "GET" || string(browse.RequestBody) != "default" || browse.ThinkTime != 2*time.Second {
+		t.Errorf("browse = %+v", browse)
+	}
+	if buy.Name != "group2" || buy.Request.Method != "POST" || string(buy.RequestBody) != "{}" || buy.ThinkTime != 0 {
+		t.Errorf("buy = %+v", buy)
+	}
+	if got := buy.Request.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", got)
+	}
+	if got := buy.Request.Header.Get("X-Token"); got != "t" {
+		t.Errorf("X-Token = %q; want t", got)
+	}
+	if base.Header.Get("Content-Type") != "text/html" {
+		t.Errorf("the headers of the base request were changed")
+	}
+}
+
+func TestScenarioYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "scenario.yml")
+	ioutil.WriteFile(name, []byte(`groups:
+- name: browse
+  url: "http://example.com/items"
+  think_time: 2s
+  weight: 9
+  class: critical
+- method: post
+  url: "http://example.com/cart"
+  headers:
+    Content-Type: application/json
+  body: |
+    {"sku": 1}
+slos:
+  critical: {"p99": "1s"}
+`), 0644)
+
+	s, err := loadScenario(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Groups) != 2 {
+		t.Fatalf("got %d groups; want 2", len(s.Groups))
+	}
+	browse, buy := s.Groups[0], s.Groups[1]
+	if browse.Name != "browse" || browse.URL != "http://example.com/items" || browse.ThinkTime != "2s" || browse.Weight != 9 || browse.Class != "critical" {
+		t.Errorf("browse = %+v", browse)
+	}
+	if buy.Method != "post" || buy.Headers["Content-Type"] != "application/json" || buy.Body != "{\"sku\": 1}\n" {
+		t.Errorf("buy = %+v", buy)
+	}
+	if slo := s.SLOs["critical"]; slo.P99 != "1s" {
+		t.Errorf("slos = %+v; want a p99 of 1s for critical", s.SLOs)
+	}
+
+	ioutil.WriteFile(name, []byte("groups:\n- url: x\n  weight: heavy\n"), 0644)
+	if _, err := loadScenario(name); err == nil {
+		t.Errorf("loadScenario with a string weight did not error")
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.Path+" "+string(body))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	base, _ := http.NewRequest("GET", server.URL, nil)
+	steps := []scenarioGroup{
+		{Name: "create", Method: "POST", URL: server.URL + "/rooms", Body: "room"},
+		{Name: "fail", URL: server.URL + "/fail"},
+		{Name: "never", URL: server.URL + "/never"},
+	}
+	err := runSteps(http.DefaultClient, steps, base, nil)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("err = %v; want the 500 of the failed step", err)
+	}
+	want := []string{"POST /rooms room", "GET /fail "}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q; want %q", got, want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	res := &http.Response{StatusCode: 201, Header: http.Header{"Location": {"/rooms/7"}}}
+	body := []byte(`{"data": {"id": 42, "items": [{"id": "a b"}]}}`)
+	tests := []struct {
+		e    scenarioExtract
+		want string
+		ok   bool
+	}{
+		{scenarioExtract{Header: "Location"}, "/rooms/7", true},
+		{scenarioExtract{JSON: "data.id"}, "42", true},
+		{scenarioExtract{JSON: "data.items.0.id"}, "a b", true},
+		{scenarioExtract{JSON: "data.items.1.id"}, "", false},
+		{scenarioExtract{JSON: "data"}, "", false},
+		{scenarioExtract{Header: "X-Id"}, "", false},
+		{scenarioExtract{JSON: "$.data.id"}, "42", true},
+		{scenarioExtract{Regex: `"id": "([^"]+)"`}, "a b", true},
+		{scenarioExtract{Regex: `[0-9]+`}, "42", true},
+		{scenarioExtract{Regex: `"name": "([^"]+)"`}, "", false},
+	}
+	for _, tt := range tests {
+		if err := tt.e.compile(); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := tt.e.value(res, body)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%+v: got %q, %v; want %q, %v", tt.e, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	reqURL, _ := url.Parse("https://api.example.com/rooms")
+	var out bytes.Buffer
+	cw := &cleanupWriter{w: &out}
+	cw.extractor(scenarioGroup{Extract: &scenarioExtract{Header: "Location"}}, reqURL)(res, nil)
+	cw.extractor(scenarioGroup{Extract: &scenarioExtract{JSON: "data.items.0.id"}, CleanupURL: "https://api.example.com/items/{id}"}, reqURL)(res, body)
+	res.StatusCode = 500
+	cw.extractor(scenarioGroup{Extract: &scenarioExtract{Header: "Location"}}, reqURL)(res, nil)
+	want := "https://api.example.com/rooms/7\nhttps://api.example.com/items/a%20b\n"
+	if out.String() != want {
+		t.Errorf("cleanup file = %q; want %q", out.String(), want)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" || r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	urls := []string{server.URL + "/a", server.URL + "/gone", server.URL + "/fail"}
+	var errs bytes.Buffer
+	failed := cleanup(http.DefaultClient, urls, http.Header{"Authorization": {"token"}}, 2, &errs)
+	if failed != 1 || !strings.Contains(errs.String(), "/fail: 500") {
+		t.Errorf("failed = %d, errors %q; want 1 for /fail", failed, errs.String())
+	}
+}
+
+func TestRWGroups(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	req.Header.Set("Content-Type", "application/json")
+	groups, err := rwGroups(req, []byte("{}"), "GET", "90:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reads, writes int
+	for _, g := range groups {
+		switch {
+		case g.Name == "read" && g.Request.Method == "GET" && g.RequestBody == nil && g.Request.Header.Get("Content-Type") == "":
+			reads++
+		case g.Name == "write" && g.Request.Method == "POST" && string(g.RequestBody) == "{}":
+			writes++
+		default:
+			t.Errorf("unexpected group %+v", g)
+		}
+	}
+	if reads != 9 || writes != 1 {
+		t.Errorf("%d reads, %d writes; want 9, 1", reads, writes)
+	}
+	for _, bad := range []string{"90", "a:b", "0:0", "-1:2", "1:2:3"} {
+		if _, _, err := parseRatio(bad); err == nil {
+			t.Errorf("parseRatio(%q) did not error", bad)
+		}
+	}
+	if r, w, _ := parseRatio("0:5"); r != 0 || w != 1 {
+		t.Errorf("parseRatio(0:5) = %d, %d; want 0, 1", r, w)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	f, err := ioutil.TempFile("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# api\nhttp://example.com/search 6\n\nhttp://other.example.com/items 2\n")
+	f.Close()
+
+	targets, err := readTargets(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets = append(targets, target{url: "http://example.com/", weight: 2})
+	req, _ := http.NewRequest("POST", targets[0].url, nil)
+	groups, err := targetGroups(req, []byte("{}"), targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, g := range groups {
+		if g.Name != g.Request.URL.String() || g.Request.Host != g.Request.URL.Host || g.Request.Method != "POST" || string(g.RequestBody) != "{}" {
+			t.Errorf("unexpected group %+v", g)
+		}
+		counts[g.Name]++
+	}
+	want := map[string]int{
+		"http://example.com/search":      3,
+		"http://other.example.com/items": 1,
+		"http://example.com/":            1,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("groups = %v; want %v", counts, want)
+	}
+
+	for _, bad := range []string{"http://example.com 0\n", "http://example.com x\n", "http://example.com 1 2\n", "# none\n"} {
+		ioutil.WriteFile(f.Name(), []byte(bad), 0644)
+		if _, err := readTargets(f.Name()); err == nil {
+			t.Errorf("readTargets(%q) did not error", bad)
+		}
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	rep := report.Report{
+		NumRes:              100,
+		Slowest:             0.9,
+		LatencyDistribution: []report.LatencyDistribution{{Percentage: 95, Latency: 0.2}},
+		ErrorDist:           map[string]int{"boom": 1},
+		StatusCodeDist:      map[int]int{200: 97, 404: 1, 503: 1},
+	}
+	tests := []struct {
+		limits thresholds
+		want   int
+	}{
+		{thresholds{errorRate: -1}, 0},
+		{thresholds{p95: 300 * time.Millisecond, p99: time.Second, errorRate: 0.02}, 0},
+		{thresholds{p95: 100 * time.Millisecond, errorRate: -1}, 1},
+		// The p99 is missing, the slowest latency is used instead.
+		{thresholds{p99: 500 * time.Millisecond, errorRate: -1}, 1},
+		// The error and the 503 are failures, the 404 is not.
+		{thresholds{errorRate: 0.01}, 1},
+		{thresholds{p95: time.Millisecond, p99: time.Millisecond, errorRate: 0}, 3},
+	}
+	for _, tt := range tests {
+		if got := tt.limits.check(rep); len(got) != tt.want {
+			t.Errorf("%+v: violations = %q; want %d", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestLoadHAR(t *testing.T) {
+	f, err := ioutil.TempFile("", "har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"log": {"entries": [
+		{"startedDateTime": "2024-01-01T00:00:00.000Z", "time": 100, "request": {"method": "GET", "url": "https://example.com/",
+			"headers": [{"name": ":authority", "value": "example.com"}, {"name": "cookie", "value": "a=1"}, {"name": "cookie", "value": "b=2"}, {"name": "Connection", "value": "keep-alive"}]}},
+		{"startedDateTime": "2024-01-01T00:00:01.100Z", "time": 50, "request": {"method": "POST", "url": "https://example.com/login",
+			"headers": [], "postData": {"mimeType": "application/x-www-form-urlencoded", "params": [{"name": "user", "value": "a b"}]}}},
+		{"startedDateTime": "2024-01-01T00:00:01.120Z", "time": 10, "request": {"method": "GET", "url": "data:image/png;base64,AA=="}},
+		{"startedDateTime": "2024-01-01T00:00:01.130Z", "time": 10, "request": {"method": "PUT", "url": "https://example.com/blob",
+			"headers": [{"name": "Content-Type", "value": "application/octet-stream"}], "postData": {"text": "AAE=", "encoding": "base64"}}}
+	]}}`)
+	f.Close()
+
+	s, err := loadHAR(f.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scenarioGroup{
+		{Name: "1 GET /", Method: "GET", URL: "https://example.com/", Headers: map[string]string{"Cookie": "a=1; b=2"}, ThinkTime: "1s"},
+		{Name: "2 POST /login", Method: "POST", URL: "https://example.com/login", Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, Body: "user=a+b"},
+		{Name: "3 PUT /blob", Method: "PUT", URL: "https://example.com/blob", Headers: map[string]string{"Content-Type": "application/octet-stream"}, Body: "\x00\x01"},
+	}
+	if !s.Sequential || !reflect.DeepEqual(s.Groups, want) {
+		t.Errorf("loadHAR = %+v; want sequential %+v", s.Groups, want)
+	}
+	if s, err = loadHAR(f.Name(), false); err != nil || s.Groups[0].ThinkTime != "" {
+		t.Errorf("loadHAR without timing = %+v, %v; want no think time", s, err)
+	}
+}
+
+func TestScenarioSLOs(t *testing.T) {
+	f, err := ioutil.TempFile("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"groups": [
+		{"name": "pay", "url": "http://example.com/pay", "class": "critical"},
+		{"name": "feed", "url": "http://example.com/feed", "class": "best-effort"}
+	], "slos": {"critical": {"p95": "100ms", "error_rate": "1%"}, "best-effort": {"p99": "2s"}},
+	"service_slos": [{"name": "checkout", "class": "critical", "metric": "p99", "target": "300ms"}, {"metric": "error_rate", "target": "0.1%"}]}`)
+	f.Close()
+
+	s, err := loadScenario(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := report.Report{Classes: []report.ClassStats{
+		{Class: "best-effort", Requests: 100, Failures: 50, P95: 1, P99: 1.5},
+		{Class: "critical", Requests: 100, Failures: 2, P95: 0.2, P99: 0.3},
+	}}
+	got := checkClasses(s.classLimits, rep)
+	want := []string{
+		"class critical: p95 latency 0.2000 secs exceeds 100ms",
+		"class critical: error rate 2.00% exceeds 1.00%",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkClasses = %q; want %q", got, want)
+	}
+
+	wantSLOs := []report.SLO{
+		{Name: "checkout", Class: "critical", Metric: "p99", Target: 0.3},
+		{Name: "error_rate", Metric: "error_rate", Target: 0.001},
+	}
+	if !reflect.DeepEqual(s.serviceSLOs, wantSLOs) {
+		t.Errorf("service slos = %+v; want %+v", s.serviceSLOs, wantSLOs)
+	}
+	rep.SLOs = []report.SLOResult{
+		{SLO: wantSLOs[0], Actual: 0.35, Margin: -0.05},
+		{SLO: wantSLOs[1], Actual: 0, Margin: 0.001, Pass: true},
+		{SLO: report.SLO{Name: "batch p95", Metric: "p95"}, NoData: true},
+	}
+	got = checkSLOs(rep)
+	want = []string{
+		"SLO checkout missed: p99 latency 0.3500 secs exceeds 300ms",
+		"SLO batch p95 has no requests to measure it on",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkSLOs = %q; want %q", got, want)
+	}
+
+	for _, slo := range []serviceSLO{
+		{Metric: "p42", Target: "1s"},
+		{Metric: "p99", Target: "1%"},
+		{Class: "batch", Metric: "p99", Target: "1s"},
+	} {
+		s := &scenario{Groups: []scenarioGroup{{Class: "critical"}}, ServiceSLOs: []serviceSLO{slo}}
+		if err := s.parseSLOs(); err == nil {
+			t.Errorf("parseSLOs with service slo %+v = nil error; want an error", slo)
+		}
+	}
+
+	s = &scenario{Groups: []scenarioGroup{{Class: "critical"}}, SLOs: map[string]scenarioSLO{"other": {P95: "1s"}}}
+	if err := s.parseSLOs(); err == nil {
+		t.Error("parseSLOs with a class no group has = nil error; want an error")
+	}
+	s.SLOs = map[string]scenarioSLO{"critical": {ErrorRate: "x"}}
+	if err := s.parseSLOs(); err == nil {
+		t.Error("parseSLOs with an invalid error_rate = nil error; want an error")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	f, err := ioutil.TempFile("", "access.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "GET /a?x=1 HTTP/1.1" 200 2326 "http://ref/" "Mozilla/5.0 (X11)"
+10.0.0.2 - - [10/Oct/2024:13:55:38 +0000] "POST /b%2Fc HTTP/1.1" 201 12 "-" "-"
+not a request
+10.0.0.3 - - [10/Oct/2024:13:55:37 +0000] "-" 400 0 "-" "-"
+10.0.0.1 - - [10/Oct/2024:13:55:35 +0000] "GET / HTTP/1.1" 200 2326 "-" "curl/8.0"
+`)
+	f.Close()
+
+	if _, _, err := readAccessLog(f.Name(), "json"); err == nil {
+		t.Error("readAccessLog in an unknown format = nil error; want an error")
+	}
+	entries, skipped, err := readAccessLog(f.Name(), "combined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || skipped != 2 {
+		t.Fatalf("readAccessLog = %d entries, %d skipped; want 3, 2", len(entries), skipped)
+	}
+	base, _ := http.NewRequest("GET", "https://staging:8443/api/", nil)
+	base.Header.Set("X-Token", "t")
+	groups, schedule, err := replayGroups(base, nil, entries, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, g := range groups {
+		r := g.Request
+		got = append(got, r.Method+" "+r.URL.String()+" "+r.Header.Get("X-Token")+"|"+r.UserAgent()+"|"+r.Referer())
+	}
+	want := []string{
+		"GET https://staging:8443/api/ t|curl/8.0|",
+		"GET https://staging:8443/api/a?x=1 t|Mozilla/5.0 (X11)|http://ref/",
+		"POST https://staging:8443/api/b%2Fc t||",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayGroups = %q; want %q", got, want)
+	}
+	if want := []time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond}; !reflect.DeepEqual(schedule, want) {
+		t.Errorf("schedule = %v; want %v", schedule, want)
+	}
+}
+
+func TestOpenRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string, compress bool) {
+		var buf bytes.Buffer
+		if compress {
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(content))
+			gz.Close()
+		} else {
+			buf.WriteString(content)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("r.gz", "# v1\na\n", true)
+	write("r.1.gz", "# v1\nb\n", true)
+	write("r.2.gz", "# v1\nc\n", true)
+	write("plain.gz", "# v1\nd\n", false)
+	write("rot", "# v1\ne\n", false)
+	write("rot.1", "# v1\nf\n", false)
+
+	tests := []struct {
+		name, want string
+	}{
+		{"r", "# v1\na\nb\nc\n"},
+		{"r.gz", "# v1\na\nb\nc\n"},
+		{"plain.gz", "# v1\nd\n"},
+		{"rot", "# v1\ne\nf\n"},
+	}
+	for _, tt := range tests {
+		r, err := openRecord(filepath.Join(dir, tt.name))
+		if err != nil {
+			t.Errorf("openRecord(%q) = %v", tt.name, err)
+			continue
+		}
+		got, _ := ioutil.ReadAll(r)
+		r.Close()
+		if string(got) != tt.want {
+			t.Errorf("openRecord(%q) reads %q; want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := openRecord(filepath.Join(dir, "missing")); err == nil {
+		t.Error("openRecord of a missing file = nil error; want an error")
+	}
+}
+
+func TestScenarioMetrics(t *testing.T) {
+	res := &http.Response{StatusCode: 201, Header: http.Header{"X-Items": {"3"}}}
+	body := []byte(`{"took_ms": 250}`)
+	metrics := metricsFunc([]scenarioMetric{
+		{Name: "created", Type: "counter", Status: "2xx"},
+		{Name: "items", Type: "counter", scenarioExtract: scenarioExtract{Header: "X-Items"}},
+		{Name: "took", Type: "timer", scenarioExtract: scenarioExtract{JSON: "took_ms"}},
+		{Name: "latency", Type: "timer"},
+		{Name: "failed", Type: "counter", Status: "500"},
+	})
+	got := metrics(res, body, 100*time.Millisecond)
+	want := []report.CustomMetric{
+		{Name: "created", Value: 1},
+		{Name: "items", Value: 3},
+		{Name: "took", Timer: true, Value: 0.25},
+		{Name: "latency", Timer: true, Value: 0.1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metrics = %+v; want %+v", got, want)
+	}
+
+	for _, m := range []scenarioMetric{
+		{Type: "counter"},
+		{Name: "a", Type: "gauge"},
+		{Name: "a", Type: "counter", Status: "6xx"},
+		{Name: "a", Type: "timer", scenarioExtract: scenarioExtract{Header: "X", JSON: "y"}},
+	} {
+		if err := m.check(); err == nil {
+			t.Errorf("check(%+v) = nil; want an error", m)
+		}
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	got, err := parseResolve([]string{"example.com:443:10.0.0.1", "example.com:80:[::1]"})
+	want := map[string]string{"example.com:443": "10.0.0.1", "example.com:80": "::1"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseResolve = %v, %v; want %v", got, err, want)
+	}
+	if addr := resolvedAddr(got, "example.com:80"); addr != "[::1]:80" {
+		t.Errorf("resolvedAddr = %q; want [::1]:80", addr)
+	}
+	if addr := resolvedAddr(got, "example.org:80"); addr != "example.org:80" {
+		t.Errorf("resolvedAddr = %q; want example.org:80 unchanged", addr)
+	}
+	for _, v := range []string{"example.com:443", "example.com:0:10.0.0.1", "example.com:443:host", ":443:10.0.0.1"} {
+		if _, err := parseResolve([]string{v}); err == nil {
+			t.Errorf("parseResolve(%q) = nil error; want an error", v)
+		}
+	}
+}
+
+func TestParseDNSChaos(t *testing.T) {
+	got, err := parseDNSChaos("fail=5%,flap=10%,to=10.0.0.2|[::1]")
+	want := &requester.DNSChaos{FailRate: 0.05, FlapRate: 0.1, Alternates: []string{"10.0.0.2", "::1"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDNSChaos = %+v, %v; want %+v", got, err, want)
+	}
+	for _, s := range []string{"fail", "fail=x", "flap=10%", "flap=5%,to=host", "fail=60%,flap=50%,to=10.0.0.2", "drop=1%"} {
+		if _, err := parseDNSChaos(s); err == nil {
+			t.Errorf("parseDNSChaos(%q) = nil error; want an error", s)
+		}
+	}
+}
+
+func TestParseThinkTime(t *testing.T) {
+	for s, want := range map[string]requester.RequestGroup{
+		"2s":             {ThinkTime: 2 * time.Second},
+		"uniform:1s-3s":  {ThinkTime: time.Second, ThinkDist: requester.ThinkUniform, ThinkMax: 3 * time.Second},
+		"exp:500ms":      {ThinkTime: 500 * time.Millisecond, ThinkDist: requester.ThinkExponential},
+		"uniform:0s-1ms": {ThinkDist: requester.ThinkUniform, ThinkMax: time.Millisecond},
+	} {
+		var g requester.RequestGroup
+		if err := parseThinkTime(s, &g); err != nil || g.ThinkTime != want.ThinkTime || g.ThinkDist != want.ThinkDist || g.ThinkMax != want.ThinkMax {
+			t.Errorf("parseThinkTime(%q) = %v, %v, %v, %v; want %v, %v, %v", s, g.ThinkTime, g.ThinkDist, g.ThinkMax, err, want.ThinkTime, want.ThinkDist, want.ThinkMax)
+		}
+	}
+	for _, s := range []string{"", "x", "-1s", "uniform:1s", "uniform:3s-1s", "normal:1s", "exp:-1s"} {
+		if err := parseThinkTime(s, &requester.RequestGroup{}); err == nil {
+			t.Errorf("parseThinkTime(%q) = nil error; want an error", s)
+		}
+	}
+}
+
+func TestParseHistogramBuckets(t *testing.T) {
+	if n, marks, err := parseHistogramBuckets("20"); n != 20 || marks != nil || err != nil {
+		t.Errorf("parseHistogramBuckets(20) = %v, %v, %v; want 20 buckets", n, marks, err)
+	}
+	if n, marks, err := parseHistogramBuckets("5ms, 1s"); n != 0 || !reflect.DeepEqual(marks, []float64{0.005, 1}) || err != nil {
+		t.Errorf("parseHistogramBuckets(5ms, 1s) = %v, %v, %v; want bounds 0.005 and 1", n, marks, err)
+	}
+	for _, s := range []string{"0", "1s,5ms", "5ms,x"} {
+		if _, _, err := parseHistogramBuckets(s); err == nil {
+			t.Errorf("parseHistogramBuckets(%q) = nil error; want an error", s)
+		}
+	}
+}
+
+func TestCanonicalMethod(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"get", "GET"},
+		{"Patch", "PATCH"},
+		{"PURGE", "PURGE"},
+		{"purge", "purge"},
+		{"REPORT", "REPORT"},
+	}
+	for _, tt := range tests {
+		if got := canonicalMethod(tt.in); got != tt.want {
+			t.Errorf("canonicalMethod(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	p, err := parseNetwork("LTE")
+	if err != nil || *p != networkPresets["lte"] {
+		t.Errorf("parseNetwork(LTE) = %+v, %v; want the lte preset", p, err)
+	}
+	p, err = parseNetwork("custom:rtt=80ms,jitter=20ms,loss=1%")
+	want := requester.NetworkProfile{RTT: 80 * time.Millisecond, Jitter: 20 * time.Millisecond, Loss: 0.01}
+	if err != nil || *p != want {
+		t.Errorf("parseNetwork(custom) = %+v, %v; want %+v", p, err, want)
+	}
+	for _, s := range []string{"5g", "custom:rtt", "custom:rtt=fast", "custom:loss=200%", "custom:mtu=1500", "custom:rtt=-1s"} {
+		if _, err := parseNetwork(s); err == nil {
+			t.Errorf("parseNetwork(%q) succeeded; want an error", s)
+		}
+	}
+}
+
+func TestWorkerSetup(t *testing.T) {
+	g, err := flagSetupStep("http://example.com/login", "user=ann", []string{"Content-Type: application/x-www-form-urlencoded"}, []string{"token=json:data.token", "sid=header:X-Session"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := http.NewRequest("GET", "http://example.com/", nil)
+	base.Header.Set("Authorization", "Bearer {{setup token}}")
+	base.Header.Set("Accept", "text/plain")
+	setup, err := workerSetup([]scenarioGroup{g}, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := setup[0]
+	if s.Request.Method != "POST" || string(s.RequestBody) != "user=ann" {
+		t.Errorf("setup request = %s with body %q; want a POST of user=ann", s.Request.Method, s.RequestBody)
+	}
+	want := http.Header{"Accept": {"text/plain"}, "Content-Type": {"application/x-www-form-urlencoded"}}
+	if !reflect.DeepEqual(s.Request.Header, want) {
+		t.Errorf("setup headers = %v; want %v", s.Request.Header, want)
+	}
+	res := &http.Response{Header: http.Header{"X-Session": {"s1"}}}
+	for name, want := range map[string]string{"token": "t1", "sid": "s1"} {
+		if v, ok := s.Capture[name](res, []byte(`{"data": {"token": "t1"}}`)); !ok || v != want {
+			t.Errorf("capture %s = %q, %v; want %q", name, v, ok, want)
+		}
+	}
+
+	for _, c := range []string{"token", "=json:token", "token=body:x", "token=json:"} {
+		if _, err := flagSetupStep("http://example.com/login", "", nil, []string{c}); err == nil {
+			t.Errorf("flagSetupStep with capture %q succeeded; want an error", c)
+		}
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	f, err := ioutil.TempFile("", "matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"args": ["-z", "1s", "http://example.com/"], "matrix": {"disable-keepalive": [false, true], "c": [10, 50]}, "cooldown": "5s"}`)
+	f.Close()
+
+	cfg, err := loadMatrix(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.cooldown != 5*time.Second {
+		t.Errorf("cooldown = %v; want 5s", cfg.cooldown)
+	}
+	var got []string
+	for _, run := range cfg.runs() {
+		var s []string
+		for _, p := range run {
+			s = append(s, p.Name+"="+p.Value)
+		}
+		got = append(got, strings.Join(s, " "))
+	}
+	want := []string{
+		"c=10 disable-keepalive=false",
+		"c=10 disable-keepalive=true",
+		"c=50 disable-keepalive=false",
+		"c=50 disable-keepalive=true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runs = %q; want %q", got, want)
+	}
+
+	for _, bad := range []string{
+		`{"args": ["http://example.com/"]}`,
+		`{"matrix": {"c": []}}`,
+		`{"matrix": {"o": ["csv"]}}`,
+		`{"args": ["-o", "csv"], "matrix": {"c": [1]}}`,
+		`{"matrix": {"c": [1]}, "cooldown": "x"}`,
+		`{"matrix": {"c": [1]}, "health": {}}`,
+		`{"matrix": {"c": [1]}, "health": {"url": "http://example.com/", "interval": "0s"}}`,
+	} {
+		ioutil.WriteFile(f.Name(), []byte(bad), 0644)
+		if _, err := loadMatrix(f.Name()); err == nil {
+			t.Errorf("loadMatrix(%s) did not error", bad)
+		}
+	}
+}
+
+func TestMatrixYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "matrix.yaml")
+	ioutil.WriteFile(name, []byte(`# Keep-alive against connection churn.
+args: [-z, 1s, "http://example.com/"]
+matrix:
+  c: [10, 50]
+  disable-keepalive:
+  - false
+  - true
+cooldown: 5s
+health:
+  url: "http://example.com/health"
+  interval: 2s
+`), 0644)
+
+	cfg, err := loadMatrix(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &matrixConfig{
+		Args:     []string{"-z", "1s", "http://example.com/"},
+		Matrix:   map[string][]interface{}{"c": {10.0, 50.0}, "disable-keepalive": {false, true}},
+		Cooldown: "5s",
+		Health:   &matrixHealth{URL: "http://example.com/health", Interval: "2s", interval: 2 * time.Second, timeout: time.Minute},
+		cooldown: 5 * time.Second,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("loadMatrix = %+v; want %+v", cfg, want)
+	}
+
+	ioutil.WriteFile(name, []byte("matrix:\n  c: [1]\n cooldown: 5s\n"), 0644)
+	if _, err := loadMatrix(name); err == nil || !strings.Contains(err.Error(), "matrix.yaml:3:") {
+		t.Errorf("loadMatrix with a misindented key: %v; want an error on line 3", err)
+	}
+}
+
+func TestReadYAML(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a: 1\nb: x # comment\nc: 'it''s'\nd: \"#1\"\ne: true\nf: ~\n", `{"a":1,"b":"x","c":"it's","d":"#1","e":true,"f":null}`},
+		{"- a\n- [1, \"2\",\n   three]\n- {\"k\": [1]}\n", `["a",[1,"2","three"],{"k":[1]}]`},
+		{"---\nitems:\n  - name: a\n    tags:\n    - x\n  - name: b\n    empty:\n", `{"items":[{"name":"a","tags":["x"]},{"empty":null,"name":"b"}]}`},
+		{"body: |\n  {\"a\": 1}\n\n  # kept\nnext: |-\n  x\n", `{"body":"{\"a\": 1}\n\n# kept\n","next":"x"}`},
+		{"url: http://example.com:8080/a\nurls: [http://a:1/]\n", `{"url":"http://example.com:8080/a","urls":["http://a:1/"]}`},
+	}
+	for _, tt := range tests {
+		doc, err := readYAML("t.yaml", []byte(tt.in))
+		if err != nil {
+			t.Errorf("readYAML(%q): %v", tt.in, err)
+			continue
+		}
+		if b, _ := json.Marshal(doc); string(b) != tt.want {
+			t.Errorf("readYAML(%q) = %s; want %s", tt.in, b, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"a: 1\na: 2\n",
+		"a: 1\n  b: 2\n",
+		"just a line\n",
+		"a: [1, 2\n",
+		"a: {b: 1}\n",
+		"a:\n\tb: 1\n",
+	} {
+		if _, err := readYAML("t.yaml", []byte(bad)); err == nil {
+			t.Errorf("readYAML(%q) did not error", bad)
+		}
+	}
+}
+
+func TestMatrixHealth(t *testing.T) {
+	var polls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&polls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	h := &matrixHealth{URL: ts.URL, interval: time.Millisecond, timeout: time.Second}
+	if err := h.wait(); err != nil || atomic.LoadInt32(&polls) != 3 {
+		t.Errorf("wait() = %v after %d polls; want healthy after 3", err, polls)
+	}
+	atomic.StoreInt32(&polls, -1000)
+	h.timeout = 10 * time.Millisecond
+	if err := h.wait(); err == nil {
+		t.Error("wait() = nil for an unhealthy target; want an error")
+	}
+}
+
+func TestParseHook(t *testing.T) {
+	tests := []struct {
+		in   string
+		want hook
+	}{
+		{"pre:redis-cli flushall", hook{When: "pre", Command: "redis-cli flushall"}},
+		{"post: echo a:b", hook{When: "post", Command: "echo a:b"}},
+		{"at=30s:profile", hook{When: "at", After: 30 * time.Second, Command: "profile"}},
+		{"every=1m:date", hook{When: "every", After: time.Minute, Command: "date"}},
+	}
+	for _, tt := range tests {
+		if h, err := parseHook(tt.in); err != nil || *h != tt.want {
+			t.Errorf("parseHook(%q) = %+v, %v; want %+v", tt.in, h, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"pre", "pre:", "during:x", "at=x:y", "every=0s:y"} {
+		if _, err := parseHook(bad); err == nil {
+			t.Errorf("parseHook(%q) did not error", bad)
+		}
+	}
+}
+
+func TestHookRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run in sh")
+	}
+	r := &hookRunner{hooks: []*hook{
+		{When: "pre", Command: "echo before"},
+		{When: "every", After: 10 * time.Millisecond, Command: "true"},
+		{When: "post", Command: "echo after; exit 2"},
+	}}
+	if err := r.runAll("pre"); err != nil {
+		t.Fatal(err)
+	}
+	r.start()
+	time.Sleep(50 * time.Millisecond)
+	r.wait()
+	if err := r.runAll("post"); err == nil || !strings.Contains(err.Error(), "after") {
+		t.Errorf("post hook error = %v; want the failure with its output", err)
+	}
+	runs := r.results()
+	if len(runs) < 4 || runs[0].Output != "before\n" || runs[1].When != "every=10ms" {
+		t.Fatalf("runs = %+v", runs)
+	}
+	if last := runs[len(runs)-1]; last.When != "post" || last.ExitCode != 2 || last.Error == "" {
+		t.Errorf("post hook run = %+v; want exit code 2", last)
+	}
+}
+
+func TestSplitFlag(t *testing.T) {
+	args := []string{"-agents", "a:1,b:2", "--n=10", "-c", "4", "http://x/"}
+	v, args, ok := splitFlag(args, "n")
+	if !ok || v != "10" {
+		t.Errorf("-n = %q, %v; want 10, true", v, ok)
+	}
+	v, args, ok = splitFlag(args, "agents")
+	if !ok || v != "a:1,b:2" {
+		t.Errorf("-agents = %q, %v; want a:1,b:2, true", v, ok)
+	}
+	if _, _, ok := splitFlag(args, "rps"); ok {
+		t.Errorf("-rps is set; want not set")
+	}
+	if want := []string{"-c", "4", "http://x/"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v; want %v", args, want)
+	}
+	var got []int
+	for i := 0; i < 3; i++ {
+		got = append(got, share(10, 3, i))
+	}
+	if want := []int{4, 3, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("shares = %v; want %v", got, want)
+	}
+}
+
+func TestCheckAgentArgs(t *testing.T) {
+	ok := [][]string{
+		{"-m", "POST", "-d", "{}", "-H", "Accept: text/html", "-c", "5", "http://x/"},
+		{"--z=10s", "-disable-keepalive", "--", "http://x/"},
+	}
+	for _, args := range ok {
+		if err := checkAgentArgs(args); err != nil {
+			t.Errorf("checkAgentArgs(%q) = %v; want nil", args, err)
+		}
+	}
+	bad := [][]string{
+		{"-config", "hey.toml"},
+		{"-a", "user:@env:PASSWORD", "http://x/"},
+		{"-ua-file", "agents.txt", "http://x/"},
+		{"-cert", "c.pem", "-key", "k.pem", "http://x/"},
+		{"-events", "https://example.com/hook", "http://x/"},
+		// A flag hidden as the value of another one.
+		{"-d", "-D", "http://x/"},
+	}
+	for _, args := range bad {
+		if err := checkAgentArgs(args); err == nil {
+			t.Errorf("checkAgentArgs(%q) = nil; want error", args)
+		}
+	}
+	for addr, want := range map[string]bool{"127.0.0.1:7070": true, "[::1]:7070": true, "localhost:7070": true, ":7070": false, "10.0.0.1:7070": false} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v; want %v", addr, got, want)
+		}
+	}
+}
+
+func TestAgent(t *testing.T) {
+	a := &agent{exe: "/nonexistent", token: "secret"}
+	for _, tt := range []struct {
+		auth, body string
+		want       int
+	}{
+		{"", `{"args": ["http://x/"]}`, http.StatusUnauthorized},
+		{"Bearer secret", `{"args": ["-hook", "x", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-record=/etc/x", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-D", "/etc/shadow", "-m", "POST", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-H", "X: @file:/etc/shadow", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["--scenario=s.json"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-fail-if-p99", "1s", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-data", "/etc/passwd", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-data-random", "http://x/"], "data": "id\n1\n"}`, http.StatusInternalServerError},
+		{"Bearer secret", `{"version": 2, "args": ["http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"args": ["-c", "x", "http://x/"]}`, http.StatusBadRequest},
+		{"Bearer secret", `{"version": 1, "args": ["-c", "2", "-H", "X: y", "http://x/"]}`, http.StatusInternalServerError},
+		{"Bearer secret", `{"args": ["http://x/"]}`, http.StatusInternalServerError},
+	} {
+		req := httptest.NewRequest("POST", agentPath, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", tt.auth)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s with %q: status %d; want %d", tt.body, tt.auth, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAgentHealth(t *testing.T) {
+	a := &agent{exe: "/nonexistent", token: "secret", region: "eu"}
+	ready := httptest.NewServer(a)
+	defer ready.Close()
+	busy := httptest.NewServer(&agent{running: true})
+	defer busy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	var warnings bytes.Buffer
+	addrs, healths := checkAgents(context.Background(), []string{broken.URL, ready.URL, busy.URL}, "secret", &warnings)
+	if !reflect.DeepEqual(addrs, []string{ready.URL}) || healths[0].Region != "eu" || healths[0].CPUs != runtime.NumCPU() {
+		t.Errorf("checkAgents() = %q, %+v; want the ready agent", addrs, healths)
+	}
+	if w := warnings.String(); strings.Count(w, "left out of the run") != 2 || !strings.Contains(w, "busy") {
+		t.Errorf("warnings = %q; want the broken and the busy agent left out", w)
+	}
+	if _, err := getHealth(context.Background(), ready.URL, "wrong"); err == nil {
+		t.Error("getHealth() with the wrong token succeeded")
+	}
+
+	for _, tt := range []struct {
+		h    agentHealth
+		c    int
+		h2   bool
+		want int
+	}{
+		{agentHealth{CPUs: 4, Load: 1, FileLimit: 1024}, 100, false, 0},
+		{agentHealth{CPUs: 4, Load: 1, FileLimit: 1024}, 1000, false, 1},
+		{agentHealth{CPUs: 4, Load: 1, FileLimit: 1024}, 1000, true, 0},
+		{agentHealth{CPUs: 4, Load: 6}, 1000, false, 1},
+		{agentHealth{CPUs: 4, Load: 6, FileLimit: 256}, 1000, false, 2},
+	} {
+		if got := capacityWarnings(&tt.h, tt.c, tt.h2); len(got) != tt.want {
+			t.Errorf("capacityWarnings(%+v, %d, %v) = %q; want %d warnings", tt.h, tt.c, tt.h2, got, tt.want)
+		}
+	}
+}
+
+func TestAgentStats(t *testing.T) {
+	a := &agent{token: "secret"}
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+	live := newLiveProgress(2)
+	live.set(1, report.Tick{Requests: 5, Rate: 5})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- followAgent(ctx, ts.URL, "secret", func(t report.Tick) {
+			live.set(0, t)
+			cancel()
+		})
+	}()
+	// Broadcast until the stream of followAgent is up.
+	for i := 0; i < 500 && ctx.Err() == nil; i++ {
+		a.broadcast([]byte(`{"requests": 10, "errors": 1, "rate": 10}` + "\n"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("followAgent did not return")
+	}
+	if got := live.tick(); got.Requests != 15 || got.Errors != 1 || got.Rate != 15 {
+		t.Errorf("merged progress = %+v; want that of both agents", got)
+	}
+	if err := followAgent(context.Background(), ts.URL, "wrong", nil); err == nil {
+		t.Error("followAgent() with the wrong token succeeded")
+	}
+}
+
+func TestAgentControl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in for hey is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "hey-agent-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A stand-in for hey that records its -config on SIGHUP and runs
+	// until it is interrupted.
+	exe := filepath.Join(dir, "hey")
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case $1 in -record) rec=$2; shift;; -config) conf=$2; shift;; esac
+  shift
+done
+cat "$conf" >> "$rec"
+trap 'cat "$conf" >> "$rec"' HUP
+trap 'echo stopped >> "$rec"; exit 0' INT
+echo '{"requests": 1}' >&2
+while :; do sleep 0.05; done
+`
+	if err := ioutil.WriteFile(exe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	a := &agent{exe: exe}
+	do := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+	if w := do(agentStopPath, ""); w.Code != http.StatusConflict {
+		t.Errorf("/stop without a run: status %d; want 409", w.Code)
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do(agentPath, `{"version": 1, "args": ["-c", "2", "-q", "1.5", "-H", "A: b", "http://x/"]}`)
+	}()
+	for i := 0; ; i++ {
+		a.mu.Lock()
+		started := a.load != nil
+		a.mu.Unlock()
+		if started {
+			break
+		}
+		if i == 500 {
+			t.Fatal("the run did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w := do(agentAdjustPath, `{"c": -1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("/adjust to -1 workers: status %d; want 400", w.Code)
+	}
+	if w := do(agentAdjustPath, `{"c": 4, "headers": ["A: @env:HOME"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("/adjust to a secret header: status %d; want 400", w.Code)
+	}
+	if w := do(agentAdjustPath, `{"q": 5, "headers": ["A: \"c\""]}`); w.Code != http.StatusOK {
+		t.Errorf("/adjust: status %d %q; want 200", w.Code, w.Body.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if w := do(agentStopPath, ""); w.Code != http.StatusOK {
+		t.Errorf("/stop: status %d %q; want 200", w.Code, w.Body.String())
+	}
+	var w *httptest.ResponseRecorder
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("/stop did not stop the run")
+	}
+	want := "q = 1.5\nc = 2\nH = [\"A: b\"]\n" +
+		"q = 5\nc = 2\nH = [\"A: \\\"c\\\"\"]\n" +
+		"stopped\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("run: status %d, record %q; want 200, %q", w.Code, w.Body.String(), want)
+	}
+}
+
+func TestSplitBoolFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want bool
+		rest []string
+	}{
+		{[]string{"-quiet", "-c", "2"}, true, []string{"-c", "2"}},
+		{[]string{"-c", "2", "--quiet=false", "x"}, false, []string{"-c", "2", "x"}},
+		{[]string{"-c", "2", "quiet"}, false, []string{"-c", "2", "quiet"}},
+	} {
+		v, rest := splitBoolFlag(tt.args, "quiet")
+		if v != tt.want || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("splitBoolFlag(%q) = %v, %q; want %v, %q", tt.args, v, rest, tt.want, tt.rest)
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ts := httptest.NewServer(&agent{})
+	lost := make(chan error, 1)
+	go heartbeat(context.Background(), ts.URL, "", time.Millisecond, func(err error) { lost <- err })
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-lost:
+		t.Fatalf("lost a healthy agent: %v", err)
+	default:
+	}
+	ts.Close()
+	select {
+	case err := <-lost:
+		if !strings.Contains(err.Error(), "missed 3 health checks") {
+			t.Errorf("lost with %v; want the missed checks", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the heartbeat did not lose the closed agent")
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in for hey is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "hey-daemon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A stand-in for hey that runs until it is interrupted.
+	exe := filepath.Join(dir, "hey")
+	script := "#!/bin/sh\ntrap 'echo reloaded' HUP\ntrap 'echo interrupted; exit 0' INT\necho \"run $*\"\nwhile :; do sleep 0.05; done\n"
+	if err := ioutil.WriteFile(exe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{exe: exe, config: "load.yaml", dir: dir, ext: ".txt", every: time.Hour, keep: 2, token: "secret", retry: time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		d.run()
+		close(done)
+	}()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, req)
+		return w
+	}
+	status := func() daemonStatus {
+		var s daemonStatus
+		if err := json.NewDecoder(do("GET", "/status").Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	// await waits until the daemon is in state after n runs.
+	await := func(state string, n int) {
+		for i := 0; i < 200; i++ {
+			if s := status(); s.State == state && s.Runs == n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("status = %+v; want %s after %d runs", status(), state, n)
+	}
+
+	await("running", 0)
+	// Let the script set its traps.
+	time.Sleep(100 * time.Millisecond)
+	if w := do("POST", "/reload"); w.Code != http.StatusOK {
+		t.Errorf("/reload: status %d; want 200", w.Code)
+	}
+	for runs := 1; runs <= 3; runs++ {
+		time.Sleep(100 * time.Millisecond)
+		do("POST", "/pause")
+		await("paused", runs)
+		do("POST", "/resume")
+		await("running", runs)
+	}
+	if s := status(); len(s.Reports) != 2 || !strings.HasSuffix(s.Reports[1], "-3.txt") {
+		t.Errorf("reports = %q; want those of the runs 2 and 3", s.Reports)
+	}
+	if w := do("GET", "/report"); !strings.Contains(w.Body.String(), "run -config load.yaml -z 1h0m0s\n") || !strings.Contains(w.Body.String(), "interrupted") {
+		t.Errorf("/report = %q; want the output of the third run", w.Body.String())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "hey-*.txt"))
+	if len(files) != 3 {
+		t.Errorf("reports in -dir = %q; want the last 2 and that of the run", files)
+	}
+	if w := do("POST", "/status"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status: status %d; want 405", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/status", nil)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("/status without the token: status %d; want 401", w.Code)
+	}
+
+	d.stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop")
+	}
+	if s := status(); s.Failed != 0 || s.Runs != 4 {
+		t.Errorf("runs, failed = %d, %d; want 4, 0", s.Runs, s.Failed)
+	}
+}
+
+func TestReadData(t *testing.T) {
+	f, err := ioutil.TempFile("", "hey-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("id,name\n1,ann\n2\n")
+	f.Close()
+	// The short row fails the requests that use it, not the run.
+	data, err := readData(f.Name())
+	if err != nil || !reflect.DeepEqual(data.Rows, [][]string{{"1", "ann"}, {"2"}}) {
+		t.Errorf("readData() = %+v, %v; want both rows", data, err)
+	}
+}
+
+func TestDataShare(t *testing.T) {
+	data := &requester.Data{Columns: []string{"id", "name"}}
+	for i := 0; i < 10; i++ {
+		data.Rows = append(data.Rows, []string{strconv.Itoa(i), "a,b"})
+	}
+	seen := make(map[string]bool)
+	var rows []int
+	for i := 0; i < 3; i++ {
+		s, n := dataShare(data, 3, i)
+		records, err := csv.NewReader(strings.NewReader(s)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records[0], data.Columns) || len(records)-1 != n {
+			t.Errorf("share %d = %q with %d rows; want the columns and the rows", i, records, n)
+		}
+		for _, r := range records[1:] {
+			if seen[r[0]] || r[1] != "a,b" {
+				t.Errorf("share %d has row %q, used before or changed", i, r)
+			}
+			seen[r[0]] = true
+		}
+		rows = append(rows, n)
+	}
+	if len(seen) != 10 || !reflect.DeepEqual(rows, []int{4, 3, 3}) {
+		t.Errorf("shares of 10 rows = %v, covering %d rows; want 4, 3, 3 covering all", rows, len(seen))
+	}
+
+	if got, want := dataCoverage("users.csv", false, rows, []int64{100, 2, 3}), "9 of the 10 rows of users.csv were used, none by two agents"; got != want {
+		t.Errorf("dataCoverage() = %q; want %q", got, want)
+	}
+	if got := dataCoverage("users.csv", true, rows, []int64{100, 2, 3}); !strings.Contains(got, "random") {
+		t.Errorf("dataCoverage() with -data-random = %q; want rows picked at random", got)
+	}
+	for _, tt := range []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-data-random", "http://x/"}, true},
+		{[]string{"--data-random=true"}, true},
+		{[]string{"-data-random=false"}, false},
+		{[]string{"http://x/"}, false},
+	} {
+		if got := boolFlag(tt.args, "data-random"); got != tt.want {
+			t.Errorf("boolFlag(%q) = %v; want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"hey.toml": `# comment
+n = 100
+z = "10s"   # trailing comment
+H = [
+  "Accept: text/html",
+  'X-Name: a # b',
+]
+disable-keepalive = true
+urls = ["https://example.com/"]
+`,
+		"hey.yaml": `n: 100
+z: 10s
+H:
+  - "Accept: text/html"
+  - 'X-Name: a # b'
+disable-keepalive: true
+urls: [https://example.com/]
+`,
+	}
+	for file, content := range files {
+		name := dir + "/" + file
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var hs headerSlice
+		fs := flag.NewFlagSet("hey", flag.ContinueOnError)
+		n := fs.Int("n", 200, "")
+		c := fs.Int("c", 50, "")
+		z := fs.Duration("z", 0, "")
+		keepalive := fs.Bool("disable-keepalive", false, "")
+		fs.Var(&hs, "H", "")
+		if err := fs.Parse([]string{"-n", "5"}); err != nil {
+			t.Fatal(err)
+		}
+		urls, err := applyConfig(fs, name)
+		if err != nil {
+			t.Fatalf("%s: applyConfig() = %v", file, err)
+		}
+		if *n != 5 || *c != 50 || *z != 10*time.Second || !*keepalive {
+			t.Errorf("%s: n, c, z, disable-keepalive = %d, %d, %v, %v; want 5, 50, 10s, true", file, *n, *c, *z, *keepalive)
+		}
+		if want := (headerSlice{"Accept: text/html", "X-Name: a # b"}); !reflect.DeepEqual(hs, want) {
+			t.Errorf("%s: H = %q; want %q", file, hs, want)
+		}
+		if want := []string{"https://example.com/"}; !reflect.DeepEqual(urls, want) {
+			t.Errorf("%s: urls = %q; want %q", file, urls, want)
+		}
+	}
+	for _, bad := range []string{"x = 1\n", "n = [1, 2]\n", "n = 1\nn = 2\n", "[table]\n", "n = 'a\n", "n = abc\n"} {
+		name := dir + "/bad.toml"
+		if err := ioutil.WriteFile(name, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("hey", flag.ContinueOnError)
+		fs.Int("n", 200, "")
+		if _, err := applyConfig(fs, name); err == nil {
+			t.Errorf("applyConfig(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestLoadAdjustment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := dir + "/hey.toml"
+	if err := ioutil.WriteFile(name, []byte("n = 100\nq = 2.5\nc = 8\nH = [\"X-A: 1\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := loadAdjustment(name, map[string]bool{"c": true}, http.Header{"X-B": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.QPS == nil || *a.QPS != 2.5 || a.C != 0 {
+		t.Errorf("q, c = %v, %d; want 2.5 and c left as set on the command line", a.QPS, a.C)
+	}
+	if want := (http.Header{"X-A": {"1"}, "X-B": nil}); !reflect.DeepEqual(a.Header, want) {
+		t.Errorf("H = %v; want %v", a.Header, want)
+	}
+
+	// Options the file does not set keep their value.
+	if err := ioutil.WriteFile(name, []byte("n = 100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if a, err = loadAdjustment(name, nil, nil); err != nil || a.QPS != nil || a.C != 0 || a.Header != nil {
+		t.Errorf("loadAdjustment() of a file without q, c and H = %+v, %v; want no change", a, err)
+	}
+	for _, bad := range []string{"c = 0\n", "q = -1\n", "q = fast\n", "H = [\"nocolon\"]\n"} {
+		if err := ioutil.WriteFile(name, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadAdjustment(name, nil, nil); err == nil {
+			t.Errorf("loadAdjustment(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestGRPCCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "point.proto"), []byte(`syntax = "proto3";
+package demo.v1;
+
+message Point {
+  sint32 x = 1;
+  int64 y = 2 [json_name = "why"];
+}
+`), 0644)
+	name := filepath.Join(dir, "demo.proto")
+	ioutil.WriteFile(name, []byte(`syntax = "proto3";
+package demo.v1;
+
+import "point.proto";
+import "google/protobuf/empty.proto"; // not there, skipped
+
+/* A request. */
+message Req {
+  string name = 1;
+  repeated int32 ids = 2;
+  Point at = 3;
+  Kind kind = 4;
+  map<string, Point> places = 5;
+  bytes blob = 6 [deprecated = true];
+  double ratio = 7;
+  repeated string tags = 8;
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+    FAST = 2;
+  }
+  oneof pick {
+    bool flag = 9;
+  }
+  reserved 10 to 12;
+}
+
+service Demo {
+  rpc Call(Req) returns (Req);
+  rpc Stream(stream Req) returns (stream Req) {
+    option (custom) = {a: 1};
+  }
+}
+`), 0644)
+
+	url, body, err := grpcCall("localhost:50051", "demo.v1.Demo.Call", name, []byte(`{
+		"name": "a", "ids": [1, -1], "at": {"x": -2, "why": "3"}, "kind": "FAST",
+		"places": {"p": {"x": 1}}, "blob": "AQI=", "ratio": 0.5, "tags": ["t"], "flag": true
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://localhost:50051/demo.v1.Demo/Call"; url != want {
+		t.Errorf("url = %q; want %q", url, want)
+	}
+	want := []byte{
+		0x0a, 1, 'a', // name
+		0x12, 11, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, // ids, packed
+		0x1a, 4, 0x08, 3, 0x10, 3, // at
+		0x20, 2, // kind
+		0x2a, 7, 0x0a, 1, 'p', 0x12, 2, 0x08, 2, // places
+		0x32, 2, 1, 2, // blob
+		0x39, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f, // ratio
+		0x42, 1, 't', // tags
+		0x48, 1, // flag
+	}
+	want = append([]byte{0, 0, 0, 0, byte(len(want))}, want...)
+	if !bytes.Equal(body, want) {
+		t.Errorf("body = % x; want % x", body, want)
+	}
+
+	if url, body, err = grpcCall("grpcs://localhost:443", "demo.v1.Demo/Stream", name, nil); err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://localhost:443/demo.v1.Demo/Stream" || !bytes.Equal(body, []byte{0, 0, 0, 0, 0}) {
+		t.Errorf("grpcCall with no payload = %q, % x; want an empty message over TLS", url, body)
+	}
+
+	for _, tt := range []struct {
+		target, method, payload string
+	}{
+		{"localhost", "demo.v1.Demo/Call", `{}`},
+		{"localhost:1", "demo.v1.Demo/Missing", `{}`},
+		{"localhost:1", "demo.v1.Demo/Call", `{"nom": "a"}`},
+		{"localhost:1", "demo.v1.Demo/Call", `{"name": 1}`},
+		{"localhost:1", "demo.v1.Demo/Call", `{"kind": "SLOW"}`},
+		{"localhost:1", "demo.v1.Demo/Call", `{"ids": [1.5]}`},
+		{"localhost:1", "demo.v1.Demo/Call", `{"at": {"x": 4294967296}}`},
+		{"localhost:1", "demo.v1.Demo/Call", `{"blob": "not base64!"}`},
+		{"localhost:1", "demo.v1.Demo/Call", `[]`},
+	} {
+		if _, _, err := grpcCall(tt.target, tt.method, name, []byte(tt.payload)); err == nil {
+			t.Errorf("grpcCall(%q, %q, %s) did not error", tt.target, tt.method, tt.payload)
+		}
+	}
+
+	ioutil.WriteFile(name, []byte("syntax = \"proto3\";\nmessage A {\n  google.protobuf.Timestamp at = 1;\n}\n"), 0644)
+	if _, err := readProto(name); err == nil || !strings.Contains(err.Error(), "google.protobuf.Timestamp") {
+		t.Errorf("readProto with an unknown type: %v; want an error naming it", err)
+	}
+	ioutil.WriteFile(name, []byte("syntax = \"proto3\";\nmessage A {\n  string a = 1\n}\n"), 0644)
+	if _, err := readProto(name); err == nil || !strings.Contains(err.Error(), "demo.proto:4:") {
+		t.Errorf("readProto with a missing ';': %v; want an error on line 4", err)
+	}
+}
+
+func TestFileLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	if limit := fileLimit(1); limit == 0 {
+		t.Errorf("fileLimit = 0; want the limit on open files")
+	}
+}
+
+func TestValidateRun(t *testing.T) {
+	base, _ := http.NewRequest("GET", "http://example.com/", nil)
+	scen := &scenario{
+		Setup:    []scenarioGroup{{Name: "login", Method: "post", URL: "http://example.com/login", Body: "secret"}},
+		Teardown: []scenarioGroup{{Name: "logout", URL: "http://example.com/logout"}},
+	}
+	groups := []*requester.RequestGroup{{Name: "get", Request: base}}
+	groups[0].Request, _ = http.NewRequest("GET", "http://example.com/users/{{.user}}?id={{capture id}}", nil)
+	groups[0].Request.Header.Set("X-Seq", "{{seq}}")
+	names := requester.TemplateNames{Columns: []string{"user"}, Captures: []string{"id"}}
+	data := &requester.Data{Columns: []string{"user"}, Rows: [][]string{{"alice"}, {"bob"}}}
+
+	var buf bytes.Buffer
+	if err := validateRun(&buf, scen, base, nil, nil, groups, names, data); err != nil {
+		t.Fatal(err)
+	}
+	want := `Setup login:
+  POST http://example.com/login
+
+  secret
+
+Group get:
+  GET http://example.com/users/alice?id=<id>
+  X-Seq: 0
+
+Teardown logout:
+  GET http://example.com/logout
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, tt := range []struct {
+		url, header, want string
+	}{
+		{url: "example.com/{{.user}}", want: "has no scheme"},
+		{url: "ftp://example.com/", want: "unsupported scheme"},
+		{url: "http:///path", want: "has no host"},
+		{url: "http://example.com/{{.name}}", want: "name"},
+		{url: "http://example.com/", header: "a\nb", want: "invalid value of header X-Test"},
+	} {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header["X-Test"] = []string{tt.header}
+		}
+		g := []*requester.RequestGroup{{Request: req}}
+		err = validateRun(ioutil.Discard, nil, base, nil, nil, g, names, data)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validateRun(%s) = %v; want an error with %q", tt.url, err, tt.want)
+		}
+	}
+}
+
+// fakeKafka serves Metadata and Produce requests on l as broker id,
+// naming leader as the leader of partition 0 of topic events, and
+// sends the key and value of every produced record to records.
+func fakeKafka(t *testing.T, l net.Listener, brokers map[int32]net.Listener, leader int32, records chan<- [2]string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				var size [4]byte
+				if _, err := io.ReadFull(conn, size[:]); err != nil {
+					return
+				}
+				req := make([]byte, binary.BigEndian.Uint32(size[:]))
+				io.ReadFull(conn, req)
+				r := &kafkaReader{b: req}
+				api, version, corrID := r.int16(), r.int16(), r.int32()
+				r.string() // client_id
+				res := appendInt32(nil, corrID)
+				switch {
+				case api == kafkaMetadata && version == kafkaMetadataVersion:
+					res = appendInt32(res, 0)
+					res = appendInt32(res, int32(len(brokers)))
+					for id, b := range brokers {
+						host, port, _ := net.SplitHostPort(b.Addr().String())
+						p, _ := strconv.Atoi(port)
+						res = appendInt32(res, id)
+						res = appendString(res, host)
+						res = appendInt32(res, int32(p))
+						res = appendInt16(res, -1)
+					}
+					res = appendInt16(res, -1)
+					res = appendInt32(res, 1)
+					res = appendInt32(res, 1)
+					res = appendInt16(res, 0)
+					res = appendString(res, "events")
+					res = append(res, 0)
+					res = appendInt32(res, 1)
+					res = appendInt16(res, 0)
+					res = appendInt32(res, 0)
+					res = appendInt32(res, leader)
+					res = appendInt32(res, 0)
+					res = appendInt32(res, 0)
+				case api == kafkaProduce && version == kafkaProduceVersion:
+					r.int16() // transactional_id
+					r.int16() // acks
+					r.int32() // timeout
+					r.int32() // topics
+					topic := r.string()
+					r.int32() // partitions
+					r.int32() // partition
+					batch := r.next(int(r.int32()))
+					if r.err != nil || topic != "events" {
+						t.Errorf("produce to %q: %v", topic, r.err)
+						return
+					}
+					if int(binary.BigEndian.Uint32(batch[8:])) != len(batch)-12 || batch[16] != 2 || binary.BigEndian.Uint32(batch[17:]) != crc32.Checksum(batch[21:], castagnoli) {
+						t.Errorf("record batch %x has a bad length, magic or crc", batch)
+					}
+					rec := batch[61:]
+					var fields [][]byte
+					_, n := binary.Varint(rec) // length
+					rec = rec[n+1:]            // attributes
+					_, n = binary.Varint(rec)  // timestamp_delta
+					rec = rec[n:]
+					_, n = binary.Varint(rec) // offset_delta
+					rec = rec[n:]
+					for i := 0; i < 2; i++ {
+						l, n := binary.Varint(rec)
+						fields = append(fields, rec[n:n+int(l)])
+						rec = rec[n+int(l):]
+					}
+					records <- [2]string{string(fields[0]), string(fields[1])}
+					res = appendInt32(res, 1)
+					res = appendString(res, topic)
+					res = appendInt32(res, 1)
+					res = appendInt32(res, 0)
+					res = appendInt16(res, 0)
+					res = appendInt64(res, 0)
+					res = appendInt64(res, -1)
+					res = appendInt32(res, 0)
+				default:
+					t.Errorf("unexpected request %d v%d", api, version)
+					return
+				}
+				conn.Write(append(appendInt32(nil, int32(len(res))), res...))
+			}
+		}()
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	brokers := make(map[int32]net.Listener)
+	for _, id := range []int32{1, 2} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		brokers[id] = l
+	}
+	records := make(chan [2]string, 10)
+	for _, l := range brokers {
+		go fakeKafka(t, l, brokers, 2, records)
+	}
+
+	s, err := parseEventSinks([]string{"kafka://" + brokers[1].Addr().String() + "/events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := report.NewLifecycle("run1", report.Limits{}, s.sinks...)
+	l.Emit(report.LifecycleRunStarted, "http://example.com")
+	l.Phase("run")
+	if errs := s.close(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, want := range []string{report.LifecycleRunStarted, report.LifecyclePhaseChanged} {
+		got := <-records
+		var rec report.LifecycleRecord
+		if err := json.Unmarshal([]byte(got[1]), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != "run1" || rec.Type != want {
+			t.Errorf("got a %s record keyed %q; want a %s record keyed run1", rec.Type, got[0], want)
+		}
+	}
+
+	for _, target := range []string{"kafka://localhost:9092", "kafka://localhost/events", "kafka://localhost:9092/events?partition=x"} {
+		if _, err := parseEventSinks([]string{target}); err == nil {
+			t.Errorf("parseEventSinks(%s) succeeded; want an error", target)
+		}
+	}
+}
+
+func TestKafkaMaxResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		var size [4]byte
+		if _, err := io.ReadFull(server, size[:]); err != nil {
+			return
+		}
+		io.ReadFull(server, make([]byte, binary.BigEndian.Uint32(size[:])))
+		server.Write(appendInt32(nil, kafkaMaxResponse+1))
+	}()
+	p := &kafkaProducer{}
+	if _, err := p.roundTrip(client, kafkaMetadata, kafkaMetadataVersion, nil); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("roundTrip with an oversized response = %v; want an error", err)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the config directory is only redirected with XDG_CONFIG_HOME on Linux")
+	}
+	dir, err := ioutil.TempDir("", "calibrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", dir)
+
+	if cal := loadCalibration(); cal != nil {
+		t.Fatalf("loadCalibration() = %+v before calibrating; want nil", cal)
+	}
+	cal, err := calibrate(2, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.C != 2 || cal.Rps <= 0 || cal.Fastest <= 0 || cal.Fastest > cal.Average {
+		t.Fatalf("calibrate() = %+v; want a rate and a latency floor", cal)
+	}
+	path, err := cal.save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "hey", "calibration.json"); path != want {
+		t.Errorf("saved to %s; want %s", path, want)
+	}
+	got := loadCalibration()
+	if got == nil || got.Rps != cal.Rps || got.Fastest != cal.Fastest || got.Average != cal.Average {
+		t.Fatalf("loadCalibration() = %+v; want %+v", got, cal)
+	}
+	if got.clientLimited(cal.Rps*0.5) || !got.clientLimited(cal.Rps*0.9) {
+		t.Errorf("clientLimited at 50%% and 90%% of %v; want only the latter", cal.Rps)
+	}
+
+	// A calibration without a rate is ignored.
+	ioutil.WriteFile(path, []byte(`{"rps": 0}`), 0644)
+	if cal := loadCalibration(); cal != nil {
+		t.Errorf("loadCalibration() = %+v with no rate; want nil", cal)
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	var mu sync.Mutex
+	busts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cache-Control") != "no-cache" || r.Header.Get("Pragma") != "max-age=0" {
+			t.Errorf("Cache-Control %q, Pragma %q; want no-cache and max-age=0", r.Header.Get("Cache-Control"), r.Header.Get("Pragma"))
+		}
+		mu.Lock()
+		busts[r.Header.Get("X-Cache-Bust")]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	setNoCache(req.Header)
+	// -H overrides the headers of -no-cache.
+	req.Header.Set("Pragma", "max-age=0")
+	if err := requester.CheckTemplate(req.Header.Get("X-Cache-Bust"), requester.TemplateNames{}); err != nil {
+		t.Fatal(err)
+	}
+	w := &requester.Work{Request: req, N: 20, C: 2, Templates: true, Writer: ioutil.Discard}
+	w.Run()
+	if len(busts) != 20 {
+		t.Errorf("got %d distinct X-Cache-Bust values for 20 requests: %v", len(busts), busts)
+	}
+	for v := range busts {
+		if len(v) != 36 {
+			t.Errorf("X-Cache-Bust = %q; want a UUID", v)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package heytest runs hey workloads in Go benchmarks, so that services
+// can have load regression tests next to their code:
+//
+//	func BenchmarkSearch(b *testing.B) {
+//		server := httptest.NewServer(newHandler())
+//		defer server.Close()
+//		heytest.Run(b, server.URL+"/search?q=hey", heytest.Options{C: 10})
+//	}
+//
+// Every iteration of the benchmark is a request, so ns/op is the time
+// per request of the whole workload. The latency percentiles, the rate
+// and the errors are reported as additional metrics, e.g. p99-ms.
+package heytest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/rakyll/hey/requester"
+	"github.com/rakyll/hey/requester/report"
+)
+
+// Options configures the workload of Run.
+type Options struct {
+	// Method is the HTTP method of the requests. Defaults to GET.
+	Method string
+
+	// Header is the header of the requests.
+	Header http.Header
+
+	// Body is the body of the requests.
+	Body []byte
+
+	// C is the number of workers that make requests concurrently.
+	// Defaults to 1.
+	C int
+
+	// QPS is the rate limit of every worker, in requests per second.
+	// Zero means no rate limit.
+	QPS float64
+}
+
+// Run makes b.N requests to url as configured by opts and reports their
+// metrics to b. It returns the report of the workload, e.g. to fail the
+// benchmark on errors.
+func Run(b *testing.B, url string, opts Options) report.Report {
+	b.Helper()
+	method := opts.Method
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(opts.Body))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for k, vs := range opts.Header {
+		req.Header[k] = vs
+	}
+	c := opts.C
+	if c < 1 {
+		c = 1
+	}
+	if c > b.N {
+		c = b.N
+	}
+	w := &requester.Work{
+		Request:     req,
+		RequestBody: opts.Body,
+		N:           b.N,
+		C:           c,
+		QPS:         opts.QPS,
+		Writer:      ioutil.Discard,
+	}
+	b.ResetTimer()
+	w.Run()
+	b.StopTimer()
+
+	rep := w.Report()
+	for _, d := range rep.LatencyDistribution {
+		switch d.Percentage {
+		case 50, 90, 99:
+			b.ReportMetric(d.Latency*1000, "p"+strconv.Itoa(d.Percentage)+"-ms")
+		}
+	}
+	b.ReportMetric(rep.Rps, "req/s")
+	failed := 0
+	for _, n := range rep.ErrorDist {
+		failed += n
+	}
+	b.ReportMetric(float64(failed), "errors")
+	return rep
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package heytest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method == "POST" && string(body) == "hi" && r.Header.Get("X-Test") == "yes" {
+			atomic.AddInt64(&count, 1)
+		}
+	}))
+	defer server.Close()
+
+	var n int
+	res := testing.Benchmark(func(b *testing.B) {
+		n += b.N
+		Run(b, server.URL, Options{
+			Method: "POST",
+			Header: http.Header{"X-Test": {"yes"}},
+			Body:   []byte("hi"),
+			C:      4,
+		})
+	})
+	if count != int64(n) {
+		t.Errorf("server got %d requests; want %d", count, n)
+	}
+	for _, m := range []string{"req/s", "errors"} {
+		if _, ok := res.Extra[m]; !ok {
+			t.Errorf("metrics = %v; want %s", res.Extra, m)
+		}
+	}
+	if res.Extra["errors"] != 0 {
+		t.Errorf("%v errors; want 0", res.Extra["errors"])
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	Run(b, server.URL, Options{C: 10})
+}
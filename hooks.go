@@ -0,0 +1,177 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHookOutput caps the output of a hook kept in the manifest.
+const maxHookOutput = 4096
+
+// hook is a command run at a defined point of the run, given as
+// "pre:<command>", "post:<command>", "at=<duration>:<command>" or
+// "every=<duration>:<command>".
+type hook struct {
+	When    string
+	After   time.Duration
+	Command string
+}
+
+// parseHook parses the value of -hook.
+func parseHook(s string) (*hook, error) {
+	i := strings.Index(s, ":")
+	if i < 0 || strings.TrimSpace(s[i+1:]) == "" {
+		return nil, fmt.Errorf("invalid hook %q, want <when>:<command>", s)
+	}
+	h := &hook{When: s[:i], Command: strings.TrimSpace(s[i+1:])}
+	switch {
+	case h.When == "pre", h.When == "post":
+	case strings.HasPrefix(h.When, "at="), strings.HasPrefix(h.When, "every="):
+		parts := strings.SplitN(h.When, "=", 2)
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d < 0 || parts[0] == "every" && d == 0 {
+			return nil, fmt.Errorf("invalid hook time %q", parts[1])
+		}
+		h.When, h.After = parts[0], d
+	default:
+		return nil, fmt.Errorf("invalid hook %q, want pre, post, at=<duration> or every=<duration>", h.When)
+	}
+	return h, nil
+}
+
+// hookRun is the outcome of a hook, recorded in the manifest.
+type hookRun struct {
+	When     string    `json:"when"`
+	Command  string    `json:"command"`
+	Host     string    `json:"host,omitempty"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+}
+
+// hookRunner runs the hooks of a run, locally or on a host over ssh.
+type hookRunner struct {
+	hooks []*hook
+	host  string
+
+	mu   sync.Mutex
+	runs []hookRun
+
+	wg   sync.WaitGroup
+	stop chan struct{}
+}
+
+// run runs h and records its outcome, which it returns.
+func (r *hookRunner) run(h *hook) hookRun {
+	var cmd *exec.Cmd
+	switch {
+	case r.host != "":
+		cmd = exec.Command("ssh", r.host, h.Command)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("cmd", "/C", h.Command)
+	default:
+		cmd = exec.Command("sh", "-c", h.Command)
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	when := h.When
+	if h.When == "at" || h.When == "every" {
+		when += "=" + h.After.String()
+	}
+	run := hookRun{When: when, Command: h.Command, Host: r.host, Start: time.Now()}
+	err := cmd.Run()
+	run.Duration = time.Since(run.Start).Seconds()
+	if err != nil {
+		run.Error = err.Error()
+		run.ExitCode = -1
+	}
+	if cmd.ProcessState != nil {
+		run.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if out.Len() > maxHookOutput {
+		out.Truncate(maxHookOutput)
+	}
+	run.Output = out.String()
+	r.mu.Lock()
+	r.runs = append(r.runs, run)
+	r.mu.Unlock()
+	return run
+}
+
+// runAll runs the hooks of the given kind, "pre" or "post", in order
+// and returns the first that failed.
+func (r *hookRunner) runAll(when string) error {
+	for _, h := range r.hooks {
+		if h.When != when {
+			continue
+		}
+		if run := r.run(h); run.Error != "" {
+			return fmt.Errorf("%s hook %q: %s: %s", when, h.Command, run.Error, strings.TrimSpace(run.Output))
+		}
+	}
+	return nil
+}
+
+// start runs the "at" and "every" hooks in the background from now on,
+// the start of the run, until wait is called.
+func (r *hookRunner) start() {
+	r.stop = make(chan struct{})
+	for _, h := range r.hooks {
+		if h.When != "at" && h.When != "every" {
+			continue
+		}
+		r.wg.Add(1)
+		go func(h *hook) {
+			defer r.wg.Done()
+			t := time.NewTimer(h.After)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+				case <-r.stop:
+					return
+				}
+				r.run(h)
+				if h.When == "at" {
+					return
+				}
+				t.Reset(h.After)
+			}
+		}(h)
+	}
+}
+
+// wait stops the background hooks and waits for those still running.
+func (r *hookRunner) wait() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// results returns the outcomes of the hooks run so far.
+func (r *hookRunner) results() []hookRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]hookRun(nil), r.runs...)
+}
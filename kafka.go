@@ -0,0 +1,411 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Kafka requests hey makes, in versions that brokers from 1.0 to
+// 4.x support.
+const (
+	kafkaProduce        = 0
+	kafkaProduceVersion = 3
+
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 4
+)
+
+// kafkaTimeout bounds connecting to a broker and every request to it.
+const kafkaTimeout = 5 * time.Second
+
+// kafkaMaxResponse bounds the size of a response, far above that of the
+// responses to the requests hey makes, so that a peer that is not a
+// broker cannot make it allocate gigabytes.
+const kafkaMaxResponse = 1 << 20
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaProducer sends messages to a partition of a Kafka topic, one
+// request per message, waiting for the partition leader to write it
+// (acks=1). It is a minimal client of the Kafka protocol without
+// TLS, SASL or compression, for the lifecycle events of a run.
+type kafkaProducer struct {
+	brokers   []string
+	topic     string
+	partition int32
+
+	mu     sync.Mutex
+	conn   net.Conn // to the leader of the partition
+	corrID int32
+}
+
+// newKafkaProducer returns a producer for a kafka://host:port/topic
+// URL, which may list several brokers to bootstrap from separated by
+// commas, and select a partition other than 0 with ?partition=N.
+func newKafkaProducer(target string) (*kafkaProducer, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	topic := strings.Trim(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("invalid Kafka sink %q, want kafka://host:port/topic", target)
+	}
+	p := &kafkaProducer{topic: topic}
+	for _, b := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			return nil, fmt.Errorf("invalid Kafka broker %q: %v", b, err)
+		}
+		p.brokers = append(p.brokers, b)
+	}
+	if s := u.Query().Get("partition"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Kafka partition %q", s)
+		}
+		p.partition = int32(n)
+	}
+	return p, nil
+}
+
+// produce sends a message with the given key and value. After an error
+// the next message looks the leader of the partition up again.
+func (p *kafkaProducer) produce(key, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		conn, err := p.dialLeader()
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+	err := p.sendProduce(key, value)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+// close closes the connection to the leader, if any.
+func (p *kafkaProducer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// dialLeader asks the first broker that answers for the leader of the
+// partition and connects to it.
+func (p *kafkaProducer) dialLeader() (net.Conn, error) {
+	var err error
+	for _, b := range p.brokers {
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", b, kafkaTimeout); err != nil {
+			continue
+		}
+		var leader string
+		leader, err = p.leader(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		if leader == b {
+			return conn, nil
+		}
+		conn.Close()
+		return net.DialTimeout("tcp", leader, kafkaTimeout)
+	}
+	return nil, err
+}
+
+// leader returns the address of the leader of the partition, from a
+// Metadata request on conn.
+func (p *kafkaProducer) leader(conn net.Conn) (string, error) {
+	var b []byte
+	b = appendInt32(b, 1) // topics
+	b = appendString(b, p.topic)
+	b = append(b, 0) // allow_auto_topic_creation
+	r, err := p.roundTrip(conn, kafkaMetadata, kafkaMetadataVersion, b)
+	if err != nil {
+		return "", err
+	}
+	r.int32() // throttle_time_ms
+	brokers := make(map[int32]string)
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster_id
+	r.int32()  // controller_id
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		code := r.int16()
+		name := r.string()
+		r.int8() // is_internal
+		for m := r.int32(); m > 0 && r.err == nil; m-- {
+			pcode := r.int16()
+			index := r.int32()
+			leader := r.int32()
+			r.int32s() // replica_nodes
+			r.int32s() // isr_nodes
+			if name != p.topic || index != p.partition {
+				continue
+			}
+			if code != 0 {
+				return "", kafkaError(code)
+			}
+			if pcode != 0 {
+				return "", kafkaError(pcode)
+			}
+			addr, ok := brokers[leader]
+			if !ok {
+				return "", fmt.Errorf("kafka: %s/%d has no leader", p.topic, p.partition)
+			}
+			return addr, nil
+		}
+		if name == p.topic && code != 0 {
+			return "", kafkaError(code)
+		}
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+	return "", fmt.Errorf("kafka: no partition %d of topic %s", p.partition, p.topic)
+}
+
+// sendProduce sends a Produce request of a single record to the leader.
+func (p *kafkaProducer) sendProduce(key, value []byte) error {
+	batch := recordBatch(key, value, time.Now())
+	var b []byte
+	b = appendInt16(b, -1) // transactional_id
+	b = appendInt16(b, 1)  // acks
+	b = appendInt32(b, int32(kafkaTimeout/time.Millisecond))
+	b = appendInt32(b, 1) // topics
+	b = appendString(b, p.topic)
+	b = appendInt32(b, 1) // partitions
+	b = appendInt32(b, p.partition)
+	b = appendInt32(b, int32(len(batch)))
+	b = append(b, batch...)
+	r, err := p.roundTrip(p.conn, kafkaProduce, kafkaProduceVersion, b)
+	if err != nil {
+		return err
+	}
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.string() // name
+		for m := r.int32(); m > 0 && r.err == nil; m-- {
+			r.int32() // partition
+			if code := r.int16(); code != 0 {
+				return kafkaError(code)
+			}
+			r.int64() // base_offset
+			r.int64() // log_append_time_ms
+		}
+	}
+	return r.err
+}
+
+// roundTrip sends a request with the given body on conn and returns a
+// reader of the body of its response.
+func (p *kafkaProducer) roundTrip(conn net.Conn, api, version int16, body []byte) (*kafkaReader, error) {
+	p.corrID++
+	var b []byte
+	b = appendInt32(b, 0) // size, set below
+	b = appendInt16(b, api)
+	b = appendInt16(b, version)
+	b = appendInt32(b, p.corrID)
+	b = appendString(b, "hey")
+	b = append(b, body...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+
+	conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > kafkaMaxResponse {
+		return nil, fmt.Errorf("kafka: response of %d bytes exceeds %d bytes", n, kafkaMaxResponse)
+	}
+	res := make([]byte, n)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{b: res}
+	if id := r.int32(); r.err == nil && id != p.corrID {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, p.corrID)
+	}
+	return r, r.err
+}
+
+// recordBatch returns a record batch, in the v2 message format, of a
+// single record with the given key and value.
+func recordBatch(key, value []byte, t time.Time) []byte {
+	var rec []byte
+	rec = append(rec, 0)            // attributes
+	rec = appendKafkaVarint(rec, 0) // timestamp_delta
+	rec = appendKafkaVarint(rec, 0) // offset_delta
+	rec = appendKafkaVarint(rec, int64(len(key)))
+	rec = append(rec, key...)
+	rec = appendKafkaVarint(rec, int64(len(value)))
+	rec = append(rec, value...)
+	rec = appendKafkaVarint(rec, 0) // headers
+
+	ms := t.UnixNano() / int64(time.Millisecond)
+	var b []byte
+	b = appendInt64(b, 0)  // base_offset
+	b = appendInt32(b, 0)  // batch_length, set below
+	b = appendInt32(b, -1) // partition_leader_epoch
+	b = append(b, 2)       // magic
+	b = appendInt32(b, 0)  // crc, set below
+	crcStart := len(b)
+	b = appendInt16(b, 0) // attributes
+	b = appendInt32(b, 0) // last_offset_delta
+	b = appendInt64(b, ms)
+	b = appendInt64(b, ms)
+	b = appendInt64(b, -1) // producer_id
+	b = appendInt16(b, -1) // producer_epoch
+	b = appendInt32(b, -1) // base_sequence
+	b = appendInt32(b, 1)  // records
+	b = appendKafkaVarint(b, int64(len(rec)))
+	b = append(b, rec...)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(b)-12))
+	binary.BigEndian.PutUint32(b[crcStart-4:], crc32.Checksum(b[crcStart:], castagnoli))
+	return b
+}
+
+// kafkaError is an error code of a Kafka response.
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	switch e {
+	case 3:
+		return "kafka: unknown topic or partition"
+	case 6:
+		return "kafka: not the leader of the partition"
+	case 7:
+		return "kafka: request timed out"
+	case 29:
+		return "kafka: topic authorization failed"
+	}
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+func appendInt16(b []byte, v int16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return appendInt32(appendInt32(b, int32(v>>32)), int32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendInt16(b, int16(len(s))), s...)
+}
+
+// appendKafkaVarint appends v zigzag encoded, as the fields of records.
+func appendKafkaVarint(b []byte, v int64) []byte {
+	return appendProtoVarint(b, uint64(v<<1^v>>63))
+}
+
+// kafkaReader reads the fields of a Kafka response. After an error,
+// its methods return zero values and err is set.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+var errKafkaShort = errors.New("kafka: short response")
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.b) < n {
+		if r.err == nil {
+			r.err = errKafkaShort
+		}
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string, "" if it is null.
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) int32s() []int32 {
+	var vs []int32
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		vs = append(vs, r.int32())
+	}
+	return vs
+}
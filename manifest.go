@@ -0,0 +1,114 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// manifest records the effective configuration of a run, so that its
+// results can be reproduced later.
+type manifest struct {
+	Version    string    `json:"version"`
+	GoVersion  string    `json:"go_version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Hostname   string    `json:"hostname,omitempty"`
+	NumCPU     int       `json:"num_cpu"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	Start      time.Time `json:"start"`
+
+	// Args are the command line arguments as given.
+	Args []string `json:"args"`
+
+	// URL is the target of the run.
+	URL string `json:"url"`
+
+	// Flags holds the value of every flag, including the defaults
+	// that were not set on the command line. The password of -a is
+	// redacted.
+	Flags map[string]string `json:"flags"`
+
+	// Headers are the values of the repeatable -H flag.
+	Headers []string `json:"headers,omitempty"`
+
+	// BodySHA256 is the hex encoded SHA-256 of the request body.
+	BodySHA256 string `json:"body_sha256,omitempty"`
+
+	// Hooks are the outcomes of the -hook commands. The -manifest file
+	// is written again after the run to include them all; the manifest
+	// of the json output only has those run before the run.
+	Hooks []hookRun `json:"hooks,omitempty"`
+}
+
+// newManifest captures the effective configuration after the flags
+// have been parsed.
+func newManifest(url string, body []byte, headers []string) *manifest {
+	m := &manifest{
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Start:      time.Now(),
+		Args:       append([]string(nil), os.Args[1:]...),
+		URL:        url,
+		Flags:      make(map[string]string),
+		Headers:    headers,
+	}
+	m.Hostname, _ = os.Hostname()
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "H" {
+			return
+		}
+		m.Flags[f.Name] = f.Value.String()
+	})
+	if a := m.Flags["a"]; a != "" {
+		if match, err := parseInputWithRegexp(a, authRegexp); err == nil {
+			m.Flags["a"] = match[1] + ":REDACTED"
+		}
+	}
+	for i, arg := range m.Args {
+		switch {
+		case (arg == "-a" || arg == "--a") && i+1 < len(m.Args):
+			m.Args[i+1] = m.Flags["a"]
+		case strings.HasPrefix(arg, "-a="), strings.HasPrefix(arg, "--a="):
+			m.Args[i] = arg[:strings.Index(arg, "=")+1] + m.Flags["a"]
+		}
+	}
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		m.BodySHA256 = hex.EncodeToString(sum[:])
+	}
+	return m
+}
+
+// writeFile writes the manifest as indented JSON to the named file.
+func (m *manifest) writeFile(name string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
@@ -0,0 +1,316 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const matrixUsage = `Usage: hey matrix -config <file> [options...]
+
+Runs hey once for every combination of the flag values of a matrix, one
+run after the other, and prints a table comparing the runs.
+
+The config is a JSON file or, if its name ends in .yaml or .yml, a
+YAML file, e.g.:
+
+  args: [-z, 30s, "http://localhost:8080/"]
+  matrix:
+    c: [10, 50, 200]
+    disable-keepalive: [false, true]
+  cooldown: 10s
+  health:
+    url: "http://localhost:8080/health"
+    timeout: 1m
+
+"args" are the flags and URLs of every run, "matrix" maps flag names to
+the values to combine and "cooldown" is the pause between two runs.
+If "health" is set, its "url" is polled every "interval", 1s by
+default, before every run until it responds with a 2xx status, so that
+the system under test recovered from the previous run. The matrix fails
+if it does not within "timeout", 1m by default.
+
+Options:
+  -config    Matrix config file.
+  -cooldown  Pause between two runs, e.g. 30s. Overrides the cooldown of
+             the config.
+  -o       Output type. "csv" prints the comparison as comma-separated
+           values. By default a table is printed.
+`
+
+// matrixConfig is the config of "hey matrix".
+type matrixConfig struct {
+	Args     []string                 `json:"args"`
+	Matrix   map[string][]interface{} `json:"matrix"`
+	Cooldown string                   `json:"cooldown"`
+	Health   *matrixHealth            `json:"health"`
+
+	cooldown time.Duration
+}
+
+// matrixHealth is the health check of the target between runs.
+type matrixHealth struct {
+	URL      string `json:"url"`
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// wait polls the health check URL until it responds with a 2xx status.
+func (h *matrixHealth) wait() error {
+	client := &http.Client{Timeout: h.interval}
+	deadline := time.Now().Add(h.timeout)
+	for {
+		res, err := client.Get(h.URL)
+		if err == nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode >= 200 && res.StatusCode < 300 {
+				return nil
+			}
+			err = errors.New(res.Status)
+		}
+		if time.Now().Add(h.interval).After(deadline) {
+			return fmt.Errorf("%s is not healthy after %v: %v", h.URL, h.timeout, err)
+		}
+		time.Sleep(h.interval)
+	}
+}
+
+// loadMatrix reads and validates the matrix config at path.
+func loadMatrix(path string) (*matrixConfig, error) {
+	var cfg matrixConfig
+	err := decodeFile(path, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Matrix) == 0 {
+		return nil, fmt.Errorf("%s: no matrix", path)
+	}
+	for name, values := range cfg.Matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%s: no values for -%s", path, name)
+		}
+		if name == "o" || name == "output-file" {
+			return nil, fmt.Errorf("%s: -%s cannot be part of the matrix", path, name)
+		}
+	}
+	for _, arg := range cfg.Args {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && (name == "o" || name == "output-file") {
+			return nil, fmt.Errorf("%s: -%s cannot be set in the args, the runs report as json", path, name)
+		}
+	}
+	if h := cfg.Health; h != nil {
+		if h.URL == "" {
+			return nil, fmt.Errorf("%s: no health check url", path)
+		}
+		h.interval, h.timeout = time.Second, time.Minute
+		if h.Interval != "" {
+			if h.interval, err = time.ParseDuration(h.Interval); err != nil || h.interval <= 0 {
+				return nil, fmt.Errorf("%s: invalid health check interval %q", path, h.Interval)
+			}
+		}
+		if h.Timeout != "" {
+			if h.timeout, err = time.ParseDuration(h.Timeout); err != nil || h.timeout <= 0 {
+				return nil, fmt.Errorf("%s: invalid health check timeout %q", path, h.Timeout)
+			}
+		}
+	}
+	if cfg.Cooldown != "" {
+		if cfg.cooldown, err = time.ParseDuration(cfg.Cooldown); err != nil || cfg.cooldown < 0 {
+			return nil, fmt.Errorf("%s: invalid cooldown %q", path, cfg.Cooldown)
+		}
+	}
+	return &cfg, nil
+}
+
+// matrixParam is a flag value of a run of the matrix.
+type matrixParam struct {
+	Name  string
+	Value string
+}
+
+// runs returns the flag values of every run: every combination of the
+// values of the matrix, with the flags sorted by name and the values of
+// the last flag varying fastest.
+func (cfg *matrixConfig) runs() [][]matrixParam {
+	names := make([]string, 0, len(cfg.Matrix))
+	for name := range cfg.Matrix {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	runs := [][]matrixParam{nil}
+	for _, name := range names {
+		var next [][]matrixParam
+		for _, run := range runs {
+			for _, v := range cfg.Matrix[name] {
+				p := matrixParam{Name: name, Value: fmt.Sprint(v)}
+				next = append(next, append(append([]matrixParam(nil), run...), p))
+			}
+		}
+		runs = next
+	}
+	return runs
+}
+
+// matrixResult is the part of the json output of a run that is compared.
+type matrixResult struct {
+	Summary struct {
+		Requests int64   `json:"requests"`
+		Rps      float64 `json:"rps"`
+		Average  float64 `json:"average"`
+	} `json:"summary"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	Errors      map[string]int     `json:"errors"`
+}
+
+func (r *matrixResult) errors() int {
+	var n int
+	for _, c := range r.Errors {
+		n += c
+	}
+	return n
+}
+
+// runMatrix runs hey with params and args and returns its summary.
+func runMatrix(exe string, params []matrixParam, args []string) (*matrixResult, error) {
+	cmdArgs := []string{"-o", "json", "-quiet"}
+	for _, p := range params {
+		cmdArgs = append(cmdArgs, "-"+p.Name+"="+p.Value)
+	}
+	cmd := exec.Command(exe, append(cmdArgs, args...)...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == exitThresholds {
+		// The run is complete, it only violated a threshold.
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res matrixResult
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("invalid output: %v", err)
+	}
+	return &res, nil
+}
+
+// printMatrix writes the comparison of the runs to w, as a table or,
+// if output is "csv", as comma-separated values.
+func printMatrix(w io.Writer, output string, runs [][]matrixParam, results []*matrixResult) {
+	sep := "\t"
+	if output == "csv" {
+		sep = ","
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		defer tw.Flush()
+		w = tw
+	}
+	var header []string
+	for _, p := range runs[0] {
+		header = append(header, p.Name)
+	}
+	header = append(header, "requests", "rps", "average", "p50", "p95", "p99", "errors")
+	fmt.Fprintln(w, strings.Join(header, sep))
+	for i, run := range runs {
+		var row []string
+		for _, p := range run {
+			row = append(row, p.Value)
+		}
+		res := results[i]
+		row = append(row,
+			fmt.Sprint(res.Summary.Requests),
+			fmt.Sprintf("%.4f", res.Summary.Rps),
+			fmt.Sprintf("%.4f", res.Summary.Average),
+			fmt.Sprintf("%.4f", res.Percentiles["p50"]),
+			fmt.Sprintf("%.4f", res.Percentiles["p95"]),
+			fmt.Sprintf("%.4f", res.Percentiles["p99"]),
+			fmt.Sprint(res.errors()),
+		)
+		fmt.Fprintln(w, strings.Join(row, sep))
+	}
+}
+
+// matrixMain implements the "hey matrix" command.
+func matrixMain(args []string) {
+	fs := flag.NewFlagSet("matrix", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, matrixUsage)
+	}
+	config := fs.String("config", "", "")
+	output := fs.String("o", "", "")
+	cooldown := fs.Duration("cooldown", -1, "")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *config == "" || (*output != "" && *output != "csv") {
+		fs.Usage()
+		os.Exit(1)
+	}
+	cfg, err := loadMatrix(*config)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	if *cooldown >= 0 {
+		cfg.cooldown = *cooldown
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		errAndExit(err.Error())
+	}
+
+	runs := cfg.runs()
+	results := make([]*matrixResult, len(runs))
+	for i, params := range runs {
+		if i > 0 && cfg.cooldown > 0 {
+			fmt.Fprintf(os.Stderr, "Cooling down for %v...\n", cfg.cooldown)
+			time.Sleep(cfg.cooldown)
+		}
+		if cfg.Health != nil {
+			if err := cfg.Health.wait(); err != nil {
+				errAndExit(err.Error())
+			}
+		}
+		var desc []string
+		for _, p := range params {
+			desc = append(desc, "-"+p.Name+"="+p.Value)
+		}
+		fmt.Fprintf(os.Stderr, "Run %d/%d: %s\n", i+1, len(runs), strings.Join(desc, " "))
+		if results[i], err = runMatrix(exe, params, cfg.Args); err != nil {
+			errAndExit(fmt.Sprintf("Run %d failed: %v", i+1, err))
+		}
+	}
+	if *output == "" {
+		fmt.Println("Latencies in seconds.")
+		fmt.Println()
+	}
+	printMatrix(os.Stdout, *output, runs, results)
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// networkPresets are the -emulate-network profiles of typical mobile
+// networks.
+var networkPresets = map[string]requester.NetworkProfile{
+	"3g":  {RTT: 300 * time.Millisecond, Jitter: 80 * time.Millisecond, Loss: 0.01},
+	"lte": {RTT: 70 * time.Millisecond, Jitter: 20 * time.Millisecond, Loss: 0.002},
+}
+
+// parseNetwork parses an -emulate-network value, either the name of a
+// preset or custom:rtt=<d>,jitter=<d>,loss=<rate>.
+func parseNetwork(s string) (*requester.NetworkProfile, error) {
+	if p, ok := networkPresets[strings.ToLower(s)]; ok {
+		return &p, nil
+	}
+	if !strings.HasPrefix(s, "custom:") {
+		return nil, fmt.Errorf("unknown network %q, want 3g, lte or custom:rtt=<d>,jitter=<d>,loss=<rate>", s)
+	}
+	var p requester.NetworkProfile
+	for _, kv := range strings.Split(strings.TrimPrefix(s, "custom:"), ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid setting %q, want key=value", kv)
+		}
+		k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		var err error
+		switch k {
+		case "rtt":
+			p.RTT, err = time.ParseDuration(v)
+		case "jitter":
+			p.Jitter, err = time.ParseDuration(v)
+		case "loss":
+			p.Loss, err = parseRate(v)
+		default:
+			return nil, fmt.Errorf("unknown setting %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", k, err)
+		}
+	}
+	if p.RTT < 0 || p.Jitter < 0 {
+		return nil, fmt.Errorf("rtt and jitter must not be negative")
+	}
+	return &p, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	gourl "net/url"
+	"time"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsPreflight connects to the target and prints the negotiated TLS
+// version and protocol and the served certificate chain. It warns about
+// certificates that expire within warn. conf holds the client certificate
+// and trusted certificate authorities, if any, and resolve the addresses
+// pinned by -resolve.
+func tlsPreflight(w io.Writer, u *gourl.URL, serverName string, h2 bool, warn time.Duration, conf *tls.Config, resolve map[string]string) error {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+	if serverName == "" {
+		serverName = u.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(serverName); err == nil {
+		serverName = host
+	}
+	protos := []string{"http/1.1"}
+	if h2 {
+		protos = []string{"h2", "http/1.1"}
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	conf = conf.Clone()
+	conf.ServerName = serverName
+	conf.NextProtos = protos
+	conn, err := tls.DialWithDialer(d, "tcp", resolvedAddr(resolve, addr), conf)
+	if err != nil {
+		return fmt.Errorf("tls preflight failed: %v", err)
+	}
+	defer conn.Close()
+	cs := conn.ConnectionState()
+
+	version, ok := tlsVersions[cs.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04x", cs.Version)
+	}
+	proto := cs.NegotiatedProtocol
+	if proto == "" {
+		proto = "none"
+	}
+	fmt.Fprintf(w, "TLS preflight for %v (SNI %v):\n", addr, serverName)
+	fmt.Fprintf(w, "  Version:\t%v\n", version)
+	fmt.Fprintf(w, "  ALPN protocol:\t%v\n", proto)
+	fmt.Fprintf(w, "  Certificate chain:\n")
+	now := time.Now()
+	for i, cert := range cs.PeerCertificates {
+		left := cert.NotAfter.Sub(now)
+		fmt.Fprintf(w, "  [%d]\t%v, issued by %v, expires %v (%d days)\n",
+			i, cert.Subject, cert.Issuer, cert.NotAfter.Format("2006-01-02"), int(left.Hours()/24))
+		if left < warn {
+			fmt.Fprintf(w, "  WARNING: certificate %v expires in less than %v.\n", cert.Subject, warn)
+		}
+	}
+	if len(cs.PeerCertificates) > 0 {
+		if err := cs.PeerCertificates[0].VerifyHostname(serverName); err != nil {
+			fmt.Fprintf(w, "  WARNING: %v\n", err)
+		}
+	}
+	if h2 && cs.NegotiatedProtocol != "h2" {
+		fmt.Fprintf(w, "  WARNING: HTTP/2 was requested but the server did not negotiate h2.\n")
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,1079 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// protoSet holds the messages, enums and service methods of a .proto
+// file and the files it imports, by their fully-qualified names.
+type protoSet struct {
+	messages map[string]*protoMessage
+	enums    map[string]*protoEnum
+	methods  map[string]*protoMethod
+	read     map[string]bool
+}
+
+// protoMessage is a message type.
+type protoMessage struct {
+	name   string
+	fields []*protoField
+
+	// mapEntry is set for the entries of a map field, whose key is
+	// field 1 and value field 2.
+	mapEntry bool
+}
+
+// protoField is a field of a message.
+type protoField struct {
+	name     string
+	jsonName string
+	number   int
+	repeated bool
+	packed   bool
+
+	// typ is the scalar type of the field or the name of its message
+	// or enum type as written; message or enum is set once resolved.
+	typ     string
+	scope   string
+	message *protoMessage
+	enum    *protoEnum
+}
+
+// protoEnum is an enum type.
+type protoEnum struct {
+	name   string
+	values map[string]int32
+}
+
+// protoMethod is a method of a service.
+type protoMethod struct {
+	// name is "package.Service/Method", the path of its calls.
+	name    string
+	input   string
+	scope   string
+	message *protoMessage
+}
+
+// protoScalars are the scalar types and their wire types.
+var protoScalars = map[string]int{
+	"double": 1, "float": 5, "int32": 0, "int64": 0, "uint32": 0,
+	"uint64": 0, "sint32": 0, "sint64": 0, "fixed32": 5, "fixed64": 1,
+	"sfixed32": 5, "sfixed64": 1, "bool": 0, "string": 2, "bytes": 2,
+}
+
+// readProto reads the .proto file name and the files it imports from
+// the same directory, if they exist there. Imports that do not, e.g.
+// the well-known types, are skipped; using one of their types is an
+// error.
+func readProto(name string) (*protoSet, error) {
+	s := &protoSet{
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]*protoEnum),
+		methods:  make(map[string]*protoMethod),
+		read:     make(map[string]bool),
+	}
+	if err := s.readFile(filepath.Dir(name), filepath.Base(name), true); err != nil {
+		return nil, err
+	}
+	for _, m := range s.messages {
+		for _, f := range m.fields {
+			if _, ok := protoScalars[f.typ]; ok {
+				continue
+			}
+			full := s.resolve(f.scope, f.typ)
+			if f.message, f.enum = s.messages[full], s.enums[full]; f.message == nil && f.enum == nil {
+				return nil, fmt.Errorf("%s: type %s of %s.%s not found", name, f.typ, m.name, f.name)
+			}
+			f.packed = f.packed && f.message == nil
+		}
+	}
+	for n, m := range s.methods {
+		if m.message = s.messages[s.resolve(m.scope, m.input)]; m.message == nil {
+			return nil, fmt.Errorf("%s: input type %s of %s not found", name, m.input, n)
+		}
+	}
+	return s, nil
+}
+
+func (s *protoSet) readFile(dir, name string, required bool) error {
+	if s.read[name] {
+		return nil
+	}
+	s.read[name] = true
+	b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		if required {
+			return err
+		}
+		return nil
+	}
+	toks, err := tokenizeProto(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	p := &protoParser{set: s, toks: toks, proto3: true}
+	imports, err := p.file()
+	if err != nil {
+		return fmt.Errorf("%s:%d: %v", name, p.line(), err)
+	}
+	for _, imp := range imports {
+		if err := s.readFile(dir, imp, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the fully-qualified name of the type name used in the
+// scope, the fully-qualified name of a package or message, by looking
+// it up in the scope and then in the enclosing scopes in turn.
+func (s *protoSet) resolve(scope, name string) string {
+	if strings.HasPrefix(name, ".") {
+		return name[1:]
+	}
+	for {
+		full := name
+		if scope != "" {
+			full = scope + "." + name
+		}
+		if s.messages[full] != nil || s.enums[full] != nil || scope == "" {
+			return full
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// protoToken is a token of a .proto file.
+type protoToken struct {
+	text string
+	line int
+}
+
+// tokenizeProto splits src into identifiers, numbers, strings and
+// symbols, dropping comments.
+func tokenizeProto(src string) ([]protoToken, error) {
+	var toks []protoToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					break
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, protoToken{src[i : j+1], line})
+			i = j + 1
+		case c == '_' || c == '.' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, protoToken{src[i:j], line})
+			i = j
+		default:
+			toks = append(toks, protoToken{string(c), line})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// protoParser parses the tokens of a .proto file into its set.
+type protoParser struct {
+	set    *protoSet
+	toks   []protoToken
+	i      int
+	pkg    string
+	proto3 bool
+}
+
+func (p *protoParser) line() int {
+	if p.i < len(p.toks) {
+		return p.toks[p.i].line
+	}
+	if len(p.toks) > 0 {
+		return p.toks[len(p.toks)-1].line
+	}
+	return 1
+}
+
+func (p *protoParser) peek() string {
+	if p.i < len(p.toks) {
+		return p.toks[p.i].text
+	}
+	return ""
+}
+
+func (p *protoParser) next() string {
+	t := p.peek()
+	if p.i < len(p.toks) {
+		p.i++
+	}
+	return t
+}
+
+func (p *protoParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			return fmt.Errorf("want %q, got the end of the file", t)
+		}
+		return fmt.Errorf("want %q, got %q", t, got)
+	}
+	return nil
+}
+
+// ident returns the next token, which must be an identifier.
+func (p *protoParser) ident() (string, error) {
+	t := p.next()
+	if t == "" || !(t[0] == '_' || t[0] == '.' || unicode.IsLetter(rune(t[0]))) {
+		return "", fmt.Errorf("want a name, got %q", t)
+	}
+	return t, nil
+}
+
+// skipStatement skips the tokens up to the end of the statement, a ';'
+// or a {...} block.
+func (p *protoParser) skipStatement() error {
+	for {
+		switch p.next() {
+		case ";":
+			return nil
+		case "{":
+			return p.skipBlock()
+		case "":
+			return fmt.Errorf("unterminated statement")
+		}
+	}
+}
+
+// skipBlock skips the tokens up to the '}' that closes the current block.
+func (p *protoParser) skipBlock() error {
+	for depth := 1; depth > 0; {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case "":
+			return fmt.Errorf("unterminated block")
+		}
+	}
+	return nil
+}
+
+// file parses the file and returns the files it imports.
+func (p *protoParser) file() (imports []string, err error) {
+	for p.peek() != "" {
+		switch t := p.next(); t {
+		case ";":
+		case "syntax":
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			p.proto3 = p.next() != `"proto2"`
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "package":
+			if p.pkg, err = p.ident(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "import":
+			if p.peek() == "public" || p.peek() == "weak" {
+				p.next()
+			}
+			imp, err := unquoteProto(p.next())
+			if err != nil {
+				return nil, fmt.Errorf("invalid import")
+			}
+			imports = append(imports, imp)
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "message":
+			if err := p.message(p.pkg); err != nil {
+				return nil, err
+			}
+		case "enum":
+			if err := p.enum(p.pkg); err != nil {
+				return nil, err
+			}
+		case "service":
+			if err := p.service(); err != nil {
+				return nil, err
+			}
+		case "option", "edition", "extend":
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q", t)
+		}
+	}
+	return imports, nil
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// message parses a message definition in scope.
+func (p *protoParser) message(scope string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	m := &protoMessage{name: qualify(scope, name)}
+	p.set.messages[m.name] = m
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	return p.messageBody(m)
+}
+
+// messageBody parses the fields and nested types of m up to the closing
+// '}'. It also parses the body of a oneof, whose fields belong to m.
+func (p *protoParser) messageBody(m *protoMessage) error {
+	for {
+		switch t := p.peek(); t {
+		case "}":
+			p.next()
+			return nil
+		case "":
+			return fmt.Errorf("unterminated message %s", m.name)
+		case ";":
+			p.next()
+		case "message":
+			p.next()
+			if err := p.message(m.name); err != nil {
+				return err
+			}
+		case "enum":
+			p.next()
+			if err := p.enum(m.name); err != nil {
+				return err
+			}
+		case "oneof":
+			p.next()
+			if _, err := p.ident(); err != nil {
+				return err
+			}
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.messageBody(m); err != nil {
+				return err
+			}
+		case "option", "reserved", "extensions", "extend":
+			p.next()
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		case "map":
+			p.next()
+			if err := p.mapField(m); err != nil {
+				return err
+			}
+		default:
+			if err := p.field(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// field parses a field of m.
+func (p *protoParser) field(m *protoMessage) error {
+	f := &protoField{scope: m.name}
+	switch p.peek() {
+	case "repeated":
+		p.next()
+		f.repeated = true
+	case "optional", "required":
+		p.next()
+	}
+	var err error
+	if f.typ, err = p.ident(); err != nil {
+		return err
+	}
+	if f.typ == "group" {
+		return fmt.Errorf("groups are not supported")
+	}
+	if f.name, err = p.ident(); err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	if f.number, err = strconv.Atoi(p.next()); err != nil || f.number < 1 {
+		return fmt.Errorf("invalid number of field %s", f.name)
+	}
+	wire, scalar := protoScalars[f.typ]
+	// Repeated numbers are packed by default since proto3.
+	f.packed = f.repeated && p.proto3 && (!scalar || wire != 2)
+	f.jsonName = jsonName(f.name)
+	if p.peek() == "[" {
+		if err := p.fieldOptions(f); err != nil {
+			return err
+		}
+	}
+	m.fields = append(m.fields, f)
+	return p.expect(";")
+}
+
+// fieldOptions parses the [options] of f, keeping packed and json_name.
+func (p *protoParser) fieldOptions(f *protoField) error {
+	p.next()
+	for {
+		name := p.next()
+		if name == "(" {
+			// A custom option, e.g. (validate.rules).string.min_len.
+			for name = ""; p.peek() != "="; {
+				if p.peek() == "" {
+					return fmt.Errorf("unterminated options of field %s", f.name)
+				}
+				name += p.next()
+			}
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		value := p.next()
+		switch value {
+		case "{":
+			if err := p.skipBlock(); err != nil {
+				return err
+			}
+		case "-":
+			value += p.next()
+		}
+		switch name {
+		case "packed":
+			f.packed = f.repeated && value == "true"
+		case "json_name":
+			s, err := unquoteProto(value)
+			if err != nil {
+				return fmt.Errorf("invalid json_name of field %s", f.name)
+			}
+			f.jsonName = s
+		}
+		switch t := p.next(); t {
+		case "]":
+			return nil
+		case ",":
+		default:
+			return fmt.Errorf("want ',' or ']' in the options of field %s, got %q", f.name, t)
+		}
+	}
+}
+
+// mapField parses a map<key, value> field of m, which is a repeated
+// field of entries with the key as field 1 and the value as field 2.
+func (p *protoParser) mapField(m *protoMessage) error {
+	if err := p.expect("<"); err != nil {
+		return err
+	}
+	key, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	value, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(">"); err != nil {
+		return err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(p.next())
+	if err != nil || number < 1 {
+		return fmt.Errorf("invalid number of field %s", name)
+	}
+	entry := &protoMessage{name: m.name + "." + name + "Entry", mapEntry: true}
+	entry.fields = []*protoField{
+		{name: "key", jsonName: "key", number: 1, typ: key, scope: m.name},
+		{name: "value", jsonName: "value", number: 2, typ: value, scope: m.name},
+	}
+	p.set.messages[entry.name] = entry
+	f := &protoField{name: name, jsonName: jsonName(name), number: number, repeated: true, typ: "." + entry.name, scope: m.name}
+	if p.peek() == "[" {
+		if err := p.fieldOptions(f); err != nil {
+			return err
+		}
+	}
+	m.fields = append(m.fields, f)
+	return p.expect(";")
+}
+
+// enum parses an enum definition in scope.
+func (p *protoParser) enum(scope string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	e := &protoEnum{name: qualify(scope, name), values: make(map[string]int32)}
+	p.set.enums[e.name] = e
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		switch t := p.next(); t {
+		case "}":
+			return nil
+		case "":
+			return fmt.Errorf("unterminated enum %s", e.name)
+		case ";":
+		case "option", "reserved":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		default:
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			v := p.next()
+			if v == "-" {
+				v += p.next()
+			}
+			n, err := strconv.ParseInt(v, 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid value of %s", t)
+			}
+			e.values[t] = int32(n)
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// service parses a service definition, keeping the input types of its
+// methods.
+func (p *protoParser) service() error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	service := qualify(p.pkg, name)
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		switch t := p.next(); t {
+		case "}":
+			return nil
+		case "":
+			return fmt.Errorf("unterminated service %s", service)
+		case ";":
+		case "rpc":
+			method, err := p.ident()
+			if err != nil {
+				return err
+			}
+			if err := p.expect("("); err != nil {
+				return err
+			}
+			if p.peek() == "stream" {
+				p.next()
+			}
+			input, err := p.ident()
+			if err != nil {
+				return err
+			}
+			name := service + "/" + method
+			p.set.methods[name] = &protoMethod{name: name, input: input, scope: p.pkg}
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		default:
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unquoteProto unquotes a "double" or 'single' quoted string.
+func unquoteProto(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = `"` + strings.Replace(s[1:len(s)-1], `"`, `\"`, -1) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+// jsonName returns the lowerCamelCase JSON name of the field name.
+func jsonName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// method returns the method name, "package.Service/Method" or
+// "package.Service.Method".
+func (s *protoSet) method(name string) (*protoMethod, error) {
+	if !strings.Contains(name, "/") {
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[:i] + "/" + name[i+1:]
+		}
+	}
+	if m, ok := s.methods[name]; ok {
+		return m, nil
+	}
+	var names []string
+	for n := range s.methods {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("method %s not found, the file defines no services", name)
+	}
+	return nil, fmt.Errorf("method %s not found, want one of %s", name, strings.Join(names, ", "))
+}
+
+// encodeJSON encodes the JSON object data as a message of type m, after
+// the JSON mapping of protocol buffers: fields are named by their name
+// or JSON name, 64-bit integers may be strings, enums names or numbers
+// and bytes base64.
+func encodeJSON(m *protoMessage, data []byte) ([]byte, error) {
+	d := json.NewDecoder(strings.NewReader(string(data)))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return m.encode(nil, v, m.name)
+}
+
+// encode appends the message of type m with the fields of the JSON
+// value v at path to b.
+func (m *protoMessage) encode(b []byte, v interface{}, path string) ([]byte, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: want an object, got %s", path, jsonKind(v))
+	}
+	var unknown []string
+	for k := range obj {
+		if m.field(k) == nil {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s: unknown field %s", path, strings.Join(unknown, ", "))
+	}
+	for _, f := range m.fields {
+		fv, ok := obj[f.jsonName]
+		if !ok {
+			fv = obj[f.name]
+		}
+		if fv == nil {
+			continue
+		}
+		var err error
+		if b, err = f.encode(b, fv, path+"."+f.name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// field returns the field of m with the name or JSON name k.
+func (m *protoMessage) field(k string) *protoField {
+	for _, f := range m.fields {
+		if f.name == k || f.jsonName == k {
+			return f
+		}
+	}
+	return nil
+}
+
+// encode appends field f with the JSON value v at path to b.
+func (f *protoField) encode(b []byte, v interface{}, path string) ([]byte, error) {
+	if !f.repeated {
+		return f.encodeOne(b, v, path)
+	}
+	var items []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		if f.message == nil || !f.message.mapEntry {
+			return nil, fmt.Errorf("%s: want an array, got an object", path)
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			items = append(items, map[string]interface{}{"key": mapKey(k, f.message.fields[0].typ), "value": v[k]})
+		}
+	default:
+		return nil, fmt.Errorf("%s: want an array, got %s", path, jsonKind(v))
+	}
+	if f.packed {
+		var packed []byte
+		for i, item := range items {
+			var err error
+			if packed, err = f.appendValue(packed, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return nil, err
+			}
+		}
+		b = appendProtoTag(b, f.number, 2)
+		b = appendProtoVarint(b, uint64(len(packed)))
+		return append(b, packed...), nil
+	}
+	for i, item := range items {
+		var err error
+		if b, err = f.encodeOne(b, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// mapKey returns the JSON value of the map key k of type typ.
+func mapKey(k, typ string) interface{} {
+	switch typ {
+	case "string":
+		return k
+	case "bool":
+		return k == "true"
+	}
+	return json.Number(k)
+}
+
+// encodeOne appends a single value of f, with its tag, to b.
+func (f *protoField) encodeOne(b []byte, v interface{}, path string) ([]byte, error) {
+	wire := 2
+	if f.enum != nil {
+		wire = 0
+	} else if f.message == nil {
+		wire = protoScalars[f.typ]
+	}
+	b = appendProtoTag(b, f.number, wire)
+	return f.appendValue(b, v, path)
+}
+
+// appendValue appends the JSON value v of f, without a tag, to b.
+func (f *protoField) appendValue(b []byte, v interface{}, path string) ([]byte, error) {
+	if f.message != nil {
+		msg, err := f.message.encode(nil, v, path)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoVarint(b, uint64(len(msg)))
+		return append(b, msg...), nil
+	}
+	if f.enum != nil {
+		if s, ok := v.(string); ok {
+			n, ok := f.enum.values[s]
+			if !ok {
+				return nil, fmt.Errorf("%s: %q is not a value of %s", path, s, f.enum.name)
+			}
+			return appendProtoVarint(b, uint64(int64(n))), nil
+		}
+		n, err := jsonInt(v, 32, path)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtoVarint(b, uint64(n)), nil
+	}
+	switch f.typ {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: want a string, got %s", path, jsonKind(v))
+		}
+		b = appendProtoVarint(b, uint64(len(s)))
+		return append(b, s...), nil
+	case "bytes":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: want a base64 string, got %s", path, jsonKind(v))
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			if data, err = base64.URLEncoding.DecodeString(s); err != nil {
+				return nil, fmt.Errorf("%s: invalid base64", path)
+			}
+		}
+		b = appendProtoVarint(b, uint64(len(data)))
+		return append(b, data...), nil
+	case "bool":
+		t, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s: want a boolean, got %s", path, jsonKind(v))
+		}
+		if t {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case "double", "float":
+		x, err := jsonFloat(v, path)
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == "float" {
+			return appendProtoFixed32(b, math.Float32bits(float32(x))), nil
+		}
+		return appendProtoFixed64(b, math.Float64bits(x)), nil
+	case "int32", "sint32", "sfixed32", "int64", "sint64", "sfixed64":
+		bits := 64
+		if strings.HasSuffix(f.typ, "32") {
+			bits = 32
+		}
+		n, err := jsonInt(v, bits, path)
+		if err != nil {
+			return nil, err
+		}
+		switch f.typ {
+		case "sint32", "sint64":
+			return appendProtoVarint(b, uint64(n<<1)^uint64(n>>63)), nil
+		case "sfixed32":
+			return appendProtoFixed32(b, uint32(n)), nil
+		case "sfixed64":
+			return appendProtoFixed64(b, uint64(n)), nil
+		}
+		return appendProtoVarint(b, uint64(n)), nil
+	}
+	// uint32, uint64, fixed32 and fixed64.
+	bits := 64
+	if strings.HasSuffix(f.typ, "32") {
+		bits = 32
+	}
+	n, err := jsonUint(v, bits, path)
+	if err != nil {
+		return nil, err
+	}
+	switch f.typ {
+	case "fixed32":
+		return appendProtoFixed32(b, uint32(n)), nil
+	case "fixed64":
+		return appendProtoFixed64(b, n), nil
+	}
+	return appendProtoVarint(b, n), nil
+}
+
+func appendProtoTag(b []byte, number, wire int) []byte {
+	return appendProtoVarint(b, uint64(number)<<3|uint64(wire))
+}
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendProtoFixed32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendProtoFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// jsonNumber returns the number v, a JSON number or a string.
+func jsonNumber(v interface{}, path string) (string, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return string(v), nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("%s: want a number, got %s", path, jsonKind(v))
+}
+
+func jsonInt(v interface{}, bits int, path string) (int64, error) {
+	s, err := jsonNumber(v, path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		// Integers may be written with an exponent, e.g. 1e3.
+		x, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || x != math.Trunc(x) || x < -math.Pow(2, float64(bits-1)) || x >= math.Pow(2, float64(bits-1)) {
+			return 0, fmt.Errorf("%s: %s is not an int%d", path, s, bits)
+		}
+		n = int64(x)
+	}
+	return n, nil
+}
+
+func jsonUint(v interface{}, bits int, path string) (uint64, error) {
+	s, err := jsonNumber(v, path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		x, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || x != math.Trunc(x) || x < 0 || x >= math.Pow(2, float64(bits)) {
+			return 0, fmt.Errorf("%s: %s is not a uint%d", path, s, bits)
+		}
+		n = uint64(x)
+	}
+	return n, nil
+}
+
+func jsonFloat(v interface{}, path string) (float64, error) {
+	s, err := jsonNumber(v, path)
+	if err != nil {
+		return 0, err
+	}
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s is not a number", path, s)
+	}
+	return x, nil
+}
+
+// jsonKind describes the kind of the JSON value v in errors.
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+// grpcCall returns the url and the body of the gRPC call of the method
+// name of the .proto file protoPath to target, host:port or
+// grpcs://host:port for TLS, with payload, the JSON of the request
+// message.
+func grpcCall(target, name, protoPath string, payload []byte) (string, []byte, error) {
+	scheme := "http"
+	switch {
+	case strings.HasPrefix(target, "grpcs://"):
+		scheme, target = "https", strings.TrimPrefix(target, "grpcs://")
+	case strings.HasPrefix(target, "grpc://"):
+		target = strings.TrimPrefix(target, "grpc://")
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return "", nil, fmt.Errorf("want host:port or grpcs://host:port, got %q", target)
+	}
+	set, err := readProto(protoPath)
+	if err != nil {
+		return "", nil, err
+	}
+	method, err := set.method(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	msg, err := encodeJSON(method.message, payload)
+	if err != nil {
+		return "", nil, err
+	}
+	// A gRPC message is prefixed by its compression flag and length.
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+	return scheme + "://" + target + "/" + method.name, body, nil
+}
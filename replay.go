@@ -0,0 +1,167 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+const reportUsage = `Usage: hey report [options...] <record file>
+
+Prints the report of a run recorded with -record. A record that was
+rotated, see -rotate-size of hey, is read from all its files, file,
+file.1, file.2..., and gzipped files are read with or without their .gz
+suffix.
+
+Options:
+  -o  Output type. If none provided, a summary is printed.
+      "csv" dumps the recorded results in comma-separated values format,
+      "jsonl" as lines of JSON.
+      "json" prints the summary as JSON, "html" an HTML page with charts.
+  -csv-fields  Comma separated list of the columns of the csv output.
+  -latency-unit  Unit of the latencies in the summary output, one of
+                 s, ms, us or auto. Default is auto.
+  -quantile-engine  Estimator of the latency percentiles, exact or tdigest.
+  -worker-stats  Report how requests and latencies were spread across
+                 workers.
+  -spool  Spool the results to memory-mapped files in the given directory
+          instead of keeping them in memory, see -spool of hey.
+`
+
+// reportMain implements the "hey report" command.
+func reportMain(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, reportUsage)
+	}
+	output := fs.String("o", "", "")
+	csvFields := fs.String("csv-fields", "", "")
+	latencyUnit := fs.String("latency-unit", "auto", "")
+	quantileEngine := fs.String("quantile-engine", "exact", "")
+	workerStats := fs.Bool("worker-stats", false, "")
+	spoolDir := fs.String("spool", "", "")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	opts := report.Options{
+		Output:         *output,
+		LatencyUnit:    *latencyUnit,
+		QuantileEngine: *quantileEngine,
+		WorkerStats:    *workerStats,
+		SpoolDir:       *spoolDir,
+	}
+	if !report.ValidLatencyUnit(opts.LatencyUnit) {
+		errAndExit("-latency-unit must be one of s, ms, us or auto.")
+	}
+	if opts.SpoolDir != "" {
+		if err := report.CheckSpoolDir(opts.SpoolDir); err != nil {
+			errAndExit("-spool: " + err.Error())
+		}
+	}
+	if *csvFields != "" {
+		fields, err := report.ParseCSVFields(*csvFields)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		opts.CSVFields = fields
+	}
+
+	f, err := openRecord(fs.Arg(0))
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	defer f.Close()
+	rep, total, err := report.Replay(f, os.Stdout, opts)
+	if err != nil {
+		errAndExit(fmt.Sprintf("%s: %v", fs.Arg(0), err))
+	}
+	rep.Finalize(total)
+}
+
+// recordFiles is the stream of the files of a rotated record.
+type recordFiles struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *recordFiles) Close() error {
+	for _, f := range r.files {
+		f.Close()
+	}
+	return nil
+}
+
+// openRecord opens the record file name and the files it was rotated to,
+// name.1, name.2 and so on, as a single stream. Files gzipped with -gzip
+// are read with their .gz suffix, which name may leave out.
+func openRecord(name string) (io.ReadCloser, error) {
+	r := &recordFiles{}
+	var parts []io.Reader
+	base := name
+	for seq := 0; ; seq++ {
+		part := base
+		if seq > 0 {
+			part = fmt.Sprintf("%s.%d", base, seq)
+		}
+		f, err := os.Open(part)
+		if os.IsNotExist(err) {
+			f, err = os.Open(part + ".gz")
+		}
+		if os.IsNotExist(err) && seq > 0 {
+			break
+		}
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, f)
+		br := bufio.NewReader(f)
+		var p io.Reader = br
+		if magic, _ := br.Peek(2); string(magic) == "\x1f\x8b" {
+			gz, err := gzip.NewReader(br)
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("%s: %v", f.Name(), err)
+			}
+			if seq == 0 {
+				base = strings.TrimSuffix(f.Name(), ".gz")
+			}
+			p = gz
+		}
+		if seq > 0 {
+			// Skip the header line the file repeats.
+			pr := bufio.NewReader(p)
+			if _, err := pr.ReadString('\n'); err != nil {
+				r.Close()
+				return nil, fmt.Errorf("%s: not a hey record file", f.Name())
+			}
+			p = pr
+		}
+		parts = append(parts, p)
+	}
+	r.Reader = io.MultiReader(parts...)
+	return r, nil
+}
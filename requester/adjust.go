@@ -0,0 +1,214 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Adjustment is a change of the load of a running Adjustable run, see
+// Work.Adjust.
+type Adjustment struct {
+	// QPS, if set, is the new rate limit of every worker without a
+	// group QPS, zero for no limit.
+	QPS *float64
+
+	// C, if not zero, is the new number of workers. Workers above it
+	// pause until it is raised again, and raising it above the C the
+	// run started with starts more workers.
+	C int
+
+	// Header, if not nil, are the headers set on the requests made from
+	// then on, replacing those of the previous adjustment. Their values
+	// replace those of the requests and are not templates; a key with
+	// no values removes the header.
+	Header http.Header
+}
+
+// liveLoad is the load of an Adjustable run.
+type liveLoad struct {
+	// left counts the requests of the run the workers did not take yet.
+	left int64
+	// qps holds the bits of the rate limit of the workers.
+	qps uint64
+	// c is the number of active workers.
+	c int32
+	// header holds the http.Header of the last adjustment, if any.
+	header atomic.Value
+
+	mu sync.Mutex
+	// changed is closed and replaced by every adjustment.
+	changed chan struct{}
+	// started and running count the workers started and still running.
+	started int
+	running int
+	// done is closed once the last worker returned.
+	done chan struct{}
+
+	// drained is closed once all requests of the run are taken.
+	drained   chan struct{}
+	drainOnce sync.Once
+}
+
+// initLive initializes the load of an Adjustable run to that of b.
+func (b *Work) initLive() {
+	l := &b.live
+	l.left = int64(b.N)
+	l.qps = math.Float64bits(b.QPS)
+	l.c = int32(b.C)
+	l.changed = make(chan struct{})
+	l.done = make(chan struct{})
+	l.drained = make(chan struct{})
+}
+
+// adjustable reports whether the load of the run can be adjusted while
+// it runs: it is Adjustable, and neither a WebSocket, HTTP/1.1
+// pipelined nor open model run.
+func (b *Work) adjustable() bool {
+	return b.Adjustable && !b.WebSocket && (b.Pipeline <= 1 || b.H2) && b.Rate == 0 && len(b.Schedule) == 0
+}
+
+// Adjust changes the load of a running Adjustable run, from the next
+// request of every worker on. It returns an error, leaving the load as
+// it is, if the run cannot be adjusted: a WebSocket, HTTP/1.1 pipelined
+// or open model run, or one with IsolateGroups or Setup steps whose
+// workers would be added to.
+func (b *Work) Adjust(a Adjustment) error {
+	if !b.adjustable() {
+		return errors.New("the load of the run cannot be adjusted")
+	}
+	if a.C < 0 || a.QPS != nil && *a.QPS < 0 {
+		return errors.New("the rate limit and the number of workers cannot be negative")
+	}
+	if a.C > b.C && (b.assignGroups() != nil || len(b.Setup) > 0) {
+		return errors.New("workers cannot be added to runs with isolated groups or setup steps")
+	}
+	b.Init()
+	l := &b.live
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if a.QPS != nil {
+		atomic.StoreUint64(&l.qps, math.Float64bits(*a.QPS))
+	}
+	if a.Header != nil {
+		h := make(http.Header, len(a.Header))
+		for k, vs := range a.Header {
+			h[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+		}
+		l.header.Store(h)
+	}
+	if a.C > 0 {
+		atomic.StoreInt32(&l.c, int32(a.C))
+		// Workers are only added to a run that runs; runAdjustable
+		// starts them otherwise.
+		for l.running > 0 && l.started < a.C {
+			b.startWorker(b.newWorker(l.started, nil))
+		}
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+// runAdjustable runs the workers of an adjustable run until they took
+// all the requests of the run or it is stopped.
+func (b *Work) runAdjustable(groups []*RequestGroup) {
+	l := &b.live
+	l.mu.Lock()
+	for l.started < b.C || l.started < int(atomic.LoadInt32(&l.c)) {
+		b.startWorker(b.newWorker(l.started, groups))
+	}
+	l.mu.Unlock()
+	<-l.done
+}
+
+// startWorker starts w. It must be called with b.live.mu held.
+func (b *Work) startWorker(w *worker) {
+	l := &b.live
+	l.started++
+	l.running++
+	go func() {
+		b.runWorker(b.workerClient(b.client, w), math.MaxInt32, w)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.running--; l.running == 0 {
+			close(l.done)
+		}
+	}()
+}
+
+// await waits while w is paused by Adjust, then takes a request of the
+// run for it. It returns false once the run is stopped or all its
+// requests are taken.
+func (b *Work) await(w *worker) bool {
+	l := &b.live
+	for w.id >= int(atomic.LoadInt32(&l.c)) {
+		l.mu.Lock()
+		changed := l.changed
+		l.mu.Unlock()
+		if w.id < int(atomic.LoadInt32(&l.c)) {
+			break
+		}
+		select {
+		case <-b.stopCh:
+			return false
+		case <-l.drained:
+			return false
+		case <-changed:
+		}
+	}
+	return b.take(1) == 1
+}
+
+// take takes up to k of the requests of the run left and returns how
+// many it took.
+func (b *Work) take(k int) int {
+	l := &b.live
+	left := atomic.AddInt64(&l.left, -int64(k))
+	if left <= 0 {
+		l.drainOnce.Do(func() { close(l.drained) })
+	}
+	if prev := left + int64(k); prev < int64(k) {
+		if prev < 0 {
+			return 0
+		}
+		return int(prev)
+	}
+	return k
+}
+
+// liveQPS returns the rate limit of the workers of an adjustable run.
+func (b *Work) liveQPS() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&b.live.qps))
+}
+
+// adjustHeader sets the headers of the last adjustment on req.
+func (b *Work) adjustHeader(req *http.Request) {
+	h, _ := b.live.header.Load().(http.Header)
+	for k, vs := range h {
+		switch {
+		case k == "Host" && len(vs) > 0:
+			req.Host = vs[0]
+		case len(vs) == 0:
+			delete(req.Header, k)
+		default:
+			req.Header[k] = vs
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// The benchmarks and allocation tests of the hot path: the worker loop,
+// the recording of results and the report. Changes to the hot path
+// should not make them slower or allocate more.
+
+// okTransport answers every request with an empty 200 OK, so that the
+// worker loop is measured without the network.
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    200,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Request:       req,
+	}, nil
+}
+
+// benchWork returns a Work of n requests whose client does not use the
+// network.
+func benchWork(n, c int) *Work {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	w := &Work{Request: req, N: n, C: c, Writer: ioutil.Discard}
+	w.Init()
+	w.client.Transport = okTransport{}
+	return w
+}
+
+// drain receives the results of w until they are closed.
+func drain(w *Work) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range w.results {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func BenchmarkWorkerLoop(b *testing.B) {
+	for _, c := range []int{1, 8} {
+		b.Run("c="+strconv.Itoa(c), func(b *testing.B) {
+			w := benchWork(b.N, c)
+			b.ReportAllocs()
+			b.ResetTimer()
+			w.Run()
+		})
+	}
+}
+
+func BenchmarkRecord(b *testing.B) {
+	w := benchWork(b.N, 1)
+	done := drain(w)
+	res := &report.Result{StatusCode: 200, Duration: time.Millisecond}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.record(res)
+	}
+	close(w.results)
+	<-done
+}
+
+func BenchmarkReporterAdd(b *testing.B) {
+	r := report.New(ioutil.Discard, report.Options{N: b.N})
+	res := &report.Result{StatusCode: 200, Duration: time.Millisecond, Proto: "HTTP/1.1"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Add(res)
+	}
+}
+
+func BenchmarkReport(b *testing.B) {
+	for _, output := range []string{"", "json"} {
+		b.Run("o="+output, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				r := report.New(ioutil.Discard, report.Options{N: 10000, Output: output})
+				for j := 0; j < 10000; j++ {
+					r.Add(&report.Result{StatusCode: 200, Duration: time.Duration(j%5000) * time.Microsecond})
+				}
+				b.StartTimer()
+				r.Finalize(time.Second)
+			}
+		})
+	}
+}
+
+// maxRequestAllocs is the allocation budget of a request of the
+// worker loop, including those of net/http: 33 when it was set, plus some
+// room for other Go versions.
+const maxRequestAllocs = 40
+
+func TestHotPathAllocs(t *testing.T) {
+	w := benchWork(1, 1)
+	done := drain(w)
+	res := &report.Result{StatusCode: 200, Duration: time.Millisecond}
+	if n := testing.AllocsPerRun(1000, func() { w.record(res) }); n != 0 {
+		t.Errorf("record allocates %v times; want 0", n)
+	}
+	worker := w.newWorker(0, nil)
+	if n := testing.AllocsPerRun(1000, func() { w.makeRequest(w.client, worker, 0) }); n > maxRequestAllocs {
+		t.Errorf("makeRequest allocates %v times; want at most %d", n, maxRequestAllocs)
+	}
+	close(w.results)
+	<-done
+
+	r := report.New(ioutil.Discard, report.Options{N: 2000})
+	res.Proto = "HTTP/1.1"
+	if n := testing.AllocsPerRun(1000, func() { r.Add(res) }); n != 0 {
+		t.Errorf("Reporter.Add allocates %v times; want 0", n)
+	}
+}
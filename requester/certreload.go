@@ -0,0 +1,107 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// certReloader presents the client certificate of a run and reloads it
+// from its files when they change, so that long runs can use short-lived
+// certificates. The files are checked at most once a second, when a
+// server asks for the certificate.
+type certReloader struct {
+	certFile, keyFile string
+
+	// onReload is called with the new generation, or the error the
+	// reload failed with.
+	onReload func(gen int, err error)
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	stamp   string
+	checked time.Time
+
+	// handshakes is the number of handshakes that presented each
+	// generation of the certificate, the first one first.
+	handshakes []int64
+}
+
+func newCertReloader(cert tls.Certificate, certFile, keyFile string) *certReloader {
+	c := &certReloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		cert:       &cert,
+		checked:    time.Now(),
+		handshakes: []int64{0},
+	}
+	c.stamp = c.fileStamp()
+	return c
+}
+
+// fileStamp identifies the current version of the files, or returns ""
+// if one of them is missing.
+func (c *certReloader) fileStamp() string {
+	var stamp string
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return ""
+		}
+		stamp += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+	}
+	return stamp
+}
+
+// getClientCertificate is the GetClientCertificate of the TLS config.
+func (c *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := time.Now(); t.Sub(c.checked) >= time.Second {
+		c.checked = t
+		// A pair that is being replaced may fail to load until both
+		// files are written, which changes the stamp again.
+		if stamp := c.fileStamp(); stamp != "" && stamp != c.stamp {
+			c.stamp = stamp
+			cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+			if err == nil {
+				c.cert = &cert
+				c.handshakes = append(c.handshakes, 0)
+			}
+			if c.onReload != nil {
+				c.onReload(len(c.handshakes), err)
+			}
+		}
+	}
+	c.handshakes[len(c.handshakes)-1]++
+	return c.cert, nil
+}
+
+// generations returns the number of handshakes per generation.
+func (c *certReloader) generations() []report.CertGeneration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gens := make([]report.CertGeneration, len(c.handshakes))
+	for i, n := range c.handshakes {
+		gens[i] = report.CertGeneration{Generation: i + 1, Handshakes: n}
+	}
+	return gens
+}
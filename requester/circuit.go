@@ -0,0 +1,121 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the error of requests that were not sent because
+// the circuit breaker of their target was open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker emulates a resilient client. It opens after threshold
+// consecutive failures and rejects requests until cooldown has passed.
+// Then a single probe request is let through: the breaker closes if it
+// succeeds and opens again if it fails.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	// onChange is called on every state transition.
+	onChange func(from, to circuitState)
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a request may be sent. If not, it returns how
+// long the caller should wait before trying again.
+func (c *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case circuitOpen:
+		if wait := c.openedAt.Add(c.cooldown).Sub(now); wait > 0 {
+			return false, wait
+		}
+		c.setState(circuitHalfOpen)
+		c.probing = true
+		return true, 0
+	case circuitHalfOpen:
+		if c.probing {
+			return false, c.cooldown
+		}
+		c.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// done records the outcome of an allowed request.
+func (c *circuitBreaker) done(failed bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == circuitHalfOpen {
+		c.probing = false
+		if failed {
+			c.openedAt = now
+			c.setState(circuitOpen)
+		} else {
+			c.failures = 0
+			c.setState(circuitClosed)
+		}
+		return
+	}
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.state == circuitClosed && c.failures >= c.threshold {
+		c.openedAt = now
+		c.setState(circuitOpen)
+	}
+}
+
+func (c *circuitBreaker) setState(s circuitState) {
+	if s == c.state {
+		return
+	}
+	from := c.state
+	c.state = s
+	if c.onChange != nil {
+		c.onChange(from, s)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// acceptEncoding is the Accept-Encoding of the requests with
+// VerifyCompression, the encodings hey can decode.
+const acceptEncoding = "gzip, deflate"
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// wireReader reads the body as it was sent and keeps the error of
+// reading it, to tell a cut off body from one that does not decode.
+type wireReader struct {
+	r   io.Reader
+	err error
+}
+
+func (w *wireReader) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	if err != nil && err != io.EOF {
+		w.err = err
+	}
+	return n, err
+}
+
+// decoder decodes the body of a response as its Content-Encoding claims.
+type decoder struct {
+	wire     *wireReader
+	encoding string
+	r        io.Reader
+	err      error
+}
+
+// newDecoder returns a decoder of the body of resp.
+func newDecoder(resp *http.Response) *decoder {
+	d := &decoder{
+		wire:     &wireReader{r: resp.Body},
+		encoding: strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))),
+	}
+	switch d.encoding {
+	case "", "identity":
+		d.r = d.wire
+	case "gzip", "x-gzip":
+		if r, err := gzip.NewReader(d.wire); err == nil {
+			d.r = r
+		} else {
+			d.err = err
+		}
+	case "deflate":
+		if r, err := zlib.NewReader(d.wire); err == nil {
+			d.r = r
+		} else {
+			d.err = err
+		}
+	default:
+		// The body is read as is and reported as not decodable.
+		d.r = d.wire
+		d.err = errUnsupportedEncoding
+	}
+	return d
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	if d.r == nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+// check returns the outcome of reading the body with the error rerr:
+// a *report.DecompressionError if the body did not decode, or whether
+// it was cut off.
+func (d *decoder) check(rerr error) (err error, truncated bool) {
+	switch {
+	case d.wire.err != nil:
+		return nil, true
+	case rerr == nil && d.err != nil:
+		rerr = d.err
+	case rerr == nil:
+		return nil, false
+	}
+	return &report.DecompressionError{Encoding: d.encoding, Err: rerr}, false
+}
@@ -0,0 +1,46 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "math/rand"
+
+// Data is a table of values, e.g. test accounts, that the templates of
+// the requests refer to with {{.name}}, the value of the column name.
+// Every request uses one row. A request whose row has no value for a
+// column it refers to fails with a generation error.
+type Data struct {
+	// Columns are the names of the columns.
+	Columns []string
+
+	// Rows are the values of the rows, in the order of Columns.
+	Rows [][]string
+
+	// Random is an option to pick a random row for every request,
+	// instead of the rows in turn.
+	Random bool
+}
+
+// row returns the row of the request with the given number and the
+// number of the row, counting from 1.
+func (d *Data) row(seq uint64) ([]string, int) {
+	if len(d.Rows) == 0 {
+		return nil, 0
+	}
+	i := int(seq % uint64(len(d.Rows)))
+	if d.Random {
+		i = rand.Intn(len(d.Rows))
+	}
+	return d.Rows[i], i + 1
+}
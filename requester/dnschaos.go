@@ -0,0 +1,63 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+)
+
+// DNSChaos makes lookups of host names fail or return other addresses
+// on purpose, to test how clients cope with an unstable DNS. A lookup is
+// made for every new connection to a host that is not an IP address.
+type DNSChaos struct {
+	// FailRate is the share of the lookups that fail, from 0 to 1.
+	FailRate float64
+
+	// FlapRate is the share of the lookups that return one of the
+	// Alternates, at random, instead of the actual address.
+	FlapRate float64
+
+	// Alternates are the IP addresses flapping lookups return.
+	Alternates []string
+}
+
+// chaosAddr returns the address to dial for addr, a "host:port", after
+// a lookup of DNSChaos: either addr itself, an error or an address with
+// one of the alternates as host.
+func (b *Work) chaosAddr(addr string) (string, error) {
+	c := b.DNSChaos
+	host, port, err := net.SplitHostPort(addr)
+	if c == nil || err != nil || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	r := rand.Float64()
+	switch {
+	case r < c.FailRate:
+		atomic.AddUint64(&b.dnsFailed, 1)
+		return "", &net.DNSError{Err: "injected lookup failure", Name: host, IsTemporary: true}
+	case r < c.FailRate+c.FlapRate && len(c.Alternates) > 0:
+		atomic.AddUint64(&b.dnsFlapped, 1)
+		return net.JoinHostPort(c.Alternates[rand.Intn(len(c.Alternates))], port), nil
+	}
+	return addr, nil
+}
+
+// DNSFaults returns the number of lookups DNSChaos failed and flapped
+// so far.
+func (b *Work) DNSFaults() (failed, flapped uint64) {
+	return atomic.LoadUint64(&b.dnsFailed), atomic.LoadUint64(&b.dnsFlapped)
+}
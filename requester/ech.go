@@ -0,0 +1,49 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package requester
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// ECHSupported reports whether hey was built with a Go release that
+// supports Encrypted Client Hello.
+const ECHSupported = true
+
+// setECH enables Encrypted Client Hello with the given ECHConfigList.
+// ECH requires TLS 1.3.
+func setECH(c *tls.Config, configList []byte) {
+	c.EncryptedClientHelloConfigList = configList
+	c.MinVersion = tls.VersionTLS13
+}
+
+// echOutcome returns whether the server accepted or rejected the
+// Encrypted Client Hello of a handshake, or "" if none was offered.
+func echOutcome(cs tls.ConnectionState, err error) string {
+	var rejected *tls.ECHRejectionError
+	switch {
+	case cs.ECHAccepted:
+		return report.ECHAccepted
+	case errors.As(err, &rejected):
+		return report.ECHRejected
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package requester
+
+import (
+	"crypto/tls"
+	"fmt"
+	"testing"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+func TestECHOutcome(t *testing.T) {
+	rejected := fmt.Errorf("Get: %w", &tls.ECHRejectionError{})
+	tests := []struct {
+		cs   tls.ConnectionState
+		err  error
+		want string
+	}{
+		{tls.ConnectionState{ECHAccepted: true}, nil, report.ECHAccepted},
+		{tls.ConnectionState{}, rejected, report.ECHRejected},
+		{tls.ConnectionState{}, fmt.Errorf("connection reset"), ""},
+		{tls.ConnectionState{}, nil, ""},
+	}
+	for _, tt := range tests {
+		if got := echOutcome(tt.cs, tt.err); got != tt.want {
+			t.Errorf("echOutcome(%v, %v) = %q; want %q", tt.cs.ECHAccepted, tt.err, got, tt.want)
+		}
+	}
+}
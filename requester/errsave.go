@@ -0,0 +1,90 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// errorSaver writes failing responses to files in a directory, up to
+// a maximum number of files.
+type errorSaver struct {
+	dir string
+	max int64
+
+	n int64 // files written or being written
+
+	mu      sync.Mutex
+	written int
+	err     error // first write error
+}
+
+// failed reports whether a response with the given status code and
+// error, after it was read, is saved: a non-2xx status or a failed
+// expectation.
+func failed(code int, err error) bool {
+	return code < 200 || code > 299 || report.ClassifyError(err) == report.ErrorClassExpectation
+}
+
+// save writes req and its response res, with the response body body,
+// to the next file of the directory, unless the maximum number of files
+// is reached. err is the error of the response, if any.
+func (s *errorSaver) save(req *http.Request, res *http.Response, body []byte, err error) {
+	n := atomic.AddInt64(&s.n, 1)
+	if n > s.max {
+		return
+	}
+	var buf bytes.Buffer
+	if err != nil {
+		fmt.Fprintf(&buf, "# %v\n", err)
+	}
+	fmt.Fprintf(&buf, "%s %s HTTP/%d.%d\r\n", req.Method, req.URL.RequestURI(), req.ProtoMajor, req.ProtoMinor)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&buf, "Host: %s\r\n", host)
+	req.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	fmt.Fprintf(&buf, "%s %s\r\n", res.Proto, res.Status)
+	res.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	name := filepath.Join(s.dir, fmt.Sprintf("%06d-%d.http", n, res.StatusCode))
+	werr := ioutil.WriteFile(name, buf.Bytes(), 0644)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if werr == nil {
+		s.written++
+	} else if s.err == nil {
+		s.err = werr
+	}
+}
+
+// saved returns the number of files written and the first write error.
+func (s *errorSaver) saved() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written, s.err
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// HeaderExpectation is a header every response must have.
+type HeaderExpectation struct {
+	// Name is the name of the header.
+	Name string
+
+	// Value must match one of the values of the header.
+	Value *regexp.Regexp
+
+	// Text describes the expectation in error messages,
+	// e.g. "X-Cache: HIT".
+	Text string
+}
+
+// expectsBody reports whether the expectations of the run need the
+// response bodies.
+func (b *Work) expectsBody() bool {
+	return len(b.ExpectBodyContains) > 0 || len(b.ExpectBodyRegexps) > 0
+}
+
+// checkResponse returns a *report.ExpectationError if res, with the
+// given body, does not meet the expectations of the run. body is only
+// read if expectsBody.
+func (b *Work) checkResponse(res *http.Response, body []byte) error {
+	if err := b.checkStatus(res.StatusCode); err != nil {
+		return err
+	}
+	if err := b.checkHeaders(res.Header); err != nil {
+		return err
+	}
+	return b.checkBody(body)
+}
+
+// checkStatus returns a *report.ExpectationError if code is not one of
+// the expected status codes of the run, if any.
+func (b *Work) checkStatus(code int) error {
+	if len(b.ExpectStatus) == 0 {
+		return nil
+	}
+	codes := make([]string, len(b.ExpectStatus))
+	for i, c := range b.ExpectStatus {
+		if c == code {
+			return nil
+		}
+		codes[i] = strconv.Itoa(c)
+	}
+	return &report.ExpectationError{
+		Assertion: report.AssertionStatus,
+		Reason:    "status " + strconv.Itoa(code) + " is not one of " + strings.Join(codes, ","),
+	}
+}
+
+// checkHeaders returns a *report.ExpectationError if h does not meet
+// the header expectations of the run.
+func (b *Work) checkHeaders(h http.Header) error {
+	for _, e := range b.ExpectHeaders {
+		if !matchHeader(h[http.CanonicalHeaderKey(e.Name)], e.Value) {
+			return &report.ExpectationError{Assertion: report.AssertionHeader, Reason: "missing header " + e.Text}
+		}
+	}
+	return nil
+}
+
+// checkBody returns a *report.ExpectationError if body does not meet
+// the body expectations of the run.
+func (b *Work) checkBody(body []byte) error {
+	for _, s := range b.ExpectBodyContains {
+		if !bytes.Contains(body, []byte(s)) {
+			return &report.ExpectationError{Assertion: report.AssertionBodyContains, Reason: "body does not contain " + strconv.Quote(s)}
+		}
+	}
+	for _, re := range b.ExpectBodyRegexps {
+		if !re.Match(body) {
+			return &report.ExpectationError{Assertion: report.AssertionBodyRegex, Reason: "body does not match " + strconv.Quote(re.String())}
+		}
+	}
+	return nil
+}
+
+func matchHeader(values []string, re *regexp.Regexp) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
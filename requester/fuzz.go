@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// mutation malforms the head of a request, its request line followed by
+// its header lines.
+type mutation struct {
+	name   string
+	mutate func(head []string) []string
+}
+
+// mutations are the ways requests are malformed if FuzzRate is set.
+var mutations = []mutation{
+	{"oversized-header", func(head []string) []string {
+		return append(head, "X-Hey-Fuzz: "+strings.Repeat("a", 64<<10))
+	}},
+	{"invalid-header", func(head []string) []string {
+		return append(head, "X-Hey-Fuzz")
+	}},
+	{"invalid-content-length", func(head []string) []string {
+		return append(withoutHeader(head, "Content-Length"), "Content-Length: -1")
+	}},
+	{"conflicting-content-length", func(head []string) []string {
+		return append(withoutHeader(head, "Content-Length"), "Content-Length: 0", "Content-Length: 8")
+	}},
+	{"unknown-method", func(head []string) []string {
+		return withMethod(head, "FUZZ")
+	}},
+	{"invalid-method", func(head []string) []string {
+		return withMethod(head, "G(T")
+	}},
+}
+
+func withoutHeader(head []string, name string) []string {
+	out := head[:1]
+	for _, l := range head[1:] {
+		if !strings.HasPrefix(strings.ToLower(l), strings.ToLower(name)+":") {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func withMethod(head []string, method string) []string {
+	if i := strings.IndexByte(head[0], ' '); i >= 0 {
+		head[0] = method + head[0][i:]
+	}
+	return head
+}
+
+// makeMutatedRequest sends req malformed by a random mutation over a new
+// HTTP/1.1 connection, as net/http refuses to send malformed requests.
+// Its result is labeled with the mutation and reported separately.
+func (b *Work) makeMutatedRequest(req *http.Request, g *RequestGroup, w *worker, lag time.Duration) *report.Result {
+	m := mutations[rand.Intn(len(mutations))]
+	res := &report.Result{
+		Start:    time.Now(),
+		Offset:   now(),
+		Label:    g.Name,
+		Class:    g.Class,
+		Mutation: m.name,
+		Worker:   w.id,
+		Lag:      lag,
+	}
+	s := res.Offset
+	defer func() {
+		res.Duration = now() - s
+		b.record(res)
+	}()
+
+	var raw bytes.Buffer
+	if res.Err = req.Write(&raw); res.Err != nil {
+		res.ErrorClass = report.ErrorClassGeneration
+		return res
+	}
+	parts := strings.SplitN(raw.String(), "\r\n\r\n", 2)
+	head := m.mutate(strings.Split(parts[0], "\r\n"))
+	msg := strings.Join(head, "\r\n") + "\r\n\r\n" + parts[1]
+
+	conn, err := b.dialFor(req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer conn.Close()
+	if b.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(time.Duration(b.Timeout) * time.Second))
+	}
+	if _, res.Err = io.WriteString(conn, msg); res.Err != nil {
+		return res
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.StatusCode = resp.StatusCode
+	res.Proto = resp.Proto
+	res.ContentLength = resp.ContentLength
+	res.BytesRead, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return res
+}
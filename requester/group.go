@@ -0,0 +1,365 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// ThinkDist is a distribution of the think time of a RequestGroup.
+type ThinkDist int
+
+const (
+	// ThinkFixed always pauses for ThinkTime.
+	ThinkFixed ThinkDist = iota
+	// ThinkUniform pauses for a time between ThinkTime and ThinkMax,
+	// all equally likely.
+	ThinkUniform
+	// ThinkExponential pauses for exponentially distributed times with
+	// a mean of ThinkTime, like the gaps between the actions of users
+	// that act independently.
+	ThinkExponential
+)
+
+// RequestGroup is a named kind of request of a scenario, e.g. the page
+// views and the purchases of a shop.
+type RequestGroup struct {
+	// Name identifies the group. It is used as the Label of its results.
+	Name string
+
+	// Request is the request to be made.
+	Request *http.Request
+
+	// RequestBody is the body of the request.
+	RequestBody []byte
+
+	// Class is the priority class of the group, e.g. critical or
+	// best-effort. The results of every class are reported on
+	// separately, see report.Result.Class.
+	Class string
+
+	// ThinkTime is the time a worker pauses after a request of the
+	// group, like a user reading a page before the next click. It is
+	// the shortest pause with ThinkUniform and the mean pause with
+	// ThinkExponential.
+	ThinkTime time.Duration
+
+	// ThinkDist is the distribution of the pauses, ThinkFixed by
+	// default.
+	ThinkDist ThinkDist
+
+	// ThinkMax is the longest pause with ThinkUniform.
+	ThinkMax time.Duration
+
+	// C is the number of workers dedicated to the group with
+	// IsolateGroups. The workers that are not dedicated to a group are
+	// assigned in turn to the groups with no C.
+	C int
+
+	// QPS is the rate limit of every worker of the group with
+	// IsolateGroups, overriding the QPS of the run.
+	QPS float64
+
+	// Weight is the relative share of the requests made of the group.
+	// If a group has a Weight, every request picks its group at random
+	// by weight instead of making the groups in turn, e.g. to model a
+	// traffic mix of 90% reads and 10% writes. Groups without one then
+	// weigh 1. It is ignored with IsolateGroups and if the groups are
+	// made in order, see Work.Sequential.
+	Weight float64
+
+	// Extract, if set, is called with every complete response of the
+	// group and its body, e.g. to record the identifiers of the
+	// resources the requests created. It is called concurrently.
+	Extract func(res *http.Response, body []byte)
+
+	// Metrics, if set, is called with every complete response of the
+	// group, its body and its latency, and returns the custom metrics
+	// to record for it, e.g. a completed business transaction. It is
+	// called concurrently.
+	Metrics func(res *http.Response, body []byte, latency time.Duration) []report.CustomMetric
+
+	// Capture maps the names of values to keep from every complete 2xx
+	// response of the group to the functions that extract them from the
+	// response and its body, e.g. the id of a created resource. The
+	// later requests of the same worker refer to them as {{capture
+	// name}}, and a value that cannot be extracted keeps its previous
+	// one; a request that refers to a value not captured yet fails with
+	// a generation error. If a group captures values, every worker makes
+	// the groups in order, so that a request can use the values of the
+	// previous one. It cannot be used with Rate.
+	Capture map[string]func(res *http.Response, body []byte) (string, bool)
+
+	// body, header, path and query are the templates of RequestBody,
+	// of the header values and of the URL that have placeholders,
+	// with Work.Templates.
+	body   *template
+	header map[string][]*template
+	path   *template
+	query  *template
+	err    error
+}
+
+// parseTemplates parses the templates of g, with the names they refer
+// to.
+func (g *RequestGroup) parseTemplates(names TemplateNames) {
+	if g.body, g.err = parseTemplate(string(g.RequestBody), names); g.err != nil {
+		return
+	}
+	// The escaped path would escape the braces of the placeholders.
+	path := g.Request.URL.RawPath
+	if path == "" {
+		path = g.Request.URL.Path
+	}
+	if g.path, g.err = parseTemplate(path, names); g.err != nil {
+		return
+	}
+	if g.query, g.err = parseTemplate(g.Request.URL.RawQuery, names); g.err != nil {
+		return
+	}
+	for k, vs := range g.Request.Header {
+		for i, v := range vs {
+			t, err := parseTemplate(v, names)
+			if err != nil {
+				g.err = err
+				return
+			}
+			if t == nil {
+				continue
+			}
+			if g.header == nil {
+				g.header = make(map[string][]*template)
+			}
+			if g.header[k] == nil {
+				g.header[k] = make([]*template, len(vs))
+			}
+			g.header[k][i] = t
+		}
+	}
+}
+
+// expand returns the body of a request of g and sets the expanded URL
+// and header values of req. It returns an error if a placeholder has no
+// value or a header value is invalid once expanded.
+func (g *RequestGroup) expand(req *http.Request, ctx *templateContext) ([]byte, error) {
+	if g.path != nil || g.query != nil {
+		u := *req.URL
+		if g.path != nil {
+			path, err := g.path.execute(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if u.RawPath != "" {
+				u.RawPath = string(path)
+				u.Path, _ = url.PathUnescape(u.RawPath)
+			} else {
+				u.Path = string(path)
+			}
+		}
+		if g.query != nil {
+			query, err := g.query.execute(ctx)
+			if err != nil {
+				return nil, err
+			}
+			u.RawQuery = string(query)
+		}
+		req.URL = &u
+	}
+	for k, ts := range g.header {
+		for i, t := range ts {
+			if t == nil {
+				continue
+			}
+			v, err := t.execute(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.ContainsAny(v, "\r\n\x00") {
+				return nil, fmt.Errorf("template: invalid value %q of header %s", v, k)
+			}
+			req.Header[k][i] = string(v)
+		}
+	}
+	if g.body == nil {
+		return g.RequestBody, nil
+	}
+	return g.body.execute(ctx)
+}
+
+// Render returns the first request of g and its body, with the
+// placeholders of its templates expanded with the first row of data,
+// if any, and with values standing in for the setup and captured
+// ones. It does not change g, and returns an error if a template is
+// invalid.
+func (g *RequestGroup) Render(names TemplateNames, data *Data, values map[string]string) (*http.Request, []byte, error) {
+	t := &RequestGroup{Request: g.Request, RequestBody: g.RequestBody}
+	t.parseTemplates(names)
+	if t.err != nil {
+		return nil, nil, t.err
+	}
+	ctx := &templateContext{setup: values, captured: values}
+	if data != nil && len(data.Rows) > 0 {
+		ctx.row, ctx.rowNum = data.Rows[0], 1
+	}
+	req := cloneRequest(t.Request, nil)
+	body, err := t.expand(req, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req, body, nil
+}
+
+// think returns the time a worker pauses after a request of g.
+func (g *RequestGroup) think() time.Duration {
+	switch g.ThinkDist {
+	case ThinkUniform:
+		if g.ThinkMax > g.ThinkTime {
+			return g.ThinkTime + time.Duration(rand.Int63n(int64(g.ThinkMax-g.ThinkTime)+1))
+		}
+	case ThinkExponential:
+		return time.Duration(rand.ExpFloat64() * float64(g.ThinkTime))
+	}
+	return g.ThinkTime
+}
+
+// groups returns the request groups of the run, a single unnamed one
+// for Request if no groups are set.
+func (b *Work) groups() []*RequestGroup {
+	if len(b.Groups) > 0 {
+		return b.Groups
+	}
+	return b.defaultGroups
+}
+
+// chained reports whether workers make the groups in order, as the run
+// is Sequential or a group captures values.
+func (b *Work) chained() bool {
+	if b.Sequential {
+		return true
+	}
+	for _, g := range b.Groups {
+		if len(g.Capture) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// weights returns the cumulative weights of the groups if a group has
+// a Weight, nil otherwise.
+func (b *Work) weights() []float64 {
+	weighted := false
+	for _, g := range b.Groups {
+		weighted = weighted || g.Weight > 0
+	}
+	if !weighted {
+		return nil
+	}
+	cum := make([]float64, len(b.Groups))
+	var total float64
+	for i, g := range b.Groups {
+		if g.Weight > 0 {
+			total += g.Weight
+		} else {
+			total++
+		}
+		cum[i] = total
+	}
+	return cum
+}
+
+// pickGroup returns a group at random by weight, see weights.
+func (b *Work) pickGroup() *RequestGroup {
+	r := rand.Float64() * b.cumWeights[len(b.cumWeights)-1]
+	i := sort.Search(len(b.cumWeights), func(i int) bool { return b.cumWeights[i] > r })
+	return b.Groups[min(i, len(b.Groups)-1)]
+}
+
+// groupCaptures returns the names of the values the groups capture.
+func (b *Work) groupCaptures() []string {
+	var names []string
+	for _, g := range b.Groups {
+		for name := range g.Capture {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// assignGroups returns the group of every worker with IsolateGroups,
+// nil otherwise.
+func (b *Work) assignGroups() []*RequestGroup {
+	if !b.IsolateGroups || len(b.Groups) == 0 {
+		return nil
+	}
+	assigned := make([]*RequestGroup, 0, b.C)
+	var shared []*RequestGroup
+	for _, g := range b.Groups {
+		if g.C == 0 {
+			shared = append(shared, g)
+		}
+		for i := 0; i < g.C; i++ {
+			assigned = append(assigned, g)
+		}
+	}
+	if len(shared) == 0 {
+		shared = b.Groups
+	}
+	for i := 0; len(assigned) < b.C; i++ {
+		assigned = append(assigned, shared[i%len(shared)])
+	}
+	return assigned[:b.C]
+}
+
+// workerQPS returns the rate limit of w.
+func (b *Work) workerQPS(w *worker) float64 {
+	if w.group != nil && w.group.QPS > 0 {
+		return w.group.QPS
+	}
+	if b.Adjustable {
+		return b.liveQPS()
+	}
+	return b.QPS
+}
+
+// targetRate returns the rate the workers are limited to in total, or
+// zero if they are not limited.
+func (b *Work) targetRate() float64 {
+	if b.Rate > 0 {
+		return b.Rate
+	}
+	groups := b.assignGroups()
+	if groups == nil {
+		return b.QPS * float64(b.C)
+	}
+	var rate float64
+	for _, g := range groups {
+		qps := b.workerQPS(&worker{group: g})
+		if qps == 0 {
+			// Unlimited workers make the run unlimited.
+			return 0
+		}
+		rate += qps
+	}
+	return rate
+}
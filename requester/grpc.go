@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/http2"
+)
+
+// grpcCodes are the names of the gRPC status codes.
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// grpcTransport returns the transport of gRPC calls, which dials like
+// tr. Calls to http urls are made over HTTP/2 without TLS, with prior
+// knowledge.
+func (b *Work) grpcTransport(tr *http.Transport) *http2.Transport {
+	dial := tr.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	plain := b.Request.URL.Scheme == "http"
+	return &http2.Transport{
+		TLSClientConfig:    b.tlsConfig,
+		AllowHTTP:          plain,
+		DisableCompression: tr.DisableCompression,
+		DialTLS: func(network, addr string, conf *tls.Config) (net.Conn, error) {
+			conn, err := dial(context.Background(), network, addr)
+			if err != nil || plain {
+				return conn, err
+			}
+			tc := tls.Client(conn, conf)
+			if err := tc.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tc, nil
+		},
+	}
+}
+
+// grpcStatus returns the name of the gRPC status of the call that got
+// resp, whose body must have been read. A response without a status,
+// e.g. from a proxy, is mapped from its HTTP status as gRPC clients do.
+func grpcStatus(resp *http.Response) string {
+	s := resp.Trailer.Get("Grpc-Status")
+	if s == "" {
+		// A call that fails at once sends its status in the headers.
+		s = resp.Header.Get("Grpc-Status")
+	}
+	if s == "" {
+		code := 2 // UNKNOWN
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			code = 13 // INTERNAL
+		case http.StatusUnauthorized:
+			code = 16 // UNAUTHENTICATED
+		case http.StatusForbidden:
+			code = 7 // PERMISSION_DENIED
+		case http.StatusNotFound:
+			code = 12 // UNIMPLEMENTED
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			code = 14 // UNAVAILABLE
+		}
+		return grpcCodes[code]
+	}
+	if code, err := strconv.Atoi(s); err == nil && code >= 0 && code < len(grpcCodes) {
+		return grpcCodes[code]
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// minRTO is the minimum TCP retransmission timeout, as on Linux.
+const minRTO = 200 * time.Millisecond
+
+// NetworkProfile describes network conditions the client connections
+// emulate, e.g. those of a mobile network, on top of the actual ones.
+// Connecting takes a round trip, and so does every exchange of a request
+// and its response: half of it is added to writes and half to the first
+// read after a write. Lost packets cost a retransmission timeout.
+type NetworkProfile struct {
+	// RTT is the round-trip time.
+	RTT time.Duration
+
+	// Jitter is the maximum deviation of the RTT.
+	Jitter time.Duration
+
+	// Loss is the share of the packets lost, from 0 to 1, where every
+	// write and every read counts as a packet.
+	Loss float64
+}
+
+// oneWay returns the delay of a packet in one direction.
+func (p *NetworkProfile) oneWay() time.Duration {
+	d := p.RTT / 2
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(p.Jitter)+1))/2 - p.Jitter/4
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d + p.lost()
+}
+
+// lost returns the retransmission timeout if a packet is lost, or 0.
+func (p *NetworkProfile) lost() time.Duration {
+	if p.Loss <= 0 || rand.Float64() >= p.Loss {
+		return 0
+	}
+	if rto := 2 * p.RTT; rto > minRTO {
+		return rto
+	}
+	return minRTO
+}
+
+// connect waits for the round trip of the TCP handshake of c and
+// returns c emulating p, or closes c if ctx is done first.
+func (p *NetworkProfile) connect(ctx context.Context, c net.Conn) (net.Conn, error) {
+	t := time.NewTimer(p.oneWay() + p.oneWay())
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+	return &emulatedConn{Conn: c, p: p}, nil
+}
+
+// emulatedConn is a connection that delays writes and reads as its
+// network profile says.
+type emulatedConn struct {
+	net.Conn
+	p *NetworkProfile
+
+	mu sync.Mutex
+	// wrote is set by a write and cleared by the next read, which then
+	// waits for the response to travel back.
+	wrote bool
+}
+
+func (c *emulatedConn) Write(b []byte) (int, error) {
+	time.Sleep(c.p.oneWay())
+	c.mu.Lock()
+	c.wrote = true
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *emulatedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		turn := c.wrote
+		c.wrote = false
+		c.mu.Unlock()
+		if turn {
+			time.Sleep(c.p.oneWay())
+		} else {
+			time.Sleep(c.p.lost())
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// runOpenModel starts N requests at the arrival rate of b.Rate, or at
+// the offsets of b.Schedule, each in its own goroutine, no matter how
+// many requests are still in flight. Requests are attributed to the C
+// workers in turn.
+func (b *Work) runOpenModel(client *http.Client, groups []*RequestGroup) {
+	workers := make([]*worker, b.C)
+	clients := make([]*http.Client, b.C)
+	for i := range workers {
+		workers[i] = b.newWorker(i, groups)
+		clients[i] = b.workerClient(client, workers[i])
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	n := b.N
+	var interval time.Duration
+	if len(b.Schedule) > 0 {
+		n = min(n, len(b.Schedule))
+	} else {
+		interval = time.Duration(float64(time.Second) / b.Rate)
+	}
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		// Requests are scheduled from the start of the run, so that a
+		// late arrival does not delay the following ones.
+		next := start.Add(time.Duration(i) * interval)
+		if len(b.Schedule) > 0 {
+			next = start.Add(b.Schedule[i])
+		}
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-b.stopCh:
+				return
+			case <-time.After(d):
+			}
+		} else {
+			select {
+			case <-b.stopCh:
+				return
+			default:
+			}
+		}
+		lag := time.Since(next)
+		wg.Add(1)
+		go func(w *worker, client *http.Client) {
+			defer wg.Done()
+			if b.breaker != nil {
+				b.makeGuardedRequest(client, w, lag)
+			} else {
+				b.makeRequest(client, w, lag)
+			}
+		}(workers[i%b.C], clients[i%b.C])
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// runPipelinedWorker makes n requests for w over a dedicated HTTP/1.1 connection,
+// writing up to b.Pipeline requests before reading any of their responses.
+// net/http does not support pipelining, so requests are written directly
+// to the connection.
+func (b *Work) runPipelinedWorker(n int, w *worker) {
+	var throttle <-chan time.Time
+	if b.QPS > 0 {
+		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond)
+	}
+
+	var conn net.Conn
+	var br *bufio.Reader
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < n; {
+		// Check if application is stopped. Do not send into a closed channel.
+		select {
+		case <-b.stopCh:
+			return
+		default:
+		}
+
+		var connDuration time.Duration
+		if conn == nil {
+			s := now()
+			c, err := b.dial()
+			if err != nil {
+				b.record(&report.Result{Start: time.Now(), Offset: s, Duration: now() - s, Err: err, Worker: w.id})
+				i++
+				continue
+			}
+			conn, br = c, bufio.NewReader(c)
+			connDuration = now() - s
+		}
+		if b.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(time.Duration(b.Timeout) * time.Second))
+		}
+
+		batch := min(b.Pipeline, n-i)
+		reqs := make([]*http.Request, 0, batch)
+		starts := make([]time.Duration, 0, batch)
+		wall := make([]time.Time, 0, batch)
+		lags := make([]time.Duration, 0, batch)
+		var err error
+		for j := 0; j < batch; j++ {
+			var lag time.Duration
+			if b.QPS > 0 {
+				lag = time.Since(<-throttle)
+			}
+			lags = append(lags, lag)
+			var req *http.Request
+			if req, _, err = b.newRequest(w); err != nil {
+				break
+			}
+			starts = append(starts, now())
+			wall = append(wall, time.Now())
+			if err = req.Write(conn); err != nil {
+				break
+			}
+			reqs = append(reqs, req)
+		}
+		for j, req := range reqs {
+			var res *http.Response
+			if err == nil {
+				res, err = http.ReadResponse(br, req)
+			}
+			r := &report.Result{Start: wall[j], Offset: starts[j], Err: err, Worker: w.id, Lag: lags[j]}
+			if err == nil {
+				r.StatusCode = res.StatusCode
+				r.Proto = res.Proto
+				r.ContentLength = res.ContentLength
+				if b.SecurityHeaders {
+					r.SecurityHeaders = report.SecurityHeaderMask(res.Header)
+				}
+				var body bytes.Buffer
+				var dst io.Writer = ioutil.Discard
+				if b.expectsBody() || b.errSaver != nil {
+					dst = &body
+				}
+				var rerr error
+				r.BytesRead, rerr = io.Copy(dst, res.Body)
+				r.Truncated = rerr != nil
+				res.Body.Close()
+				if rerr == nil {
+					r.Err = b.checkResponse(res, body.Bytes())
+				}
+				if b.errSaver != nil && failed(res.StatusCode, r.Err) {
+					b.errSaver.save(req, res, body.Bytes(), r.Err)
+				}
+				if rerr != nil {
+					// The rest of the batch cannot be read.
+					err = rerr
+				} else if res.Close {
+					// The server will not answer the rest of the batch.
+					err = io.ErrUnexpectedEOF
+				}
+			}
+			if j == 0 {
+				r.ConnDuration = connDuration
+			}
+			r.Duration = now() - starts[j]
+			b.record(r)
+		}
+		// Requests that could not be written are reported as failed.
+		for j := len(reqs); j < batch; j++ {
+			b.record(&report.Result{Start: time.Now(), Offset: now(), Err: err, Worker: w.id})
+		}
+		i += batch
+
+		if err != nil || b.DisableKeepAlives {
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// dial opens a connection to the host of the request, negotiating TLS
+// for https and wss URLs.
+func (b *Work) dial() (net.Conn, error) {
+	return b.dialFor(b.Request)
+}
+
+// dialFor opens a connection to the host of req, see dial.
+func (b *Work) dialFor(req *http.Request) (net.Conn, error) {
+	u := req.URL
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	timeout := time.Duration(b.Timeout) * time.Second
+	d := &net.Dialer{Timeout: timeout}
+	addr, err := b.chaosAddr(b.resolve(addr))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if b.Network != nil {
+		if conn, err = b.Network.connect(context.Background(), conn); err != nil {
+			return nil, err
+		}
+	}
+	if !secure {
+		return conn, nil
+	}
+	conf := b.tlsConfig.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName = req.Host
+		if h, _, err := net.SplitHostPort(conf.ServerName); err == nil {
+			conf.ServerName = h
+		}
+	}
+	conf.NextProtos = []string{"http/1.1"}
+	tc := tls.Client(conn, conf)
+	if timeout > 0 {
+		tc.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// makeBatch makes k concurrent requests on c for w. Over
+// HTTP/2 the requests are multiplexed as streams on a shared connection.
+// The first request is started with the given lag. If throttle is set,
+// every other one waits for a tick of it, so that QPS limits the rate
+// of the requests rather than that of the batches, like the pipelined
+// HTTP/1.1 requests. It reports whether the run was stopped while it
+// waited, in which case the worker must stop too.
+func (b *Work) makeBatch(c *http.Client, k int, w *worker, lag time.Duration, throttle <-chan time.Time) (stopped bool) {
+	var wg sync.WaitGroup
+	for j := 0; j < k; j++ {
+		if j > 0 && throttle != nil {
+			select {
+			case <-b.stopCh:
+				wg.Wait()
+				return true
+			case t := <-throttle:
+				lag = time.Since(t)
+			}
+		}
+		wg.Add(1)
+		go func(lag time.Duration) {
+			b.makeRequest(c, w, lag)
+			wg.Done()
+		}(lag)
+	}
+	wg.Wait()
+	return false
+}
@@ -0,0 +1,217 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rawTransport is an HTTP/1.1 round tripper that writes the headers of
+// requests in the case and order of Work.HeaderOrder. net/http
+// canonicalizes the names of headers and sorts them.
+type rawTransport struct {
+	b *Work
+
+	mu   sync.Mutex
+	idle map[string][]*rawConn // by host:port
+}
+
+type rawConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (t *rawTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	trace := httptrace.ContextClientTrace(ctx)
+	key := req.URL.Host
+	if trace != nil && trace.GetConn != nil {
+		trace.GetConn(key)
+	}
+	conn, reused, err := t.conn(req, key)
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn.Conn, Reused: reused})
+	}
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	// done is closed once the response was handled. Contexts are
+	// canceled after that when the client is done with them, which
+	// must not close a connection that is idle by then.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-done:
+			default:
+				conn.Close()
+			}
+		case <-done:
+		}
+	}()
+	fail := func(err error) (*http.Response, error) {
+		close(done)
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err := conn.Write(t.head(req)); err != nil {
+		return fail(err)
+	}
+	if req.Body != nil {
+		if _, err := io.Copy(conn, req.Body); err != nil {
+			return fail(err)
+		}
+		req.Body.Close()
+	}
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+	if _, err := conn.br.Peek(1); err != nil {
+		return fail(err)
+	}
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	resp, err := http.ReadResponse(conn.br, req)
+	if err != nil {
+		return fail(err)
+	}
+	resp.Body = &rawBody{ReadCloser: resp.Body, t: t, key: key, conn: conn, done: done, reuse: !resp.Close && !t.b.DisableKeepAlives}
+	return resp, nil
+}
+
+// conn returns an idle connection to key or dials a new one.
+func (t *rawTransport) conn(req *http.Request, key string) (*rawConn, bool, error) {
+	t.mu.Lock()
+	if conns := t.idle[key]; len(conns) > 0 {
+		c := conns[len(conns)-1]
+		t.idle[key] = conns[:len(conns)-1]
+		t.mu.Unlock()
+		return c, true, nil
+	}
+	t.mu.Unlock()
+	c, err := t.b.dialFor(req)
+	if err != nil {
+		return nil, false, err
+	}
+	return &rawConn{Conn: c, br: bufio.NewReader(c)}, false, nil
+}
+
+func (t *rawTransport) put(key string, c *rawConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle == nil {
+		t.idle = make(map[string][]*rawConn)
+	}
+	t.idle[key] = append(t.idle[key], c)
+}
+
+// head returns the request line and the headers of req. The headers of
+// HeaderOrder come first, spelled as there, followed by the others in
+// the order net/http would write them.
+func (t *rawTransport) head(req *http.Request) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	written := make(map[string]bool)
+	write := func(name string) {
+		key := http.CanonicalHeaderKey(name)
+		if written[key] {
+			return
+		}
+		written[key] = true
+		if key == "Host" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, host)
+			return
+		}
+		for _, v := range req.Header[key] {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, v)
+		}
+	}
+	for _, name := range t.b.HeaderOrder {
+		write(name)
+	}
+	write("Host")
+	var rest []string
+	for key := range req.Header {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		write(key)
+	}
+	if req.ContentLength > 0 || req.Body != nil || req.Method == "POST" || req.Method == "PUT" {
+		if !written["Content-Length"] {
+			fmt.Fprintf(&buf, "Content-Length: %d\r\n", req.ContentLength)
+		}
+	}
+	if t.b.DisableKeepAlives && !written["Connection"] {
+		buf.WriteString("Connection: close\r\n")
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// rawBody returns the connection of a response to the idle connections
+// once the body was read completely, and closes it otherwise.
+type rawBody struct {
+	io.ReadCloser
+	t     *rawTransport
+	key   string
+	conn  *rawConn
+	done  chan struct{}
+	reuse bool
+	eof   bool
+	once  sync.Once
+}
+
+func (b *rawBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *rawBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		close(b.done)
+		if b.reuse && b.eof && err == nil {
+			b.conn.SetDeadline(time.Time{})
+			b.t.put(b.key, b.conn)
+			return
+		}
+		b.conn.Close()
+	})
+	return err
+}
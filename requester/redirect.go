@@ -0,0 +1,65 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// maxRedirects matches the limit of the default http.Client policy.
+const maxRedirects = 10
+
+type redirectChainKey struct{}
+
+// redirectChain collects the redirect hops of a single request.
+type redirectChain struct {
+	last time.Duration
+	hops []report.Redirect
+}
+
+func withRedirectChain(ctx context.Context, c *redirectChain) context.Context {
+	return context.WithValue(ctx, redirectChainKey{}, c)
+}
+
+// add records the redirect response of a hop.
+func (c *redirectChain) add(res *http.Response) {
+	t := now()
+	c.hops = append(c.hops, report.Redirect{
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		Duration:   t - c.last,
+	})
+	c.last = t
+}
+
+// checkRedirect is the redirect policy of the client. It records the
+// hops of requests that carry a redirect chain.
+func (b *Work) checkRedirect(req *http.Request, via []*http.Request) error {
+	if b.DisableRedirects {
+		return http.ErrUseLastResponse
+	}
+	if c, ok := req.Context().Value(redirectChainKey{}).(*redirectChain); ok && req.Response != nil {
+		c.add(req.Response)
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "sort"
+
+// ClassStats describes the requests of a class, see Result.Class.
+type ClassStats struct {
+	Class string `json:"class"`
+
+	Requests int64 `json:"requests"`
+
+	// Failures is the number of requests that failed or got a 5xx
+	// response.
+	Failures int64 `json:"failures"`
+
+	// P50 to P99 are the percentiles of the latencies of the requests
+	// that got a response, in seconds.
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// RegionStats describes the requests made from a region, see
+// Result.Region.
+type RegionStats struct {
+	Region string `json:"region"`
+
+	Requests int64 `json:"requests"`
+
+	// Failures is the number of requests that failed or got a 5xx
+	// response.
+	Failures int64 `json:"failures"`
+
+	// P50 to P99 are the percentiles of the latencies of the requests
+	// that got a response, in seconds.
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// classStats aggregates the results by class, or by another key of
+// them, see add.
+type classStats struct {
+	requests map[string]int64
+	failures map[string]int64
+
+	// lats holds the first maxRes latencies of every class.
+	lats map[string][]float64
+}
+
+// add adds res to the results of key, its class or region. Results
+// without one are left out.
+func (c *classStats) add(key string, res *Result) {
+	if key == "" {
+		return
+	}
+	if c.requests == nil {
+		c.requests = make(map[string]int64)
+		c.failures = make(map[string]int64)
+		c.lats = make(map[string][]float64)
+	}
+	c.requests[key]++
+	if res.Err != nil || res.StatusCode >= 500 {
+		c.failures[key]++
+	}
+	if res.Err == nil && len(c.lats[key]) < maxRes {
+		c.lats[key] = append(c.lats[key], res.Duration.Seconds())
+	}
+}
+
+// stats returns the stats of the classes, ordered by name.
+func (c *classStats) stats() []ClassStats {
+	var stats []ClassStats
+	for class, n := range c.requests {
+		s := ClassStats{Class: class, Requests: n, Failures: c.failures[class]}
+		if lats := append([]float64(nil), c.lats[class]...); len(lats) > 0 {
+			sort.Float64s(lats)
+			at := func(p int) float64 {
+				return lats[min(len(lats)-1, len(lats)*p/100)]
+			}
+			s.P50, s.P90, s.P95, s.P99 = at(50), at(90), at(95), at(99)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Class < stats[j].Class })
+	return stats
+}
+
+// regionStats returns the stats of the regions, ordered by name.
+func (c *classStats) regionStats() []RegionStats {
+	var stats []RegionStats
+	for _, s := range c.stats() {
+		stats = append(stats, RegionStats{
+			Region:   s.Class,
+			Requests: s.Requests,
+			Failures: s.Failures,
+			P50:      s.P50,
+			P90:      s.P90,
+			P95:      s.P95,
+			P99:      s.P99,
+		})
+	}
+	return stats
+}
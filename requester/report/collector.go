@@ -0,0 +1,256 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+)
+
+// collectorBuffer is the number of results a Collector buffers while
+// the collector is slower than the run.
+const collectorBuffer = 10000
+
+// collectorMethod is the path of the Stream method of collector.proto.
+const collectorMethod = "/hey.collector.v1.ResultCollector/Stream"
+
+// Collector streams the results of a run to an external collector over
+// the client streaming gRPC call of collector.proto as they complete,
+// so that performance platforms can ingest them natively. Results are
+// dropped rather than slowing the run down if the collector does not
+// keep up. It is safe for concurrent use.
+type Collector struct {
+	// RunID identifies the run in every result.
+	RunID string
+
+	mu      sync.RWMutex
+	results chan *Result
+	closed  bool
+	dropped int64
+
+	pw   *io.PipeWriter
+	done chan struct{} // closed when the results are sent
+	res  chan error    // receives the outcome of the call
+}
+
+// DialCollector starts a stream to the collector at target, a
+// grpc://host:port URL, or grpcs://host:port for TLS verified with conf.
+func DialCollector(target string, conf *tls.Config) (*Collector, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || u.Scheme != "grpc" && u.Scheme != "grpcs" {
+		return nil, fmt.Errorf("invalid collector %q, want grpc://host:port or grpcs://host:port", target)
+	}
+	tr := &http2.Transport{TLSClientConfig: conf}
+	scheme := "https"
+	if u.Scheme == "grpc" {
+		// gRPC without TLS is HTTP/2 with prior knowledge.
+		scheme = "http"
+		tr.AllowHTTP = true
+		tr.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("POST", scheme+"://"+u.Host+collectorMethod, pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	var id [8]byte
+	rand.Read(id[:])
+	c := &Collector{
+		RunID:   fmt.Sprintf("%x", id),
+		results: make(chan *Result, collectorBuffer),
+		pw:      pw,
+		done:    make(chan struct{}),
+		res:     make(chan error, 1),
+	}
+	go func() {
+		// The response may only come at the end of the stream.
+		err := call(tr, req)
+		// Unblock the sender if the call ended early.
+		pr.CloseWithError(fmt.Errorf("collector call ended: %v", err))
+		c.res <- err
+	}()
+	go c.send()
+	return c, nil
+}
+
+// call makes the gRPC call of req and returns its error, if any.
+func call(tr http.RoundTripper, req *http.Request) error {
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded %s", res.Status)
+	}
+	// A call that fails at once sends its status in the headers.
+	status, msg := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if msg, err := url.PathUnescape(msg); err == nil {
+			return fmt.Errorf("collector call failed with status %s: %s", status, msg)
+		}
+		return fmt.Errorf("collector call failed with status %s", status)
+	}
+	return nil
+}
+
+// send writes the results to the stream until they are closed.
+func (c *Collector) send() {
+	defer close(c.done)
+	var buf []byte
+	failed := false
+	for res := range c.results {
+		if failed {
+			atomic.AddInt64(&c.dropped, 1)
+			continue
+		}
+		buf = appendMessage(buf[:0], c.RunID, res)
+		if _, err := c.pw.Write(buf); err != nil {
+			failed = true
+			atomic.AddInt64(&c.dropped, 1)
+		}
+	}
+}
+
+// Observe queues res to be streamed. It drops res if the buffer is
+// full or the collector is closed.
+func (c *Collector) Observe(res *Result) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.results <- res:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// Close sends the buffered results, ends the stream and returns the
+// number of dropped results and the error of the call, if any. It must
+// be called once, after the run.
+func (c *Collector) Close() (dropped int64, err error) {
+	c.mu.Lock()
+	c.closed = true
+	close(c.results)
+	c.mu.Unlock()
+	<-c.done
+	c.pw.Close()
+	err = <-c.res
+	return atomic.LoadInt64(&c.dropped), err
+}
+
+// appendMessage appends the gRPC message of res, a length-prefixed
+// Result of collector.proto, to b.
+func appendMessage(b []byte, runID string, res *Result) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0, 0) // not compressed, length
+	b = appendString(b, 1, runID)
+	if !res.Start.IsZero() {
+		b = appendVarint(b, 2, uint64(res.Start.UnixNano()))
+	}
+	b = appendDouble(b, 3, res.Offset.Seconds())
+	b = appendDouble(b, 4, res.Duration.Seconds())
+	b = appendDouble(b, 5, res.DNSDuration.Seconds())
+	b = appendDouble(b, 6, res.ConnDuration.Seconds())
+	b = appendDouble(b, 7, res.TLSDuration.Seconds())
+	b = appendDouble(b, 8, res.ReqDuration.Seconds())
+	b = appendDouble(b, 9, res.DelayDuration.Seconds())
+	b = appendDouble(b, 10, res.ResDuration.Seconds())
+	b = appendVarint(b, 11, uint64(res.StatusCode))
+	b = appendString(b, 12, res.Proto)
+	b = appendVarint(b, 13, uint64(res.ContentLength))
+	b = appendVarint(b, 14, uint64(res.BytesRead))
+	if res.Err != nil {
+		b = appendString(b, 15, res.Err.Error())
+	}
+	b = appendString(b, 16, res.ErrorClass)
+	b = appendString(b, 17, res.Assertion)
+	b = appendString(b, 18, res.Label)
+	b = appendVarint(b, 19, uint64(res.Worker))
+	b = appendVarint(b, 20, uint64(res.Attempt))
+	binary.BigEndian.PutUint32(b[start+1:], uint32(len(b)-start-5))
+	return b
+}
+
+// The wire types of the protocol buffer encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// appendVarint appends field n with the varint v to b, unless v is
+// zero, the default.
+func appendVarint(b []byte, n int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(n)<<3|wireVarint)
+	return appendUvarint(b, v)
+}
+
+// appendDouble appends field n with the double v to b, unless v is
+// zero, the default.
+func appendDouble(b []byte, n int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(n)<<3|wireFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+// appendString appends field n with the string s to b, unless s is
+// empty, the default.
+func appendString(b []byte, n int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendUvarint(b, uint64(n)<<3|wireBytes)
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendUvarint appends the varint encoding of v to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "time"
+
+// maxCorrections limits the number of samples added for a single slow
+// response. Beyond it the samples are spread evenly and weighted.
+const maxCorrections = 1000
+
+// correction estimates the latency distribution corrected for
+// coordinated omission. A worker that waits for a slow response does
+// not start the requests it was scheduled to start in the meantime, so
+// their latencies, which would have included the wait, are missing from
+// the results. Like HdrHistogram's recordValueWithExpectedInterval, it
+// adds a sample for every missed start: for a latency l and interval i,
+// l-i, l-2i and so on down to i.
+type correction struct {
+	interval float64
+	digest   *tdigest
+}
+
+func newCorrection(interval time.Duration, compression float64) *correction {
+	return &correction{interval: interval.Seconds(), digest: newTDigest(compression)}
+}
+
+func (c *correction) add(lat float64) {
+	c.digest.add(lat)
+	missed := int((lat - c.interval) / c.interval)
+	if missed <= 0 {
+		return
+	}
+	n := min(missed, maxCorrections)
+	step := c.interval * float64(missed) / float64(n)
+	w := float64(missed) / float64(n)
+	for j := 1; j <= n; j++ {
+		c.digest.addWeighted(lat-step*float64(j), w)
+	}
+}
+
+func (c *correction) distribution() []LatencyDistribution {
+	if c.digest.count == 0 && len(c.digest.buf) == 0 {
+		return nil
+	}
+	res := make([]LatencyDistribution, len(pctls))
+	for i, p := range pctls {
+		res[i] = LatencyDistribution{Percentage: p, Latency: c.digest.quantile(float64(p) / 100)}
+	}
+	return res
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "sort"
+
+// CustomMetric is a value recorded for a result beyond the built-in HTTP
+// metrics, e.g. a completed business transaction.
+type CustomMetric struct {
+	// Name identifies the metric.
+	Name string `json:"name"`
+
+	// Timer is set if Value is a duration in seconds. Otherwise the
+	// metric is a counter and Value is added to it.
+	Timer bool `json:"timer,omitempty"`
+
+	Value float64 `json:"value"`
+}
+
+// CustomMetricStats is the aggregate of a custom metric.
+type CustomMetricStats struct {
+	Name string `json:"name"`
+
+	// Count is the number of recorded values.
+	Count int64 `json:"count"`
+
+	// Sum is the total of a counter.
+	Sum float64 `json:"sum,omitempty"`
+
+	// Timing describes the durations of a timer, in seconds.
+	Timing *LagStats `json:"timing,omitempty"`
+}
+
+// customMetrics aggregates the custom metrics of the results.
+type customMetrics struct {
+	counts map[string]int64
+	sums   map[string]float64
+
+	// timers holds the first maxRes values of every timer.
+	timers map[string][]float64
+}
+
+func (c *customMetrics) add(res *Result) {
+	for _, m := range res.Metrics {
+		if c.counts == nil {
+			c.counts = make(map[string]int64)
+			c.sums = make(map[string]float64)
+			c.timers = make(map[string][]float64)
+		}
+		c.counts[m.Name]++
+		if !m.Timer {
+			c.sums[m.Name] += m.Value
+		} else if len(c.timers[m.Name]) < maxRes {
+			c.timers[m.Name] = append(c.timers[m.Name], m.Value)
+		}
+	}
+}
+
+// stats returns the aggregates of the metrics, ordered by name.
+func (c *customMetrics) stats() []CustomMetricStats {
+	var stats []CustomMetricStats
+	for name, n := range c.counts {
+		s := CustomMetricStats{Name: name, Count: n, Sum: c.sums[name]}
+		if t := c.timers[name]; len(t) > 0 {
+			s.Timing = newLagStats(append([]float64(nil), t...))
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
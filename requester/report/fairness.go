@@ -0,0 +1,172 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+	"sort"
+)
+
+// WorkerStat holds the requests made by a single worker.
+type WorkerStat struct {
+	Worker   int   `json:"worker"`
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+
+	// AvgLatency is the average latency of the successful requests,
+	// in seconds.
+	AvgLatency float64 `json:"avg_latency"`
+
+	latTotal float64
+}
+
+// WorkerFairness summarizes how evenly requests were spread across
+// workers. A large spread means some workers were starved by the
+// scheduler or the rate limiter, which skews the results.
+type WorkerFairness struct {
+	Workers        int     `json:"workers"`
+	MinRequests    int64   `json:"min_requests"`
+	MaxRequests    int64   `json:"max_requests"`
+	MeanRequests   float64 `json:"mean_requests"`
+	StddevRequests float64 `json:"stddev_requests"`
+
+	// MinAvgLatency and MaxAvgLatency are the extremes of the
+	// AvgLatency of the workers with successful requests, zero if
+	// there are none.
+	MinAvgLatency float64 `json:"min_avg_latency"`
+	MaxAvgLatency float64 `json:"max_avg_latency"`
+
+	// Starved are the workers with the fewest requests, at most five.
+	Starved []WorkerStat `json:"starved"`
+}
+
+// addWorkerResult accounts res to the stats of its worker.
+func addWorkerResult(stats []WorkerStat, res *Result) []WorkerStat {
+	for len(stats) <= res.Worker {
+		stats = append(stats, WorkerStat{Worker: len(stats)})
+	}
+	s := &stats[res.Worker]
+	s.Requests++
+	if res.Err != nil {
+		s.Errors++
+	} else {
+		s.latTotal += res.Duration.Seconds()
+	}
+	return stats
+}
+
+func workerFairness(stats []WorkerStat) *WorkerFairness {
+	if len(stats) == 0 {
+		return nil
+	}
+	f := &WorkerFairness{
+		Workers:     len(stats),
+		MinRequests: math.MaxInt64,
+	}
+	var total int64
+	hasLatency := false
+	for i := range stats {
+		s := &stats[i]
+		total += s.Requests
+		if s.Requests < f.MinRequests {
+			f.MinRequests = s.Requests
+		}
+		if s.Requests > f.MaxRequests {
+			f.MaxRequests = s.Requests
+		}
+		ok := s.Requests - s.Errors
+		if ok == 0 {
+			// A worker with only errors has no latency to compare.
+			continue
+		}
+		s.AvgLatency = s.latTotal / float64(ok)
+		if !hasLatency || s.AvgLatency < f.MinAvgLatency {
+			f.MinAvgLatency = s.AvgLatency
+		}
+		if s.AvgLatency > f.MaxAvgLatency {
+			f.MaxAvgLatency = s.AvgLatency
+		}
+		hasLatency = true
+	}
+	f.MeanRequests = float64(total) / float64(len(stats))
+	var variance float64
+	for _, s := range stats {
+		d := float64(s.Requests) - f.MeanRequests
+		variance += d * d
+	}
+	f.StddevRequests = math.Sqrt(variance / float64(len(stats)))
+
+	sorted := make([]WorkerStat, len(stats))
+	copy(sorted, stats)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Requests < sorted[j].Requests })
+	f.Starved = sorted[:min(5, len(sorted))]
+	return f
+}
+
+// ConnDistribution summarizes how requests were spread across the
+// connections they were made on. A few hot connections serving most of
+// the requests distort HTTP/1.1 benchmarks.
+type ConnDistribution struct {
+	Connections  int     `json:"connections"`
+	MinRequests  int64   `json:"min_requests"`
+	MaxRequests  int64   `json:"max_requests"`
+	MeanRequests float64 `json:"mean_requests"`
+
+	// MaxShare is the fraction of the requests made on the busiest
+	// connection.
+	MaxShare float64 `json:"max_share"`
+
+	// Histogram counts the connections by their number of requests, in
+	// buckets of powers of two.
+	Histogram []ConnBucket `json:"histogram"`
+}
+
+// ConnBucket is the number of connections with Min to Max requests.
+type ConnBucket struct {
+	Min         int64 `json:"min"`
+	Max         int64 `json:"max"`
+	Connections int   `json:"connections"`
+}
+
+func connDistribution(counts map[uint64]int64) *ConnDistribution {
+	if len(counts) == 0 {
+		return nil
+	}
+	d := &ConnDistribution{Connections: len(counts), MinRequests: math.MaxInt64}
+	var total int64
+	buckets := make(map[int]int)
+	for _, n := range counts {
+		total += n
+		if n < d.MinRequests {
+			d.MinRequests = n
+		}
+		if n > d.MaxRequests {
+			d.MaxRequests = n
+		}
+		b := 0
+		for n>>uint(b+1) > 0 {
+			b++
+		}
+		buckets[b]++
+	}
+	d.MeanRequests = float64(total) / float64(len(counts))
+	d.MaxShare = float64(d.MaxRequests) / float64(total)
+	for b := 0; int64(1)<<uint(b) <= d.MaxRequests; b++ {
+		if n := buckets[b]; n > 0 {
+			d.Histogram = append(d.Histogram, ConnBucket{Min: 1 << uint(b), Max: 1<<uint(b+1) - 1, Connections: n})
+		}
+	}
+	return d
+}
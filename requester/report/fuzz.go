@@ -0,0 +1,53 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "sort"
+
+// MutationStats are the outcomes of the requests that were malformed on
+// purpose in the same way.
+type MutationStats struct {
+	Mutation       string         `json:"mutation"`
+	Requests       int64          `json:"requests"`
+	StatusCodeDist map[int]int    `json:"status_codes,omitempty"`
+	ErrorDist      map[string]int `json:"errors,omitempty"`
+}
+
+// mutationStats aggregates the results with a Mutation by mutation.
+type mutationStats map[string]*MutationStats
+
+func (m mutationStats) add(res *Result) {
+	s := m[res.Mutation]
+	if s == nil {
+		s = &MutationStats{Mutation: res.Mutation, StatusCodeDist: make(map[int]int), ErrorDist: make(map[string]int)}
+		m[res.Mutation] = s
+	}
+	s.Requests++
+	if res.Err != nil {
+		s.ErrorDist[res.Err.Error()]++
+	} else {
+		s.StatusCodeDist[res.StatusCode]++
+	}
+}
+
+// stats returns the stats of the mutations, ordered by name.
+func (m mutationStats) stats() []MutationStats {
+	var stats []MutationStats
+	for _, s := range m {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Mutation < stats[j].Mutation })
+	return stats
+}
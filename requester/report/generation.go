@@ -0,0 +1,44 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// maxGenerationSamples is the number of distinct errors a
+// GenerationStats keeps as samples.
+const maxGenerationSamples = 5
+
+// GenerationStats describes the requests that could not be built, e.g.
+// because their data row had no value for a template, see
+// ErrorClassGeneration.
+type GenerationStats struct {
+	// Errors is the number of requests that could not be built.
+	Errors int64 `json:"errors"`
+
+	// Samples are the first distinct errors, in order.
+	Samples []string `json:"samples"`
+}
+
+func (g *GenerationStats) add(res *Result) {
+	g.Errors++
+	if len(g.Samples) == maxGenerationSamples {
+		return
+	}
+	msg := res.Err.Error()
+	for _, s := range g.Samples {
+		if s == msg {
+			return
+		}
+	}
+	g.Samples = append(g.Samples, msg)
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// DefaultHDRPrecision is the number of significant decimal digits the
+// HDR histogram quantile engine keeps if none is provided.
+const DefaultHDRPrecision = 3
+
+// hdrHistogram is a High Dynamic Range histogram of latencies in
+// nanoseconds, as described by HdrHistogram. It keeps values to a
+// fixed number of significant digits with counts in log-linear
+// buckets, so that the memory it uses only grows with the logarithm of
+// the largest value and not with the number of samples.
+type hdrHistogram struct {
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int
+	subBucketMask               int64
+
+	counts   []int64
+	total    int64
+	min, max float64
+}
+
+func newHDRHistogram(precision int) *hdrHistogram {
+	if precision <= 0 {
+		precision = DefaultHDRPrecision
+	}
+	largest := 2 * math.Pow10(precision)
+	magnitude := uint(math.Ceil(math.Log2(largest)))
+	h := &hdrHistogram{
+		subBucketHalfCountMagnitude: magnitude - 1,
+		subBucketHalfCount:          1 << (magnitude - 1),
+		subBucketMask:               1<<magnitude - 1,
+		min:                         math.Inf(1),
+		max:                         math.Inf(-1),
+	}
+	return h
+}
+
+// add adds a latency in seconds.
+func (h *hdrHistogram) add(lat float64) {
+	v := int64(lat * 1e9)
+	if v < 0 {
+		v = 0
+	}
+	i := h.index(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.total++
+	h.min = math.Min(h.min, lat)
+	h.max = math.Max(h.max, lat)
+}
+
+func (h *hdrHistogram) index(v int64) int {
+	bucket := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask)) - int(h.subBucketHalfCountMagnitude+1)
+	sub := int(v >> uint(bucket))
+	return (bucket+1)<<h.subBucketHalfCountMagnitude + sub - h.subBucketHalfCount
+}
+
+// bounds returns the lowest and the highest value, in nanoseconds, that
+// count towards the index i.
+func (h *hdrHistogram) bounds(i int) (lo, hi int64) {
+	bucket := i>>h.subBucketHalfCountMagnitude - 1
+	sub := i&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucket < 0 {
+		sub -= h.subBucketHalfCount
+		bucket = 0
+	}
+	lo = int64(sub) << uint(bucket)
+	return lo, lo + 1<<uint(bucket) - 1
+}
+
+// quantile returns the latency in seconds at the quantile q. It is
+// accurate to the precision of h.
+func (h *hdrHistogram) quantile(q float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(q * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+	var n int64
+	for i, c := range h.counts {
+		if n += c; n >= target {
+			_, hi := h.bounds(i)
+			return math.Max(h.min, math.Min(h.max, float64(hi)/1e9))
+		}
+	}
+	return h.max
+}
+
+// histogram counts the latencies up to each of marks, which are sorted
+// and end with the slowest latency, see Reporter.histogram.
+func (h *hdrHistogram) histogram(marks []float64) []int {
+	counts := make([]int, len(marks))
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		lo, _ := h.bounds(i)
+		b := sort.SearchFloat64s(marks, math.Max(h.min, float64(lo)/1e9))
+		if b == len(marks) {
+			b--
+		}
+		counts[b] += int(c)
+	}
+	return counts
+}
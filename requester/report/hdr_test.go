@@ -0,0 +1,80 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHDRQuantiles(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	h := newHDRHistogram(3)
+	var samples []float64
+	for i := 0; i < 1000000; i++ {
+		// Latencies are usually skewed with a long tail.
+		v := rnd.ExpFloat64() / 100
+		samples = append(samples, v)
+		h.add(v)
+	}
+	sort.Float64s(samples)
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999, 0.9999} {
+		want := samples[int(math.Ceil(q*float64(len(samples))))-1]
+		got := h.quantile(q)
+		if math.Abs(got-want)/want > 0.001 {
+			t.Errorf("quantile(%v) = %v; want %v within 0.1%%", q, got, want)
+		}
+	}
+	if got, want := h.quantile(1), samples[len(samples)-1]; got != want {
+		t.Errorf("quantile(1) = %v; want %v", got, want)
+	}
+	if len(h.counts) > 50000 {
+		t.Errorf("histogram has %v counts; want a bounded number", len(h.counts))
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, engine := range []string{"exact", "hdr"} {
+		r := New(ioutil.Discard, Options{QuantileEngine: engine, HistogramMarks: []float64{0.01, 0.1}})
+		for _, d := range []time.Duration{5, 10, 50, 500} {
+			r.Add(&Result{StatusCode: 200, Duration: d * time.Millisecond})
+		}
+		r.Finalize(time.Second)
+		var counts []int
+		for _, b := range r.Snapshot().Histogram {
+			counts = append(counts, b.Count)
+		}
+		if len(counts) != 3 || counts[0] != 2 || counts[1] != 1 || counts[2] != 1 {
+			t.Errorf("%s: histogram counts = %v; want [2 1 1]", engine, counts)
+		}
+	}
+
+	r := New(ioutil.Discard, Options{HistogramBuckets: 4})
+	for i := 1; i <= 2000; i++ {
+		r.Add(&Result{StatusCode: 200, Duration: time.Duration(i) * time.Millisecond})
+	}
+	r.Finalize(time.Second)
+	s := r.Snapshot()
+	if len(s.Histogram) != 5 {
+		t.Errorf("histogram has %d buckets; want 5", len(s.Histogram))
+	}
+	if len(s.TailLatencyDistribution) != 1 || s.TailLatencyDistribution[0].Percentile != 99.9 {
+		t.Errorf("tail latencies = %+v; want 99.9%% only with 2000 results", s.TailLatencyDistribution)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 240
+	chartMargin = 40
+
+	// maxChartPoints is the maximum number of requests drawn in the
+	// latency over time chart.
+	maxChartPoints = 5000
+)
+
+var htmlTmpl = template.Must(template.New("html").Funcs(template.FuncMap{
+	"formatNumber":  formatNumber,
+	"formatLatency": formatLatency,
+	"percent":       percent,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hey report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 1.5em; font-size: 1.2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em 0.2em 0; text-align: left; }
+svg { background: #fafafa; border: 1px solid #ddd; }
+svg text { font-size: 11px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>hey report</h1>
+
+<h2>Summary</h2>
+{{ if .R.Aborted }}<p>The run was aborted, the summary covers the requests completed until then.</p>
+{{ end }}<table>
+<tr><th>Total</th><td>{{ formatNumber .R.Total.Seconds }} secs</td></tr>
+<tr><th>Requests</th><td>{{ .R.NumRes }}</td></tr>
+<tr><th>Requests/sec</th><td>{{ formatNumber .R.Rps }}</td></tr>
+<tr><th>Slowest</th><td>{{ formatLatency .R.Slowest .R.LatencyUnit }}</td></tr>
+<tr><th>Fastest</th><td>{{ formatLatency .R.Fastest .R.LatencyUnit }}</td></tr>
+<tr><th>Average</th><td>{{ formatLatency .R.Average .R.LatencyUnit }}</td></tr>
+{{ if gt .R.SizeTotal 0 }}<tr><th>Total data</th><td>{{ .R.SizeTotal }} bytes</td></tr>{{ end }}
+</table>
+
+<h2>Latency distribution</h2>
+<table>
+{{ range .R.LatencyDistribution }}{{ if .Percentage }}<tr><th>{{ .Percentage }}%</th><td>{{ formatLatency .Latency $.R.LatencyUnit }}</td></tr>
+{{ end }}{{ end }}{{ range .R.TailLatencyDistribution }}<tr><th>{{ .Percentile }}%</th><td>{{ formatLatency .Latency $.R.LatencyUnit }}</td></tr>
+{{ end }}</table>
+
+<h2>Latency over time</h2>
+{{ .LatencyChart }}
+
+<h2>Response time histogram ({{ .R.LatencyUnit }})</h2>
+{{ .HistogramChart }}
+
+<h2>Status code distribution</h2>
+{{ .StatusChart }}
+{{ if .R.ErrorDist }}
+<h2>Error distribution</h2>
+<table>
+{{ range $err, $num := .R.ErrorDist }}<tr><td>{{ $num }}</td><td>{{ $err }}</td></tr>
+{{ end }}</table>
+{{ end }}
+{{ if .R.TargetMetrics }}
+<h2>Target metrics</h2>
+<table>
+<tr><th>Offset</th><th>Requests/sec</th><th>p95</th><th>Metrics</th></tr>
+{{ range .R.TargetMetrics }}<tr><td>{{ formatNumber .Offset }} secs</td><td>{{ printf "%.1f" .Rps }}</td><td>{{ formatLatency .P95 $.R.LatencyUnit }}</td><td>{{ range $series, $v := .Values }}{{ $series }} {{ printf "%.6g" $v }}<br>{{ end }}</td></tr>
+{{ end }}</table>
+{{ end }}
+</body>
+</html>
+`))
+
+func printHTML(w io.Writer, s Report) error {
+	return htmlTmpl.Execute(w, struct {
+		R              Report
+		LatencyChart   template.HTML
+		HistogramChart template.HTML
+		StatusChart    template.HTML
+	}{
+		R:              s,
+		LatencyChart:   latencyChart(s),
+		HistogramChart: histogramChart(s),
+		StatusChart:    statusChart(s),
+	})
+}
+
+// svgChart starts an SVG chart with axes and returns the buffer to
+// draw into. The plot area is offset by chartMargin on the left and at
+// the bottom.
+func svgChart(xLabel, yLabel string) *bytes.Buffer {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`, chartWidth, chartHeight)
+	fmt.Fprintf(&buf, `<line x1="%d" y1="0" x2="%d" y2="%d" stroke="#999"/>`, chartMargin, chartMargin, chartHeight-chartMargin)
+	fmt.Fprintf(&buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, chartMargin, chartHeight-chartMargin, chartWidth, chartHeight-chartMargin)
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, (chartWidth+chartMargin)/2, chartHeight-8, template.HTMLEscapeString(xLabel))
+	fmt.Fprintf(&buf, `<text x="12" y="%d" transform="rotate(-90 12 %d)" text-anchor="middle">%s</text>`, (chartHeight-chartMargin)/2, (chartHeight-chartMargin)/2, template.HTMLEscapeString(yLabel))
+	return &buf
+}
+
+func endChart(buf *bytes.Buffer) template.HTML {
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String())
+}
+
+// scale maps v in [0, max] to [0, size].
+func scale(v, max, size float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return v / max * size
+}
+
+func latencyChart(s Report) template.HTML {
+	unit := s.LatencyUnit
+	buf := svgChart("seconds since start", "latency ("+unit+")")
+	plotW := float64(chartWidth - chartMargin - 10)
+	plotH := float64(chartHeight - chartMargin - 10)
+	var maxX float64
+	for _, o := range s.Offsets {
+		if o > maxX {
+			maxX = o
+		}
+	}
+	step := 1
+	if len(s.Lats) > maxChartPoints {
+		step = len(s.Lats) / maxChartPoints
+	}
+	for i := 0; i < len(s.Lats); i += step {
+		x := float64(chartMargin) + scale(s.Offsets[i], maxX, plotW)
+		y := float64(chartHeight-chartMargin) - scale(s.Lats[i], s.Slowest, plotH)
+		color := "#3572b0"
+		if s.StatusCodes[i] >= 400 {
+			color = "#d04437"
+		}
+		fmt.Fprintf(buf, `<circle cx="%.1f" cy="%.1f" r="1.5" fill="%s"/>`, x, y, color)
+	}
+	fmt.Fprintf(buf, `<text x="%d" y="12" text-anchor="end">%.3f</text>`, chartMargin-4, s.Slowest*latencyUnits[unit])
+	fmt.Fprintf(buf, `<text x="%d" y="%d" text-anchor="end">%.1f</text>`, chartWidth-4, chartHeight-chartMargin+14, maxX)
+	return endChart(buf)
+}
+
+func histogramChart(s Report) template.HTML {
+	unit := s.LatencyUnit
+	buf := svgChart("latency ("+unit+")", "requests")
+	if len(s.Histogram) == 0 {
+		return endChart(buf)
+	}
+	plotH := float64(chartHeight - chartMargin - 20)
+	barW := float64(chartWidth-chartMargin-10) / float64(len(s.Histogram))
+	var max int
+	for _, b := range s.Histogram {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	for i, b := range s.Histogram {
+		h := scale(float64(b.Count), float64(max), plotH)
+		x := float64(chartMargin) + float64(i)*barW
+		y := float64(chartHeight-chartMargin) - h
+		fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#3572b0"><title>%d</title></rect>`, x+2, y, barW-4, h, b.Count)
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`, x+barW/2, y-3, b.Count)
+		fmt.Fprintf(buf, `<text x="%.1f" y="%d" text-anchor="middle">%.3f</text>`, x+barW/2, chartHeight-chartMargin+14, b.Mark*latencyUnits[unit])
+	}
+	return endChart(buf)
+}
+
+func statusChart(s Report) template.HTML {
+	buf := svgChart("responses", "status code")
+	codes := make([]int, 0, len(s.StatusCodeDist))
+	var max int
+	for code, n := range s.StatusCodeDist {
+		codes = append(codes, code)
+		if n > max {
+			max = n
+		}
+	}
+	sort.Ints(codes)
+	if len(codes) == 0 {
+		return endChart(buf)
+	}
+	plotW := float64(chartWidth - chartMargin - 80)
+	barH := float64(chartHeight-chartMargin-10) / float64(len(codes))
+	for i, code := range codes {
+		n := s.StatusCodeDist[code]
+		w := scale(float64(n), float64(max), plotW)
+		y := 5 + float64(i)*barH
+		color := "#14892c"
+		switch {
+		case code >= 500:
+			color = "#d04437"
+		case code >= 400:
+			color = "#f6c342"
+		case code >= 300:
+			color = "#3572b0"
+		}
+		fmt.Fprintf(buf, `<rect x="%d" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, chartMargin+1, y+2, w, barH-4, color)
+		fmt.Fprintf(buf, `<text x="%d" y="%.1f" text-anchor="end">%d</text>`, chartMargin-4, y+barH/2+4, code)
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f">%d</text>`, float64(chartMargin)+w+6, y+barH/2+4, n)
+	}
+	return endChart(buf)
+}
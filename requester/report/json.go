@@ -0,0 +1,257 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// jsonReport is the structure of the json output. Durations are in
+// seconds. Optional sections are omitted if they are not enabled.
+type jsonReport struct {
+	Manifest interface{} `json:"manifest,omitempty"`
+
+	Summary struct {
+		Total          float64 `json:"total"`
+		Aborted        bool    `json:"aborted"`
+		Requests       int64   `json:"requests"`
+		Rps            float64 `json:"rps"`
+		Slowest        float64 `json:"slowest"`
+		Fastest        float64 `json:"fastest"`
+		Average        float64 `json:"average"`
+		SizeTotal      int64   `json:"size_total"`
+		SizePerRequest int64   `json:"size_per_request"`
+		Truncated      int64   `json:"truncated"`
+	} `json:"summary"`
+
+	// Percentiles maps "p50" and so on to the latency at the percentile.
+	Percentiles map[string]float64 `json:"percentiles"`
+
+	// CorrectedPercentiles are the percentiles corrected for
+	// coordinated omission, if rate limited.
+	CorrectedPercentiles map[string]float64   `json:"corrected_percentiles,omitempty"`
+	Histogram            []jsonBucket         `json:"histogram"`
+	Details              map[string]jsonPhase `json:"details"`
+	Phases               []PhaseStats         `json:"phases,omitempty"`
+
+	StatusCodes    map[string]int `json:"status_codes"`
+	Errors         map[string]int `json:"errors"`
+	Assertions     map[string]int `json:"assertions,omitempty"`
+	Protocols      map[string]int `json:"protocols,omitempty"`
+	GRPCStatuses   map[string]int `json:"grpc_statuses,omitempty"`
+	RedirectChains map[string]int `json:"redirect_chains,omitempty"`
+
+	Sizes          *SizeStats          `json:"sizes,omitempty"`
+	GeneratorLag   *LagStats           `json:"generator_lag,omitempty"`
+	Rate           *RateStats          `json:"rate,omitempty"`
+	Throttling     *ThrottleStats      `json:"throttling,omitempty"`
+	Generation     *GenerationStats    `json:"generation_errors,omitempty"`
+	Streams        *StreamStats        `json:"streams,omitempty"`
+	Security       *SecurityStats      `json:"security_headers,omitempty"`
+	CustomMetrics  []CustomMetricStats `json:"custom_metrics,omitempty"`
+	Classes        []ClassStats        `json:"classes,omitempty"`
+	Regions        []RegionStats       `json:"regions,omitempty"`
+	SLOs           []SLOResult         `json:"slos,omitempty"`
+	Mutations      []MutationStats     `json:"mutations,omitempty"`
+	TLS            *TLSStats           `json:"tls,omitempty"`
+	WorkerFairness *WorkerFairness     `json:"worker_fairness,omitempty"`
+	Connections    *ConnDistribution   `json:"connections,omitempty"`
+	Events         []Event             `json:"events,omitempty"`
+
+	// TargetMetrics are the metrics scraped from the target.
+	TargetMetrics []TargetMetricsSample `json:"target_metrics,omitempty"`
+}
+
+type jsonBucket struct {
+	Mark      float64 `json:"mark"`
+	Count     int     `json:"count"`
+	Frequency float64 `json:"frequency"`
+}
+
+// jsonPhase describes a stage of the requests.
+type jsonPhase struct {
+	Average float64 `json:"average"`
+	Fastest float64 `json:"fastest"`
+	Slowest float64 `json:"slowest"`
+}
+
+func newJSONPhase(avg, a, b float64) jsonPhase {
+	return jsonPhase{Average: finite(avg), Fastest: math.Min(a, b), Slowest: math.Max(a, b)}
+}
+
+// finite returns v, or 0 if v is NaN or infinite, which JSON cannot
+// represent. This is the case for averages if all requests failed.
+func finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+func newJSONReport(s Report, manifest interface{}) *jsonReport {
+	j := &jsonReport{
+		Manifest:       manifest,
+		Percentiles:    make(map[string]float64),
+		Histogram:      make([]jsonBucket, 0, len(s.Histogram)),
+		StatusCodes:    make(map[string]int),
+		Errors:         s.ErrorDist,
+		Assertions:     s.AssertionDist,
+		Protocols:      s.ProtoDist,
+		GRPCStatuses:   s.GRPCDist,
+		Sizes:          s.Sizes,
+		GeneratorLag:   s.GeneratorLag,
+		Rate:           s.Rate,
+		Throttling:     s.Throttling,
+		Generation:     s.Generation,
+		Streams:        s.Streams,
+		Security:       s.Security,
+		CustomMetrics:  s.CustomMetrics,
+		Classes:        s.Classes,
+		Regions:        s.Regions,
+		SLOs:           s.SLOs,
+		Mutations:      s.Mutations,
+		TLS:            s.TLS,
+		WorkerFairness: s.WorkerFairness,
+		Connections:    s.Connections,
+		Events:         s.Events,
+		TargetMetrics:  s.TargetMetrics,
+	}
+	j.Summary.Total = s.Total.Seconds()
+	j.Summary.Aborted = s.Aborted
+	j.Summary.Requests = s.NumRes
+	j.Summary.Rps = finite(s.Rps)
+	j.Summary.Slowest = s.Slowest
+	j.Summary.Fastest = s.Fastest
+	j.Summary.Average = finite(s.Average)
+	j.Summary.SizeTotal = s.SizeTotal
+	j.Summary.SizePerRequest = s.SizeReq
+	j.Summary.Truncated = s.Truncated
+
+	for _, d := range s.LatencyDistribution {
+		if d.Percentage > 0 {
+			j.Percentiles[fmt.Sprintf("p%d", d.Percentage)] = d.Latency
+		}
+	}
+	for _, d := range s.TailLatencyDistribution {
+		j.Percentiles["p"+strconv.FormatFloat(d.Percentile, 'f', -1, 64)] = d.Latency
+	}
+	for _, d := range s.CorrectedLatencyDistribution {
+		if d.Percentage > 0 {
+			if j.CorrectedPercentiles == nil {
+				j.CorrectedPercentiles = make(map[string]float64)
+			}
+			j.CorrectedPercentiles[fmt.Sprintf("p%d", d.Percentage)] = d.Latency
+		}
+	}
+	for _, b := range s.Histogram {
+		j.Histogram = append(j.Histogram, jsonBucket{Mark: b.Mark, Count: b.Count, Frequency: b.Frequency})
+	}
+	j.Details = map[string]jsonPhase{
+		"dns_dialup":    newJSONPhase(s.AvgConn, s.ConnMin, s.ConnMax),
+		"dns_lookup":    newJSONPhase(s.AvgDNS, s.DnsMin, s.DnsMax),
+		"request_write": newJSONPhase(s.AvgReq, s.ReqMin, s.ReqMax),
+		"response_wait": newJSONPhase(s.AvgDelay, s.DelayMin, s.DelayMax),
+		"response_read": newJSONPhase(s.AvgRes, s.ResMin, s.ResMax),
+	}
+	j.Phases = s.Phases
+	for code, n := range s.StatusCodeDist {
+		j.StatusCodes[strconv.Itoa(code)] = n
+	}
+	if s.RedirectDist != nil {
+		j.RedirectChains = make(map[string]int)
+		for hops, n := range s.RedirectDist {
+			j.RedirectChains[strconv.Itoa(hops)] = n
+		}
+	}
+	return j
+}
+
+func printJSON(w io.Writer, s Report, manifest interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newJSONReport(s, manifest))
+}
+
+// jsonlResult is a line of the jsonl output, a single result. Durations
+// are in seconds. Its fields are named like the csv fields.
+type jsonlResult struct {
+	Time       time.Time `json:"ts"`
+	Offset     float64   `json:"offset"`
+	Latency    float64   `json:"latency"`
+	DNS        float64   `json:"dns"`
+	Conn       float64   `json:"conn"`
+	TLS        float64   `json:"tls"`
+	TLSVerify  float64   `json:"tls_verify,omitempty"`
+	OCSP       bool      `json:"ocsp,omitempty"`
+	ECH        string    `json:"ech,omitempty"`
+	Write      float64   `json:"write"`
+	TTFB       float64   `json:"ttfb"`
+	Read       float64   `json:"read"`
+	Status     int       `json:"status"`
+	Proto      string    `json:"proto,omitempty"`
+	GRPCStatus string    `json:"grpc_status,omitempty"`
+	Redirects  int       `json:"redirects,omitempty"`
+	Size       int64     `json:"size"`
+	Bytes      int64     `json:"bytes"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Worker     int       `json:"worker"`
+	Attempt    int       `json:"attempt,omitempty"`
+	Label      string    `json:"label,omitempty"`
+	Class      string    `json:"class,omitempty"`
+	Mutation   string    `json:"mutation,omitempty"`
+	Assertion  string    `json:"assertion,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+}
+
+func newJSONLResult(res *Result) *jsonlResult {
+	j := &jsonlResult{
+		Time:       res.Start,
+		Offset:     res.Offset.Seconds(),
+		Latency:    res.Duration.Seconds(),
+		DNS:        res.DNSDuration.Seconds(),
+		Conn:       res.ConnDuration.Seconds(),
+		TLS:        res.TLSDuration.Seconds(),
+		TLSVerify:  res.TLSVerifyDuration.Seconds(),
+		OCSP:       res.OCSPStapled,
+		ECH:        res.ECH,
+		Write:      res.ReqDuration.Seconds(),
+		TTFB:       res.DelayDuration.Seconds(),
+		Read:       res.ResDuration.Seconds(),
+		Status:     res.StatusCode,
+		Proto:      res.Proto,
+		GRPCStatus: res.GRPCStatus,
+		Redirects:  len(res.Redirects),
+		Size:       res.ContentLength,
+		Bytes:      res.BytesRead,
+		Truncated:  res.Truncated,
+		Worker:     res.Worker,
+		Attempt:    res.Attempt,
+		Label:      res.Label,
+		Class:      res.Class,
+		Mutation:   res.Mutation,
+		Assertion:  res.Assertion,
+		ErrorClass: res.ErrorClass,
+	}
+	if res.Err != nil {
+		j.Error = res.Err.Error()
+	}
+	return j
+}
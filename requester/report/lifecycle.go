@@ -0,0 +1,200 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Types of lifecycle events.
+const (
+	LifecycleRunStarted        = "run_started"
+	LifecyclePhaseChanged      = "phase_changed"
+	LifecycleThresholdBreached = "threshold_breached"
+	LifecycleEvent             = "event"
+	LifecycleRunFinished       = "run_finished"
+)
+
+// minBreachResults is the number of results from which on live
+// statistics are checked against the limits, so that the first few
+// requests cannot breach them alone.
+const minBreachResults = 100
+
+// LifecycleRecord is a structured record of a step of a run.
+type LifecycleRecord struct {
+	Time  time.Time `json:"time"`
+	RunID string    `json:"run_id"`
+
+	// Type is one of the Lifecycle types.
+	Type string `json:"type"`
+
+	// Phase is the phase the run entered, with LifecyclePhaseChanged.
+	Phase string `json:"phase,omitempty"`
+
+	// Detail describes the record, e.g. the breached threshold.
+	Detail string `json:"detail,omitempty"`
+
+	// Summary sums the run up, with LifecycleRunFinished.
+	Summary *LifecycleSummary `json:"summary,omitempty"`
+}
+
+// LifecycleSummary sums up a finished run. Latencies are in seconds.
+type LifecycleSummary struct {
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Rps      float64 `json:"rps"`
+	P95      float64 `json:"p95"`
+	P99      float64 `json:"p99"`
+	Aborted  bool    `json:"aborted,omitempty"`
+
+	// Violations are the thresholds the run violated.
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Limits are the thresholds a Lifecycle checks the run against while it
+// runs. Latencies are zero and ErrorRate is negative if unset.
+type Limits struct {
+	P95, P99  time.Duration
+	ErrorRate float64
+}
+
+// Lifecycle sends structured records of the steps of a run to sinks as
+// they happen, so that automation can react while the run goes on: the
+// run started, its phase changed, a limit was breached, an event such
+// as a circuit breaker transition occurred and the run finished. Limits
+// are checked every second against all the results so far, and each is
+// reported once. It is safe for concurrent use.
+type Lifecycle struct {
+	// RunID identifies the run in every record.
+	RunID string
+
+	limits Limits
+	sinks  []func(LifecycleRecord)
+
+	mu       sync.Mutex
+	lats     *tdigest
+	total    int64
+	failed   int64
+	breached map[string]bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewLifecycle returns a Lifecycle of the run runID that checks limits
+// and sends its records to sinks, which must not block for long.
+func NewLifecycle(runID string, limits Limits, sinks ...func(LifecycleRecord)) *Lifecycle {
+	return &Lifecycle{
+		RunID:    runID,
+		limits:   limits,
+		sinks:    sinks,
+		lats:     newTDigest(100),
+		breached: make(map[string]bool),
+	}
+}
+
+// Emit sends a record of the given type.
+func (l *Lifecycle) Emit(typ, detail string) {
+	l.send(LifecycleRecord{Type: typ, Detail: detail})
+}
+
+// Phase sends a record of the run entering the named phase.
+func (l *Lifecycle) Phase(name string) {
+	l.send(LifecycleRecord{Type: LifecyclePhaseChanged, Phase: name})
+}
+
+// Finish sends the record of the finished run with its summary.
+func (l *Lifecycle) Finish(s LifecycleSummary) {
+	l.send(LifecycleRecord{Type: LifecycleRunFinished, Summary: &s})
+}
+
+func (l *Lifecycle) send(rec LifecycleRecord) {
+	rec.Time = time.Now()
+	rec.RunID = l.RunID
+	for _, sink := range l.sinks {
+		sink(rec)
+	}
+}
+
+// Observe accounts a finished request.
+func (l *Lifecycle) Observe(res *Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total++
+	if res.Err != nil || res.StatusCode >= 500 {
+		l.failed++
+	}
+	if res.Err == nil {
+		l.lats.add(res.Duration.Seconds())
+	}
+}
+
+// Start checks the limits every second in the background until Stop is
+// called.
+func (l *Lifecycle) Start() {
+	l.stop = make(chan struct{})
+	l.stopped = make(chan struct{})
+	go func() {
+		defer close(l.stopped)
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				l.check()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking the limits.
+func (l *Lifecycle) Stop() {
+	close(l.stop)
+	<-l.stopped
+}
+
+// check sends a record for every limit the results so far breach for
+// the first time.
+func (l *Lifecycle) check() {
+	l.mu.Lock()
+	var breaches []string
+	if l.total >= minBreachResults {
+		for _, p := range []struct {
+			name  string
+			q     float64
+			limit time.Duration
+		}{{"p95", 0.95, l.limits.P95}, {"p99", 0.99, l.limits.P99}} {
+			if p.limit <= 0 || l.breached[p.name] {
+				continue
+			}
+			if lat := l.lats.quantile(p.q); lat > p.limit.Seconds() {
+				l.breached[p.name] = true
+				breaches = append(breaches, fmt.Sprintf("%s latency %4.4f secs exceeds %v", p.name, lat, p.limit))
+			}
+		}
+		if rate := float64(l.failed) / float64(l.total); l.limits.ErrorRate >= 0 && rate > l.limits.ErrorRate && !l.breached["errors"] {
+			l.breached["errors"] = true
+			breaches = append(breaches, fmt.Sprintf("error rate %.2f%% exceeds %.2f%%", 100*rate, 100*l.limits.ErrorRate))
+		}
+	}
+	l.mu.Unlock()
+	for _, b := range breaches {
+		l.Emit(LifecycleThresholdBreached, b)
+	}
+}
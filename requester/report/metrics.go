@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// metricsBuckets are the upper bounds of the latency histogram buckets
+// in seconds, the Prometheus defaults.
+var metricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics holds live metrics of a run and serves them over HTTP in the
+// Prometheus text exposition format. It is safe for concurrent use.
+type Metrics struct {
+	inFlight int64
+
+	mu        sync.Mutex
+	completed map[int]int64
+	errors    map[string]int64
+	buckets   []int64
+	sum       float64
+	count     int64
+}
+
+// NewMetrics returns empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		completed: make(map[int]int64),
+		errors:    make(map[string]int64),
+		buckets:   make([]int64, len(metricsBuckets)),
+	}
+}
+
+// Begin marks a request as in flight.
+func (m *Metrics) Begin() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+// End marks a request started with Begin as no longer in flight.
+func (m *Metrics) End() {
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+// Observe accounts a finished request.
+func (m *Metrics) Observe(res *Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if res.Err != nil {
+		m.errors[res.ErrorClass]++
+		return
+	}
+	m.completed[res.StatusCode]++
+	d := res.Duration.Seconds()
+	for i, le := range metricsBuckets {
+		if d <= le {
+			m.buckets[i]++
+		}
+	}
+	m.sum += d
+	m.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP hey_requests_in_flight Requests currently in flight.\n")
+	fmt.Fprintf(w, "# TYPE hey_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "hey_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP hey_requests_total Completed requests by status code.\n")
+	fmt.Fprintf(w, "# TYPE hey_requests_total counter\n")
+	codes := make([]int, 0, len(m.completed))
+	for code := range m.completed {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "hey_requests_total{code=\"%d\"} %d\n", code, m.completed[code])
+	}
+
+	fmt.Fprintf(w, "# HELP hey_errors_total Failed requests by error class.\n")
+	fmt.Fprintf(w, "# TYPE hey_errors_total counter\n")
+	classes := make([]string, 0, len(m.errors))
+	for class := range m.errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "hey_errors_total{class=%q} %d\n", class, m.errors[class])
+	}
+
+	fmt.Fprintf(w, "# HELP hey_request_duration_seconds Latency of completed requests.\n")
+	fmt.Fprintf(w, "# TYPE hey_request_duration_seconds histogram\n")
+	for i, le := range metricsBuckets {
+		fmt.Fprintf(w, "hey_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "hey_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "hey_request_duration_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "hey_request_duration_seconds_count %d\n", m.count)
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// PhaseStats describes the durations of a phase of the requests, in
+// seconds.
+type PhaseStats struct {
+	// Phase is "dns", "connect", "tls", "write", "ttfb" or "read".
+	Phase string `json:"phase"`
+
+	// Count is the number of requests that went through the phase. The
+	// DNS lookup, TCP connect and TLS handshake only happen on new
+	// connections.
+	Count int `json:"count"`
+
+	LagStats
+}
+
+// phaseNames are the names of the phases in the summary.
+var phaseNames = map[string]string{
+	"dns":     "DNS lookup",
+	"connect": "TCP connect",
+	"tls":     "TLS handshake",
+	"write":   "req write",
+	"ttfb":    "resp wait",
+	"read":    "resp read",
+}
+
+// connPhases collects the durations of the phases of new connections,
+// the first maxRes of each.
+type connPhases struct {
+	dns, connect, tls []float64
+}
+
+func (p *connPhases) add(res *Result) {
+	if res.ConnDuration <= 0 || len(p.connect) >= maxRes {
+		return
+	}
+	p.dns = append(p.dns, res.DNSDuration.Seconds())
+	connect := res.ConnDuration - res.DNSDuration - res.TLSDuration
+	if connect < 0 {
+		connect = 0
+	}
+	p.connect = append(p.connect, connect.Seconds())
+	if res.TLSDuration > 0 {
+		p.tls = append(p.tls, res.TLSDuration.Seconds())
+	}
+}
+
+// phaseStats returns the stats of the phases of the requests, given the
+// durations of the request write, time to first byte and response read
+// of every request.
+func (p *connPhases) phaseStats(write, ttfb, read []float64) []PhaseStats {
+	var stats []PhaseStats
+	for _, ph := range []struct {
+		name string
+		lats []float64
+	}{
+		{"dns", p.dns},
+		{"connect", p.connect},
+		{"tls", p.tls},
+		{"write", write},
+		{"ttfb", ttfb},
+		{"read", read},
+	} {
+		if len(ph.lats) == 0 {
+			continue
+		}
+		lats := append([]float64(nil), ph.lats...)
+		stats = append(stats, PhaseStats{Phase: ph.name, Count: len(lats), LagStats: *newLagStats(lats)})
+	}
+	return stats
+}
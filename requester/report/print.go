@@ -0,0 +1,396 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package report aggregates the results of a load test and prints them.
+
+Hey supports two output formats: summary and CSV
+
+The summary output presents a number of statistics about the requests in a
+human-readable format, including:
+- general statistics: requests/second, total runtime, and average, fastest, and slowest requests.
+- a response time histogram.
+- a percentile latency distribution.
+- statistics (average, fastest, slowest) and percentiles of the phases of the requests.
+
+The comma-separated CSV format is proceeded by a header, and consists of the following columns:
+1. response-time:	Total time taken for request (in seconds)
+2. DNS+dialup:		Time taken to establish the TCP connection (in seconds)
+3. DNS:				Time taken to do the DNS lookup (in seconds)
+4. Request-write:	Time taken to write full request (in seconds)
+5. Response-delay: 	Time taken to first byte received (in seconds)
+6. Response-read:	Time taken to read full response (in seconds)
+7. status-code:		HTTP status code of the response (e.g. 200)
+8. offset:			The time since the start of the benchmark when the request was started. (in seconds)
+9. start-time:		Wall clock time the request was started (RFC 3339 with nanoseconds)
+10. worker:			Index of the worker (virtual user) that made the request
+
+The columns of the CSV output can be selected and ordered with -csv-fields.
+In that case every request, including failed ones, gets a row, and the
+output starts with the line "# schema=1", the version of the following
+field names, before the header row that uses them. Field names are never
+renamed or reused with a different meaning within a version; new fields
+may be added to it.
+
+	ts:		Wall clock time the request was started (RFC 3339 with nanoseconds)
+	offset:		The time since the start of the benchmark when the request was started (in seconds)
+	latency:	Total time taken for request (in seconds)
+	dns:		Time taken to do the DNS lookup (in seconds)
+	conn:		Time taken to establish the connection, including DNS and TLS (in seconds)
+	tls:		Time taken for the TLS handshake (in seconds)
+	tls_verify:	Time taken to verify the served certificate chain, if measured (in seconds)
+	ocsp:		Whether the server stapled an OCSP response to the TLS handshake
+	ech:		Outcome of the Encrypted Client Hello, accepted or rejected, empty if none was offered
+	write:		Time taken to write full request (in seconds)
+	ttfb:		Time taken to first byte received after the request was written (in seconds)
+	read:		Time taken to read full response (in seconds)
+	status:		HTTP status code of the response, 0 on errors
+	proto:		Negotiated protocol of the response, e.g. HTTP/2.0
+	redirects:	Number of redirects followed before the final response, if recorded
+	worker:		Index of the worker (virtual user) that made the request
+	size:		Content length of the response (in bytes)
+	bytes:		Number of response body bytes read
+	truncated:	Whether the response body ended before it was complete
+	error:		Error message, empty on success
+	error_class:	Class of the error: timeout, canceled, dns, tls, connection, generation, circuit_open, decompression, expectation or other
+	label:		Label of the request definition, if any
+	attempt:	Number of the attempt, starting at 1
+	grpc_status:	gRPC status of the response, e.g. OK or UNAVAILABLE, empty unless the request was a gRPC call
+*/
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+func newTemplate(output string) *template.Template {
+	outputTmpl := output
+	switch outputTmpl {
+	case "":
+		outputTmpl = defaultTmpl
+	case "csv":
+		outputTmpl = csvTmpl
+	}
+	return template.Must(template.New("tmpl").Funcs(tmplFuncMap).Parse(outputTmpl))
+}
+
+// csvColumns maps the field names accepted by -csv-fields to their values.
+var csvColumns = map[string]func(r *Result) string{
+	"ts":          func(r *Result) string { return formatTime(r.Start) },
+	"offset":      func(r *Result) string { return formatNumber(r.Offset.Seconds()) },
+	"latency":     func(r *Result) string { return formatNumber(r.Duration.Seconds()) },
+	"dns":         func(r *Result) string { return formatNumber(r.DNSDuration.Seconds()) },
+	"conn":        func(r *Result) string { return formatNumber(r.ConnDuration.Seconds()) },
+	"tls":         func(r *Result) string { return formatNumber(r.TLSDuration.Seconds()) },
+	"tls_verify":  func(r *Result) string { return formatNumber(r.TLSVerifyDuration.Seconds()) },
+	"ocsp":        func(r *Result) string { return strconv.FormatBool(r.OCSPStapled) },
+	"ech":         func(r *Result) string { return r.ECH },
+	"write":       func(r *Result) string { return formatNumber(r.ReqDuration.Seconds()) },
+	"ttfb":        func(r *Result) string { return formatNumber(r.DelayDuration.Seconds()) },
+	"read":        func(r *Result) string { return formatNumber(r.ResDuration.Seconds()) },
+	"status":      func(r *Result) string { return strconv.Itoa(r.StatusCode) },
+	"proto":       func(r *Result) string { return r.Proto },
+	"redirects":   func(r *Result) string { return strconv.Itoa(len(r.Redirects)) },
+	"worker":      func(r *Result) string { return strconv.Itoa(r.Worker) },
+	"size":        func(r *Result) string { return strconv.FormatInt(r.ContentLength, 10) },
+	"bytes":       func(r *Result) string { return strconv.FormatInt(r.BytesRead, 10) },
+	"truncated":   func(r *Result) string { return strconv.FormatBool(r.Truncated) },
+	"label":       func(r *Result) string { return r.Label },
+	"attempt":     func(r *Result) string { return strconv.Itoa(r.Attempt) },
+	"grpc_status": func(r *Result) string { return r.GRPCStatus },
+	"error_class": func(r *Result) string { return r.ErrorClass },
+	"error": func(r *Result) string {
+		if r.Err == nil {
+			return ""
+		}
+		return r.Err.Error()
+	},
+}
+
+// ParseCSVFields parses a comma-separated list of csv field names,
+// e.g. "ts,latency,status", and reports unknown names.
+func ParseCSVFields(s string) ([]string, error) {
+	fields := strings.Split(s, ",")
+	for i, f := range fields {
+		f = strings.TrimSpace(f)
+		if _, ok := csvColumns[f]; !ok {
+			return nil, fmt.Errorf("unknown csv field %q", f)
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// csvSchema is the version of the field names of csvColumns.
+const csvSchema = 1
+
+// csvRecord returns the values of the given fields of r.
+func csvRecord(fields []string, r *Result) []string {
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = csvColumns[f](r)
+	}
+	return record
+}
+
+func printCSV(w io.Writer, fields []string, rows [][]string) error {
+	if _, err := fmt.Fprintf(w, "# schema=%d\n", csvSchema); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	return cw.WriteAll(rows)
+}
+
+var tmplFuncMap = template.FuncMap{
+	"formatNumber":    formatNumber,
+	"formatNumberInt": formatNumberInt,
+	"formatTime":      formatTime,
+	"formatLatency":   formatLatency,
+	"percent":         percent,
+	"escapePercent":   escapePercent,
+	"histogram":       histogram,
+	"jsonify":         jsonify,
+	"formatBytes":     formatBytes,
+	"ratio":           func(a, b int64) float64 { return float64(a) / float64(b) },
+	"sub":             func(a, b int64) int64 { return a - b },
+	"int64":           func(v float64) int64 { return int64(v) },
+	"phaseName":       func(phase string) string { return phaseNames[phase] },
+}
+
+func jsonify(v interface{}) string {
+	d, _ := json.Marshal(v)
+	return string(d)
+}
+
+func formatNumber(duration float64) string {
+	return fmt.Sprintf("%4.4f", duration)
+}
+
+func formatNumberInt(duration int) string {
+	return fmt.Sprintf("%d", duration)
+}
+
+// latencyUnits are the units accepted for the latency in human-readable
+// output, with the number of units per second.
+var latencyUnits = map[string]float64{
+	"s":  1,
+	"ms": 1e3,
+	"us": 1e6,
+}
+
+// ValidLatencyUnit reports whether unit is "auto" or one of the supported
+// latency units.
+func ValidLatencyUnit(unit string) bool {
+	_, ok := latencyUnits[unit]
+	return ok || unit == "auto" || unit == ""
+}
+
+// autoLatencyUnit picks the largest unit in which the given latency,
+// in seconds, is at least 1.
+func autoLatencyUnit(latency float64) string {
+	switch {
+	case latency >= 1:
+		return "s"
+	case latency >= 1e-3:
+		return "ms"
+	default:
+		return "us"
+	}
+}
+
+func formatLatency(latency float64, unit string) string {
+	label := unit
+	if unit == "s" {
+		label = "secs"
+	}
+	return fmt.Sprintf("%4.4f %s", latency*latencyUnits[unit], label)
+}
+
+func percent(fraction float64) float64 {
+	return fraction * 100
+}
+
+// escapePercent escapes the percent signs of s, as the summary is
+// printed as a format.
+func escapePercent(s string) string {
+	return strings.Replace(s, "%", "%%", -1)
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func histogram(buckets []Bucket, unit string) string {
+	max := 0
+	for _, b := range buckets {
+		if v := b.Count; v > max {
+			max = v
+		}
+	}
+	res := new(bytes.Buffer)
+	for i := 0; i < len(buckets); i++ {
+		// Normalize bar lengths.
+		var barLen int
+		if max > 0 {
+			barLen = (buckets[i].Count*40 + max/2) / max
+		}
+		res.WriteString(fmt.Sprintf("  %4.3f [%v]\t|%v\n", buckets[i].Mark*latencyUnits[unit], buckets[i].Count, strings.Repeat(barChar, barLen)))
+	}
+	return res.String()
+}
+
+var (
+	defaultTmpl = `{{ if .Aborted }}
+Aborted: the run was interrupted after {{ formatNumber .Total.Seconds }} secs, the summary covers the {{ .NumRes }} requests completed until then.
+{{ end }}
+Summary:
+  Total:	{{ formatNumber .Total.Seconds }} secs
+  Slowest:	{{ formatLatency .Slowest .LatencyUnit }}
+  Fastest:	{{ formatLatency .Fastest .LatencyUnit }}
+  Average:	{{ formatLatency .Average .LatencyUnit }}
+  Requests/sec:	{{ formatNumber .Rps }}
+  {{ if gt .SizeTotal 0 }}
+  Total data:	{{ .SizeTotal }} bytes
+  Size/request:	{{ .SizeReq }} bytes{{ end }}
+
+Response time histogram ({{ .LatencyUnit }}):
+{{ histogram .Histogram .LatencyUnit }}
+
+Latency distribution:{{ range .LatencyDistribution }}
+  {{ .Percentage }}%% in {{ formatLatency .Latency $.LatencyUnit }}{{ end }}{{ range .TailLatencyDistribution }}
+  {{ .Percentile }}%% in {{ formatLatency .Latency $.LatencyUnit }}{{ end }}
+{{ if .CorrectedLatencyDistribution }}
+Corrected latency distribution (for coordinated omission):{{ range .CorrectedLatencyDistribution }}
+  {{ .Percentage }}%% in {{ formatLatency .Latency $.LatencyUnit }}{{ end }}
+{{ end }}
+Details (average, fastest, slowest):
+  DNS+dialup:	{{ formatLatency .AvgConn .LatencyUnit }}, {{ formatLatency .ConnMin .LatencyUnit }}, {{ formatLatency .ConnMax .LatencyUnit }}
+  DNS-lookup:	{{ formatLatency .AvgDNS .LatencyUnit }}, {{ formatLatency .DnsMin .LatencyUnit }}, {{ formatLatency .DnsMax .LatencyUnit }}
+  req write:	{{ formatLatency .AvgReq .LatencyUnit }}, {{ formatLatency .ReqMin .LatencyUnit }}, {{ formatLatency .ReqMax .LatencyUnit }}
+  resp wait:	{{ formatLatency .AvgDelay .LatencyUnit }}, {{ formatLatency .DelayMin .LatencyUnit }}, {{ formatLatency .DelayMax .LatencyUnit }}
+  resp read:	{{ formatLatency .AvgRes .LatencyUnit }}, {{ formatLatency .ResMin .LatencyUnit }}, {{ formatLatency .ResMax .LatencyUnit }}
+{{ if .Phases }}
+Phases (count, 50%%, 90%%, 99%%, slowest):{{ range .Phases }}
+  {{ phaseName .Phase }}:	{{ .Count }}, {{ formatLatency .P50 $.LatencyUnit }}, {{ formatLatency .P90 $.LatencyUnit }}, {{ formatLatency .P99 $.LatencyUnit }}, {{ formatLatency .Max $.LatencyUnit }}{{ end }}
+{{ end }}
+Status code distribution:{{ range $code, $num := .StatusCodeDist }}
+  [{{ $code }}]	{{ $num }} responses{{ end }}
+{{ if gt .Truncated 0 }}
+Truncated responses:	{{ .Truncated }} ({{ printf "%.2f" (percent (ratio .Truncated .NumRes)) }}%%)
+{{ end }}{{ with .Sizes }}
+Response size distribution:
+  Min {{ formatBytes .Min }}, mean {{ formatBytes (int64 .Mean) }}, max {{ formatBytes .Max }}
+  50%% in {{ formatBytes .P50 }}, 90%% in {{ formatBytes .P90 }}, 99%% in {{ formatBytes .P99 }}
+  Latency by size (average, 50%%, 99%%):{{ range .Buckets }}
+  [{{ .Label }}]	{{ .Count }} responses ({{ printf "%.1f" (percent .Frequency) }}%%)	{{ formatLatency .AvgLatency $.LatencyUnit }}, {{ formatLatency .P50Latency $.LatencyUnit }}, {{ formatLatency .P99Latency $.LatencyUnit }}{{ end }}
+{{ end }}{{ if gt (len .ProtoDist) 0 }}
+Protocol distribution:{{ range $proto, $num := .ProtoDist }}
+  [{{ $proto }}]	{{ $num }} responses{{ end }}
+{{ end }}{{ if .GRPCDist }}
+gRPC status distribution:{{ range $status, $num := .GRPCDist }}
+  [{{ $status }}]	{{ $num }} responses{{ end }}
+{{ end }}{{ if .RedirectDist }}
+Redirect chain lengths:{{ range $hops, $num := .RedirectDist }}
+  [{{ $hops }}]	{{ $num }} responses{{ end }}
+{{ end }}
+{{ with .GeneratorLag }}Generator lag (scheduled to actual start):
+  Average:	{{ formatLatency .Average $.LatencyUnit }}
+  50%% in {{ formatLatency .P50 $.LatencyUnit }}, 90%% in {{ formatLatency .P90 $.LatencyUnit }}, 99%% in {{ formatLatency .P99 $.LatencyUnit }}
+  Max:	{{ formatLatency .Max $.LatencyUnit }}
+
+{{ end }}{{ with .Rate }}Request rate:
+  Target:	{{ formatNumber .Target }} req/s
+  Achieved:	{{ formatNumber .Achieved }} req/s ({{ printf "%.1f" (percent .Ratio) }}%%)
+  Drift:{{ range .Windows }}
+  [{{ printf "%.0f" .Start }}-{{ printf "%.0f" .End }} secs]	{{ formatNumber .Rate }} req/s{{ end }}{{ if not .Sustained }}
+  Warning: the generator could not sustain the target rate.{{ end }}
+
+{{ end }}{{ with .Throttling }}Throttling:
+  429 and 503 responses:	{{ .Throttled }} ({{ printf "%.2f" (percent .Rate) }}%%)
+  Backoffs:	{{ .Backoffs }}, average {{ formatNumber .AvgBackoff }} secs, total {{ formatNumber .TotalBackoff }} secs
+
+{{ end }}{{ with .TLS }}TLS handshakes:
+  Full:	{{ sub .Handshakes .Resumed }}, average {{ formatLatency .AvgFull $.LatencyUnit }}
+  Resumed:	{{ .Resumed }}, average {{ formatLatency .AvgResumed $.LatencyUnit }}
+  OCSP stapled:	{{ .Stapled }} of {{ sub .Handshakes .Resumed }} full handshakes{{ if gt .Verified 0 }}
+  Chain verification:	{{ .Verified }}, average {{ formatLatency .AvgVerify $.LatencyUnit }}, max {{ formatLatency .MaxVerify $.LatencyUnit }}{{ end }}{{ if or (gt .ECHAccepted 0) (gt .ECHRejected 0) }}
+  Encrypted Client Hello:	{{ .ECHAccepted }} accepted, {{ .ECHRejected }} rejected{{ end }}{{ range .ClientCerts }}
+  Client certificate {{ .Generation }}:	{{ .Handshakes }} handshakes{{ end }}
+
+{{ end }}{{ with .Streams }}Response streams:
+  Streams:	{{ .Streams }}, {{ .Chunks }} chunks
+  Chunk gap:	average {{ formatLatency .Average $.LatencyUnit }}, 50%% in {{ formatLatency .P50 $.LatencyUnit }}, 90%% in {{ formatLatency .P90 $.LatencyUnit }}, 99%% in {{ formatLatency .P99 $.LatencyUnit }}, max {{ formatLatency .Max $.LatencyUnit }}
+  Stalled:	{{ .Stalled }} streams (gap of {{ formatNumber .StallThreshold }} secs or more)
+
+{{ end }}{{ if .CustomMetrics }}Custom metrics:{{ range .CustomMetrics }}
+  [{{ .Name }}]	{{ if .Timing }}{{ .Count }} timings, average {{ formatLatency .Timing.Average $.LatencyUnit }}, 50%% in {{ formatLatency .Timing.P50 $.LatencyUnit }}, 99%% in {{ formatLatency .Timing.P99 $.LatencyUnit }}, max {{ formatLatency .Timing.Max $.LatencyUnit }}{{ else }}{{ printf "%g" .Sum }}{{ end }}{{ end }}
+
+{{ end }}{{ if .Classes }}Classes:{{ range .Classes }}
+  [{{ .Class }}]	{{ .Requests }} requests, {{ .Failures }} failed, 95%% in {{ formatLatency .P95 $.LatencyUnit }}, 99%% in {{ formatLatency .P99 $.LatencyUnit }}{{ end }}
+
+{{ end }}{{ if .Regions }}Regions:{{ range .Regions }}
+  [{{ .Region }}]	{{ .Requests }} requests, {{ .Failures }} failed, 50%% in {{ formatLatency .P50 $.LatencyUnit }}, 90%% in {{ formatLatency .P90 $.LatencyUnit }}, 95%% in {{ formatLatency .P95 $.LatencyUnit }}, 99%% in {{ formatLatency .P99 $.LatencyUnit }}{{ end }}
+
+{{ end }}{{ if .SLOs }}SLO compliance:{{ range .SLOs }}
+  [{{ .Name }}]	{{ if .NoData }}no requests to measure it on	FAIL{{ else if eq .Metric "error_rate" }}target {{ printf "%.2f" (percent .Target) }}%%, actual {{ printf "%.2f" (percent .Actual) }}%%, margin {{ printf "%.2f" (percent .Margin) }}%%	{{ if .Pass }}PASS{{ else }}FAIL{{ end }}{{ else }}target {{ formatLatency .Target $.LatencyUnit }}, actual {{ formatLatency .Actual $.LatencyUnit }}, margin {{ formatLatency .Margin $.LatencyUnit }}	{{ if .Pass }}PASS{{ else }}FAIL{{ end }}{{ end }}{{ end }}
+
+{{ end }}{{ if .Mutations }}Mutated requests:{{ range .Mutations }}
+  [{{ .Mutation }}]	{{ .Requests }} requests{{ range $code, $num := .StatusCodeDist }}, {{ $num }} got {{ $code }}{{ end }}{{ range $err, $num := .ErrorDist }}, {{ $num }} failed with {{ $err }}{{ end }}{{ end }}
+
+{{ end }}{{ with .Security }}Security headers ({{ .Responses }} responses):{{ range .Headers }}
+  [{{ .Name }}]	{{ printf "%.2f" (percent .Rate) }}%% present{{ end }}
+
+{{ end }}{{ if .Events }}Events:{{ range .Events }}
+  [{{ formatNumber .Offset.Seconds }} secs]	{{ .Name }}: {{ .Detail }}{{ end }}
+
+{{ end }}{{ if .TargetMetrics }}Target metrics:{{ range .TargetMetrics }}
+  [{{ formatNumber .Offset }} secs]	{{ printf "%.1f" .Rps }} requests/sec, p95 {{ formatLatency .P95 $.LatencyUnit }}{{ range $series, $v := .Values }}, {{ $series }} {{ printf "%.6g" $v }}{{ end }}{{ end }}
+
+{{ end }}{{ with .WorkerFairness }}Worker distribution:
+  Workers:	{{ .Workers }}
+  Requests/worker:	min {{ .MinRequests }}, mean {{ printf "%.1f" .MeanRequests }}, max {{ .MaxRequests }}, stddev {{ printf "%.1f" .StddevRequests }}
+  Avg latency/worker:	min {{ formatLatency .MinAvgLatency $.LatencyUnit }}, max {{ formatLatency .MaxAvgLatency $.LatencyUnit }}
+  Least active workers:{{ range .Starved }}
+  [{{ .Worker }}]	{{ .Requests }} requests, {{ .Errors }} errors, {{ formatLatency .AvgLatency $.LatencyUnit }} avg{{ end }}
+
+{{ end }}{{ with .Connections }}Connection distribution:
+  Connections:	{{ .Connections }}
+  Requests/connection:	min {{ .MinRequests }}, mean {{ printf "%.1f" .MeanRequests }}, max {{ .MaxRequests }}
+  Busiest connection:	{{ printf "%.2f" (percent .MaxShare) }}%% of requests{{ range .Histogram }}
+  [{{ .Min }}{{ if ne .Min .Max }}-{{ .Max }}{{ end }}]	{{ .Connections }} connections{{ end }}
+
+{{ end }}{{ with .Generation }}Generation errors:
+  Requests not built:	{{ .Errors }}{{ range .Samples }}
+  e.g.	{{ escapePercent . }}{{ end }}
+
+{{ end }}{{ if .AssertionDist }}Failed assertions:{{ range $kind, $num := .AssertionDist }}
+  [{{ $kind }}]	{{ $num }} responses{{ end }}
+
+{{ end }}{{ if gt (len .ErrorDist) 0 }}Error distribution:{{ range $err, $num := .ErrorDist }}
+  [{{ $num }}]	{{ $err }}{{ end }}{{ end }}
+`
+	csvTmpl = `{{ $connLats := .ConnLats }}{{ $dnsLats := .DnsLats }}{{ $dnsLats := .DnsLats }}{{ $reqLats := .ReqLats }}{{ $delayLats := .DelayLats }}{{ $resLats := .ResLats }}{{ $statusCodeLats := .StatusCodes }}{{ $offsets := .Offsets}}{{ $starts := .Starts }}{{ $workers := .Workers }}response-time,DNS+dialup,DNS,Request-write,Response-delay,Response-read,status-code,offset,start-time,worker{{ range $i, $v := .Lats }}
+{{ formatNumber $v }},{{ formatNumber (index $connLats $i) }},{{ formatNumber (index $dnsLats $i) }},{{ formatNumber (index $reqLats $i) }},{{ formatNumber (index $delayLats $i) }},{{ formatNumber (index $resLats $i) }},{{ formatNumberInt (index $statusCodeLats $i) }},{{ formatNumber (index $offsets $i) }},{{ formatTime (index $starts $i) }},{{ formatNumberInt (index $workers $i) }}{{ end }}`
+)
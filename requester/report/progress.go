@@ -0,0 +1,264 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress prints a progress line of a run at an interval while it
+// runs: the requests done, the rate and 95th percentile latency since
+// the previous line and the number of errors. It is safe for
+// concurrent use.
+type Progress struct {
+	w        io.Writer
+	interval time.Duration
+	// inPlace redraws the line on a terminal instead of printing a
+	// new one every interval.
+	inPlace bool
+	// json prints every Tick as JSON instead of a line.
+	json bool
+	// follow, if set, returns the Ticks to print instead of those of
+	// the observed results, see Follow.
+	follow func() Tick
+
+	mu     sync.Mutex
+	done   int64
+	errors int64
+	lats   []float64 // since the previous line
+	start  time.Time
+	last   time.Time
+	// lastDone is done at the previous line.
+	lastDone int64
+	width    int // of the line printed in place
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewProgress returns a Progress that prints to w every interval. If
+// inPlace is set, w is a terminal and the line is redrawn in place.
+func NewProgress(w io.Writer, interval time.Duration, inPlace bool) *Progress {
+	return &Progress{
+		w:        w,
+		interval: interval,
+		inPlace:  inPlace,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// NewJSONProgress returns a Progress that prints a Tick as a line of
+// JSON to w every interval, e.g. for a controller to merge the progress
+// of its agents.
+func NewJSONProgress(w io.Writer, interval time.Duration) *Progress {
+	p := NewProgress(w, interval, false)
+	p.json = true
+	return p
+}
+
+// Follow makes p print the Ticks tick returns instead of the progress of
+// the results it observes, e.g. the merged progress of the agents of a
+// distributed run. It must be called before Start.
+func (p *Progress) Follow(tick func() Tick) {
+	p.follow = tick
+}
+
+// Observe accounts a finished request.
+func (p *Progress) Observe(res *Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if res.Err != nil {
+		p.errors++
+		return
+	}
+	p.lats = append(p.lats, res.Duration.Seconds())
+}
+
+// Start prints the progress in the background until Stop is called.
+func (p *Progress) Start() {
+	p.start = time.Now()
+	p.last = p.start
+	go func() {
+		defer close(p.stopped)
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				p.print(now)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops printing and clears the line printed in place, so that
+// the summary follows on a clean line.
+func (p *Progress) Stop() {
+	close(p.stop)
+	<-p.stopped
+	if p.inPlace && p.width > 0 {
+		fmt.Fprintf(p.w, "\r%s\r", strings.Repeat(" ", p.width))
+	}
+}
+
+func (p *Progress) print(now time.Time) {
+	if p.json {
+		b, _ := json.Marshal(p.tick(now))
+		p.w.Write(append(b, '\n'))
+		return
+	}
+	line := p.line(now)
+	if !p.inPlace {
+		fmt.Fprintln(p.w, line)
+		return
+	}
+	pad := ""
+	if n := p.width - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprintf(p.w, "\r%s%s", line, pad)
+	p.width = len(line)
+}
+
+// line returns the progress line at now and starts a new interval.
+func (p *Progress) line(now time.Time) string {
+	return p.tick(now).Line()
+}
+
+// tick returns the progress at now and starts a new interval.
+func (p *Progress) tick(now time.Time) Tick {
+	if p.follow != nil {
+		t := p.follow()
+		t.Elapsed = now.Sub(p.start).Seconds()
+		return t
+	}
+	p.mu.Lock()
+	done, errors, lats := p.done, p.errors, p.lats
+	p.lats = nil
+	p.mu.Unlock()
+
+	elapsed := now.Sub(p.last).Seconds()
+	p.last = now
+	var rps float64
+	if elapsed > 0 {
+		rps = float64(done-p.lastDone) / elapsed
+	}
+	p.lastDone = done
+	sort.Float64s(lats)
+	return Tick{
+		Elapsed:   now.Sub(p.start).Seconds(),
+		Requests:  done,
+		Errors:    errors,
+		Rate:      rps,
+		Latencies: spread(lats, tickLatencies),
+		Count:     int64(len(lats)),
+	}
+}
+
+// tickLatencies is the number of latencies a Tick keeps at most.
+const tickLatencies = 100
+
+// Tick is the progress of a run at a progress line: the requests done
+// and the errors since its start, and the rate and the latencies since
+// the previous line.
+type Tick struct {
+	// Elapsed is the time since the start of the run, in seconds.
+	Elapsed  float64 `json:"elapsed"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+
+	// Rate is the number of requests per second.
+	Rate float64 `json:"rate"`
+
+	// Latencies are sorted latencies in seconds, evenly spaced over the
+	// distribution of the Count latencies of the successful requests,
+	// so that every one stands for Count/len(Latencies) requests.
+	Latencies []float64 `json:"latencies,omitempty"`
+	Count     int64     `json:"count"`
+}
+
+// Line returns the progress line of t.
+func (t Tick) Line() string {
+	p95 := "-"
+	if n := len(t.Latencies); n > 0 {
+		l := t.Latencies[min(n-1, n*95/100)]
+		p95 = formatLatency(l, autoLatencyUnit(l))
+	}
+	elapsed := time.Duration(t.Elapsed * float64(time.Second))
+	return fmt.Sprintf("[%v] %d requests, %.1f requests/sec, p95 %s, %d errors",
+		elapsed.Round(time.Second), t.Requests, t.Rate, p95, t.Errors)
+}
+
+// MergeTicks returns the progress of the runs of ticks as a whole, e.g.
+// of the agents of a distributed run.
+func MergeTicks(ticks []Tick) Tick {
+	type point struct{ lat, weight float64 }
+	var m Tick
+	var points []point
+	for _, t := range ticks {
+		m.Elapsed = math.Max(m.Elapsed, t.Elapsed)
+		m.Requests += t.Requests
+		m.Errors += t.Errors
+		m.Rate += t.Rate
+		m.Count += t.Count
+		for _, l := range t.Latencies {
+			points = append(points, point{l, float64(t.Count) / float64(len(t.Latencies))})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].lat < points[j].lat })
+	if len(points) <= tickLatencies {
+		for _, pt := range points {
+			m.Latencies = append(m.Latencies, pt.lat)
+		}
+		return m
+	}
+	// Pick the latencies at evenly spaced ranks of the merged
+	// distribution, every point standing for weight requests.
+	var cum float64
+	j := 0
+	for i := 0; i < tickLatencies; i++ {
+		rank := (float64(i) + 0.5) * float64(m.Count) / tickLatencies
+		for j < len(points)-1 && cum+points[j].weight < rank {
+			cum += points[j].weight
+			j++
+		}
+		m.Latencies = append(m.Latencies, points[j].lat)
+	}
+	return m
+}
+
+// spread returns n of the sorted values, evenly spaced over their
+// distribution, or all of them if there are no more than n.
+func spread(sorted []float64, n int) []float64 {
+	if len(sorted) <= n {
+		return sorted
+	}
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = sorted[(2*i+1)*len(sorted)/(2*n)]
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+	"time"
+)
+
+// sustainedRatio is the fraction of the target rate a run has to achieve
+// for the rate to count as sustained.
+const sustainedRatio = 0.95
+
+// maxRateWindows is the maximum number of windows the drift of the
+// achieved rate is shown in.
+const maxRateWindows = 10
+
+// RateStats compares the achieved request rate with the requested one.
+type RateStats struct {
+	// Target is the requested number of requests per second.
+	Target float64 `json:"target"`
+
+	// Achieved is the number of requests per second actually made.
+	Achieved float64 `json:"achieved"`
+
+	// Ratio is Achieved divided by Target.
+	Ratio float64 `json:"ratio"`
+
+	// Sustained is false if the generator fell short of the target rate.
+	Sustained bool `json:"sustained"`
+
+	// Windows is the achieved rate over consecutive windows of the run,
+	// which shows whether it drifted over time.
+	Windows []RateWindow `json:"windows"`
+}
+
+// RateWindow is the achieved rate during a part of the run.
+type RateWindow struct {
+	// Start and End are the offsets of the window in seconds.
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+
+	// Rate is the number of requests per second started in the window.
+	Rate float64 `json:"rate"`
+}
+
+// addRateCount counts a request started at offset in the per-second
+// counts.
+func addRateCount(counts []int, offset time.Duration) []int {
+	sec := int(offset / time.Second)
+	if sec < 0 {
+		sec = 0
+	}
+	for len(counts) <= sec {
+		counts = append(counts, 0)
+	}
+	counts[sec]++
+	return counts
+}
+
+func newRateStats(target float64, numRes int64, total time.Duration, counts []int) *RateStats {
+	s := &RateStats{Target: target}
+	if total > 0 {
+		s.Achieved = float64(numRes) / total.Seconds()
+	}
+	s.Ratio = s.Achieved / target
+	s.Sustained = s.Ratio >= sustainedRatio
+
+	secs := int(math.Ceil(total.Seconds()))
+	if secs > len(counts) {
+		secs = len(counts)
+	}
+	width := (secs + maxRateWindows - 1) / maxRateWindows
+	for start := 0; start < secs; start += width {
+		end := min(start+width, secs)
+		var n int
+		for _, c := range counts[start:end] {
+			n += c
+		}
+		w := RateWindow{Start: float64(start), End: math.Min(float64(end), total.Seconds())}
+		if d := w.End - w.Start; d > 0 {
+			w.Rate = float64(n) / d
+		}
+		s.Windows = append(s.Windows, w)
+	}
+	return s
+}
@@ -0,0 +1,396 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A record file holds the raw results of a run, so that they can be
+// reported on again later. It starts with an uncompressed header line
+//
+//	HEYREC <version> <compression>
+//
+// where compression is "gzip" or "none". The rest of the file is
+// compressed as stated and holds one JSON object per line. The "type"
+// field of each object is "run" for the first line, "result" for each
+// result, "event" for each event, "target" for each scrape of the
+// metrics of the target and "end" for the last line.
+//
+// The format evolves by adding fields and line types only: readers
+// ignore fields and line types they do not know. RecordVersion is only
+// increased for incompatible changes, and readers refuse files with a
+// version newer than their own.
+const RecordVersion = 1
+
+const recordMagic = "HEYREC"
+
+// recordLine is a single line of a record file. Durations are in
+// nanoseconds.
+type recordLine struct {
+	Type string `json:"type"`
+
+	// Set on "run" lines.
+	Version        int           `json:"version,omitempty"`
+	RateLimited    bool          `json:"rate_limited,omitempty"`
+	TargetRate     float64       `json:"target_rate,omitempty"`
+	Correction     time.Duration `json:"correction_interval,omitempty"`
+	RedirectChain  bool          `json:"redirect_chain,omitempty"`
+	RetryAfter     bool          `json:"retry_after,omitempty"`
+	StreamStats    bool          `json:"stream_stats,omitempty"`
+	StallThreshold time.Duration `json:"stall_threshold,omitempty"`
+	Security       bool          `json:"security_headers,omitempty"`
+
+	// Set on "result" lines.
+	Result *recordResult `json:"result,omitempty"`
+
+	// Set on "event" lines.
+	Event *Event `json:"event,omitempty"`
+
+	// Set on "target" lines.
+	Target *TargetSample `json:"target,omitempty"`
+
+	// Set on "end" lines.
+	Total   time.Duration `json:"total,omitempty"`
+	Aborted bool          `json:"aborted,omitempty"`
+}
+
+type recordResult struct {
+	Start         time.Time       `json:"start"`
+	Offset        time.Duration   `json:"offset"`
+	Duration      time.Duration   `json:"duration"`
+	ConnDuration  time.Duration   `json:"conn,omitempty"`
+	DNSDuration   time.Duration   `json:"dns,omitempty"`
+	TLSDuration   time.Duration   `json:"tls,omitempty"`
+	TLSResumed    bool            `json:"tls_resumed,omitempty"`
+	OCSPStapled   bool            `json:"ocsp_stapled,omitempty"`
+	TLSVerify     time.Duration   `json:"tls_verify,omitempty"`
+	ECH           string          `json:"ech,omitempty"`
+	ReqDuration   time.Duration   `json:"write,omitempty"`
+	DelayDuration time.Duration   `json:"ttfb,omitempty"`
+	ResDuration   time.Duration   `json:"read,omitempty"`
+	StatusCode    int             `json:"status,omitempty"`
+	Proto         string          `json:"proto,omitempty"`
+	GRPCStatus    string          `json:"grpc_status,omitempty"`
+	ContentLength int64           `json:"size"`
+	BytesRead     int64           `json:"bytes,omitempty"`
+	Truncated     bool            `json:"truncated,omitempty"`
+	Err           string          `json:"error,omitempty"`
+	ErrorClass    string          `json:"error_class,omitempty"`
+	Label         string          `json:"label,omitempty"`
+	Class         string          `json:"class,omitempty"`
+	Region        string          `json:"region,omitempty"`
+	Attempt       int             `json:"attempt,omitempty"`
+	Worker        int             `json:"worker"`
+	ConnID        uint64          `json:"conn_id,omitempty"`
+	Lag           time.Duration   `json:"lag,omitempty"`
+	Backoff       time.Duration   `json:"backoff,omitempty"`
+	Redirects     []Redirect      `json:"redirects,omitempty"`
+	ChunkGaps     []time.Duration `json:"chunk_gaps,omitempty"`
+	Security      uint            `json:"security_headers,omitempty"`
+	Metrics       []CustomMetric  `json:"metrics,omitempty"`
+	Mutation      string          `json:"mutation,omitempty"`
+	Assertion     string          `json:"assertion,omitempty"`
+}
+
+func newRecordResult(res *Result) *recordResult {
+	rr := &recordResult{
+		Start:         res.Start,
+		Offset:        res.Offset,
+		Duration:      res.Duration,
+		ConnDuration:  res.ConnDuration,
+		DNSDuration:   res.DNSDuration,
+		TLSDuration:   res.TLSDuration,
+		TLSResumed:    res.TLSResumed,
+		OCSPStapled:   res.OCSPStapled,
+		TLSVerify:     res.TLSVerifyDuration,
+		ECH:           res.ECH,
+		ReqDuration:   res.ReqDuration,
+		DelayDuration: res.DelayDuration,
+		ResDuration:   res.ResDuration,
+		StatusCode:    res.StatusCode,
+		Proto:         res.Proto,
+		GRPCStatus:    res.GRPCStatus,
+		ContentLength: res.ContentLength,
+		BytesRead:     res.BytesRead,
+		Truncated:     res.Truncated,
+		ErrorClass:    res.ErrorClass,
+		Label:         res.Label,
+		Class:         res.Class,
+		Region:        res.Region,
+		Attempt:       res.Attempt,
+		Worker:        res.Worker,
+		ConnID:        res.ConnID,
+		Lag:           res.Lag,
+		Backoff:       res.Backoff,
+		Redirects:     res.Redirects,
+		ChunkGaps:     res.ChunkGaps,
+		Security:      res.SecurityHeaders,
+		Metrics:       res.Metrics,
+		Mutation:      res.Mutation,
+		Assertion:     res.Assertion,
+	}
+	if res.Err != nil {
+		rr.Err = res.Err.Error()
+	}
+	return rr
+}
+
+func (rr *recordResult) result() *Result {
+	res := &Result{
+		Start:             rr.Start,
+		Offset:            rr.Offset,
+		Duration:          rr.Duration,
+		ConnDuration:      rr.ConnDuration,
+		DNSDuration:       rr.DNSDuration,
+		TLSDuration:       rr.TLSDuration,
+		TLSResumed:        rr.TLSResumed,
+		OCSPStapled:       rr.OCSPStapled,
+		TLSVerifyDuration: rr.TLSVerify,
+		ECH:               rr.ECH,
+		ReqDuration:       rr.ReqDuration,
+		DelayDuration:     rr.DelayDuration,
+		ResDuration:       rr.ResDuration,
+		StatusCode:        rr.StatusCode,
+		Proto:             rr.Proto,
+		GRPCStatus:        rr.GRPCStatus,
+		ContentLength:     rr.ContentLength,
+		BytesRead:         rr.BytesRead,
+		Truncated:         rr.Truncated,
+		ErrorClass:        rr.ErrorClass,
+		Label:             rr.Label,
+		Class:             rr.Class,
+		Region:            rr.Region,
+		Attempt:           rr.Attempt,
+		Worker:            rr.Worker,
+		ConnID:            rr.ConnID,
+		Lag:               rr.Lag,
+		Backoff:           rr.Backoff,
+		Redirects:         rr.Redirects,
+		ChunkGaps:         rr.ChunkGaps,
+		SecurityHeaders:   rr.Security,
+		Metrics:           rr.Metrics,
+		Mutation:          rr.Mutation,
+		Assertion:         rr.Assertion,
+	}
+	if rr.Err != "" {
+		res.Err = errors.New(rr.Err)
+	}
+	return res
+}
+
+// RecordWriter writes a record file. It is safe for concurrent use.
+type RecordWriter struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+// NewRecordWriter writes the header of a record file to w and returns a
+// RecordWriter for the rest of it. The results are gzipped if compress
+// is set.
+func NewRecordWriter(w io.Writer, compress bool) (*RecordWriter, error) {
+	rw := &RecordWriter{buf: bufio.NewWriter(w)}
+	compression := "none"
+	if compress {
+		compression = "gzip"
+	}
+	if _, err := fmt.Fprintf(rw.buf, "%s %d %s\n", recordMagic, RecordVersion, compression); err != nil {
+		return nil, err
+	}
+	var body io.Writer = rw.buf
+	if compress {
+		rw.gz = gzip.NewWriter(rw.buf)
+		body = rw.gz
+	}
+	rw.enc = json.NewEncoder(body)
+	return rw, nil
+}
+
+func (rw *RecordWriter) write(l *recordLine) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.enc.Encode(l)
+}
+
+// WriteRun writes the options the run was reported with. It must be
+// called before any result is written.
+func (rw *RecordWriter) WriteRun(opts Options) error {
+	return rw.write(&recordLine{
+		Type:           "run",
+		Version:        RecordVersion,
+		RateLimited:    opts.RateLimited,
+		TargetRate:     opts.TargetRate,
+		Correction:     opts.CorrectionInterval,
+		RedirectChain:  opts.RedirectChain,
+		RetryAfter:     opts.RetryAfter,
+		StreamStats:    opts.StreamStats,
+		StallThreshold: opts.StallThreshold,
+		Security:       opts.SecurityHeaders,
+	})
+}
+
+// WriteResult writes a single result.
+func (rw *RecordWriter) WriteResult(res *Result) error {
+	return rw.write(&recordLine{Type: "result", Result: newRecordResult(res)})
+}
+
+// WriteEvent writes a single event.
+func (rw *RecordWriter) WriteEvent(e Event) error {
+	return rw.write(&recordLine{Type: "event", Event: &e})
+}
+
+// WriteTargetSample writes a single scrape of the metrics of the target.
+func (rw *RecordWriter) WriteTargetSample(s TargetSample) error {
+	return rw.write(&recordLine{Type: "target", Target: &s})
+}
+
+// Close writes the total duration of the run, and whether it was
+// aborted, and flushes the file. It does not close the underlying
+// writer.
+func (rw *RecordWriter) Close(total time.Duration, aborted bool) error {
+	err := rw.write(&recordLine{Type: "end", Total: total, Aborted: aborted})
+	if rw.gz != nil {
+		if cerr := rw.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if ferr := rw.buf.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Replay reads the record file from r and adds its results and events
+// to a new Reporter that writes to w. The options of the run are taken
+// from the file; only the output related fields of opts are used. It
+// returns the Reporter and the total duration of the recorded run.
+func Replay(r io.Reader, w io.Writer, opts Options) (*Reporter, time.Duration, error) {
+	return Merge([]io.Reader{r}, w, opts)
+}
+
+// Merge reads the record files of runs made at the same time, e.g. by
+// the agents of a distributed run, and adds all their results and
+// events to a single new Reporter that writes to w, like Replay. The
+// options of the run are taken from the first file. The total duration
+// is that of the longest run, and the merged run is aborted if one of
+// the runs was.
+func Merge(rs []io.Reader, w io.Writer, opts Options) (*Reporter, time.Duration, error) {
+	var (
+		rep     *Reporter
+		total   time.Duration
+		aborted bool
+	)
+	for _, r := range rs {
+		br := bufio.NewReader(r)
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, 0, errors.New("not a hey record file")
+		}
+		f := strings.Fields(header)
+		if len(f) != 3 || f[0] != recordMagic {
+			return nil, 0, errors.New("not a hey record file")
+		}
+		version, err := strconv.Atoi(f[1])
+		if err != nil || version < 1 {
+			return nil, 0, fmt.Errorf("invalid record file version %q", f[1])
+		}
+		if version > RecordVersion {
+			return nil, 0, fmt.Errorf("record file version %d is newer than the supported version %d; upgrade hey", version, RecordVersion)
+		}
+		var body io.Reader = br
+		switch f[2] {
+		case "none":
+		case "gzip":
+			gz, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, 0, err
+			}
+			defer gz.Close()
+			body = gz
+		default:
+			return nil, 0, fmt.Errorf("unsupported record file compression %q", f[2])
+		}
+
+		started, ended := false, false
+		dec := json.NewDecoder(body)
+		for {
+			var l recordLine
+			if err := dec.Decode(&l); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, 0, err
+			}
+			if !started && l.Type != "run" {
+				return nil, 0, errors.New("record file does not start with a run line")
+			}
+			switch l.Type {
+			case "run":
+				started = true
+				if rep != nil {
+					break
+				}
+				opts.RateLimited = l.RateLimited
+				opts.TargetRate = l.TargetRate
+				opts.CorrectionInterval = l.Correction
+				opts.RedirectChain = l.RedirectChain
+				opts.RetryAfter = l.RetryAfter
+				opts.StreamStats = l.StreamStats
+				opts.StallThreshold = l.StallThreshold
+				opts.SecurityHeaders = l.Security
+				rep = New(w, opts)
+			case "result":
+				if l.Result != nil {
+					rep.Add(l.Result.result())
+				}
+			case "event":
+				if l.Event != nil {
+					rep.AddEvent(*l.Event)
+				}
+			case "target":
+				if l.Target != nil {
+					rep.AddTargetSample(*l.Target)
+				}
+			case "end":
+				ended = true
+				if l.Total > total {
+					total = l.Total
+				}
+				aborted = aborted || l.Aborted
+			}
+		}
+		if !started {
+			return nil, 0, errors.New("record file is empty")
+		}
+		if !ended {
+			return nil, 0, errors.New("record file is truncated")
+		}
+	}
+	if rep == nil {
+		return nil, 0, errors.New("no record files")
+	}
+	rep.aborted = aborted
+	return rep, total, nil
+}
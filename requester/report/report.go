@@ -0,0 +1,1136 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	barChar = "■"
+)
+
+// We report for max 1M results.
+const maxRes = 1000000
+
+// We report at most 1000 events.
+const maxEvents = 1000
+
+// Options configures a Reporter.
+type Options struct {
+	// Output is the output type. If empty, a summary is printed. "csv"
+	// dumps the results as comma-separated values, "jsonl" writes every
+	// result as a line of JSON as soon as it is added, "json" prints the
+	// summary as JSON and "html" writes a self-contained HTML page with
+	// charts. Any other value is used as a text/template
+	// executed with the Report.
+	Output string
+
+	// Manifest, if set, is included as is in the json output, e.g. to
+	// describe the configuration of the run.
+	Manifest interface{}
+
+	// N is the expected number of results, used to size buffers.
+	N int
+
+	// CSVFields selects and orders the columns of the csv output.
+	// If empty, the default columns are used. See ParseCSVFields.
+	CSVFields []string
+
+	// LatencyUnit is the unit latencies are shown in by the summary
+	// output: "s", "ms", "us" or "auto". If empty, it is picked
+	// automatically based on the average latency.
+	LatencyUnit string
+
+	// QuantileEngine is the estimator used for the latency percentiles.
+	// If "exact" or empty, percentiles are computed from up to the first
+	// million results. If "tdigest", they are estimated from all results
+	// with a t-digest, which keeps the tails accurate on unbounded runs.
+	// If "hdr", the percentiles and the histogram are computed from all
+	// results with an HDR histogram, accurate to HDRPrecision.
+	QuantileEngine string
+
+	// TDigestCompression is the compression of the t-digest. Higher values
+	// are more accurate and use more memory. Defaults to 100.
+	TDigestCompression float64
+
+	// HDRPrecision is the number of significant decimal digits of the
+	// latencies the HDR histogram keeps, from 1 to 5. Defaults to 3.
+	HDRPrecision int
+
+	// HistogramBuckets is the number of buckets of the response time
+	// histogram, evenly spaced between the fastest and the slowest
+	// request. Defaults to 10. HistogramMarks, if set, are the upper
+	// bounds of the buckets in seconds instead, in increasing order.
+	HistogramBuckets int
+	HistogramMarks   []float64
+
+	// WorkerStats enables a report section on how requests and latencies
+	// were spread across workers, and across connections for results
+	// with a ConnID.
+	WorkerStats bool
+
+	// RateLimited enables the generator lag section of the report.
+	RateLimited bool
+
+	// CorrectionInterval, if set, is the interval at which every worker
+	// was scheduled to start requests. It enables the latency
+	// distribution corrected for coordinated omission.
+	CorrectionInterval time.Duration
+
+	// TargetRate is the requested number of requests per second of the
+	// whole run, if rate limited. It enables the comparison with the
+	// achieved rate.
+	TargetRate float64
+
+	// RedirectChain enables the redirect chain length section of the
+	// report.
+	RedirectChain bool
+
+	// RetryAfter enables the throttling section of the report.
+	RetryAfter bool
+
+	// StreamStats enables the section on the chunk by chunk arrival of
+	// response bodies.
+	StreamStats bool
+
+	// StallThreshold is the time between two chunks from which on a
+	// response counts as stalled in the stream section.
+	StallThreshold time.Duration
+
+	// SecurityHeaders enables the section on how many responses had
+	// each of the SecurityHeaders.
+	SecurityHeaders bool
+
+	// Record, if set, receives every result and event so that the run
+	// can be reported on again later. It is closed by Finalize.
+	Record *RecordWriter
+
+	// Lifecycle, if set, receives every event as a lifecycle record.
+	Lifecycle *Lifecycle
+
+	// SLOs are the objectives the report states how the run fared
+	// against.
+	SLOs []SLO
+
+	// SpoolDir, if set, is the directory the results are spooled to in
+	// memory-mapped files instead of being kept in memory, which also
+	// lifts the limit on the number of results the statistics are
+	// computed from. See CheckSpoolDir.
+	SpoolDir string
+}
+
+// Reporter aggregates Results and prints them in the configured output
+// format. It can be used to report on load generated by other means than
+// the requester package.
+type Reporter struct {
+	avgTotal float64
+	fastest  float64
+	slowest  float64
+	average  float64
+	rps      float64
+
+	avgConn     float64
+	avgDNS      float64
+	avgReq      float64
+	avgRes      float64
+	avgDelay    float64
+	connLats    []float64
+	dnsLats     []float64
+	reqLats     []float64
+	resLats     []float64
+	delayLats   []float64
+	offsets     []float64
+	statusCodes []int
+	sizes       []int64
+	starts      []time.Time
+	workers     []int
+
+	total time.Duration
+
+	errorDist map[string]int
+	protoDist map[string]int
+	grpcDist  map[string]int
+	lats      []float64
+	sizeTotal int64
+	numRes    int64
+	truncated int64
+	output    string
+
+	latencyUnit string
+
+	// digest estimates the latency percentiles from all results if the
+	// t-digest quantile engine is used.
+	digest *tdigest
+
+	// hdr records all latencies if the HDR quantile engine is used.
+	hdr *hdrHistogram
+
+	histogramBuckets int
+	histogramMarks   []float64
+
+	// lags are the generator lags, only collected if rate limited.
+	lags []float64
+
+	// correction is only set if a correction interval is given.
+	correction *correction
+
+	// rateCounts is the number of requests started in each second of
+	// the run, only collected if there is a target rate.
+	targetRate float64
+	rateCounts []int
+
+	// redirectDist counts requests by the length of their redirect chain,
+	// only set if redirect chains are recorded.
+	redirectDist map[int]int
+
+	// throttling is only set if Retry-After is respected.
+	throttling *ThrottleStats
+
+	// generationErrs are the requests that could not be built.
+	generationErrs GenerationStats
+
+	// streams is only set if stream stats are enabled.
+	streams *streamStats
+
+	// security is only set if the security headers are audited.
+	security *securityStats
+
+	custom  customMetrics
+	classes classStats
+	regions classStats
+
+	phases connPhases
+
+	// assertionDist is the number of responses per kind of failed
+	// assertion.
+	assertionDist map[string]int
+
+	// mutations holds the results of malformed requests, which are
+	// kept out of all other stats.
+	mutations mutationStats
+
+	tls TLSStats
+
+	eventsMu sync.Mutex
+	events   []Event
+
+	// snapshot is the report computed after Finalize, guarded by
+	// eventsMu. It is dropped when an event or target sample is added,
+	// which bumps generation.
+	snapshot   *Report
+	generation int
+
+	// targetSamples are the scraped metrics of the target, guarded by
+	// eventsMu.
+	targetSamples []TargetSample
+
+	// workerStats and connCounts are only set if the worker fairness
+	// audit is enabled.
+	workerStats []WorkerStat
+	connCounts  map[uint64]int64
+
+	record    *RecordWriter
+	lifecycle *Lifecycle
+	slos      []SLO
+	recordErr error
+
+	// spool is only set if the results are spooled, in which case the
+	// columns of the results above are allocated from it.
+	spool *spool
+
+	manifest interface{}
+
+	// csvFields and rows, the values of the fields of the results, are
+	// only set for csv output with custom fields.
+	csvFields []string
+	rows      [][]string
+
+	// jsonl is only set for the jsonl output.
+	jsonl    *json.Encoder
+	jsonlErr error
+
+	// aborted is set if the run was aborted before it completed.
+	aborted bool
+
+	// finalized is set by Finalize, after which no results are added
+	// and the snapshot can be kept. It is guarded by eventsMu.
+	finalized bool
+
+	w io.Writer
+}
+
+// New returns a Reporter that writes the report to w.
+func New(w io.Writer, opts Options) *Reporter {
+	cap := min(opts.N, maxRes)
+	r := &Reporter{
+		output:      opts.Output,
+		manifest:    opts.Manifest,
+		latencyUnit: opts.LatencyUnit,
+		csvFields:   opts.CSVFields,
+		errorDist:   make(map[string]int),
+		protoDist:   make(map[string]int),
+		w:           w,
+		connLats:    make([]float64, 0, cap),
+		dnsLats:     make([]float64, 0, cap),
+		reqLats:     make([]float64, 0, cap),
+		resLats:     make([]float64, 0, cap),
+		delayLats:   make([]float64, 0, cap),
+		lats:        make([]float64, 0, cap),
+		statusCodes: make([]int, 0, cap),
+		sizes:       make([]int64, 0, cap),
+		starts:      make([]time.Time, 0, cap),
+		workers:     make([]int, 0, cap),
+	}
+	switch opts.QuantileEngine {
+	case "tdigest":
+		r.digest = newTDigest(opts.TDigestCompression)
+	case "hdr":
+		r.hdr = newHDRHistogram(opts.HDRPrecision)
+	}
+	r.histogramBuckets = opts.HistogramBuckets
+	if r.histogramBuckets <= 0 {
+		r.histogramBuckets = 10
+	}
+	r.histogramMarks = opts.HistogramMarks
+	if opts.WorkerStats {
+		r.workerStats = make([]WorkerStat, 0)
+		r.connCounts = make(map[uint64]int64)
+	}
+	if opts.RateLimited {
+		r.lags = make([]float64, 0, cap)
+	}
+	if opts.CorrectionInterval > 0 {
+		r.correction = newCorrection(opts.CorrectionInterval, opts.TDigestCompression)
+	}
+	if opts.TargetRate > 0 {
+		r.targetRate = opts.TargetRate
+		r.rateCounts = make([]int, 0)
+	}
+	if opts.RedirectChain {
+		r.redirectDist = make(map[int]int)
+	}
+	if opts.RetryAfter {
+		r.throttling = &ThrottleStats{}
+	}
+	if opts.StreamStats {
+		r.streams = &streamStats{threshold: opts.StallThreshold}
+	}
+	if opts.SecurityHeaders {
+		r.security = &securityStats{}
+	}
+	if opts.Output == "jsonl" {
+		r.jsonl = json.NewEncoder(w)
+	}
+	if opts.Record != nil {
+		r.record = opts.Record
+		r.recordErr = r.record.WriteRun(opts)
+	}
+	r.lifecycle = opts.Lifecycle
+	r.slos = opts.SLOs
+	if opts.SpoolDir != "" {
+		s, err := newSpool(opts.SpoolDir)
+		if err != nil {
+			log.Println("error: spooling results:", err.Error())
+		} else {
+			r.spool = s
+			r.free(r.lats, r.connLats, r.dnsLats, r.reqLats, r.resLats, r.delayLats, r.statusCodes, r.sizes, r.starts, r.workers)
+		}
+	}
+	return r
+}
+
+// CheckSpoolDir returns an error if the results cannot be spooled to
+// dir, see Options.SpoolDir.
+func CheckSpoolDir(dir string) error {
+	_, err := newSpool(dir)
+	return err
+}
+
+// free drops the columns of the results kept so far, which the spool
+// then allocates anew.
+func (r *Reporter) free(cols ...interface{}) {
+	r.spool.free(cols...)
+	r.lats, r.connLats, r.dnsLats, r.reqLats, r.resLats, r.delayLats = nil, nil, nil, nil, nil, nil
+	r.offsets, r.statusCodes, r.sizes, r.starts, r.workers = nil, nil, nil, nil, nil
+}
+
+// growSpool moves the columns of the results kept so far to spooled
+// columns with twice the room.
+func (r *Reporter) growSpool() {
+	n := 2 * cap(r.lats)
+	if n == 0 {
+		n = spoolMin
+	}
+	s := r.spool
+	lats := append(s.float64s(n)[:0], r.lats...)
+	connLats := append(s.float64s(n)[:0], r.connLats...)
+	dnsLats := append(s.float64s(n)[:0], r.dnsLats...)
+	reqLats := append(s.float64s(n)[:0], r.reqLats...)
+	resLats := append(s.float64s(n)[:0], r.resLats...)
+	delayLats := append(s.float64s(n)[:0], r.delayLats...)
+	offsets := append(s.float64s(n)[:0], r.offsets...)
+	statusCodes := append(s.ints(n)[:0], r.statusCodes...)
+	sizes := append(s.int64s(n)[:0], r.sizes...)
+	starts := append(s.times(n)[:0], r.starts...)
+	workers := append(s.ints(n)[:0], r.workers...)
+	r.free(r.lats, r.connLats, r.dnsLats, r.reqLats, r.resLats, r.delayLats, r.offsets, r.statusCodes, r.sizes, r.starts, r.workers)
+	r.lats, r.connLats, r.dnsLats, r.reqLats, r.resLats, r.delayLats = lats, connLats, dnsLats, reqLats, resLats, delayLats
+	r.offsets, r.statusCodes, r.sizes, r.starts, r.workers = offsets, statusCodes, sizes, starts, workers
+}
+
+// Run adds all results received from results until the channel is closed.
+func (r *Reporter) Run(results <-chan *Result) {
+	for res := range results {
+		r.Add(res)
+	}
+}
+
+// Add adds a single result to the report. It must not be called
+// concurrently.
+func (r *Reporter) Add(res *Result) {
+	if r.record != nil && r.recordErr == nil {
+		r.recordErr = r.record.WriteResult(res)
+	}
+	if r.jsonl != nil && r.jsonlErr == nil {
+		r.jsonlErr = r.jsonl.Encode(newJSONLResult(res))
+	}
+	if res.Mutation != "" {
+		if r.mutations == nil {
+			r.mutations = make(mutationStats)
+		}
+		r.mutations.add(res)
+		return
+	}
+	r.numRes++
+	if len(r.csvFields) > 0 && len(r.rows) < maxRes {
+		r.rows = append(r.rows, csvRecord(r.csvFields, res))
+	}
+	if r.workerStats != nil {
+		r.workerStats = addWorkerResult(r.workerStats, res)
+		if res.ConnID > 0 {
+			r.connCounts[res.ConnID]++
+		}
+	}
+	if r.lags != nil && len(r.lags) < maxRes {
+		r.lags = append(r.lags, res.Lag.Seconds())
+	}
+	if r.rateCounts != nil {
+		r.rateCounts = addRateCount(r.rateCounts, res.Offset)
+	}
+	if res.Err != nil {
+		r.errorDist[res.Err.Error()]++
+		if res.ErrorClass == ErrorClassGeneration {
+			r.generationErrs.add(res)
+		}
+		if res.Assertion != "" {
+			if r.assertionDist == nil {
+				r.assertionDist = make(map[string]int)
+			}
+			r.assertionDist[res.Assertion]++
+		}
+		return
+	}
+	if res.Proto != "" {
+		r.protoDist[res.Proto]++
+	}
+	if res.GRPCStatus != "" {
+		if r.grpcDist == nil {
+			r.grpcDist = make(map[string]int)
+		}
+		r.grpcDist[res.GRPCStatus]++
+	}
+	if res.Truncated {
+		r.truncated++
+	}
+	if r.redirectDist != nil {
+		r.redirectDist[len(res.Redirects)]++
+	}
+	if r.throttling != nil {
+		r.throttling.add(res)
+	}
+	if r.streams != nil {
+		r.streams.add(res)
+	}
+	if r.security != nil {
+		r.security.add(res)
+	}
+	r.custom.add(res)
+	r.classes.add(res.Class, res)
+	r.regions.add(res.Region, res)
+	r.tls.add(res)
+	r.phases.add(res)
+	if r.digest != nil {
+		r.digest.add(res.Duration.Seconds())
+	}
+	if r.hdr != nil {
+		r.hdr.add(res.Duration.Seconds())
+	}
+	if r.correction != nil {
+		r.correction.add(res.Duration.Seconds())
+	}
+	r.avgTotal += res.Duration.Seconds()
+	r.avgConn += res.ConnDuration.Seconds()
+	r.avgDelay += res.DelayDuration.Seconds()
+	r.avgDNS += res.DNSDuration.Seconds()
+	r.avgReq += res.ReqDuration.Seconds()
+	r.avgRes += res.ResDuration.Seconds()
+	keep := len(r.resLats) < maxRes
+	start := res.Start
+	if r.spool != nil {
+		// The spool grows until it fails, after which the results
+		// are no longer kept.
+		if len(r.lats) == cap(r.lats) && r.spool.err == nil {
+			r.growSpool()
+		}
+		keep = len(r.lats) < cap(r.lats)
+		start = start.UTC()
+	}
+	if keep {
+		r.lats = append(r.lats, res.Duration.Seconds())
+		r.connLats = append(r.connLats, res.ConnDuration.Seconds())
+		r.dnsLats = append(r.dnsLats, res.DNSDuration.Seconds())
+		r.reqLats = append(r.reqLats, res.ReqDuration.Seconds())
+		r.delayLats = append(r.delayLats, res.DelayDuration.Seconds())
+		r.resLats = append(r.resLats, res.ResDuration.Seconds())
+		r.statusCodes = append(r.statusCodes, res.StatusCode)
+		r.sizes = append(r.sizes, res.BytesRead)
+		r.offsets = append(r.offsets, res.Offset.Seconds())
+		r.starts = append(r.starts, start)
+		r.workers = append(r.workers, res.Worker)
+	}
+	if res.ContentLength > 0 {
+		r.sizeTotal += res.ContentLength
+	}
+}
+
+// SetCertGenerations sets the number of handshakes that presented each
+// generation of a reloaded client certificate. It must be called before
+// Finalize.
+func (r *Reporter) SetCertGenerations(gens []CertGeneration) {
+	r.tls.ClientCerts = gens
+}
+
+// AddEvent records a notable occurrence during the run. It is safe for
+// concurrent use.
+func (r *Reporter) AddEvent(e Event) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	if len(r.events) < maxEvents {
+		r.events = append(r.events, e)
+	}
+	r.snapshot = nil
+	r.generation++
+	if r.record != nil {
+		r.record.WriteEvent(e)
+	}
+	if r.lifecycle != nil {
+		r.lifecycle.Emit(LifecycleEvent, e.Name+": "+e.Detail)
+	}
+}
+
+// Finalize computes the statistics of a run that took total and prints
+// the report. All results must have been added before.
+// Abort marks the run as aborted before it completed, e.g. because it
+// was interrupted, which the report then says. If summary is not nil,
+// the summary is printed to it instead of the report in the configured
+// output format. It must be called before Finalize.
+func (r *Reporter) Abort(summary io.Writer) {
+	r.aborted = true
+	if summary != nil {
+		r.w, r.output, r.csvFields = summary, "", nil
+	}
+}
+
+func (r *Reporter) Finalize(total time.Duration) {
+	r.eventsMu.Lock()
+	r.finalized = true
+	r.snapshot = nil
+	r.eventsMu.Unlock()
+	r.total = total
+	r.rps = float64(r.numRes) / r.total.Seconds()
+	r.average = r.avgTotal / float64(len(r.lats))
+	r.avgConn = r.avgConn / float64(len(r.lats))
+	r.avgDelay = r.avgDelay / float64(len(r.lats))
+	r.avgDNS = r.avgDNS / float64(len(r.lats))
+	r.avgReq = r.avgReq / float64(len(r.lats))
+	r.avgRes = r.avgRes / float64(len(r.lats))
+	if r.record != nil {
+		if err := r.record.Close(total, r.aborted); r.recordErr == nil {
+			r.recordErr = err
+		}
+		if r.recordErr != nil {
+			log.Println("error: recording results:", r.recordErr.Error())
+		}
+	}
+	if r.spool != nil && r.spool.err != nil {
+		log.Println("error: spooling results:", r.spool.err.Error())
+	}
+	r.print()
+}
+
+func (r *Reporter) print() {
+	switch r.output {
+	case "json":
+		if err := printJSON(r.w, r.Snapshot(), r.manifest); err != nil {
+			log.Println("error:", err.Error())
+		}
+		return
+	case "html":
+		if err := printHTML(r.w, r.Snapshot()); err != nil {
+			log.Println("error:", err.Error())
+		}
+		return
+	case "jsonl":
+		// The results were written as they were added.
+		if r.jsonlErr != nil {
+			log.Println("error:", r.jsonlErr.Error())
+		}
+		return
+	}
+	if r.output == "csv" && len(r.csvFields) > 0 {
+		if err := printCSV(r.w, r.csvFields, r.rows); err != nil {
+			log.Println("error:", err.Error())
+		}
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := newTemplate(r.output).Execute(buf, r.Snapshot()); err != nil {
+		log.Println("error:", err.Error())
+		return
+	}
+	r.printf(buf.String())
+
+	r.printf("\n")
+}
+
+func (r *Reporter) printf(s string, v ...interface{}) {
+	fmt.Fprintf(r.w, s, v...)
+}
+
+// Snapshot returns the statistics computed by Finalize. After Finalize,
+// they are only computed once, as their columns may be large.
+func (r *Reporter) Snapshot() Report {
+	r.eventsMu.Lock()
+	cached, finalized, generation := r.snapshot, r.finalized, r.generation
+	r.eventsMu.Unlock()
+	if cached != nil {
+		return *cached
+	}
+	s := r.newSnapshot()
+	r.eventsMu.Lock()
+	if finalized && generation == r.generation {
+		r.snapshot = &s
+	}
+	r.eventsMu.Unlock()
+	return s
+}
+
+func (r *Reporter) newSnapshot() Report {
+	snapshot := Report{
+		Aborted:      r.aborted,
+		AvgTotal:     r.avgTotal,
+		Average:      r.average,
+		Rps:          r.rps,
+		SizeTotal:    r.sizeTotal,
+		AvgConn:      r.avgConn,
+		AvgDNS:       r.avgDNS,
+		AvgReq:       r.avgReq,
+		AvgRes:       r.avgRes,
+		AvgDelay:     r.avgDelay,
+		Total:        r.total,
+		ErrorDist:    r.errorDist,
+		ProtoDist:    r.protoDist,
+		GRPCDist:     r.grpcDist,
+		RedirectDist: r.redirectDist,
+		NumRes:       r.numRes,
+		Truncated:    r.truncated,
+		LatencyUnit:  r.latencyUnit,
+		Lats:         r.spool.float64s(len(r.lats)),
+		ConnLats:     r.spool.float64s(len(r.lats)),
+		DnsLats:      r.spool.float64s(len(r.lats)),
+		ReqLats:      r.spool.float64s(len(r.lats)),
+		ResLats:      r.spool.float64s(len(r.lats)),
+		DelayLats:    r.spool.float64s(len(r.lats)),
+		Offsets:      r.spool.float64s(len(r.lats)),
+		StatusCodes:  r.spool.ints(len(r.lats)),
+		Starts:       r.spool.times(len(r.lats)),
+		Workers:      r.spool.ints(len(r.lats)),
+	}
+
+	if snapshot.LatencyUnit == "" || snapshot.LatencyUnit == "auto" {
+		snapshot.LatencyUnit = autoLatencyUnit(r.average)
+	}
+	if r.workerStats != nil {
+		snapshot.WorkerFairness = workerFairness(r.workerStats)
+		snapshot.Connections = connDistribution(r.connCounts)
+	}
+	if len(r.lags) > 0 {
+		snapshot.GeneratorLag = newLagStats(r.lags)
+	}
+	if r.correction != nil {
+		snapshot.CorrectedLatencyDistribution = r.correction.distribution()
+	}
+	if r.targetRate > 0 {
+		snapshot.Rate = newRateStats(r.targetRate, r.numRes, r.total, r.rateCounts)
+	}
+	if r.streams != nil {
+		snapshot.Streams = r.streams.stats()
+	}
+	if r.security != nil {
+		snapshot.Security = r.security.stats()
+	}
+	snapshot.CustomMetrics = r.custom.stats()
+	snapshot.Classes = r.classes.stats()
+	snapshot.Regions = r.regions.regionStats()
+	snapshot.AssertionDist = r.assertionDist
+	snapshot.Mutations = r.mutations.stats()
+	snapshot.TLS = r.tls.stats()
+	r.eventsMu.Lock()
+	snapshot.Events = append([]Event(nil), r.events...)
+	snapshot.TargetMetrics = targetMetrics(r.targetSamples, r.offsets, r.lats)
+	r.eventsMu.Unlock()
+	if g := r.generationErrs; g.Errors > 0 {
+		g.Samples = append([]string(nil), g.Samples...)
+		snapshot.Generation = &g
+	}
+	if r.throttling != nil {
+		t := *r.throttling
+		if r.numRes > 0 {
+			t.Rate = float64(t.Throttled) / float64(r.numRes)
+		}
+		if t.Backoffs > 0 {
+			t.AvgBackoff = t.TotalBackoff / float64(t.Backoffs)
+		}
+		snapshot.Throttling = &t
+	}
+
+	if len(r.lats) == 0 {
+		snapshot.SLOs = snapshot.checkSLOs(r.slos)
+		return snapshot
+	}
+
+	snapshot.SizeReq = r.sizeTotal / int64(len(r.lats))
+	snapshot.Sizes = newSizeStats(r.sizes, r.lats)
+
+	copy(snapshot.Lats, r.lats)
+	copy(snapshot.ConnLats, r.connLats)
+	copy(snapshot.DnsLats, r.dnsLats)
+	copy(snapshot.ReqLats, r.reqLats)
+	copy(snapshot.ResLats, r.resLats)
+	copy(snapshot.DelayLats, r.delayLats)
+	copy(snapshot.StatusCodes, r.statusCodes)
+	copy(snapshot.Offsets, r.offsets)
+	copy(snapshot.Starts, r.starts)
+	copy(snapshot.Workers, r.workers)
+
+	// The columns stay in the order of the results, so that they remain
+	// paired with each other; the statistics use sorted copies.
+	lats := r.sorted(r.lats)
+	connLats := r.sorted(r.connLats)
+	dnsLats := r.sorted(r.dnsLats)
+	reqLats := r.sorted(r.reqLats)
+	resLats := r.sorted(r.resLats)
+	delayLats := r.sorted(r.delayLats)
+	r.fastest = lats[0]
+	r.slowest = lats[len(lats)-1]
+	if r.hdr != nil {
+		r.fastest, r.slowest = r.hdr.min, r.hdr.max
+	}
+
+	snapshot.Histogram = r.histogram(lats)
+	snapshot.LatencyDistribution = r.latencies(lats)
+	snapshot.TailLatencyDistribution = r.tailLatencies(lats)
+
+	snapshot.Fastest = r.fastest
+	snapshot.Slowest = r.slowest
+	snapshot.ConnMin = connLats[0]
+	snapshot.ConnMax = connLats[len(connLats)-1]
+	snapshot.DnsMin = dnsLats[0]
+	snapshot.DnsMax = dnsLats[len(dnsLats)-1]
+	snapshot.ReqMin = reqLats[0]
+	snapshot.ReqMax = reqLats[len(reqLats)-1]
+	snapshot.DelayMin = delayLats[0]
+	snapshot.DelayMax = delayLats[len(delayLats)-1]
+	snapshot.ResMin = resLats[0]
+	snapshot.ResMax = resLats[len(resLats)-1]
+	snapshot.Phases = r.phases.phaseStats(reqLats, delayLats, resLats)
+	r.spool.free(lats, connLats, dnsLats, reqLats, resLats, delayLats)
+
+	statusCodeDist := make(map[int]int, len(snapshot.StatusCodes))
+	for _, statusCode := range snapshot.StatusCodes {
+		statusCodeDist[statusCode]++
+	}
+	snapshot.StatusCodeDist = statusCodeDist
+	snapshot.SLOs = snapshot.checkSLOs(r.slos)
+
+	return snapshot
+}
+
+// sorted returns a sorted copy of the column v.
+func (r *Reporter) sorted(v []float64) []float64 {
+	c := r.spool.float64s(len(v))
+	copy(c, v)
+	sort.Float64s(c)
+	return c
+}
+
+// pctls are the percentiles of the latency distribution.
+var pctls = []int{10, 25, 50, 75, 90, 95, 99}
+
+// latencies returns the latency distribution; lats are the sorted
+// latencies.
+func (r *Reporter) latencies(lats []float64) []LatencyDistribution {
+	data := make([]float64, len(pctls))
+	if r.digest != nil {
+		for i, p := range pctls {
+			data[i] = r.digest.quantile(float64(p) / 100)
+		}
+	} else if r.hdr != nil {
+		for i, p := range pctls {
+			data[i] = r.hdr.quantile(float64(p) / 100)
+		}
+	} else {
+		j := 0
+		for i := 0; i < len(lats) && j < len(pctls); i++ {
+			current := i * 100 / len(lats)
+			if current >= pctls[j] {
+				data[j] = lats[i]
+				j++
+			}
+		}
+	}
+	res := make([]LatencyDistribution, len(pctls))
+	for i := 0; i < len(pctls); i++ {
+		if data[i] > 0 {
+			res[i] = LatencyDistribution{Percentage: pctls[i], Latency: data[i]}
+		}
+	}
+	return res
+}
+
+// tailPctls are the percentiles of the tail latency distribution and
+// the number of results from which on they are reported.
+var tailPctls = []struct {
+	pct float64
+	min int64
+}{{99.9, 1000}, {99.99, 10000}}
+
+// tailLatencies returns the latencies at the tailPctls there are enough
+// results for.
+func (r *Reporter) tailLatencies(lats []float64) []TailLatency {
+	var res []TailLatency
+	for _, p := range tailPctls {
+		q := p.pct / 100
+		var lat float64
+		switch {
+		case r.digest != nil && int64(r.digest.count)+int64(len(r.digest.buf)) >= p.min:
+			lat = r.digest.quantile(q)
+		case r.hdr != nil && r.hdr.total >= p.min:
+			lat = r.hdr.quantile(q)
+		case r.digest == nil && r.hdr == nil && int64(len(lats)) >= p.min:
+			lat = lats[min(len(lats)-1, int(q*float64(len(lats))))]
+		default:
+			continue
+		}
+		res = append(res, TailLatency{Percentile: p.pct, Latency: lat})
+	}
+	return res
+}
+
+func (r *Reporter) histogram(lats []float64) []Bucket {
+	buckets := r.histogramMarks
+	if len(buckets) == 0 {
+		bc := r.histogramBuckets
+		buckets = make([]float64, bc+1)
+		bs := (r.slowest - r.fastest) / float64(bc)
+		for i := 0; i < bc; i++ {
+			buckets[i] = r.fastest + bs*float64(i)
+		}
+		buckets[bc] = r.slowest
+	} else if r.slowest > buckets[len(buckets)-1] {
+		// The last bucket holds the requests slower than the marks.
+		buckets = append(append([]float64(nil), buckets...), r.slowest)
+	}
+	var counts []int
+	total := len(lats)
+	if r.hdr != nil {
+		counts = r.hdr.histogram(buckets)
+		total = int(r.hdr.total)
+	} else {
+		counts = make([]int, len(buckets))
+		var bi int
+		for i := 0; i < len(lats); {
+			if lats[i] <= buckets[bi] {
+				i++
+				counts[bi]++
+			} else if bi < len(buckets)-1 {
+				bi++
+			}
+		}
+	}
+	res := make([]Bucket, len(buckets))
+	for i := 0; i < len(buckets); i++ {
+		res[i] = Bucket{
+			Mark:      buckets[i],
+			Count:     counts[i],
+			Frequency: float64(counts[i]) / float64(total),
+		}
+	}
+	return res
+}
+
+// Report holds the statistics of a run.
+type Report struct {
+	AvgTotal float64
+	Fastest  float64
+	Slowest  float64
+	Average  float64
+	Rps      float64
+
+	AvgConn  float64
+	AvgDNS   float64
+	AvgReq   float64
+	AvgRes   float64
+	AvgDelay float64
+	ConnMax  float64
+	ConnMin  float64
+	DnsMax   float64
+	DnsMin   float64
+	ReqMax   float64
+	ReqMin   float64
+	ResMax   float64
+	ResMin   float64
+	DelayMax float64
+	DelayMin float64
+
+	// TailLatencyDistribution holds the latencies at 99.9% and 99.99%,
+	// if there were at least 1000 and 10000 results.
+	TailLatencyDistribution []TailLatency
+
+	// Phases are the percentiles of the phases of the requests.
+	Phases []PhaseStats
+
+	Lats        []float64
+	ConnLats    []float64
+	DnsLats     []float64
+	ReqLats     []float64
+	ResLats     []float64
+	DelayLats   []float64
+	Offsets     []float64
+	StatusCodes []int
+	Starts      []time.Time
+	Workers     []int
+
+	Total time.Duration
+
+	// Aborted is set if the run was aborted before it completed. The
+	// statistics then cover the requests completed until then.
+	Aborted bool
+
+	ErrorDist      map[string]int
+	StatusCodeDist map[int]int
+
+	// AssertionDist is the number of responses that failed an assertion
+	// per kind of assertion, e.g. "status". They are errors as well.
+	AssertionDist map[string]int
+
+	// ProtoDist is the number of responses per negotiated protocol.
+	ProtoDist map[string]int
+
+	// GRPCDist is the number of responses per gRPC status, only set for
+	// gRPC calls.
+	GRPCDist map[string]int
+
+	// RedirectDist is the number of responses per redirect chain length,
+	// only set if redirect chains are recorded.
+	RedirectDist map[int]int
+	SizeTotal    int64
+	SizeReq      int64
+	NumRes       int64
+
+	// Truncated is the number of responses whose body ended before it
+	// was complete.
+	Truncated int64
+
+	// Sizes is only set if not all response bodies were of the same
+	// size.
+	Sizes *SizeStats
+
+	// LatencyUnit is the unit latencies are shown in by the summary
+	// output, one of "s", "ms" or "us".
+	LatencyUnit string
+
+	LatencyDistribution []LatencyDistribution
+	Histogram           []Bucket
+
+	// CorrectedLatencyDistribution is only set if rate limited requests
+	// are corrected for coordinated omission.
+	CorrectedLatencyDistribution []LatencyDistribution
+
+	// WorkerFairness is only set if the worker fairness audit is enabled.
+	WorkerFairness *WorkerFairness
+
+	// Connections is only set if the worker fairness audit is enabled
+	// and the results have a ConnID.
+	Connections *ConnDistribution
+
+	// GeneratorLag is only set if requests were rate limited.
+	GeneratorLag *LagStats
+
+	// Rate is only set if there was a target rate.
+	Rate *RateStats
+
+	// Throttling is only set if Retry-After is respected.
+	Throttling *ThrottleStats
+
+	// Generation is only set if requests could not be built.
+	Generation *GenerationStats
+
+	// Streams is only set if stream stats are enabled.
+	Streams *StreamStats
+
+	// Security is only set if the security headers were audited.
+	Security *SecurityStats
+
+	// CustomMetrics are the aggregates of the custom metrics of the
+	// results, ordered by name.
+	CustomMetrics []CustomMetricStats
+
+	// Classes are the stats of the priority classes of the requests,
+	// ordered by name.
+	Classes []ClassStats
+
+	// Regions are the stats of the regions the requests were made
+	// from, ordered by name, e.g. of the agents of a distributed run.
+	Regions []RegionStats
+
+	// SLOs are how the run fared against the SLOs of Options.
+	SLOs []SLOResult
+
+	// Mutations are the outcomes of the requests that were malformed on
+	// purpose, which are not part of any other stats.
+	Mutations []MutationStats
+
+	// TLS is only set if there were TLS handshakes.
+	TLS *TLSStats
+
+	// Events are the notable occurrences during the run, in order.
+	Events []Event
+
+	// TargetMetrics are the metrics scraped from the target during the
+	// run, if any, each with the client latency until then.
+	TargetMetrics []TargetMetricsSample
+}
+
+// Event is a notable occurrence during a run, such as a circuit breaker
+// state transition.
+type Event struct {
+	// Offset is the time since the start of the run.
+	Offset time.Duration `json:"offset"`
+
+	// Name identifies the source of the event.
+	Name string `json:"name"`
+
+	// Detail describes what happened.
+	Detail string `json:"detail"`
+}
+
+// ThrottleStats describes how the server throttled the workers.
+// Durations are in seconds.
+type ThrottleStats struct {
+	// Throttled is the number of 429 Too Many Requests and 503 Service
+	// Unavailable responses, the statuses a worker backs off on.
+	Throttled int64 `json:"throttled"`
+
+	// Rate is the fraction of all requests that were throttled.
+	Rate float64 `json:"rate"`
+
+	// Backoffs is the number of times a worker backed off.
+	Backoffs     int64   `json:"backoffs"`
+	AvgBackoff   float64 `json:"avg_backoff"`
+	TotalBackoff float64 `json:"total_backoff"`
+}
+
+func (t *ThrottleStats) add(res *Result) {
+	if res.StatusCode == 429 || res.StatusCode == 503 {
+		t.Throttled++
+	}
+	if res.Backoff > 0 {
+		t.Backoffs++
+		t.TotalBackoff += res.Backoff.Seconds()
+	}
+}
+
+// LagStats describes the distribution of the generator lag, the delay
+// between the time a rate limited request was scheduled and the time a
+// worker actually started it. A high lag means the client, not the
+// server, was the bottleneck. All values are in seconds.
+type LagStats struct {
+	Average float64 `json:"average"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+func newLagStats(lags []float64) *LagStats {
+	sort.Float64s(lags)
+	var total float64
+	for _, l := range lags {
+		total += l
+	}
+	at := func(p int) float64 {
+		return lags[min(len(lags)-1, len(lags)*p/100)]
+	}
+	return &LagStats{
+		Average: total / float64(len(lags)),
+		P50:     at(50),
+		P90:     at(90),
+		P99:     at(99),
+		Max:     lags[len(lags)-1],
+	}
+}
+
+type LatencyDistribution struct {
+	Percentage int
+	Latency    float64
+}
+
+// TailLatency is the latency at a percentile beyond 99%, e.g. 99.9%.
+type TailLatency struct {
+	Percentile float64
+	Latency    float64
+}
+
+type Bucket struct {
+	Mark      float64
+	Count     int
+	Frequency float64
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
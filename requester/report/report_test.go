@@ -0,0 +1,1145 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{N: 3})
+	results := make(chan *Result, 3)
+	results <- &Result{StatusCode: 200, Duration: 10 * time.Millisecond}
+	results <- &Result{StatusCode: 503, Duration: 30 * time.Millisecond}
+	results <- &Result{Err: errors.New("boom")}
+	close(results)
+	r.Run(results)
+	r.Finalize(time.Second)
+
+	s := r.Snapshot()
+	if s.NumRes != 3 {
+		t.Errorf("NumRes = %v; want 3", s.NumRes)
+	}
+	if s.StatusCodeDist[200] != 1 || s.StatusCodeDist[503] != 1 {
+		t.Errorf("StatusCodeDist = %v; want one 200 and one 503", s.StatusCodeDist)
+	}
+	if s.ErrorDist["boom"] != 1 {
+		t.Errorf("ErrorDist = %v; want one boom", s.ErrorDist)
+	}
+	if got, want := s.Average, 0.02; got != want {
+		t.Errorf("Average = %v; want %v", got, want)
+	}
+	if !strings.Contains(buf.String(), "Summary:") {
+		t.Errorf("Summary was not printed: %q", buf.String())
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{context.Canceled, ErrorClassCanceled},
+		{&CancelError{After: time.Millisecond}, ErrorClassCanceled},
+		{&url.Error{Op: "Get", URL: "http://x", Err: context.DeadlineExceeded}, ErrorClassTimeout},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{Err: "no such host", Name: "x"}}, ErrorClassDNS},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrorClassConnection},
+		{x509.UnknownAuthorityError{}, ErrorClassTLS},
+		{errors.New("boom"), ErrorClassOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %q; want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var rec bytes.Buffer
+		rw, err := NewRecordWriter(&rec, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := New(ioutil.Discard, Options{RateLimited: true, Record: rw})
+		r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond, Lag: time.Millisecond})
+		r.Add(&Result{Err: errors.New("boom"), ErrorClass: ErrorClassOther})
+		r.AddEvent(Event{Offset: time.Second, Name: "test", Detail: "happened"})
+		r.Finalize(2 * time.Second)
+
+		got, total, err := Replay(bytes.NewReader(rec.Bytes()), ioutil.Discard, Options{})
+		if err != nil {
+			t.Fatalf("compress=%v: Replay() = %v", compress, err)
+		}
+		if total != 2*time.Second {
+			t.Errorf("total = %v; want 2s", total)
+		}
+		got.Finalize(total)
+		want := r.Snapshot()
+		s := got.Snapshot()
+		if s.NumRes != want.NumRes || s.ErrorDist["boom"] != 1 || s.Average != want.Average {
+			t.Errorf("replayed report = %+v; want %+v", s, want)
+		}
+		if s.GeneratorLag == nil || len(s.Events) != 1 || s.Events[0] != want.Events[0] {
+			t.Errorf("replayed lag %v, events %v", s.GeneratorLag, s.Events)
+		}
+	}
+}
+
+func TestSnapshotPaired(t *testing.T) {
+	r := New(ioutil.Discard, Options{})
+	for i, ms := range []int{5, 1, 3} {
+		r.Add(&Result{StatusCode: 200 + i, Duration: time.Duration(ms) * time.Millisecond, Offset: time.Duration(i) * time.Second})
+	}
+	r.Finalize(3 * time.Second)
+	// Finalize took a snapshot already; later ones must be the same.
+	for i := 0; i < 2; i++ {
+		s := r.Snapshot()
+		if want := []float64{0.005, 0.001, 0.003}; !reflect.DeepEqual(s.Lats, want) {
+			t.Errorf("snapshot %d: lats = %v; want %v", i, s.Lats, want)
+		}
+		if want := []float64{0, 1, 2}; !reflect.DeepEqual(s.Offsets, want) {
+			t.Errorf("snapshot %d: offsets = %v; want %v", i, s.Offsets, want)
+		}
+		if want := []int{200, 201, 202}; !reflect.DeepEqual(s.StatusCodes, want) {
+			t.Errorf("snapshot %d: status codes = %v; want %v", i, s.StatusCodes, want)
+		}
+		if s.Fastest != 0.001 || s.Slowest != 0.005 {
+			t.Errorf("snapshot %d: fastest, slowest = %v, %v; want 0.001, 0.005", i, s.Fastest, s.Slowest)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var recs []io.Reader
+	for i, total := range []time.Duration{time.Second, 2 * time.Second} {
+		var rec bytes.Buffer
+		rw, err := NewRecordWriter(&rec, i == 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := New(ioutil.Discard, Options{Record: rw})
+		r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond})
+		r.Add(&Result{StatusCode: 500, Duration: 30 * time.Millisecond})
+		r.Finalize(total)
+		recs = append(recs, &rec)
+	}
+	got, total, err := Merge(recs, ioutil.Discard, Options{})
+	if err != nil {
+		t.Fatalf("Merge() = %v", err)
+	}
+	if total != 2*time.Second {
+		t.Errorf("total = %v; want 2s", total)
+	}
+	got.Finalize(total)
+	s := got.Snapshot()
+	if s.NumRes != 4 || s.StatusCodeDist[500] != 2 || s.Average != 0.02 {
+		t.Errorf("merged %d results, %d 500s, average %v; want 4, 2, 0.02", s.NumRes, s.StatusCodeDist[500], s.Average)
+	}
+}
+
+func TestMergeRegions(t *testing.T) {
+	var recs []io.Reader
+	for i, region := range []string{"us-east", "eu-west"} {
+		var rec bytes.Buffer
+		rw, err := NewRecordWriter(&rec, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := New(ioutil.Discard, Options{Record: rw})
+		for j := 0; j < 10; j++ {
+			r.Add(&Result{StatusCode: 200, Duration: time.Duration(10*(i+1)) * time.Millisecond, Region: region})
+		}
+		r.Add(&Result{StatusCode: 503, Duration: time.Millisecond, Region: region})
+		r.Finalize(time.Second)
+		recs = append(recs, &rec)
+	}
+	var out bytes.Buffer
+	got, total, err := Merge(recs, &out, Options{})
+	if err != nil {
+		t.Fatalf("Merge() = %v", err)
+	}
+	got.Finalize(total)
+	want := []RegionStats{
+		{Region: "eu-west", Requests: 11, Failures: 1, P50: 0.02, P90: 0.02, P95: 0.02, P99: 0.02},
+		{Region: "us-east", Requests: 11, Failures: 1, P50: 0.01, P90: 0.01, P95: 0.01, P99: 0.01},
+	}
+	if s := got.Snapshot(); !reflect.DeepEqual(s.Regions, want) {
+		t.Errorf("regions = %+v; want %+v", s.Regions, want)
+	}
+	if !strings.Contains(out.String(), "Regions:\n  [eu-west]\t11 requests, 1 failed, 50% in 20.0000 ms") {
+		t.Errorf("summary without the regions:\n%s", out.String())
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey-spool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	heap := New(ioutil.Discard, Options{})
+	spooled := New(ioutil.Discard, Options{SpoolDir: dir})
+	if spooled.spool == nil {
+		t.Skip("spooling is not supported")
+	}
+	// More results than the spool first has room for.
+	for i := 0; i < spoolMin+10; i++ {
+		res := &Result{StatusCode: 200 + i%2, Duration: time.Duration(i%1000) * time.Microsecond, Start: time.Unix(int64(i), 0)}
+		heap.Add(res)
+		spooled.Add(res)
+	}
+	heap.Finalize(time.Second)
+	spooled.Finalize(time.Second)
+	if err := spooled.spool.err; err != nil {
+		t.Fatalf("spooling failed: %v", err)
+	}
+	want, got := heap.Snapshot(), spooled.Snapshot()
+	if !reflect.DeepEqual(got.LatencyDistribution, want.LatencyDistribution) || got.Average != want.Average || got.StatusCodeDist[201] != want.StatusCodeDist[201] {
+		t.Errorf("spooled report = %+v, %v; want %+v, %v", got.LatencyDistribution, got.Average, want.LatencyDistribution, want.Average)
+	}
+	if n := len(got.Lats); n != spoolMin+10 || !got.Starts[n-1].Equal(want.Starts[n-1]) {
+		t.Errorf("spooled %d results, last start %v; want %d, %v", n, got.Starts[n-1], spoolMin+10, want.Starts[n-1])
+	}
+	if len(spooled.spool.maps) == 0 {
+		t.Errorf("no columns are spooled")
+	}
+	// The snapshot is kept, rather than spooled again.
+	maps := len(spooled.spool.maps)
+	spooled.Snapshot()
+	if n := len(spooled.spool.maps); n != maps {
+		t.Errorf("%d spooled columns after another snapshot; want %d", n, maps)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	var out, summary bytes.Buffer
+	var rec bytes.Buffer
+	rw, err := NewRecordWriter(&rec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(&out, Options{Output: "json", Record: rw})
+	r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond})
+	r.Abort(&summary)
+	r.Finalize(time.Second)
+	if out.Len() != 0 {
+		t.Errorf("aborted run wrote its json report: %q", out.String())
+	}
+	if !strings.Contains(summary.String(), "Aborted: the run was interrupted after 1.0000 secs") || !strings.Contains(summary.String(), "Summary:") {
+		t.Errorf("summary = %q; want it marked as aborted", summary.String())
+	}
+
+	got, total, err := Replay(bytes.NewReader(rec.Bytes()), ioutil.Discard, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Finalize(total)
+	if s := got.Snapshot(); !s.Aborted || s.NumRes != 1 {
+		t.Errorf("replayed report aborted %v with %d results; want aborted with 1", s.Aborted, s.NumRes)
+	}
+}
+
+func TestReplayVersion(t *testing.T) {
+	for _, header := range []string{"HEYREC 2 none\n", "HEYREC 1 zstd\n", "hello\n"} {
+		if _, _, err := Replay(strings.NewReader(header), ioutil.Discard, Options{}); err == nil {
+			t.Errorf("Replay(%q) succeeded; want error", header)
+		}
+	}
+}
+
+func TestRateStats(t *testing.T) {
+	var counts []int
+	for i := 0; i < 25; i++ {
+		// 10 requests in each of the first two seconds, 5 in the third.
+		counts = addRateCount(counts, time.Duration(i)*100*time.Millisecond)
+	}
+	s := newRateStats(10, 25, 2500*time.Millisecond, counts)
+	if s.Achieved != 10 || s.Ratio != 1 || !s.Sustained {
+		t.Errorf("achieved %v, ratio %v, sustained %v; want 10, 1, true", s.Achieved, s.Ratio, s.Sustained)
+	}
+	want := []RateWindow{{0, 1, 10}, {1, 2, 10}, {2, 2.5, 10}}
+	if len(s.Windows) != len(want) {
+		t.Fatalf("windows = %v; want %v", s.Windows, want)
+	}
+	for i := range want {
+		if s.Windows[i] != want[i] {
+			t.Errorf("window %d = %v; want %v", i, s.Windows[i], want[i])
+		}
+	}
+	if s := newRateStats(20, 25, 2500*time.Millisecond, counts); s.Sustained {
+		t.Error("half the target rate counted as sustained")
+	}
+}
+
+func TestSizeStats(t *testing.T) {
+	if s := newSizeStats([]int64{10, 10}, []float64{1, 2}); s != nil {
+		t.Errorf("equal sizes: got %+v; want nil", s)
+	}
+	sizes := []int64{100, 200, 2000, 5 << 20}
+	lats := []float64{0.1, 0.3, 0.5, 2}
+	s := newSizeStats(sizes, lats)
+	if s.Min != 100 || s.Max != 5<<20 || s.P50 != 2000 {
+		t.Errorf("min %d, max %d, p50 %d; want 100, %d, 2000", s.Min, s.Max, s.P50, 5<<20)
+	}
+	want := []string{"< 1KB", "1KB-4KB", ">= 4MB"}
+	if len(s.Buckets) != len(want) {
+		t.Fatalf("buckets = %+v; want %v", s.Buckets, want)
+	}
+	for i, b := range s.Buckets {
+		if b.Label() != want[i] {
+			t.Errorf("bucket %d label = %q; want %q", i, b.Label(), want[i])
+		}
+	}
+	if b := s.Buckets[0]; b.Count != 2 || b.AvgLatency != 0.2 {
+		t.Errorf("first bucket = %+v; want 2 responses, 0.2 average", b)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{Output: "json", Manifest: map[string]string{"version": "test"}})
+	for i := 0; i < 100; i++ {
+		r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond})
+	}
+	r.Add(&Result{StatusCode: 503, Duration: 30 * time.Millisecond})
+	r.Add(&Result{Err: errors.New("boom")})
+	r.Finalize(time.Second)
+
+	var got struct {
+		Manifest map[string]string `json:"manifest"`
+		Summary  struct {
+			Requests int64   `json:"requests"`
+			Slowest  float64 `json:"slowest"`
+		} `json:"summary"`
+		Percentiles map[string]float64 `json:"percentiles"`
+		StatusCodes map[string]int     `json:"status_codes"`
+		Errors      map[string]int     `json:"errors"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid json output %q: %v", buf.String(), err)
+	}
+	if got.Manifest["version"] != "test" {
+		t.Errorf("manifest = %v", got.Manifest)
+	}
+	if got.Summary.Requests != 102 || got.Summary.Slowest != 0.03 {
+		t.Errorf("summary = %+v; want 102 requests, 0.03 slowest", got.Summary)
+	}
+	if got.StatusCodes["503"] != 1 || got.Errors["boom"] != 1 {
+		t.Errorf("status codes = %v, errors = %v", got.StatusCodes, got.Errors)
+	}
+	if _, ok := got.Percentiles["p99"]; !ok {
+		t.Errorf("percentiles = %v; want p99", got.Percentiles)
+	}
+}
+
+func TestJSONLOutput(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{Output: "jsonl"})
+	r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond, DelayDuration: 8 * time.Millisecond, Label: "home"})
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("output %q; want a line as soon as the result is added", buf.String())
+	}
+	r.Add(&Result{Err: errors.New("boom"), ErrorClass: ErrorClassOther})
+	r.Finalize(time.Second)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2, without a summary: %q", len(lines), buf.String())
+	}
+	var first, second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if first["status"] != 200.0 || first["latency"] != 0.01 || first["ttfb"] != 0.008 || first["label"] != "home" || first["error"] != nil {
+		t.Errorf("first line = %v", first)
+	}
+	if second["error"] != "boom" || second["error_class"] != ErrorClassOther {
+		t.Errorf("second line = %v", second)
+	}
+}
+
+// TestJSONLFields checks that the jsonl output has every csv field,
+// under the same name.
+func TestJSONLFields(t *testing.T) {
+	res := &Result{
+		Start:             time.Now(),
+		Duration:          time.Second,
+		TLSVerifyDuration: time.Millisecond,
+		OCSPStapled:       true,
+		ECH:               ECHAccepted,
+		StatusCode:        200,
+		Proto:             "HTTP/2.0",
+		GRPCStatus:        "OK",
+		Redirects:         []Redirect{{StatusCode: 302}},
+		Truncated:         true,
+		Worker:            1,
+		Attempt:           2,
+		Label:             "home",
+		Class:             "critical",
+		Err:               errors.New("boom"),
+		ErrorClass:        ErrorClassOther,
+	}
+	var buf bytes.Buffer
+	r := New(&buf, Options{Output: "jsonl"})
+	r.Add(res)
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	for f := range csvColumns {
+		if _, ok := line[f]; !ok {
+			t.Errorf("jsonl lacks the csv field %s: %v", f, line)
+		}
+	}
+	if line["attempt"] != 2.0 || line["class"] != "critical" || line["error_class"] != ErrorClassOther {
+		t.Errorf("line = %v; want attempt 2, class critical and error_class %s", line, ErrorClassOther)
+	}
+}
+
+func TestLatencyUnit(t *testing.T) {
+	tests := []struct {
+		unit    string
+		latency time.Duration
+		want    []string
+	}{
+		{"", 5 * time.Millisecond, []string{"Average:\t5.0000 ms", "Response time histogram (ms):"}},
+		{"auto", 2 * time.Second, []string{"Average:\t2.0000 secs", "Response time histogram (s):"}},
+		{"auto", 50 * time.Microsecond, []string{"Average:\t50.0000 us"}},
+		{"s", 5 * time.Millisecond, []string{"Average:\t0.0050 secs", "Slowest:\t0.0050 secs"}},
+		{"us", 5 * time.Millisecond, []string{"Average:\t5000.0000 us", "  5000.000 [2]"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		r := New(&buf, Options{LatencyUnit: tt.unit})
+		r.Add(&Result{StatusCode: 200, Duration: tt.latency})
+		r.Add(&Result{StatusCode: 200, Duration: tt.latency})
+		r.Finalize(time.Second)
+		for _, want := range tt.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("unit %q, latency %v: summary lacks %q:\n%s", tt.unit, tt.latency, want, buf.String())
+			}
+		}
+	}
+	for _, unit := range []string{"", "auto", "s", "ms", "us"} {
+		if !ValidLatencyUnit(unit) {
+			t.Errorf("ValidLatencyUnit(%q) = false; want true", unit)
+		}
+	}
+	if ValidLatencyUnit("ns") {
+		t.Errorf("ValidLatencyUnit(ns) = true; want false")
+	}
+}
+
+func TestGeneratorLag(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{RateLimited: true, LatencyUnit: "ms"})
+	for i := 1; i <= 100; i++ {
+		r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond, Lag: time.Duration(i) * time.Millisecond})
+	}
+	r.Finalize(time.Second)
+	lag := r.Snapshot().GeneratorLag
+	want := &LagStats{Average: 0.0505, P50: 0.051, P90: 0.091, P99: 0.1, Max: 0.1}
+	if lag == nil || math.Abs(lag.Average-want.Average) > 1e-9 || lag.P50 != want.P50 || lag.P90 != want.P90 || lag.P99 != want.P99 || lag.Max != want.Max {
+		t.Errorf("generator lag = %+v; want %+v", lag, want)
+	}
+	if out := buf.String(); !strings.Contains(out, "Generator lag (scheduled to actual start):\n  Average:\t50.5000 ms") {
+		t.Errorf("summary lacks the generator lag:\n%s", out)
+	}
+
+	buf.Reset()
+	r = New(&buf, Options{})
+	r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond})
+	r.Finalize(time.Second)
+	if lag := r.Snapshot().GeneratorLag; lag != nil || strings.Contains(buf.String(), "Generator lag") {
+		t.Errorf("generator lag = %+v without a rate limit; want none", lag)
+	}
+}
+
+func TestHTMLOutput(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{Output: "html"})
+	for i := 0; i < 10; i++ {
+		r.Add(&Result{StatusCode: 200, Duration: time.Duration(i+1) * time.Millisecond, Offset: time.Duration(i) * time.Millisecond})
+	}
+	r.Add(&Result{Err: errors.New("<script>")})
+	r.Finalize(time.Second)
+
+	out := buf.String()
+	if n := strings.Count(out, "<svg"); n != 3 {
+		t.Errorf("got %d charts; want 3", n)
+	}
+	if strings.Contains(out, "<script>") {
+		t.Error("error message is not escaped")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.Begin()
+	m.Begin()
+	m.End()
+	m.Observe(&Result{StatusCode: 200, Duration: 20 * time.Millisecond})
+	m.Observe(&Result{StatusCode: 200, Duration: 2 * time.Second})
+	m.Observe(&Result{Err: errors.New("boom"), ErrorClass: ErrorClassTimeout})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		"hey_requests_in_flight 1\n",
+		"hey_requests_total{code=\"200\"} 2\n",
+		"hey_errors_total{class=\"timeout\"} 1\n",
+		"hey_request_duration_seconds_bucket{le=\"0.025\"} 1\n",
+		"hey_request_duration_seconds_bucket{le=\"2.5\"} 2\n",
+		"hey_request_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"hey_request_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTLSStats(t *testing.T) {
+	var s TLSStats
+	for _, res := range []*Result{
+		{TLSDuration: time.Millisecond, OCSPStapled: true, ECH: ECHAccepted},
+		{TLSDuration: time.Millisecond, ECH: ECHRejected},
+		{TLSDuration: time.Millisecond, TLSResumed: true, OCSPStapled: true, ECH: ECHAccepted},
+		{},
+	} {
+		s.add(res)
+	}
+	got := s.stats()
+	if got.Handshakes != 3 || got.Resumed != 1 {
+		t.Errorf("handshakes %d, resumed %d; want 3, 1", got.Handshakes, got.Resumed)
+	}
+	if got.Stapled != 1 {
+		t.Errorf("stapled = %d; want 1, resumed handshakes do not count", got.Stapled)
+	}
+	if got.ECHAccepted != 2 || got.ECHRejected != 1 {
+		t.Errorf("ech accepted %d, rejected %d; want 2, 1", got.ECHAccepted, got.ECHRejected)
+	}
+}
+
+func TestGRPCDist(t *testing.T) {
+	for _, output := range []string{"", "json"} {
+		var buf bytes.Buffer
+		r := New(&buf, Options{N: 3, Output: output})
+		r.Add(&Result{StatusCode: 200, Duration: time.Millisecond, GRPCStatus: "OK"})
+		r.Add(&Result{StatusCode: 200, Duration: time.Millisecond, GRPCStatus: "OK"})
+		r.Add(&Result{StatusCode: 200, Duration: time.Millisecond, GRPCStatus: "UNAVAILABLE"})
+		r.Finalize(time.Second)
+		if got, want := r.Snapshot().GRPCDist, map[string]int{"OK": 2, "UNAVAILABLE": 1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("GRPCDist = %v; want %v", got, want)
+		}
+		if output == "json" {
+			var j struct {
+				GRPCStatuses map[string]int `json:"grpc_statuses"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &j); err != nil || j.GRPCStatuses["UNAVAILABLE"] != 1 {
+				t.Errorf("json grpc_statuses = %v, %v; want 1 UNAVAILABLE", j.GRPCStatuses, err)
+			}
+			continue
+		}
+		want := "gRPC status distribution:\n  [OK]\t2 responses\n  [UNAVAILABLE]\t1 responses"
+		if out := buf.String(); !strings.Contains(out, want) {
+			t.Errorf("summary lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCorrection(t *testing.T) {
+	r := New(ioutil.Discard, Options{N: 100, CorrectionInterval: 10 * time.Millisecond})
+	for i := 0; i < 99; i++ {
+		r.Add(&Result{Duration: time.Millisecond})
+	}
+	// A stall of a second hides the 98 requests that were due meanwhile.
+	r.Add(&Result{Duration: time.Second})
+	r.Finalize(2 * time.Second)
+	s := r.Snapshot()
+	at := func(dist []LatencyDistribution, p int) float64 {
+		for _, d := range dist {
+			if d.Percentage == p {
+				return d.Latency
+			}
+		}
+		return -1
+	}
+	if got := at(s.LatencyDistribution, 75); got != 0.001 {
+		t.Errorf("p75 = %v; want 0.001", got)
+	}
+	if got := at(s.CorrectedLatencyDistribution, 75); got < 0.2 || got > 0.8 {
+		t.Errorf("corrected p75 = %v; want about 0.5", got)
+	}
+	if got := at(s.CorrectedLatencyDistribution, 10); got > 0.002 {
+		t.Errorf("corrected p10 = %v; want about 0.001", got)
+	}
+
+	// Beyond maxCorrections the added samples are weighted.
+	c := newCorrection(time.Millisecond, 0)
+	c.add(10)
+	c.digest.merge()
+	if got := c.digest.count; math.Abs(got-10000) > 1 {
+		t.Errorf("weight of the samples = %v; want 10000", got)
+	}
+}
+
+func TestSecurityStats(t *testing.T) {
+	h := http.Header{}
+	h.Set("Strict-Transport-Security", "max-age=31536000")
+	h.Set("X-Frame-Options", "DENY")
+	r := New(ioutil.Discard, Options{SecurityHeaders: true})
+	r.Add(&Result{StatusCode: 200, SecurityHeaders: SecurityHeaderMask(h)})
+	r.Add(&Result{StatusCode: 200, SecurityHeaders: SecurityHeaderMask(http.Header{})})
+	r.Add(&Result{Err: errors.New("boom")})
+	r.Finalize(time.Second)
+
+	s := r.Snapshot().Security
+	if s == nil || s.Responses != 2 {
+		t.Fatalf("security stats = %+v; want 2 responses, errors do not count", s)
+	}
+	got := make(map[string]float64)
+	for _, h := range s.Headers {
+		got[h.Name] = h.Rate
+	}
+	if got["Strict-Transport-Security"] != 0.5 || got["X-Frame-Options"] != 0.5 || got["Content-Security-Policy"] != 0 {
+		t.Errorf("rates = %v; want HSTS and X-Frame-Options in half of the responses", got)
+	}
+}
+
+func TestCustomMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{})
+	r.Add(&Result{StatusCode: 201, Metrics: []CustomMetric{{Name: "orders", Value: 1}, {Name: "payment", Timer: true, Value: 0.2}}})
+	r.Add(&Result{StatusCode: 201, Metrics: []CustomMetric{{Name: "orders", Value: 2}, {Name: "payment", Timer: true, Value: 0.4}}})
+	r.Add(&Result{StatusCode: 500})
+	r.Finalize(time.Second)
+
+	s := r.Snapshot().CustomMetrics
+	if len(s) != 2 || s[0].Name != "orders" || s[1].Name != "payment" {
+		t.Fatalf("custom metrics = %+v; want orders and payment", s)
+	}
+	if s[0].Count != 2 || s[0].Sum != 3 || s[0].Timing != nil {
+		t.Errorf("orders = %+v; want a counter of 3", s[0])
+	}
+	if s[1].Count != 2 || s[1].Timing == nil || math.Abs(s[1].Timing.Average-0.3) > 1e-9 || s[1].Timing.Max != 0.4 {
+		t.Errorf("payment = %+v; want a timer averaging 0.3s", s[1])
+	}
+	if !strings.Contains(buf.String(), "Custom metrics:") {
+		t.Errorf("custom metrics were not printed: %q", buf.String())
+	}
+}
+
+func TestWorkerFairness(t *testing.T) {
+	r := New(ioutil.Discard, Options{WorkerStats: true, Output: "json"})
+	// Worker 0 makes 4 requests, worker 1 makes 2 and worker 2 only
+	// fails, twice, so none of its latencies count.
+	for i := 0; i < 4; i++ {
+		r.Add(&Result{StatusCode: 200, Duration: 10 * time.Millisecond, Worker: 0})
+	}
+	r.Add(&Result{StatusCode: 200, Duration: 20 * time.Millisecond, Worker: 1})
+	r.Add(&Result{StatusCode: 200, Duration: 40 * time.Millisecond, Worker: 1})
+	r.Add(&Result{Err: errors.New("boom"), Duration: time.Second, Worker: 2})
+	r.Add(&Result{Err: errors.New("boom"), Duration: time.Second, Worker: 2})
+	r.Finalize(time.Second)
+
+	f := r.Snapshot().WorkerFairness
+	if f == nil || f.Workers != 3 || f.MinRequests != 2 || f.MaxRequests != 4 {
+		t.Fatalf("fairness = %+v; want 3 workers with 2 to 4 requests", f)
+	}
+	if math.Abs(f.MeanRequests-8.0/3) > 1e-9 || math.Abs(f.StddevRequests-math.Sqrt(8.0/9)) > 1e-9 {
+		t.Errorf("mean %v, stddev %v; want 8/3 and sqrt(8/9)", f.MeanRequests, f.StddevRequests)
+	}
+	if math.Abs(f.MinAvgLatency-0.01) > 1e-9 || math.Abs(f.MaxAvgLatency-0.03) > 1e-9 {
+		t.Errorf("avg latencies %v to %v; want 0.01 to 0.03, without the worker that only failed", f.MinAvgLatency, f.MaxAvgLatency)
+	}
+	var starved []int
+	for _, s := range f.Starved {
+		starved = append(starved, s.Worker)
+	}
+	if want := []int{1, 2, 0}; !reflect.DeepEqual(starved, want) {
+		t.Errorf("starved = %v; want %v, by requests", starved, want)
+	}
+	if f.Starved[1].Errors != 2 || f.Starved[1].AvgLatency != 0 {
+		t.Errorf("worker 2 = %+v; want 2 errors and no latency", f.Starved[1])
+	}
+
+	// Only errors: no latencies, and a summary that still encodes.
+	r = New(ioutil.Discard, Options{WorkerStats: true})
+	r.Add(&Result{Err: errors.New("boom"), Worker: 0})
+	r.Finalize(time.Second)
+	f = r.Snapshot().WorkerFairness
+	if f.MinAvgLatency != 0 || f.MaxAvgLatency != 0 {
+		t.Errorf("avg latencies %v to %v with only errors; want 0", f.MinAvgLatency, f.MaxAvgLatency)
+	}
+	if _, err := json.Marshal(f); err != nil {
+		t.Errorf("encoding the fairness: %v", err)
+	}
+
+	// Many workers: the five with the fewest requests are starved.
+	stats := make([]WorkerStat, 8)
+	for i := range stats {
+		stats[i] = WorkerStat{Worker: i, Requests: int64(8 - i)}
+	}
+	if f := workerFairness(stats); len(f.Starved) != 5 || f.Starved[0].Worker != 7 || f.Starved[4].Worker != 3 {
+		t.Errorf("starved = %+v; want workers 7 to 3", f.Starved)
+	}
+	if workerFairness(nil) != nil {
+		t.Errorf("fairness of no workers; want nil")
+	}
+}
+
+func TestConnDistribution(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{WorkerStats: true})
+	for i := 0; i < 6; i++ {
+		r.Add(&Result{StatusCode: 200, ConnID: 1})
+	}
+	r.Add(&Result{StatusCode: 200, ConnID: 2})
+	r.Add(&Result{StatusCode: 200, ConnID: 3})
+	r.Add(&Result{StatusCode: 200, ConnID: 3})
+	r.Add(&Result{Err: errors.New("boom")})
+	r.Finalize(time.Second)
+
+	d := r.Snapshot().Connections
+	if d == nil || d.Connections != 3 || d.MinRequests != 1 || d.MaxRequests != 6 || d.MaxShare != 6.0/9 {
+		t.Fatalf("connections = %+v; want 3 with 1 to 6 requests", d)
+	}
+	want := []ConnBucket{{1, 1, 1}, {2, 3, 1}, {4, 7, 1}}
+	if !reflect.DeepEqual(d.Histogram, want) {
+		t.Errorf("histogram = %+v; want %+v", d.Histogram, want)
+	}
+	if !strings.Contains(buf.String(), "[4-7]	1 connections") {
+		t.Errorf("histogram was not printed: %q", buf.String())
+	}
+}
+
+func TestPhaseStats(t *testing.T) {
+	r := New(ioutil.Discard, Options{})
+	for i := 1; i <= 10; i++ {
+		d := time.Duration(i) * time.Millisecond
+		res := &Result{StatusCode: 200, Duration: 10 * d, ReqDuration: d, DelayDuration: 2 * d, ResDuration: 3 * d}
+		if i <= 2 {
+			// New connections, the others are reused.
+			res.DNSDuration, res.TLSDuration, res.ConnDuration = d, 2*d, 4*d
+		}
+		r.Add(res)
+	}
+	r.Finalize(time.Second)
+
+	s := r.Snapshot()
+	want := map[string][2]float64{
+		"dns":     {2, 0.002},
+		"connect": {2, 0.002},
+		"tls":     {2, 0.004},
+		"write":   {10, 0.01},
+		"ttfb":    {10, 0.02},
+		"read":    {10, 0.03},
+	}
+	if len(s.Phases) != len(want) {
+		t.Fatalf("phases = %+v; want %d", s.Phases, len(want))
+	}
+	for _, p := range s.Phases {
+		if w := want[p.Phase]; float64(p.Count) != w[0] || math.Abs(p.Max-w[1]) > 1e-9 {
+			t.Errorf("%s: %d requests, max %v; want %v", p.Phase, p.Count, p.Max, w)
+		}
+	}
+	if s.ConnMin != 0 || s.ConnMax != 0.008 || s.ReqMin != 0.001 || s.ReqMax != 0.01 {
+		t.Errorf("details: conn %v to %v, write %v to %v; want fastest below slowest", s.ConnMin, s.ConnMax, s.ReqMin, s.ReqMax)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf, time.Hour, false)
+	p.Start()
+	for i := 1; i <= 100; i++ {
+		p.Observe(&Result{Duration: time.Duration(i) * time.Millisecond, StatusCode: 200})
+	}
+	p.Observe(&Result{Err: errors.New("refused")})
+	now := p.start.Add(2 * time.Second)
+	if got, want := p.line(now), "[2s] 101 requests, 50.5 requests/sec, p95 96.0000 ms, 1 errors"; got != want {
+		t.Errorf("line = %q; want %q", got, want)
+	}
+	// The rate and p95 are those since the previous line.
+	p.Observe(&Result{Duration: time.Second, StatusCode: 200})
+	if got, want := p.line(now.Add(time.Second)), "[3s] 102 requests, 1.0 requests/sec, p95 1.0000 secs, 1 errors"; got != want {
+		t.Errorf("line = %q; want %q", got, want)
+	}
+	p.Stop()
+	if buf.Len() != 0 {
+		t.Errorf("printed %q before the first interval", buf.String())
+	}
+}
+
+func TestThrottleStats(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{RetryAfter: true})
+	for _, code := range []int{200, 429, 503, 500} {
+		r.Add(&Result{StatusCode: code, Duration: time.Millisecond})
+	}
+	r.Add(&Result{StatusCode: 200, Duration: time.Millisecond, Backoff: 2 * time.Second})
+	r.Finalize(time.Second)
+
+	got := r.Snapshot().Throttling
+	want := &ThrottleStats{Throttled: 2, Rate: 0.4, Backoffs: 1, AvgBackoff: 2, TotalBackoff: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("throttling = %+v; want %+v", got, want)
+	}
+	if !strings.Contains(buf.String(), "429 and 503 responses:\t2 (40.00%)") {
+		t.Errorf("summary = %q; want 2 throttled responses", buf.String())
+	}
+}
+
+func TestGenerationStats(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, Options{})
+	r.Add(&Result{StatusCode: 200, Duration: time.Millisecond})
+	for i := 0; i < 8; i++ {
+		r.Add(&Result{Err: fmt.Errorf("template: data row %d has no value for {{.id}}", i%7), ErrorClass: ErrorClassGeneration})
+	}
+	r.Add(&Result{Err: errors.New(`template: invalid value "100%\n" of header X`), ErrorClass: ErrorClassGeneration})
+	r.Add(&Result{Err: errors.New("refused"), ErrorClass: ErrorClassConnection})
+	r.Finalize(time.Second)
+
+	g := r.Snapshot().Generation
+	if g == nil || g.Errors != 9 || len(g.Samples) != maxGenerationSamples || g.Samples[4] != "template: data row 4 has no value for {{.id}}" {
+		t.Fatalf("generation errors = %+v; want 9 with the first 5 distinct as samples", g)
+	}
+	if !strings.Contains(buf.String(), "Requests not built:\t9\n  e.g.\ttemplate: data row 0 has no value for {{.id}}\n") {
+		t.Errorf("summary = %q; want the generation errors with samples", buf.String())
+	}
+
+	buf.Reset()
+	r = New(&buf, Options{})
+	r.Add(&Result{Err: errors.New(`template: invalid value "100%\n" of header X`), ErrorClass: ErrorClassGeneration})
+	r.Finalize(time.Second)
+	if !strings.Contains(buf.String(), `e.g.	template: invalid value "100%\n" of header X`) {
+		t.Errorf("summary = %q; want the sample with its percent sign", buf.String())
+	}
+}
+
+func TestMergeTicks(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewJSONProgress(&buf, time.Hour)
+	p.Start()
+	for i := 1; i <= 1000; i++ {
+		p.Observe(&Result{Duration: time.Duration(i) * time.Millisecond, StatusCode: 200})
+	}
+	p.print(p.start.Add(time.Second))
+	p.Stop()
+	var slow Tick
+	if err := json.Unmarshal(buf.Bytes(), &slow); err != nil {
+		t.Fatalf("printed %q: %v", buf.String(), err)
+	}
+	if slow.Requests != 1000 || slow.Count != 1000 || len(slow.Latencies) != 100 {
+		t.Errorf("tick = %+v; want 1000 requests and 100 latencies", slow)
+	}
+
+	// A fast agent that made as many requests as the slow one.
+	fast := Tick{Elapsed: 1, Requests: 1001, Errors: 1, Rate: 1001, Count: 1000}
+	for i := 0; i < 50; i++ {
+		fast.Latencies = append(fast.Latencies, 0.001)
+	}
+	m := MergeTicks([]Tick{slow, fast})
+	if m.Requests != 2001 || m.Errors != 1 || m.Rate != 2001 || m.Count != 2000 || len(m.Latencies) != 100 {
+		t.Errorf("merged tick = %+v; want the sums and 100 latencies", m)
+	}
+	// Half of the requests took 1ms, the p95 is the p90 of the slow ones.
+	if got, want := m.Line(), "[1s] 2001 requests, 2001.0 requests/sec, p95 906.0000 ms, 1 errors"; got != want {
+		t.Errorf("line = %q; want %q", got, want)
+	}
+}
+
+func TestTargetMetrics(t *testing.T) {
+	var rec bytes.Buffer
+	rw, err := NewRecordWriter(&rec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(ioutil.Discard, Options{Record: rw})
+	// Two responses complete in the first second, one in the second.
+	for _, res := range []struct{ offset, latency time.Duration }{
+		{0, 100 * time.Millisecond},
+		{500 * time.Millisecond, 300 * time.Millisecond},
+		{900 * time.Millisecond, 600 * time.Millisecond},
+	} {
+		r.Add(&Result{StatusCode: 200, Offset: res.offset, Duration: res.latency})
+	}
+	r.AddTargetSample(TargetSample{Offset: 2 * time.Second, Values: map[string]float64{"cpu": 0.5}})
+	r.AddTargetSample(TargetSample{Offset: time.Second, Values: map[string]float64{"cpu": 0.9}})
+	r.Finalize(2 * time.Second)
+
+	want := []TargetMetricsSample{
+		{Offset: 1, Rps: 2, P95: 0.3, Values: map[string]float64{"cpu": 0.9}},
+		{Offset: 2, Rps: 1, P95: 0.6, Values: map[string]float64{"cpu": 0.5}},
+	}
+	if got := r.Snapshot().TargetMetrics; !reflect.DeepEqual(got, want) {
+		t.Errorf("target metrics = %+v; want %+v", got, want)
+	}
+	got, total, err := Replay(bytes.NewReader(rec.Bytes()), ioutil.Discard, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Finalize(total)
+	if s := got.Snapshot(); !reflect.DeepEqual(s.TargetMetrics, want) {
+		t.Errorf("replayed target metrics = %+v; want %+v", s.TargetMetrics, want)
+	}
+}
+
+// decodeMessage decodes the fields of a protocol buffer message into
+// varints, doubles and strings by field number.
+func decodeMessage(t *testing.T, b []byte) map[int]interface{} {
+	fields := make(map[int]interface{})
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			fields[int(key>>3)], b = v, b[n:]
+		case wireFixed64:
+			fields[int(key>>3)], b = math.Float64frombits(binary.LittleEndian.Uint64(b)), b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			fields[int(key>>3)], b = string(b[n:n+int(l)]), b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func TestCollector(t *testing.T) {
+	var mu sync.Mutex
+	var got []map[int]interface{}
+	status := "0"
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != collectorMethod || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("call %s with %q; want %s with application/grpc", r.URL.Path, r.Header.Get("Content-Type"), collectorMethod)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		for {
+			var prefix [5]byte
+			if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+				break
+			}
+			msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+			io.ReadFull(r.Body, msg)
+			mu.Lock()
+			got = append(got, decodeMessage(t, msg))
+			mu.Unlock()
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", status)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "no%20space")
+		w.Write([]byte{0, 0, 0, 0, 0})
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go new(http2.Server).ServeConn(conn, &http2.ServeConnOpts{Handler: http.HandlerFunc(handler)})
+		}
+	}()
+
+	c, err := DialCollector("grpc://"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Observe(&Result{Offset: time.Second, Duration: 20 * time.Millisecond, StatusCode: 200, ContentLength: -1, Label: "home", Attempt: 1})
+	c.Observe(&Result{Err: errors.New("boom"), ErrorClass: ErrorClassOther, Worker: 3, Attempt: 1})
+	if dropped, err := c.Close(); dropped != 0 || err != nil {
+		t.Fatalf("Close() = %d, %v; want no drops and no error", dropped, err)
+	}
+	want := []map[int]interface{}{
+		{1: c.RunID, 3: 1.0, 4: 0.02, 11: uint64(200), 13: uint64(math.MaxUint64), 18: "home", 20: uint64(1)},
+		{1: c.RunID, 15: "boom", 16: ErrorClassOther, 19: uint64(3), 20: uint64(1)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed %v; want %v", got, want)
+	}
+
+	status = "8"
+	c, err = DialCollector("grpc://"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Close(); err == nil || !strings.Contains(err.Error(), "status 8: no space") {
+		t.Errorf("Close() = %v; want the status of the collector", err)
+	}
+	if _, err := DialCollector("http://"+l.Addr().String(), nil); err == nil {
+		t.Errorf("DialCollector of an http URL succeeded; want an error")
+	}
+}
+
+func TestCollectorTLS(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Write([]byte{0, 0, 0, 0, 0})
+	}))
+	http2.ConfigureServer(s.Config, nil)
+	s.TLS = s.Config.TLSConfig
+	s.TLS.ClientAuth = tls.RequireAnyClientCert
+	s.StartTLS()
+	defer s.Close()
+	target := "grpcs://" + s.Listener.Addr().String()
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+
+	for _, tt := range []struct {
+		conf *tls.Config
+		ok   bool
+	}{
+		{conf: nil},
+		{conf: &tls.Config{RootCAs: roots}},
+		{conf: &tls.Config{RootCAs: roots, Certificates: s.TLS.Certificates}, ok: true},
+	} {
+		c, err := DialCollector(target, tt.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Observe(&Result{StatusCode: 200, Attempt: 1})
+		if _, err := c.Close(); (err == nil) != tt.ok {
+			t.Errorf("Close() with %+v = %v; want ok %v", tt.conf, err, tt.ok)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	var recs []LifecycleRecord
+	l := NewLifecycle("run1", Limits{P95: 50 * time.Millisecond, ErrorRate: 0.5}, func(rec LifecycleRecord) {
+		mu.Lock()
+		recs = append(recs, rec)
+		mu.Unlock()
+	})
+	l.Emit(LifecycleRunStarted, "http://example.com")
+	l.Phase("run")
+	for i := 0; i < 200; i++ {
+		l.Observe(&Result{StatusCode: 200, Duration: 100 * time.Millisecond})
+	}
+	l.check()
+	l.check() // A breach is only reported once.
+	r := New(ioutil.Discard, Options{Lifecycle: l})
+	r.AddEvent(Event{Name: "breaker", Detail: "open"})
+	l.Finish(LifecycleSummary{Requests: 200})
+
+	var got []string
+	for _, rec := range recs {
+		if rec.RunID != "run1" || rec.Time.IsZero() {
+			t.Errorf("record %+v lacks the run ID or time", rec)
+		}
+		got = append(got, rec.Type+" "+rec.Phase+rec.Detail)
+	}
+	want := []string{
+		"run_started http://example.com",
+		"phase_changed run",
+		"threshold_breached p95 latency 0.1000 secs exceeds 50ms",
+		"event breaker: open",
+		"run_finished ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+	if s := recs[len(recs)-1].Summary; s == nil || s.Requests != 200 {
+		t.Errorf("summary = %+v, want 200 requests", s)
+	}
+}
+
+func TestClassStats(t *testing.T) {
+	var c classStats
+	add := func(res *Result) {
+		c.add(res.Class, res)
+	}
+	for i := 0; i < 100; i++ {
+		add(&Result{Class: "critical", StatusCode: 200, Duration: time.Duration(i+1) * time.Millisecond})
+	}
+	add(&Result{Class: "critical", StatusCode: 503, Duration: time.Second})
+	add(&Result{Class: "best-effort", Err: errors.New("timeout")})
+	add(&Result{StatusCode: 200})
+	got := c.stats()
+	want := []ClassStats{
+		{Class: "best-effort", Requests: 1, Failures: 1},
+		{Class: "critical", Requests: 101, Failures: 1, P50: 0.051, P90: 0.091, P95: 0.096, P99: 0.1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v; want %+v", got, want)
+	}
+}
+
+func TestCheckSLOs(t *testing.T) {
+	r := Report{
+		NumRes:              200,
+		Lats:                []float64{0.1},
+		LatencyDistribution: []LatencyDistribution{{Percentage: 99, Latency: 0.25}},
+		ErrorDist:           map[string]int{"timeout": 1},
+		StatusCodeDist:      map[int]int{200: 197, 503: 2},
+		Classes:             []ClassStats{{Class: "critical", Requests: 100, P95: 0.2}},
+	}
+	got := r.checkSLOs([]SLO{
+		{Name: "p99", Metric: "p99", Target: 0.3},
+		{Name: "errors", Metric: "error_rate", Target: 0.01},
+		{Name: "critical", Class: "critical", Metric: "p95", Target: 0.1},
+		{Name: "batch", Class: "batch", Metric: "p95", Target: 1},
+	})
+	for i, want := range []struct {
+		actual float64
+		pass   bool
+		noData bool
+	}{{0.25, true, false}, {0.015, false, false}, {0.2, false, false}, {0, false, true}} {
+		res := got[i]
+		if math.Abs(res.Actual-want.actual) > 1e-9 || res.Pass != want.pass || res.NoData != want.noData || math.Abs(res.Margin-(res.Target-res.Actual)) > 1e-9 {
+			t.Errorf("SLO %s = %+v; want actual %v, pass %v, no data %v", res.Name, res, want.actual, want.pass, want.noData)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Result is the outcome of a single request. It is the unit every output
+// format is built from. Fields are only ever added to Result, never
+// removed or changed in meaning.
+type Result struct {
+	// Start is the wall clock time the request was started.
+	Start time.Time
+
+	// Offset is the time since the start of the run when the request
+	// was started.
+	Offset time.Duration
+
+	// Duration is the total time taken by the request, from start until
+	// the response body was read.
+	Duration time.Duration
+
+	// ConnDuration is the time taken to set up a connection, including
+	// the DNS lookup, dialing and the TLS handshake. It is zero if an
+	// existing connection was reused.
+	ConnDuration time.Duration
+
+	// DNSDuration is the time taken by the DNS lookup.
+	DNSDuration time.Duration
+
+	// TLSDuration is the time taken by the TLS handshake.
+	TLSDuration time.Duration
+
+	// TLSResumed is set if the TLS handshake resumed an earlier session.
+	TLSResumed bool
+
+	// OCSPStapled is set if the server stapled an OCSP response to the
+	// TLS handshake.
+	OCSPStapled bool
+
+	// TLSVerifyDuration is the time taken to verify the certificate
+	// chain served in a full TLS handshake, if measured.
+	TLSVerifyDuration time.Duration
+
+	// ECH is the outcome of an Encrypted Client Hello offered in the
+	// TLS handshake, ECHAccepted or ECHRejected, or empty if none was
+	// offered.
+	ECH string
+
+	// ReqDuration is the time taken to write the request.
+	ReqDuration time.Duration
+
+	// DelayDuration is the time between the request being written and
+	// the first byte of the response, the time to first byte.
+	DelayDuration time.Duration
+
+	// ResDuration is the time taken to read the response.
+	ResDuration time.Duration
+
+	// StatusCode is the HTTP status code of the response, 0 if no
+	// response was received.
+	StatusCode int
+
+	// Proto is the protocol of the response as negotiated with the
+	// server, e.g. "HTTP/1.1" or "HTTP/2.0".
+	Proto string
+
+	// GRPCStatus is the name of the gRPC status of the call, e.g. OK or
+	// UNAVAILABLE, or its code if it has no name. Only set for gRPC calls
+	// that got a response.
+	GRPCStatus string
+
+	// ContentLength is the length of the response body as announced by
+	// the server, -1 if unknown.
+	ContentLength int64
+
+	// BytesRead is the number of response body bytes actually read.
+	BytesRead int64
+
+	// Truncated is set if the response body ended before it was
+	// complete, e.g. it was shorter than its Content-Length or a chunked
+	// body was cut off. Truncated responses are not counted as errors.
+	Truncated bool
+
+	// Err is the error the request failed with, nil on success.
+	Err error
+
+	// ErrorClass is the class of Err, see ClassifyError. Empty on success.
+	ErrorClass string
+
+	// SecurityHeaders is the set of SecurityHeaders the response had,
+	// see SecurityHeaderMask. Only set if the headers are audited.
+	SecurityHeaders uint
+
+	// Metrics are the custom metrics recorded for the result.
+	Metrics []CustomMetric
+
+	// Assertion is the kind of the assertion the response failed, if it
+	// failed one, see ExpectationError.
+	Assertion string
+
+	// Mutation is the way the request was malformed on purpose, if it
+	// was. Mutated results are reported separately from the others.
+	Mutation string
+
+	// Label identifies the request definition the result belongs to.
+	// It is empty if all requests are alike.
+	Label string
+
+	// Class is the priority class of the request definition, e.g.
+	// critical or best-effort, if it has one. Classes are reported on
+	// separately.
+	Class string
+
+	// Region is the region the request was made from, e.g. that of the
+	// agent of a distributed run, if it has one. Regions are reported
+	// on separately.
+	Region string
+
+	// Attempt is the number of the attempt the result belongs to,
+	// starting at 1.
+	Attempt int
+
+	// Worker is the index of the worker that made the request.
+	Worker int
+
+	// ConnID identifies the connection the request was made on, if
+	// requests per connection are tracked. Connections are numbered
+	// from 1, 0 means unknown.
+	ConnID uint64
+
+	// Lag is the delay between the time the request was scheduled and
+	// the time it was started, if requests are rate limited.
+	Lag time.Duration
+
+	// Backoff is the time the worker waited after the request because
+	// the server throttled it, if Retry-After is respected.
+	Backoff time.Duration
+
+	// Redirects are the redirect hops that were followed before the
+	// final response, if redirect chains are recorded.
+	Redirects []Redirect
+
+	// ChunkGaps are the times between consecutive chunks of the response
+	// body, the first one measured from the end of the headers, if
+	// stream stats are enabled.
+	ChunkGaps []time.Duration
+}
+
+// Redirect is a single hop of a redirect chain.
+type Redirect struct {
+	// URL is the URL that responded with the redirect.
+	URL string
+
+	// StatusCode is the status code of the redirect response.
+	StatusCode int
+
+	// Duration is the time taken by the hop.
+	Duration time.Duration
+}
+
+// Error classes returned by ClassifyError.
+const (
+	ErrorClassTimeout    = "timeout"
+	ErrorClassCanceled   = "canceled"
+	ErrorClassDNS        = "dns"
+	ErrorClassTLS        = "tls"
+	ErrorClassConnection = "connection"
+	ErrorClassOther      = "other"
+
+	// ErrorClassGeneration is used for requests that could not be
+	// built, e.g. because signing them failed.
+	ErrorClassGeneration = "generation"
+
+	// ErrorClassCircuitOpen is used for requests that were not sent
+	// because a client side circuit breaker was open.
+	ErrorClassCircuitOpen = "circuit_open"
+
+	// ErrorClassDecompression is used for responses whose body could
+	// not be decoded as its Content-Encoding claims.
+	ErrorClassDecompression = "decompression"
+
+	// ErrorClassExpectation is used for responses that did not meet an
+	// expectation of the run, e.g. lacked an expected header.
+	ErrorClassExpectation = "expectation"
+)
+
+// Outcomes of an Encrypted Client Hello, see Result.ECH.
+const (
+	ECHAccepted = "accepted"
+	ECHRejected = "rejected"
+)
+
+// Kinds of assertions a response can fail, see ExpectationError.
+const (
+	AssertionStatus       = "status"
+	AssertionHeader       = "header"
+	AssertionBodyContains = "body-contains"
+	AssertionBodyRegex    = "body-regex"
+)
+
+// ExpectationError is the error of a response that did not meet an
+// expectation of the run.
+type ExpectationError struct {
+	// Assertion is the kind of the expectation, e.g. AssertionStatus.
+	Assertion string
+	Reason    string
+}
+
+func (e *ExpectationError) Error() string {
+	return "expectation failed: " + e.Reason
+}
+
+// CancelError is the error of a request the client canceled on purpose
+// while it was in flight. It is of the class ErrorClassCanceled.
+type CancelError struct {
+	After time.Duration
+}
+
+func (e *CancelError) Error() string {
+	return fmt.Sprintf("canceled on purpose after %v", e.After)
+}
+
+// Is reports whether target is context.Canceled.
+func (e *CancelError) Is(target error) bool {
+	return target == context.Canceled
+}
+
+// DecompressionError is the error of a response whose body could not be
+// decoded as its Content-Encoding claims.
+type DecompressionError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *DecompressionError) Error() string {
+	return "decompressing " + e.Encoding + " body: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the decoder.
+func (e *DecompressionError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError returns the class of a request error, or an empty string
+// if err is nil.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var (
+		netErr      net.Error
+		dnsErr      *net.DNSError
+		recordErr   tls.RecordHeaderError
+		authErr     x509.UnknownAuthorityError
+		certErr     x509.CertificateInvalidError
+		hostnameErr x509.HostnameError
+		opErr       *net.OpError
+		decompErr   *DecompressionError
+		expectErr   *ExpectationError
+	)
+	switch {
+	case errors.As(err, &decompErr):
+		return ErrorClassDecompression
+	case errors.As(err, &expectErr):
+		return ErrorClassExpectation
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &recordErr), errors.As(err, &authErr),
+		errors.As(err, &certErr), errors.As(err, &hostnameErr):
+		return ErrorClassTLS
+	case errors.As(err, &opErr):
+		return ErrorClassConnection
+	default:
+		return ErrorClassOther
+	}
+}
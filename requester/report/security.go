@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "net/http"
+
+// SecurityHeaders are the response headers the security header audit
+// looks for.
+var SecurityHeaders = []string{
+	"Strict-Transport-Security",
+	"Content-Security-Policy",
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+	"Referrer-Policy",
+	"Permissions-Policy",
+}
+
+// SecurityHeaderMask returns the SecurityHeaders present in h as a bit
+// mask, bit i for SecurityHeaders[i], for Result.SecurityHeaders.
+func SecurityHeaderMask(h http.Header) uint {
+	var mask uint
+	for i, name := range SecurityHeaders {
+		if h.Get(name) != "" {
+			mask |= 1 << uint(i)
+		}
+	}
+	return mask
+}
+
+// SecurityStats describes how many responses had each of the
+// SecurityHeaders.
+type SecurityStats struct {
+	// Responses is the number of audited responses.
+	Responses int64 `json:"responses"`
+
+	Headers []SecurityHeaderStat `json:"headers"`
+}
+
+// SecurityHeaderStat is the number of responses that had a header.
+type SecurityHeaderStat struct {
+	Name    string  `json:"name"`
+	Present int64   `json:"present"`
+	Rate    float64 `json:"rate"`
+}
+
+// securityStats counts the security headers of the responses.
+type securityStats struct {
+	responses int64
+	present   []int64
+}
+
+func (s *securityStats) add(res *Result) {
+	if res.Err != nil || res.StatusCode == 0 {
+		return
+	}
+	if s.present == nil {
+		s.present = make([]int64, len(SecurityHeaders))
+	}
+	s.responses++
+	for i := range SecurityHeaders {
+		if res.SecurityHeaders&(1<<uint(i)) != 0 {
+			s.present[i]++
+		}
+	}
+}
+
+func (s *securityStats) stats() *SecurityStats {
+	if s.responses == 0 {
+		return nil
+	}
+	st := &SecurityStats{Responses: s.responses}
+	for i, name := range SecurityHeaders {
+		st.Headers = append(st.Headers, SecurityHeaderStat{
+			Name:    name,
+			Present: s.present[i],
+			Rate:    float64(s.present[i]) / float64(s.responses),
+		})
+	}
+	return st
+}
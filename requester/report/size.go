@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+)
+
+// sizeBucketBounds are the upper bounds, exclusive, of the response size
+// buckets. The last bucket is unbounded.
+var sizeBucketBounds = []int64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// SizeStats describes the distribution of the response body sizes, in
+// bytes, and the latency of responses by size.
+type SizeStats struct {
+	Min  int64   `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  int64   `json:"p50"`
+	P90  int64   `json:"p90"`
+	P99  int64   `json:"p99"`
+	Max  int64   `json:"max"`
+
+	// Buckets holds the responses by size, only non-empty buckets are
+	// included.
+	Buckets []SizeBucket `json:"buckets"`
+}
+
+// SizeBucket holds the responses whose body size is in [Lower, Upper).
+// Upper is zero for the last, unbounded bucket. Latencies are in seconds.
+type SizeBucket struct {
+	Lower     int64   `json:"lower"`
+	Upper     int64   `json:"upper"`
+	Count     int     `json:"count"`
+	Frequency float64 `json:"frequency"`
+
+	AvgLatency float64 `json:"avg_latency"`
+	P50Latency float64 `json:"p50_latency"`
+	P99Latency float64 `json:"p99_latency"`
+}
+
+// Label returns a short description of the size range of the bucket.
+func (b SizeBucket) Label() string {
+	switch {
+	case b.Upper == 0:
+		return ">= " + formatBytes(b.Lower)
+	case b.Lower == 0:
+		return "< " + formatBytes(b.Upper)
+	}
+	return formatBytes(b.Lower) + "-" + formatBytes(b.Upper)
+}
+
+// newSizeStats computes the size statistics of the results whose body
+// sizes and latencies are given at the same index. It returns nil if all
+// responses were of the same size.
+func newSizeStats(sizes []int64, lats []float64) *SizeStats {
+	if len(sizes) == 0 {
+		return nil
+	}
+	sorted := append([]int64(nil), sizes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	if sorted[0] == sorted[len(sorted)-1] {
+		return nil
+	}
+	var total float64
+	for _, s := range sorted {
+		total += float64(s)
+	}
+	at := func(p int) int64 {
+		return sorted[min(len(sorted)-1, len(sorted)*p/100)]
+	}
+	st := &SizeStats{
+		Min:  sorted[0],
+		Mean: total / float64(len(sorted)),
+		P50:  at(50),
+		P90:  at(90),
+		P99:  at(99),
+		Max:  sorted[len(sorted)-1],
+	}
+
+	bucketLats := make([][]float64, len(sizeBucketBounds)+1)
+	for i, s := range sizes {
+		b := sort.Search(len(sizeBucketBounds), func(j int) bool { return s < sizeBucketBounds[j] })
+		bucketLats[b] = append(bucketLats[b], lats[i])
+	}
+	for i, bl := range bucketLats {
+		if len(bl) == 0 {
+			continue
+		}
+		b := SizeBucket{
+			Count:     len(bl),
+			Frequency: float64(len(bl)) / float64(len(sizes)),
+		}
+		if i > 0 {
+			b.Lower = sizeBucketBounds[i-1]
+		}
+		if i < len(sizeBucketBounds) {
+			b.Upper = sizeBucketBounds[i]
+		}
+		l := newLagStats(bl)
+		b.AvgLatency, b.P50Latency, b.P99Latency = l.Average, l.P50, l.P99
+		st.Buckets = append(st.Buckets, b)
+	}
+	return st
+}
+
+// formatBytes formats a byte count using the largest binary unit in
+// which it is at least 1, e.g. 1536 as "1.5KB".
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	v, u := float64(n), 0
+	for v >= 1024 && u < len(units)-1 {
+		v /= 1024
+		u++
+	}
+	if u == 0 {
+		return fmt.Sprintf("%d%s", n, units[u])
+	}
+	return fmt.Sprintf("%.3g%s", v, units[u])
+}
@@ -0,0 +1,131 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "fmt"
+
+// SLO is a published service level objective of the service under
+// test that the run is checked against, e.g. a p99 latency of 300ms
+// for the requests of the checkout class.
+type SLO struct {
+	Name string `json:"name"`
+
+	// Class, if set, limits the SLO to the requests of the class, see
+	// Result.Class.
+	Class string `json:"class,omitempty"`
+
+	// Metric is one of the SLOMetrics.
+	Metric string `json:"metric"`
+
+	// Target is the highest value that meets the SLO: a latency in
+	// seconds or the share of requests that fail or get a 5xx
+	// response.
+	Target float64 `json:"target"`
+}
+
+// SLOMetrics are the metrics an SLO can set a target for.
+var SLOMetrics = []string{"p50", "p90", "p95", "p99", "error_rate"}
+
+// SLOResult is how the run fared against an SLO.
+type SLOResult struct {
+	SLO
+
+	// Actual is the value of the metric in the run.
+	Actual float64 `json:"actual"`
+
+	// Margin is the target minus the actual value: the headroom left,
+	// or how far the SLO was missed if negative.
+	Margin float64 `json:"margin"`
+
+	// Pass is set if the run met the SLO. It is not if there were no
+	// requests to measure it on.
+	Pass bool `json:"pass"`
+
+	// NoData is set if there were no requests to measure the SLO on.
+	NoData bool `json:"no_data,omitempty"`
+}
+
+// Percentile returns the latency at the given percentile of the latency
+// distribution of r in seconds, or the slowest latency if r has too few
+// results for it.
+func (r Report) Percentile(pct int) float64 {
+	for _, d := range r.LatencyDistribution {
+		if d.Percentage == pct {
+			return d.Latency
+		}
+	}
+	return r.Slowest
+}
+
+// Failures returns the number of requests of r that failed or got a 5xx
+// response.
+func (r Report) Failures() int {
+	failed := 0
+	for _, n := range r.ErrorDist {
+		failed += n
+	}
+	for code, n := range r.StatusCodeDist {
+		if code >= 500 {
+			failed += n
+		}
+	}
+	return failed
+}
+
+// checkSLOs returns how the run of r fared against slos.
+func (r Report) checkSLOs(slos []SLO) []SLOResult {
+	var results []SLOResult
+	for _, slo := range slos {
+		res := SLOResult{SLO: slo}
+		if slo.Class == "" {
+			switch {
+			case r.NumRes == 0:
+				res.NoData = true
+			case slo.Metric == "error_rate":
+				res.Actual = float64(r.Failures()) / float64(r.NumRes)
+			case len(r.Lats) == 0:
+				res.NoData = true
+			default:
+				var pct int
+				fmt.Sscanf(slo.Metric, "p%d", &pct)
+				res.Actual = r.Percentile(pct)
+			}
+		} else {
+			res.NoData = true
+			for _, c := range r.Classes {
+				if c.Class != slo.Class {
+					continue
+				}
+				res.NoData = false
+				switch slo.Metric {
+				case "p50":
+					res.Actual = c.P50
+				case "p90":
+					res.Actual = c.P90
+				case "p95":
+					res.Actual = c.P95
+				case "p99":
+					res.Actual = c.P99
+				case "error_rate":
+					res.Actual = float64(c.Failures) / float64(c.Requests)
+				}
+			}
+		}
+		res.Margin = slo.Target - res.Actual
+		res.Pass = !res.NoData && res.Margin >= 0
+		results = append(results, res)
+	}
+	return results
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// spoolMin is the number of results the spooled columns first have
+// room for.
+const spoolMin = 1 << 16
+
+var errSpoolUnsupported = errors.New("spooling is not supported on this platform")
+
+// spool allocates the per-result columns of a Reporter in memory-mapped
+// temporary files instead of the heap, so that the kernel can page the
+// results of runs too large for the memory out to disk. The files are
+// removed as soon as they are mapped. A nil spool allocates on the heap.
+//
+// The first error is kept in err; after it, and for a nil spool, the
+// columns are allocated on the heap.
+type spool struct {
+	dir  string
+	maps map[uintptr][]byte
+	err  error
+}
+
+func newSpool(dir string) (*spool, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	if _, err := mmap(nil, 0); err == errSpoolUnsupported {
+		return nil, err
+	}
+	return &spool{dir: dir, maps: make(map[uintptr][]byte)}, nil
+}
+
+// alloc returns n zeroed bytes in a new memory-mapped file.
+func (s *spool) alloc(n int) []byte {
+	if s == nil || s.err != nil || n == 0 {
+		return nil
+	}
+	f, err := ioutil.TempFile(s.dir, "hey-spool")
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if err := f.Truncate(int64(n)); err != nil {
+		s.err = err
+		return nil
+	}
+	b, err := mmap(f, n)
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	s.maps[uintptr(unsafe.Pointer(&b[0]))] = b
+	return b
+}
+
+// free unmaps the spooled columns in cols, which must not be used
+// afterwards. Columns on the heap are left to the garbage collector.
+func (s *spool) free(cols ...interface{}) {
+	if s == nil {
+		return
+	}
+	for _, c := range cols {
+		p := reflect.ValueOf(c).Pointer()
+		if b, ok := s.maps[p]; ok {
+			munmap(b)
+			delete(s.maps, p)
+		}
+	}
+}
+
+func (s *spool) float64s(n int) []float64 {
+	if b := s.alloc(n * 8); b != nil {
+		return float64View(b, n)
+	}
+	return make([]float64, n)
+}
+
+func (s *spool) int64s(n int) []int64 {
+	if b := s.alloc(n * 8); b != nil {
+		return int64View(b, n)
+	}
+	return make([]int64, n)
+}
+
+func (s *spool) ints(n int) []int {
+	if b := s.alloc(n * int(unsafe.Sizeof(0))); b != nil {
+		return intView(b, n)
+	}
+	return make([]int, n)
+}
+
+// times may only hold times in UTC, which have no pointer to their
+// location the garbage collector would need to see.
+func (s *spool) times(n int) []time.Time {
+	if b := s.alloc(n * int(unsafe.Sizeof(time.Time{}))); b != nil {
+		return timeView(b, n)
+	}
+	return make([]time.Time, n)
+}
@@ -0,0 +1,42 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.17
+// +build go1.17
+
+package report
+
+import (
+	"time"
+	"unsafe"
+)
+
+// The views return the n elements at the start of b, which must be
+// large enough to hold them.
+
+func float64View(b []byte, n int) []float64 {
+	return unsafe.Slice((*float64)(unsafe.Pointer(&b[0])), n)
+}
+
+func int64View(b []byte, n int) []int64 {
+	return unsafe.Slice((*int64)(unsafe.Pointer(&b[0])), n)
+}
+
+func intView(b []byte, n int) []int {
+	return unsafe.Slice((*int)(unsafe.Pointer(&b[0])), n)
+}
+
+func timeView(b []byte, n int) []time.Time {
+	return unsafe.Slice((*time.Time)(unsafe.Pointer(&b[0])), n)
+}
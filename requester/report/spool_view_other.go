@@ -0,0 +1,55 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !go1.17
+// +build !go1.17
+
+package report
+
+import (
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// view points the slice header at p to the n elements at the start
+// of b.
+func view(p unsafe.Pointer, b []byte, n int) {
+	h := (*reflect.SliceHeader)(p)
+	h.Data, h.Len, h.Cap = uintptr(unsafe.Pointer(&b[0])), n, n
+}
+
+func float64View(b []byte, n int) []float64 {
+	var v []float64
+	view(unsafe.Pointer(&v), b, n)
+	return v
+}
+
+func int64View(b []byte, n int) []int64 {
+	var v []int64
+	view(unsafe.Pointer(&v), b, n)
+	return v
+}
+
+func intView(b []byte, n int) []int {
+	var v []int
+	view(unsafe.Pointer(&v), b, n)
+	return v
+}
+
+func timeView(b []byte, n int) []time.Time {
+	var v []time.Time
+	view(unsafe.Pointer(&v), b, n)
+	return v
+}
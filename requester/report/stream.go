@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import "time"
+
+// StreamStats describes how response bodies arrived, chunk by chunk.
+// Durations are in seconds.
+type StreamStats struct {
+	// Streams is the number of responses with a body.
+	Streams int64 `json:"streams"`
+
+	// Chunks is the number of chunks received over all responses.
+	Chunks int64 `json:"chunks"`
+
+	// Stalled is the number of responses in which the time between two
+	// chunks reached the stall threshold.
+	Stalled int64 `json:"stalled"`
+
+	// StallThreshold is the time between two chunks from which on a
+	// response counts as stalled.
+	StallThreshold float64 `json:"stall_threshold"`
+
+	// Distribution of the time between consecutive chunks.
+	Average float64 `json:"average"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+// streamStats aggregates the chunk gaps of all results.
+type streamStats struct {
+	streams   int64
+	chunks    int64
+	stalled   int64
+	threshold time.Duration
+	gaps      []float64
+}
+
+func (s *streamStats) add(res *Result) {
+	if len(res.ChunkGaps) == 0 {
+		return
+	}
+	s.streams++
+	s.chunks += int64(len(res.ChunkGaps))
+	stalled := false
+	for _, g := range res.ChunkGaps {
+		if s.threshold > 0 && g >= s.threshold {
+			stalled = true
+		}
+		if len(s.gaps) < maxRes {
+			s.gaps = append(s.gaps, g.Seconds())
+		}
+	}
+	if stalled {
+		s.stalled++
+	}
+}
+
+func (s *streamStats) stats() *StreamStats {
+	st := &StreamStats{
+		Streams:        s.streams,
+		Chunks:         s.chunks,
+		Stalled:        s.stalled,
+		StallThreshold: s.threshold.Seconds(),
+	}
+	if len(s.gaps) > 0 {
+		l := newLagStats(s.gaps)
+		st.Average, st.P50, st.P90, st.P99, st.Max = l.Average, l.P50, l.P90, l.P99, l.Max
+	}
+	return st
+}
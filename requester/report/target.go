@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"sort"
+	"time"
+)
+
+// maxTargetSamples caps the number of target samples kept for the report.
+const maxTargetSamples = 10000
+
+// TargetSample holds the values of the metrics of the system under test
+// scraped at a point of the run.
+type TargetSample struct {
+	// Offset is the time since the start of the run.
+	Offset time.Duration `json:"offset"`
+
+	// Values maps series, e.g. `queue_depth{queue="orders"}`, to their
+	// values.
+	Values map[string]float64 `json:"values"`
+}
+
+// TargetMetricsSample is a TargetSample aligned with the latency the
+// client saw since the previous sample. Durations are in seconds.
+type TargetMetricsSample struct {
+	Offset float64 `json:"offset"`
+
+	// Rps is the rate of successful responses and P95 their 95th
+	// percentile latency since the previous sample.
+	Rps float64 `json:"rps"`
+	P95 float64 `json:"p95"`
+
+	Values map[string]float64 `json:"values"`
+}
+
+// AddTargetSample records the metrics of the target scraped during the
+// run. It is safe for concurrent use.
+func (r *Reporter) AddTargetSample(s TargetSample) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	if len(r.targetSamples) < maxTargetSamples {
+		r.targetSamples = append(r.targetSamples, s)
+	}
+	r.snapshot = nil
+	r.generation++
+	if r.record != nil {
+		r.record.WriteTargetSample(s)
+	}
+}
+
+// targetMetrics aligns the target samples with the successful responses
+// at the given offsets, in seconds since the start of the run, and
+// latencies.
+func targetMetrics(samples []TargetSample, offsets, lats []float64) []TargetMetricsSample {
+	if len(samples) == 0 {
+		return nil
+	}
+	samples = append([]TargetSample(nil), samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Offset < samples[j].Offset })
+	ends := make([]float64, len(samples))
+	for i, s := range samples {
+		ends[i] = s.Offset.Seconds()
+	}
+	// windows[i] are the latencies of the responses that completed
+	// after sample i-1 and until sample i.
+	windows := make([][]float64, len(samples))
+	for i := range offsets {
+		done := offsets[i] + lats[i]
+		if w := sort.SearchFloat64s(ends, done); w < len(samples) {
+			windows[w] = append(windows[w], lats[i])
+		}
+	}
+	res := make([]TargetMetricsSample, len(samples))
+	var start float64
+	for i, s := range samples {
+		res[i] = TargetMetricsSample{Offset: ends[i], Values: s.Values}
+		if w := windows[i]; len(w) > 0 {
+			sort.Float64s(w)
+			if d := ends[i] - start; d > 0 {
+				res[i].Rps = float64(len(w)) / d
+			}
+			res[i].P95 = w[min(len(w)-1, len(w)*95/100)]
+		}
+		start = ends[i]
+	}
+	return res
+}
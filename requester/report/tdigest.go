@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultTDigestCompression is the compression used for the t-digest
+// quantile engine if none is provided.
+const DefaultTDigestCompression = 100
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tdigest is a merging t-digest, a streaming estimator of quantiles that
+// uses memory bounded by its compression and is most accurate at the
+// tails of the distribution. See Dunning & Ertl, "Computing Extremely
+// Accurate Quantiles Using t-Digests".
+type tdigest struct {
+	compression float64
+	centroids   []centroid
+	buf         []centroid
+	count       float64
+	min, max    float64
+}
+
+func newTDigest(compression float64) *tdigest {
+	if compression <= 0 {
+		compression = DefaultTDigestCompression
+	}
+	return &tdigest{
+		compression: compression,
+		buf:         make([]centroid, 0, int(compression)*5),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// add adds a sample to the digest.
+func (t *tdigest) add(x float64) {
+	t.addWeighted(x, 1)
+}
+
+// addWeighted adds a sample that counts w times to the digest.
+func (t *tdigest) addWeighted(x, w float64) {
+	t.buf = append(t.buf, centroid{mean: x, weight: w})
+	if x < t.min {
+		t.min = x
+	}
+	if x > t.max {
+		t.max = x
+	}
+	if len(t.buf) == cap(t.buf) {
+		t.merge()
+	}
+}
+
+// k is the scale function limiting the size of the centroids, so that
+// centroids near the tails stay small.
+func (t *tdigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (t *tdigest) merge() {
+	if len(t.buf) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buf...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	for _, c := range t.buf {
+		t.count += c.weight
+	}
+	t.buf = t.buf[:0]
+
+	merged := make([]centroid, 0, len(t.centroids)+1)
+	cur := all[0]
+	var soFar float64
+	for _, c := range all[1:] {
+		q0 := soFar / t.count
+		q2 := (soFar + cur.weight + c.weight) / t.count
+		if t.k(q2)-t.k(q0) <= 1 {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		soFar += cur.weight
+		merged = append(merged, cur)
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+}
+
+// quantile returns the estimated value at quantile q, 0 <= q <= 1.
+func (t *tdigest) quantile(q float64) float64 {
+	t.merge()
+	if len(t.centroids) == 0 {
+		return 0
+	}
+	if len(t.centroids) == 1 || q <= 0 {
+		if q >= 1 {
+			return t.max
+		}
+		return t.centroids[0].mean
+	}
+	target := q * t.count
+	// Each centroid is centered at the middle of its weight.
+	first := t.centroids[0]
+	if target < first.weight/2 {
+		return t.min + (first.mean-t.min)*target/(first.weight/2)
+	}
+	var cum float64
+	for i := 0; i < len(t.centroids)-1; i++ {
+		a, b := t.centroids[i], t.centroids[i+1]
+		left := cum + a.weight/2
+		right := cum + a.weight + b.weight/2
+		if target <= right {
+			return a.mean + (b.mean-a.mean)*(target-left)/(right-left)
+		}
+		cum += a.weight
+	}
+	last := t.centroids[len(t.centroids)-1]
+	left := t.count - last.weight/2
+	if target >= t.count {
+		return t.max
+	}
+	return last.mean + (t.max-last.mean)*(target-left)/(t.count-left)
+}
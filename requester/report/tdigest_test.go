@@ -0,0 +1,48 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestTDigestQuantiles(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	d := newTDigest(100)
+	var samples []float64
+	for i := 0; i < 100000; i++ {
+		// Latencies are usually skewed with a long tail.
+		v := rnd.ExpFloat64() / 100
+		samples = append(samples, v)
+		d.add(v)
+	}
+	sort.Float64s(samples)
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		want := samples[int(q*float64(len(samples)))]
+		got := d.quantile(q)
+		if math.Abs(got-want)/want > 0.05 {
+			t.Errorf("quantile(%v) = %v; want %v", q, got, want)
+		}
+	}
+	if got, want := d.quantile(1), samples[len(samples)-1]; got != want {
+		t.Errorf("quantile(1) = %v; want %v", got, want)
+	}
+	if len(d.centroids) > 500 {
+		t.Errorf("digest has %v centroids; want a bounded number", len(d.centroids))
+	}
+}
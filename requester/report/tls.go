@@ -0,0 +1,106 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// TLSStats describes the TLS handshakes of a run, one per new
+// connection. Resumed handshakes skip the certificate exchange and are
+// faster, which skews handshake latency comparisons between runs.
+// Durations are in seconds.
+type TLSStats struct {
+	Handshakes int64 `json:"handshakes"`
+	Resumed    int64 `json:"resumed"`
+
+	// AvgFull and AvgResumed are the average durations of the full and
+	// the resumed handshakes.
+	AvgFull    float64 `json:"avg_full"`
+	AvgResumed float64 `json:"avg_resumed"`
+
+	// Stapled is the number of full handshakes in which the server
+	// stapled an OCSP response.
+	Stapled int64 `json:"ocsp_stapled"`
+
+	// Verified is the number of certificate chains whose verification
+	// was timed, AvgVerify and MaxVerify the average and the longest
+	// verification time.
+	Verified  int64   `json:"verified,omitempty"`
+	AvgVerify float64 `json:"avg_verify,omitempty"`
+	MaxVerify float64 `json:"max_verify,omitempty"`
+
+	// ECHAccepted and ECHRejected count the handshakes in which the
+	// server accepted or rejected an Encrypted Client Hello.
+	ECHAccepted int64 `json:"ech_accepted,omitempty"`
+	ECHRejected int64 `json:"ech_rejected,omitempty"`
+
+	// ClientCerts is the number of handshakes that presented each
+	// generation of a client certificate that is reloaded when it
+	// changes, the first generation first.
+	ClientCerts []CertGeneration `json:"client_certs,omitempty"`
+
+	fullTotal    float64
+	resumedTotal float64
+	verifyTotal  float64
+}
+
+// CertGeneration is a generation of a reloaded client certificate.
+type CertGeneration struct {
+	// Generation numbers the loaded certificates from 1.
+	Generation int   `json:"generation"`
+	Handshakes int64 `json:"handshakes"`
+}
+
+func (t *TLSStats) add(res *Result) {
+	if res.TLSDuration <= 0 {
+		return
+	}
+	t.Handshakes++
+	if res.TLSResumed {
+		t.Resumed++
+		t.resumedTotal += res.TLSDuration.Seconds()
+	} else {
+		t.fullTotal += res.TLSDuration.Seconds()
+		if res.OCSPStapled {
+			t.Stapled++
+		}
+	}
+	switch res.ECH {
+	case ECHAccepted:
+		t.ECHAccepted++
+	case ECHRejected:
+		t.ECHRejected++
+	}
+	if v := res.TLSVerifyDuration.Seconds(); v > 0 {
+		t.Verified++
+		t.verifyTotal += v
+		if v > t.MaxVerify {
+			t.MaxVerify = v
+		}
+	}
+}
+
+func (t TLSStats) stats() *TLSStats {
+	if t.Handshakes == 0 {
+		return nil
+	}
+	if full := t.Handshakes - t.Resumed; full > 0 {
+		t.AvgFull = t.fullTotal / float64(full)
+	}
+	if t.Resumed > 0 {
+		t.AvgResumed = t.resumedTotal / float64(t.Resumed)
+	}
+	if t.Verified > 0 {
+		t.AvgVerify = t.verifyTotal / float64(t.Verified)
+	}
+	return &t
+}
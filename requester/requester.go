@@ -17,16 +17,27 @@ package requester
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptrace"
 	"net/url"
 	"os"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rakyll/hey/requester/report"
 	"golang.org/x/net/http2"
 )
 
@@ -34,18 +45,8 @@ import (
 const maxResult = 1000000
 const maxIdleConn = 500
 
-type result struct {
-	err           error
-	statusCode    int
-	offset        time.Duration
-	duration      time.Duration
-	connDuration  time.Duration // connection setup(DNS lookup + Dial up) duration
-	dnsDuration   time.Duration // dns lookup duration
-	reqDuration   time.Duration // request "write" duration
-	resDuration   time.Duration // response "read" duration
-	delayDuration time.Duration // delay between response and request
-	contentLength int64
-}
+// Result is the outcome of a single request. See report.Result.
+type Result = report.Result
 
 type Work struct {
 	// Request is the request to be made.
@@ -53,47 +54,386 @@ type Work struct {
 
 	RequestBody []byte
 
+	// Sign, if set, is called with every request and its body and
+	// returns headers to set on it, e.g. a signature. A request fails
+	// without being sent if Sign returns an error.
+	Sign func(req *http.Request, body []byte) (http.Header, error)
+
+	// Groups, if set, are made in turn instead of Request. Request is
+	// set to the request of the first group if nil.
+	Groups []*RequestGroup
+
+	// WebSocket is an option to benchmark a WebSocket endpoint, a ws or
+	// wss Request URL, instead of making HTTP requests. Every worker
+	// opens a WebSocket, sends RequestBody as a message and records the
+	// time until the next message of the server as the duration.
+	WebSocket bool
+
+	// IsolateGroups is an option to give every group its own workers
+	// instead of sharing all workers between the groups, so that a slow
+	// group cannot starve the others. The C workers are assigned to the
+	// groups as set by their C, or in turn.
+	IsolateGroups bool
+
+	// Sequential is an option to have every worker make the groups in
+	// order, like a user going through a recorded session, instead of
+	// the workers making them in turn between them. It is implied if a
+	// group captures values.
+	Sequential bool
+
+	// Templates is an option to expand placeholders in the URL, the
+	// body and the header values of every request:
+	//
+	//	{{uuid}}        a random UUID (version 4)
+	//	{{seq}}         the number of the request, starting at 0
+	//	{{rand A B}}    a random integer between A and B, inclusive
+	//	{{timestamp}}   the current Unix time in seconds
+	//	{{.name}}       the value of the column name of Data
+	//	{{setup name}}  the value captured as name by the Setup steps
+	//	{{capture name}} the value captured as name by a group, see
+	//	                RequestGroup.Capture
+	//
+	// Requests with invalid templates fail, see CheckTemplate.
+	Templates bool
+
+	// Data, if set, is the table the {{.name}} placeholders of the
+	// templates refer to.
+	Data *Data
+
+	// Setup are requests every worker makes once, in order, before the
+	// run, see SetupStep.
+	Setup []SetupStep
+
+	// UserAgents, if set, are used in turn as the User-Agent header of
+	// the requests, replacing the one of Request.
+	UserAgents []string
+
 	// N is the total number of requests to make.
 	N int
 
 	// C is the concurrency level, the number of concurrent workers to run.
 	C int
 
+	// Adjustable makes the load of the run adjustable while it runs, see
+	// Adjust. Its workers then take their requests from the N of the
+	// run in turn instead of making N/C each.
+	Adjustable bool
+
 	// H2 is an option to make HTTP/2 requests
 	H2 bool
 
+	// GRPC is an option to make the requests gRPC calls: they are made
+	// over HTTP/2, without TLS for http urls, and their gRPC status is
+	// recorded. The request must be a POST of a gRPC message.
+	GRPC bool
+
+	// Pipeline is the number of requests each worker sends before waiting
+	// for their responses. Over HTTP/1.1 the requests are pipelined on a
+	// single connection, over HTTP/2 they are multiplexed as concurrent
+	// streams. Zero or one disables batching.
+	Pipeline int
+
 	// Timeout in seconds.
 	Timeout int
 
 	// Qps is the rate limit in queries per second.
 	QPS float64
 
+	// ThinkTime, ThinkDist and ThinkMax are the pause of a worker after
+	// every request if Groups is not set, see RequestGroup.
+	ThinkTime time.Duration
+	ThinkDist ThinkDist
+	ThinkMax  time.Duration
+
+	// Rate, if set, is the arrival rate of requests per second of the
+	// whole run. Requests are started on schedule, independent of the
+	// number in flight (an open model), instead of by C workers that
+	// wait for their responses. QPS is ignored.
+	Rate float64
+
+	// Schedule, if set, are the offsets from the start of the run at
+	// which the requests are started in an open model, instead of at
+	// the arrival rate of Rate, e.g. to replay the timing of an access
+	// log. At most N requests are made, one per offset.
+	Schedule []time.Duration
+
 	// DisableCompression is an option to disable compression in response
 	DisableCompression bool
 
+	// VerifyCompression is an option to ask for gzip and deflate
+	// compressed responses and to decode their bodies, so that bodies
+	// that do not decode as their Content-Encoding claims fail with a
+	// *report.DecompressionError. Responses in other encodings fail
+	// as well, as hey cannot verify them.
+	VerifyCompression bool
+
+	// SecurityHeaders is an option to report how many responses had
+	// each of the report.SecurityHeaders, e.g. HSTS.
+	SecurityHeaders bool
+
+	// ExpectHeaders are headers every response must have. Responses
+	// that lack one fail with a *report.ExpectationError.
+	ExpectHeaders []HeaderExpectation
+
+	// ExpectStatus, if set, are the status codes responses must have.
+	// Responses with another one fail with a *report.ExpectationError.
+	ExpectStatus []int
+
+	// ExpectBodyContains are strings every response body must contain
+	// and ExpectBodyRegexps regular expressions it must match.
+	// Responses that do not fail with a *report.ExpectationError.
+	ExpectBodyContains []string
+	ExpectBodyRegexps  []*regexp.Regexp
+
+	// EnableCookies gives every worker its own cookie jar, which keeps
+	// the cookies the server sets across the requests of the worker,
+	// e.g. a session cookie.
+	EnableCookies bool
+
 	// DisableKeepAlives is an option to prevents re-use of TCP connections between different HTTP requests
 	DisableKeepAlives bool
 
+	// DisableTLSResumption is an option to do a full TLS handshake on
+	// every new connection instead of resuming earlier sessions.
+	DisableTLSResumption bool
+
+	// TLSVerifyTiming is an option to verify the certificate chain of
+	// every full TLS handshake and record the time it takes. The
+	// outcome of the verification does not fail the request.
+	TLSVerifyTiming bool
+
+	// ECHConfigList, if set, enables Encrypted Client Hello with the
+	// given ECHConfigList, as published in the HTTPS DNS record of the
+	// host. It requires ECHSupported.
+	ECHConfigList []byte
+
+	// Certificates are presented to servers that ask for a client
+	// certificate, for mutual TLS.
+	Certificates []tls.Certificate
+
+	// CertFile and KeyFile, if set, are the files the first of the
+	// Certificates was loaded from. It is reloaded when they change
+	// during the run, and the report counts the handshakes that
+	// presented each generation of it.
+	CertFile string
+	KeyFile  string
+
+	// RootCAs, if set, are the certificate authorities the certificates
+	// of the servers are verified against instead of the system roots.
+	RootCAs *x509.CertPool
+
+	// InsecureSkipVerify disables the verification of the certificates
+	// of the servers.
+	InsecureSkipVerify bool
+
+	// ServerName, if set, is sent in the TLS handshake and verified
+	// instead of the host of the request.
+	ServerName string
+
 	// DisableRedirects is an option to prevent the following of HTTP redirects
 	DisableRedirects bool
 
+	// RespectRetryAfter is an option to make a worker back off when the
+	// server throttles it with a 429 or 503 response, for as long as the
+	// Retry-After header asks or exponentially if there is none.
+	RespectRetryAfter bool
+
+	// CircuitBreakerFailures is the number of consecutive failures after
+	// which the client side circuit breaker opens. Zero disables it.
+	CircuitBreakerFailures int
+
+	// CircuitBreakerCooldown is how long the circuit breaker stays open
+	// before a probe request is let through.
+	CircuitBreakerCooldown time.Duration
+
+	// CancelRate is the share of requests, from 0 to 1, that are canceled
+	// on purpose CancelAfter after their start if they are still in
+	// flight. They fail with a *report.CancelError.
+	CancelRate  float64
+	CancelAfter time.Duration
+
+	// FuzzRate is the share of requests, from 0 to 1, that are malformed
+	// on purpose, e.g. with an oversized header, an invalid
+	// Content-Length or an invalid method, and sent over their own
+	// HTTP/1.1 connection. Their results have a Mutation and are
+	// reported separately.
+	FuzzRate float64
+
+	// StreamStats is an option to record the time between the chunks of
+	// each response body.
+	StreamStats bool
+
+	// StallThreshold is the time between two chunks from which on a
+	// response counts as stalled.
+	StallThreshold time.Duration
+
+	// RedirectChain is an option to record every redirect hop of each
+	// request and report the distribution of redirect chain lengths.
+	RedirectChain bool
+
 	// Output represents the output type. If "csv" is provided, the
-	// output will be dumped as a csv stream.
+	// output will be dumped as a csv stream, "jsonl" writes every
+	// result as a line of JSON. See report.Options.
 	Output string
 
+	// LatencyUnit is the unit latencies are shown in by the summary
+	// output. See report.Options.
+	LatencyUnit string
+
+	// QuantileEngine is the estimator used for the latency percentiles,
+	// "exact", "tdigest" or "hdr". See report.Options.
+	QuantileEngine string
+
+	// TDigestCompression is the compression of the t-digest.
+	TDigestCompression float64
+
+	// HDRPrecision is the number of significant digits of the HDR
+	// histogram.
+	HDRPrecision int
+
+	// HistogramBuckets and HistogramMarks configure the buckets of the
+	// response time histogram. See report.Options.
+	HistogramBuckets int
+	HistogramMarks   []float64
+
+	// SpoolDir, if set, is the directory the results are spooled to
+	// instead of being kept in memory. See report.Options.
+	SpoolDir string
+
+	// WorkerStats enables a report section on how requests and latencies
+	// were spread across workers and, with keep-alives, across
+	// connections.
+	WorkerStats bool
+
+	// CSVFields selects and orders the columns of the csv output.
+	// If empty, the default columns are used. See report.ParseCSVFields.
+	CSVFields []string
+
 	// ProxyAddr is the address of HTTP proxy server in the format on "host:port".
 	// Optional.
 	ProxyAddr *url.URL
 
+	// HeaderOrder, if set, makes the requests be written with the
+	// headers named in it first, spelled exactly as there and in its
+	// order, followed by the other headers. net/http canonicalizes and
+	// sorts the names of headers, so requests are then written by hey
+	// itself, over HTTP/1.1 only and without a proxy.
+	HeaderOrder []string
+
+	// Resolve pins hosts to IP addresses, bypassing DNS. It maps
+	// "host:port" to the IP address connections to it are made to.
+	Resolve map[string]string
+
+	// Network, if set, makes the connections emulate its network
+	// conditions.
+	Network *NetworkProfile
+
+	// DNSChaos, if set, makes some of the lookups of the run fail or
+	// return other addresses.
+	DNSChaos *DNSChaos
+
 	// Writer is where results will be written. If nil, results are written to stdout.
 	Writer io.Writer
 
+	// Metrics, if set, is updated live with every request.
+	Metrics *report.Metrics
+
+	// SaveErrors, if set, is a directory to which the failing responses,
+	// those with a non-2xx status or that failed an expectation, are
+	// written with their request, one file each, up to MaxSaved files if
+	// it is positive. The directory must exist.
+	SaveErrors string
+	MaxSaved   int
+
+	// SavePartial writes the report of an aborted run, see Abort, in the
+	// Output format. Otherwise only its summary is printed, to stderr,
+	// if Output is set.
+	SavePartial bool
+
+	// Scrape, if set, scrapes the metrics of the system under test
+	// during the run into the report.
+	Scrape *Scrape
+
+	// Progress, if set, is updated with every request and prints the
+	// progress of the run while it runs.
+	Progress *report.Progress
+
+	// Collector, if set, receives every result as it completes. It is
+	// closed by the caller after the run.
+	Collector *report.Collector
+
+	// SLOs are the published objectives of the service the report
+	// states how the run fared against.
+	SLOs []report.SLO
+
+	// Lifecycle, if set, is told about every result and event of the
+	// run and checks its limits while the run runs.
+	Lifecycle *report.Lifecycle
+
+	// Region, if set, is the region of the results, see
+	// report.Result.Region.
+	Region string
+
+	// Manifest, if set, describes the run in the json output.
+	Manifest interface{}
+
+	// Record, if set, receives the raw results of the run, see
+	// report.RecordWriter.
+	Record *report.RecordWriter
+
 	initOnce sync.Once
-	results  chan *result
+	results  chan *report.Result
 	stopCh   chan struct{}
+	stopOnce sync.Once
 	start    time.Duration
 
-	report *report
+	// live is the load of an Adjustable run, see Adjust.
+	live liveLoad
+
+	report     *report.Reporter
+	reportDone chan struct{}
+
+	breaker *circuitBreaker
+
+	errSaver *errorSaver
+	scraper  *scraper
+
+	// aborted is set to 1 by Abort.
+	aborted int32
+
+	// dnsFailed and dnsFlapped count the lookups of DNSChaos.
+	dnsFailed  uint64
+	dnsFlapped uint64
+
+	// certs reloads the client certificate, if CertFile is set.
+	certs *certReloader
+
+	// tlsConfig is shared by all connections, so that they share the
+	// TLS session cache.
+	tlsConfig *tls.Config
+
+	// client makes the HTTP requests of all workers, see workerClient.
+	client *http.Client
+
+	// workers are the workers set up by RunSetup, in order.
+	workers   []*worker
+	setupOnce sync.Once
+	setupErr  error
+
+	// defaultGroups holds the group of Request if Groups is not set.
+	defaultGroups []*RequestGroup
+
+	// cumWeights are the cumulative weights of Groups, see
+	// RequestGroup.Weight.
+	cumWeights []float64
+
+	// seq counts the requests built by newRequest.
+	seq uint64
+
+	// conns maps the connections of the run to their ids, counted by
+	// connSeq, if requests per connection are reported.
+	conns   sync.Map
+	connSeq uint64
 }
 
 func (b *Work) writer() io.Writer {
@@ -106,8 +446,37 @@ func (b *Work) writer() io.Writer {
 // Init initializes internal data-structures
 func (b *Work) Init() {
 	b.initOnce.Do(func() {
-		b.results = make(chan *result, min(b.C*1000, maxResult))
-		b.stopCh = make(chan struct{}, b.C)
+		b.results = make(chan *report.Result, min(b.C*1000, maxResult))
+		b.stopCh = make(chan struct{})
+		b.initLive()
+		if len(b.Groups) > 0 && b.Request == nil {
+			b.Request = b.Groups[0].Request
+		}
+		b.defaultGroups = []*RequestGroup{{
+			Request:     b.Request,
+			RequestBody: b.RequestBody,
+			ThinkTime:   b.ThinkTime,
+			ThinkDist:   b.ThinkDist,
+			ThinkMax:    b.ThinkMax,
+		}}
+		b.cumWeights = b.weights()
+		if b.SaveErrors != "" {
+			b.errSaver = &errorSaver{dir: b.SaveErrors, max: int64(b.MaxSaved)}
+			if b.MaxSaved <= 0 {
+				b.errSaver.max = math.MaxInt64
+			}
+		}
+		if b.Templates {
+			var columns []string
+			if b.Data != nil {
+				columns = b.Data.Columns
+			}
+			names := TemplateNames{Columns: columns, Setup: b.captures(), Captures: b.groupCaptures()}
+			for _, g := range b.groups() {
+				g.parseTemplates(names)
+			}
+		}
+		b.client = b.newClient()
 	})
 }
 
@@ -115,144 +484,688 @@ func (b *Work) Init() {
 // all work is done.
 func (b *Work) Run() {
 	b.Init()
+	setupErr := b.RunSetup()
 	b.start = now()
-	b.report = newReport(b.writer(), b.results, b.Output, b.N)
+	var interval time.Duration
+	if b.QPS > 0 && b.Rate == 0 {
+		// Workers wait for responses, so correct for the requests they
+		// could not start on schedule.
+		interval = time.Duration(1e6/(b.QPS)) * time.Microsecond
+	}
+	b.report = report.New(b.writer(), report.Options{
+		Output:             b.Output,
+		Manifest:           b.Manifest,
+		N:                  b.N,
+		CSVFields:          b.CSVFields,
+		LatencyUnit:        b.LatencyUnit,
+		QuantileEngine:     b.QuantileEngine,
+		TDigestCompression: b.TDigestCompression,
+		HDRPrecision:       b.HDRPrecision,
+		HistogramBuckets:   b.HistogramBuckets,
+		HistogramMarks:     b.HistogramMarks,
+		SpoolDir:           b.SpoolDir,
+		WorkerStats:        b.WorkerStats,
+		RateLimited:        b.targetRate() > 0,
+		CorrectionInterval: interval,
+		TargetRate:         b.targetRate(),
+		RedirectChain:      b.RedirectChain,
+		RetryAfter:         b.RespectRetryAfter,
+		StreamStats:        b.StreamStats,
+		StallThreshold:     b.StallThreshold,
+		SecurityHeaders:    b.SecurityHeaders,
+		Record:             b.Record,
+		Lifecycle:          b.Lifecycle,
+		SLOs:               b.SLOs,
+	})
+	b.reportDone = make(chan struct{})
+	if b.CircuitBreakerFailures > 0 {
+		b.breaker = &circuitBreaker{
+			threshold: b.CircuitBreakerFailures,
+			cooldown:  b.CircuitBreakerCooldown,
+			onChange: func(from, to circuitState) {
+				b.report.AddEvent(report.Event{
+					Offset: now() - b.start,
+					Name:   "circuit breaker",
+					Detail: from.String() + " -> " + to.String(),
+				})
+			},
+		}
+	}
+	if b.Progress != nil {
+		b.Progress.Start()
+	}
+	if b.Lifecycle != nil {
+		b.Lifecycle.Start()
+	}
+	if b.Scrape != nil {
+		b.scraper = b.startScraper(b.Scrape)
+	}
 	// Run the reporter first, it polls the result channel until it is closed.
 	go func() {
-		runReporter(b.report)
+		b.report.Run(b.results)
+		close(b.reportDone)
 	}()
-	b.runWorkers()
+	if setupErr != nil {
+		b.report.AddEvent(report.Event{Name: "setup", Detail: setupErr.Error()})
+	} else {
+		b.runWorkers()
+	}
 	b.Finish()
 }
 
-func (b *Work) Stop() {
-	// Send stop signal so that workers can stop gracefully.
-	for i := 0; i < b.C; i++ {
-		b.stopCh <- struct{}{}
+// SavedErrors returns the number of failing responses written to the
+// SaveErrors directory and the first error writing one, if any.
+func (b *Work) SavedErrors() (int, error) {
+	if b.errSaver == nil {
+		return 0, nil
 	}
+	return b.errSaver.saved()
+}
+
+// Report returns the statistics of the run. It must only be called
+// after Run returned.
+func (b *Work) Report() report.Report {
+	return b.report.Snapshot()
+}
+
+func (b *Work) Stop() {
+	// Close the stop channel so that all workers, however many Adjust
+	// started, stop gracefully.
+	b.stopOnce.Do(func() { close(b.stopCh) })
+}
+
+// Abort stops the run like Stop, but marks it as aborted before it
+// completed, e.g. when it is interrupted. The report covers the requests
+// completed until then.
+func (b *Work) Abort() {
+	atomic.StoreInt32(&b.aborted, 1)
+	b.Stop()
 }
 
 func (b *Work) Finish() {
+	if b.scraper != nil {
+		b.scraper.close()
+	}
 	close(b.results)
 	total := now() - b.start
 	// Wait until the reporter is done.
-	<-b.report.done
-	b.report.finalize(total)
+	<-b.reportDone
+	if b.Progress != nil {
+		b.Progress.Stop()
+	}
+	if b.Lifecycle != nil {
+		b.Lifecycle.Stop()
+	}
+	if atomic.LoadInt32(&b.aborted) != 0 {
+		var summary io.Writer
+		if b.Output != "" && !b.SavePartial {
+			summary = os.Stderr
+		}
+		b.report.Abort(summary)
+	}
+	if b.certs != nil {
+		b.report.SetCertGenerations(b.certs.generations())
+	}
+	b.report.Finalize(total)
 }
 
-func (b *Work) makeRequest(c *http.Client) {
+// makeRequest makes a single request for w. It returns the result and
+// the time the worker should pause before its next request.
+func (b *Work) makeRequest(c *http.Client, w *worker, lag time.Duration) (*report.Result, time.Duration) {
+	req, g, err := b.newRequest(w)
+	if err != nil {
+		res := &report.Result{
+			Start:      time.Now(),
+			Offset:     now(),
+			Err:        err,
+			ErrorClass: report.ErrorClassGeneration,
+			Label:      g.Name,
+			Class:      g.Class,
+			Worker:     w.id,
+			Lag:        lag,
+		}
+		b.record(res)
+		return res, g.think()
+	}
+	if b.FuzzRate > 0 && rand.Float64() < b.FuzzRate {
+		return b.makeMutatedRequest(req, g, w, lag), g.think()
+	}
+	start := time.Now()
 	s := now()
 	var size int64
 	var code int
-	var dnsStart, connStart, resStart, reqStart, delayStart time.Duration
-	var dnsDuration, connDuration, resDuration, reqDuration, delayDuration time.Duration
-	req := cloneRequest(b.Request, b.RequestBody)
+	var dnsStart, connStart, tlsStart, resStart, reqStart, delayStart time.Duration
+	var dnsDuration, connDuration, tlsDuration, resDuration, reqDuration, delayDuration time.Duration
+	var tlsResumed, ocspStapled bool
+	var tlsVerifyDuration time.Duration
+	var ech string
+	var connID uint64
+	// Under HTTP/2, the trace hooks run on the read loop of the
+	// connection as well as on this goroutine, so the times they set are
+	// guarded by traceMu.
+	var traceMu sync.Mutex
 	trace := &httptrace.ClientTrace{
 		DNSStart: func(info httptrace.DNSStartInfo) {
+			traceMu.Lock()
+			defer traceMu.Unlock()
 			dnsStart = now()
 		},
 		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
+			traceMu.Lock()
+			defer traceMu.Unlock()
 			dnsDuration = now() - dnsStart
 		},
 		GetConn: func(h string) {
+			traceMu.Lock()
+			defer traceMu.Unlock()
 			connStart = now()
 		},
+		TLSHandshakeStart: func() {
+			traceMu.Lock()
+			defer traceMu.Unlock()
+			tlsStart = now()
+		},
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			traceMu.Lock()
+			defer traceMu.Unlock()
+			tlsDuration = now() - tlsStart
+			tlsResumed = cs.DidResume
+			ocspStapled = len(cs.OCSPResponse) > 0
+			ech = echOutcome(cs, err)
+			if b.TLSVerifyTiming && err == nil && !cs.DidResume {
+				tlsVerifyDuration = verifyChain(cs)
+			}
+		},
 		GotConn: func(connInfo httptrace.GotConnInfo) {
+			traceMu.Lock()
+			defer traceMu.Unlock()
 			if !connInfo.Reused {
 				connDuration = now() - connStart
 			}
+			if b.WorkerStats && !b.DisableKeepAlives {
+				connID = b.connID(connInfo.Conn)
+			}
 			reqStart = now()
 		},
 		WroteRequest: func(w httptrace.WroteRequestInfo) {
+			traceMu.Lock()
+			defer traceMu.Unlock()
 			reqDuration = now() - reqStart
 			delayStart = now()
 		},
 		GotFirstResponseByte: func() {
+			traceMu.Lock()
+			defer traceMu.Unlock()
 			delayDuration = now() - delayStart
 			resStart = now()
 		},
 	}
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	ctx := httptrace.WithClientTrace(req.Context(), trace)
+	var chain *redirectChain
+	if b.RedirectChain {
+		chain = &redirectChain{last: s}
+		ctx = withRedirectChain(ctx, chain)
+	}
+	var cancelTimer *time.Timer
+	if b.CancelRate > 0 && rand.Float64() < b.CancelRate {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		cancelTimer = time.AfterFunc(b.CancelAfter, cancel)
+	}
+	req = req.WithContext(ctx)
+	if b.Metrics != nil {
+		b.Metrics.Begin()
+		defer b.Metrics.End()
+	}
 	resp, err := c.Do(req)
+	var read int64
+	var proto string
+	var backoff time.Duration
+	var gaps []time.Duration
+	var truncated bool
+	var security uint
+	var buf *bytes.Buffer
+	var complete bool
+	var grpc string
 	if err == nil {
 		size = resp.ContentLength
 		code = resp.StatusCode
-		io.Copy(ioutil.Discard, resp.Body)
+		proto = resp.Proto
+		var body io.Reader = resp.Body
+		var dec *decoder
+		if b.VerifyCompression {
+			dec = newDecoder(resp)
+			body = dec
+		}
+		if g.Extract != nil || g.Metrics != nil || g.Capture != nil || b.expectsBody() || b.errSaver != nil {
+			buf = new(bytes.Buffer)
+			body = io.TeeReader(body, buf)
+		}
+		var rerr error
+		if b.StreamStats {
+			read, gaps, rerr = readChunks(body)
+		} else {
+			read, rerr = discard(body)
+		}
 		resp.Body.Close()
+		truncated = rerr != nil
+		if dec != nil {
+			err, truncated = dec.check(rerr)
+			rerr = err
+		}
+		if rerr == nil {
+			var data []byte
+			if buf != nil {
+				data = buf.Bytes()
+			}
+			err = b.checkResponse(resp, data)
+			rerr = err
+		}
+		complete = rerr == nil
+		if b.errSaver != nil && failed(code, err) {
+			b.errSaver.save(req, resp, buf.Bytes(), err)
+		}
+		if g.Extract != nil && complete {
+			g.Extract(resp, buf.Bytes())
+		}
+		if g.Capture != nil && complete && code >= 200 && code <= 299 {
+			w.capture(g.Capture, resp, buf.Bytes())
+		}
+		if b.RespectRetryAfter {
+			backoff = w.throttled(resp, time.Now())
+		}
+		if b.SecurityHeaders {
+			security = report.SecurityHeaderMask(resp.Header)
+		}
+		if b.GRPC {
+			grpc = grpcStatus(resp)
+		}
+	}
+	if cancelTimer != nil && !cancelTimer.Stop() && (err != nil || truncated) {
+		err = &report.CancelError{After: b.CancelAfter}
+		complete = false
 	}
 	t := now()
+	traceMu.Lock()
 	resDuration = t - resStart
 	finish := t - s
-	b.results <- &result{
-		offset:        s,
-		statusCode:    code,
-		duration:      finish,
-		err:           err,
-		contentLength: size,
-		connDuration:  connDuration,
-		dnsDuration:   dnsDuration,
-		reqDuration:   reqDuration,
-		resDuration:   resDuration,
-		delayDuration: delayDuration,
+	var metrics []report.CustomMetric
+	if g.Metrics != nil && complete {
+		metrics = g.Metrics(resp, buf.Bytes(), finish)
+	}
+	var redirects []report.Redirect
+	if chain != nil {
+		redirects = chain.hops
+	}
+	res := &report.Result{
+		Start:             start,
+		Offset:            s,
+		StatusCode:        code,
+		Proto:             proto,
+		GRPCStatus:        grpc,
+		Duration:          finish,
+		Err:               err,
+		ContentLength:     size,
+		BytesRead:         read,
+		Truncated:         truncated,
+		ConnDuration:      connDuration,
+		DNSDuration:       dnsDuration,
+		TLSDuration:       tlsDuration,
+		TLSResumed:        tlsResumed,
+		OCSPStapled:       ocspStapled,
+		TLSVerifyDuration: tlsVerifyDuration,
+		ECH:               ech,
+		ReqDuration:       reqDuration,
+		ResDuration:       resDuration,
+		DelayDuration:     delayDuration,
+		Worker:            w.id,
+		ConnID:            connID,
+		Lag:               lag,
+		Redirects:         redirects,
+		Backoff:           backoff,
+		ChunkGaps:         gaps,
+		Label:             g.Name,
+		Class:             g.Class,
+		SecurityHeaders:   security,
+		Metrics:           metrics,
 	}
+	traceMu.Unlock()
+	b.record(res)
+	if think := g.think(); think > backoff {
+		return res, think
+	}
+	return res, backoff
 }
 
-func (b *Work) runWorker(client *http.Client, n int) {
-	var throttle <-chan time.Time
-	if b.QPS > 0 {
-		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond)
+// discard reads r until EOF and returns the number of bytes read. Unlike
+// io.Copy, it only calls Read: the HTTP/2 transport answers requests
+// without a body with a bytes.Reader shared by all connections, whose
+// WriteTo is not safe for concurrent use.
+func discard(r io.Reader) (int64, error) {
+	return ioutil.Discard.(io.ReaderFrom).ReadFrom(r)
+}
+
+// makeGuardedRequest makes a request through the circuit breaker and
+// returns how long the worker should wait before the next request.
+func (b *Work) makeGuardedRequest(c *http.Client, w *worker, lag time.Duration) time.Duration {
+	ok, wait := b.breaker.allow(time.Now())
+	if !ok {
+		b.record(&report.Result{
+			Start:      time.Now(),
+			Offset:     now(),
+			Err:        ErrCircuitOpen,
+			ErrorClass: report.ErrorClassCircuitOpen,
+			Worker:     w.id,
+			Lag:        lag,
+		})
+		return wait
 	}
+	res, pause := b.makeRequest(c, w, lag)
+	b.breaker.done(res.Mutation == "" && (res.Err != nil || res.StatusCode >= 500), time.Now())
+	return pause
+}
 
-	if b.DisableRedirects {
-		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+// record completes res and hands it to the reporter.
+func (b *Work) record(res *report.Result) {
+	res.Offset -= b.start
+	res.Region = b.Region
+	if res.ErrorClass == "" {
+		res.ErrorClass = report.ClassifyError(res.Err)
+	}
+	if res.Err != nil {
+		// Only declared here, as the target of errors.As escapes to the
+		// heap and the results without an error should not allocate.
+		var expectErr *report.ExpectationError
+		if errors.As(res.Err, &expectErr) {
+			res.Assertion = expectErr.Assertion
 		}
 	}
+	if res.Attempt == 0 {
+		res.Attempt = 1
+	}
+	if b.Metrics != nil {
+		b.Metrics.Observe(res)
+	}
+	if b.Progress != nil {
+		b.Progress.Observe(res)
+	}
+	if b.Collector != nil {
+		b.Collector.Observe(res)
+	}
+	if b.Lifecycle != nil {
+		b.Lifecycle.Observe(res)
+	}
+	b.results <- res
+}
+
+func (b *Work) runWorker(client *http.Client, n int, w *worker) {
+	live := b.adjustable()
+	var qps float64
+	var ticker *time.Ticker
+	var throttle <-chan time.Time
+	// setQPS limits the worker to q requests per second, none if zero.
+	setQPS := func(q float64) {
+		if ticker != nil {
+			ticker.Stop()
+		}
+		qps, ticker, throttle = q, nil, nil
+		if d := time.Duration(1e6/q) * time.Microsecond; q > 0 && d > 0 {
+			ticker = time.NewTicker(d)
+			throttle = ticker.C
+		}
+	}
+	setQPS(b.workerQPS(w))
+	defer setQPS(0)
+
 	for i := 0; i < n; i++ {
 		// Check if application is stopped. Do not send into a closed channel.
 		select {
 		case <-b.stopCh:
 			return
 		default:
-			if b.QPS > 0 {
-				<-throttle
+			if live {
+				if !b.await(w) {
+					return
+				}
+				if q := b.workerQPS(w); q != qps {
+					setQPS(q)
+				}
+			}
+			var lag time.Duration
+			if throttle != nil {
+				lag = time.Since(<-throttle)
+			}
+			if b.H2 && b.Pipeline > 1 {
+				k := min(b.Pipeline, n-i)
+				if live {
+					k = 1 + b.take(k-1)
+				}
+				if b.makeBatch(client, k, w, lag, throttle) {
+					return
+				}
+				i += k - 1
+				continue
+			}
+			var wait time.Duration
+			if b.breaker != nil {
+				wait = b.makeGuardedRequest(client, w, lag)
+			} else {
+				_, wait = b.makeRequest(client, w, lag)
+			}
+			if wait > 0 {
+				select {
+				case <-b.stopCh:
+					return
+				case <-time.After(wait):
+				}
 			}
-			b.makeRequest(client)
 		}
 	}
 }
 
-func (b *Work) runWorkers() {
-	var wg sync.WaitGroup
-	wg.Add(b.C)
+// resolve returns the address to dial for addr, a "host:port", see
+// Resolve.
+func (b *Work) resolve(addr string) string {
+	if ip, ok := b.Resolve[addr]; ok {
+		_, port, _ := net.SplitHostPort(addr)
+		return net.JoinHostPort(ip, port)
+	}
+	return addr
+}
 
+// connID returns the id of conn, numbering the connections in the order
+// they are first used, starting at 1.
+func (b *Work) connID(conn net.Conn) uint64 {
+	if id, ok := b.conns.Load(conn); ok {
+		return id.(uint64)
+	}
+	id, _ := b.conns.LoadOrStore(conn, atomic.AddUint64(&b.connSeq, 1))
+	return id.(uint64)
+}
+
+// newClient returns the client the workers make their requests with.
+// It also sets up the TLS configuration shared by all connections.
+func (b *Work) newClient() *http.Client {
+	b.tlsConfig = &tls.Config{
+		InsecureSkipVerify: b.InsecureSkipVerify,
+		RootCAs:            b.RootCAs,
+		Certificates:       b.Certificates,
+		ServerName:         b.ServerName,
+	}
+	if b.CertFile != "" && len(b.Certificates) > 0 {
+		b.certs = newCertReloader(b.Certificates[0], b.CertFile, b.KeyFile)
+		b.certs.onReload = func(gen int, err error) {
+			detail := fmt.Sprintf("reloaded %s, generation %d", b.CertFile, gen)
+			if err != nil {
+				detail = fmt.Sprintf("reloading %s failed, keeping generation %d: %v", b.CertFile, gen, err)
+			}
+			b.report.AddEvent(report.Event{Offset: now() - b.start, Name: "client certificate", Detail: detail})
+		}
+		b.tlsConfig.Certificates = nil
+		b.tlsConfig.GetClientCertificate = b.certs.getClientCertificate
+	}
+	if b.ServerName == "" && len(b.Groups) == 0 {
+		// Groups may target different hosts, each verified by its own name.
+		b.tlsConfig.ServerName = b.Request.Host
+		if host, _, err := net.SplitHostPort(b.Request.Host); err == nil {
+			b.tlsConfig.ServerName = host
+		}
+	}
+	if len(b.ECHConfigList) > 0 {
+		setECH(b.tlsConfig, b.ECHConfigList)
+	}
+	if !b.DisableTLSResumption {
+		b.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(b.C)
+	}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         b.Request.Host,
-		},
+		TLSClientConfig:     b.tlsConfig,
 		MaxIdleConnsPerHost: min(b.C, maxIdleConn),
-		DisableCompression:  b.DisableCompression,
+		DisableCompression:  b.DisableCompression || b.VerifyCompression,
 		DisableKeepAlives:   b.DisableKeepAlives,
 		Proxy:               http.ProxyURL(b.ProxyAddr),
 	}
+	if len(b.Resolve) > 0 || b.Network != nil || b.DNSChaos != nil {
+		var d net.Dialer
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			addr, err := b.chaosAddr(b.resolve(addr))
+			if err != nil {
+				return nil, err
+			}
+			c, err := d.DialContext(ctx, network, addr)
+			if err != nil || b.Network == nil {
+				return c, err
+			}
+			return b.Network.connect(ctx, c)
+		}
+	}
+	if b.GRPC {
+		return &http.Client{
+			Transport: b.grpcTransport(tr),
+			Timeout:   time.Duration(b.Timeout) * time.Second,
+		}
+	}
 	if b.H2 {
 		http2.ConfigureTransport(tr)
 	} else {
 		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
 	}
-	client := &http.Client{Transport: tr, Timeout: time.Duration(b.Timeout) * time.Second}
+	var rt http.RoundTripper = tr
+	if len(b.HeaderOrder) > 0 {
+		rt = &rawTransport{b: b}
+	}
+	return &http.Client{
+		Transport:     rt,
+		Timeout:       time.Duration(b.Timeout) * time.Second,
+		CheckRedirect: b.checkRedirect,
+	}
+}
 
-	// Ignore the case where b.N % b.C != 0.
+func (b *Work) runWorkers() {
+	groups := b.assignGroups()
+	if b.Rate > 0 || len(b.Schedule) > 0 {
+		b.runOpenModel(b.client, groups)
+		return
+	}
+	if b.adjustable() {
+		b.runAdjustable(groups)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(b.C)
 	for i := 0; i < b.C; i++ {
-		go func() {
-			b.runWorker(client, b.N/b.C)
+		w := b.newWorker(i, groups)
+		// The first workers make the remainder of b.N / b.C.
+		n := b.N / b.C
+		if i < b.N%b.C {
+			n++
+		}
+		go func(w *worker) {
+			switch {
+			case b.WebSocket:
+				b.runWSWorker(n, w)
+			case b.Pipeline > 1 && !b.H2:
+				b.runPipelinedWorker(n, w)
+			default:
+				b.runWorker(b.workerClient(b.client, w), n, w)
+			}
 			wg.Done()
-		}()
+		}(w)
 	}
 	wg.Wait()
 }
 
+// workerClient returns the client w makes its requests with: client
+// itself, or with EnableCookies a copy with the cookie jar of w.
+func (b *Work) workerClient(client *http.Client, w *worker) *http.Client {
+	if !b.EnableCookies {
+		return client
+	}
+	if w.jar == nil {
+		w.jar, _ = cookiejar.New(nil)
+	}
+	c := *client
+	c.Jar = w.jar
+	return &c
+}
+
+// newRequest returns the next request for w to send and its group. The
+// request is a clone of the one of the group with the per-request
+// changes applied.
+func (b *Work) newRequest(w *worker) (*http.Request, *RequestGroup, error) {
+	i := atomic.AddUint64(&b.seq, 1) - 1
+	g := w.group
+	if g == nil {
+		groups := b.groups()
+		j := i
+		if b.chained() {
+			j = atomic.AddUint64(&w.next, 1) - 1
+		}
+		g = groups[j%uint64(len(groups))]
+		if b.cumWeights != nil && !b.chained() {
+			g = b.pickGroup()
+		}
+	}
+	if g.err != nil {
+		return nil, g, g.err
+	}
+	req := cloneRequest(g.Request, nil)
+	body := g.RequestBody
+	if b.Templates {
+		ctx := &templateContext{seq: i, setup: w.setup, captured: w.values()}
+		if b.Data != nil {
+			ctx.row, ctx.rowNum = b.Data.row(i)
+		}
+		var err error
+		if body, err = g.expand(req, ctx); err != nil {
+			return nil, g, err
+		}
+		req.ContentLength = int64(len(body))
+	}
+	if b.Adjustable {
+		b.adjustHeader(req)
+	}
+	if len(body) > 0 {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if b.VerifyCompression && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	if len(b.UserAgents) > 0 {
+		req.Header.Set("User-Agent", b.UserAgents[i%uint64(len(b.UserAgents))])
+	}
+	if b.Sign != nil {
+		h, err := b.Sign(req, body)
+		if err != nil {
+			return nil, g, err
+		}
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+	return req, g, nil
+}
+
 // cloneRequest returns a clone of the provided *http.Request.
 // The clone is a shallow copy of the struct and its Header map.
 func cloneRequest(r *http.Request, body []byte) *http.Request {
@@ -15,14 +15,40 @@
 package requester
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/rakyll/hey/requester/report"
+	"golang.org/x/net/http2"
 )
 
 func TestN(t *testing.T) {
@@ -45,6 +71,36 @@ func TestN(t *testing.T) {
 	}
 }
 
+func TestReportTwice(t *testing.T) {
+	var count int64
+	delays := []time.Duration{50, 10, 30}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delays[atomic.AddInt64(&count, 1)-1] * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 3, C: 1, Writer: ioutil.Discard}
+	w.Run()
+	for i := 0; i < 2; i++ {
+		r := w.Report()
+		if len(r.Lats) != 3 {
+			t.Fatalf("report %d: %d latencies; want 3", i, len(r.Lats))
+		}
+		// The requests are made one after the other, so the offsets
+		// increase and the latencies follow the delays.
+		if !sort.Float64sAreSorted(r.Offsets) || r.Lats[0] < r.Lats[2] || r.Lats[2] < r.Lats[1] {
+			t.Errorf("report %d: lats %v, offsets %v; want them in the order of the requests", i, r.Lats, r.Offsets)
+		}
+		if r.NumRes != 3 || r.StatusCodeDist[200] != 3 || r.Rps <= 0 {
+			t.Errorf("report %d: %d results, statuses %v, %v requests/sec; want 3 with 200", i, r.NumRes, r.StatusCodeDist, r.Rps)
+		}
+		if r.Fastest < 0.01 || r.Slowest < 0.05 || r.Average < r.Fastest || r.Average > r.Slowest {
+			t.Errorf("report %d: fastest %v, average %v, slowest %v; want them to follow the delays", i, r.Fastest, r.Average, r.Slowest)
+		}
+	}
+}
+
 func TestQps(t *testing.T) {
 	var wg sync.WaitGroup
 	var count int64
@@ -99,6 +155,9 @@ func TestRequest(t *testing.T) {
 	if uri != "/" {
 		t.Errorf("Uri is expected to be /, %v is found", uri)
 	}
+	if method != "GET" {
+		t.Errorf("Method is expected to be GET, %v is found", method)
+	}
 	if contentType != "text/html" {
 		t.Errorf("Content type is expected to be text/html, %v is found", contentType)
 	}
@@ -133,3 +192,1907 @@ func TestBody(t *testing.T) {
 		t.Errorf("Expected to work 10 times, found %v", count)
 	}
 }
+
+func TestPipeline(t *testing.T) {
+	var count int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, int64(1))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:  req,
+		N:        20,
+		C:        2,
+		Pipeline: 4,
+	}
+	w.Run()
+	if count != 20 {
+		t.Errorf("Expected to send 20 requests, found %v", count)
+	}
+}
+
+func TestH2BatchQPS(t *testing.T) {
+	var mu sync.Mutex
+	var starts []time.Time
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:            req,
+		N:                  8,
+		C:                  1,
+		QPS:                40,
+		H2:                 true,
+		Pipeline:           4,
+		InsecureSkipVerify: true,
+		Writer:             ioutil.Discard,
+	}
+	w.Run()
+	if len(starts) != 8 {
+		t.Fatalf("got %d requests; want 8", len(starts))
+	}
+	// 8 requests at 40 QPS take 7 intervals of 25ms, not the 1 of two
+	// batches.
+	if d := starts[7].Sub(starts[0]); d < 150*time.Millisecond {
+		t.Errorf("requests spread over %v; want at least 150ms", d)
+	}
+}
+
+func TestGeneratorLag(t *testing.T) {
+	// The server is slower than the rate limit, so requests start late.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 5, C: 1, QPS: 100, Writer: ioutil.Discard}
+	w.Run()
+	lag := w.report.Snapshot().GeneratorLag
+	if lag == nil || lag.Max < 0.015 {
+		t.Errorf("generator lag = %+v; want a max of at least 15ms", lag)
+	}
+
+	w = &Work{Request: req, N: 2, C: 1, Writer: ioutil.Discard}
+	w.Run()
+	if lag := w.report.Snapshot().GeneratorLag; lag != nil {
+		t.Errorf("generator lag = %+v without -q; want none", lag)
+	}
+}
+
+func TestH2BatchStop(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:            req,
+		N:                  1 << 30,
+		C:                  2,
+		QPS:                20,
+		H2:                 true,
+		Pipeline:           4,
+		InsecureSkipVerify: true,
+		Writer:             ioutil.Discard,
+	}
+	w.Init()
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	w.Stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the run did not end 2s after Stop")
+	}
+}
+
+func TestAdjust(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, peak int
+	tagged := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		tagged[r.Header.Get("X-Load")]++
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	// peakSince resets the peak and returns the one reached in d.
+	peakSince := func(d time.Duration) int {
+		mu.Lock()
+		peak = inFlight
+		mu.Unlock()
+		time.Sleep(d)
+		mu.Lock()
+		defer mu.Unlock()
+		return peak
+	}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:    req,
+		N:          1 << 30,
+		C:          2,
+		Adjustable: true,
+		Writer:     ioutil.Discard,
+	}
+	w.Init()
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := w.Adjust(Adjustment{C: 6, Header: http.Header{"X-Load": {"high"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := peakSince(300 * time.Millisecond); got != 6 {
+		t.Errorf("peak concurrency with C 6 = %d; want 6", got)
+	}
+	qps := 10.0
+	if err := w.Adjust(Adjustment{C: 1, QPS: &qps}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	before := tagged["high"]
+	mu.Unlock()
+	if got := peakSince(500 * time.Millisecond); got != 1 {
+		t.Errorf("peak concurrency with C 1 = %d; want 1", got)
+	}
+	w.Stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the run did not end 2s after Stop")
+	}
+	mu.Lock()
+	if tagged[""] == 0 || tagged["high"] == 0 {
+		t.Errorf("requests by X-Load = %v; want some before and after the adjustment", tagged)
+	}
+	// About 5 requests at 10 QPS in 500ms, rather than 100 unlimited.
+	if n := tagged["high"] - before; n > 10 {
+		t.Errorf("requests in 500ms at 10 QPS = %d; want at most 10", n)
+	}
+	mu.Unlock()
+
+	// Paused workers end a run with a number of requests once it is
+	// done.
+	w = &Work{Request: req, N: 50, C: 4, Adjustable: true, Writer: ioutil.Discard}
+	w.Init()
+	if err := w.Adjust(Adjustment{C: 1}); err != nil {
+		t.Fatal(err)
+	}
+	w.Run()
+	if n := w.Report().NumRes; n != 50 {
+		t.Errorf("total of the run with paused workers = %d; want 50", n)
+	}
+
+	ws := &Work{Request: req, N: 10, C: 1, WebSocket: true, Adjustable: true}
+	if err := ws.Adjust(Adjustment{C: 2}); err == nil {
+		t.Error("adjusting a WebSocket run succeeded; want an error")
+	}
+}
+
+func TestCSVFields(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	fields, err := report.ParseCSVFields("status, latency,error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var buf bytes.Buffer
+	w := &Work{
+		Request:   req,
+		N:         5,
+		C:         1,
+		Output:    "csv",
+		CSVFields: fields,
+		Writer:    &buf,
+	}
+	w.Run()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("Expected a schema line, a header and 5 rows, found %v lines", len(lines))
+	}
+	if lines[0] != "# schema=1" {
+		t.Errorf("Unexpected csv schema line %q", lines[0])
+	}
+	if lines[1] != "status,latency,error" {
+		t.Errorf("Unexpected csv header %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "201,") || !strings.HasSuffix(lines[2], ",") {
+		t.Errorf("Unexpected csv row %q", lines[2])
+	}
+	if _, err := report.ParseCSVFields("status,bogus"); err == nil {
+		t.Errorf("Expected an error for an unknown csv field")
+	}
+}
+
+func TestNegotiatedProtocol(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:            req,
+		N:                  4,
+		C:                  2,
+		H2:                 true,
+		InsecureSkipVerify: true,
+		Writer:             ioutil.Discard,
+	}
+	w.Run()
+	if got := w.report.Snapshot().ProtoDist["HTTP/2.0"]; got != 4 {
+		t.Errorf("Expected 4 HTTP/2.0 responses, found %v", got)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	msg := []byte{0, 0, 0, 0, 3, 0x0a, 1, 'a'}
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.ProtoMajor != 2 || r.Method != "POST" || r.URL.Path != "/demo.Demo/Call" || !bytes.Equal(body, msg) {
+			t.Errorf("got a %s %s %s call with % x", r.Proto, r.Method, r.URL.Path, body)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		switch atomic.AddInt32(&calls, 1) % 3 {
+		case 0:
+			w.Write(msg)
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		case 1:
+			w.Write(msg)
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "14")
+		case 2:
+			// A call that fails at once only sends headers.
+			w.Header().Set("Grpc-Status", "5")
+		}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		// Plain gRPC is HTTP/2 with prior knowledge.
+		var s http2.Server
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	for _, url := range []string{"http://" + l.Addr().String(), tlsServer.URL} {
+		atomic.StoreInt32(&calls, 0)
+		req, _ := http.NewRequest("POST", url+"/demo.Demo/Call", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		w := &Work{
+			Request:            req,
+			RequestBody:        msg,
+			N:                  6,
+			C:                  2,
+			GRPC:               true,
+			InsecureSkipVerify: true,
+			Writer:             ioutil.Discard,
+		}
+		w.Run()
+		s := w.report.Snapshot()
+		want := map[string]int{"OK": 2, "UNAVAILABLE": 2, "NOT_FOUND": 2}
+		if !reflect.DeepEqual(s.GRPCDist, want) || s.ProtoDist["HTTP/2.0"] != 6 {
+			t.Errorf("%s: gRPC statuses %v over %v; want %v over HTTP/2.0", url, s.GRPCDist, s.ProtoDist, want)
+		}
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	for _, tt := range []struct {
+		code   int
+		header string
+		want   string
+	}{
+		{200, "0", "OK"},
+		{200, "16", "UNAUTHENTICATED"},
+		{200, "42", "42"},
+		{404, "", "UNIMPLEMENTED"},
+		{503, "", "UNAVAILABLE"},
+		{200, "", "UNKNOWN"},
+	} {
+		resp := &http.Response{StatusCode: tt.code, Header: make(http.Header)}
+		if tt.header != "" {
+			resp.Header.Set("Grpc-Status", tt.header)
+		}
+		if got := grpcStatus(resp); got != tt.want {
+			t.Errorf("grpcStatus of a %d with status %q = %q; want %q", tt.code, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusFound)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	w := &Work{
+		Request:       req,
+		N:             4,
+		C:             2,
+		RedirectChain: true,
+		Writer:        ioutil.Discard,
+	}
+	w.Run()
+	if got := w.report.Snapshot().RedirectDist[2]; got != 4 {
+		t.Errorf("Expected 4 responses after 2 redirects, found %v", got)
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := func(code int, retryAfter string) *http.Response {
+		h := make(http.Header)
+		if retryAfter != "" {
+			h.Set("Retry-After", retryAfter)
+		}
+		return &http.Response{StatusCode: code, Header: h}
+	}
+	w := &worker{}
+	tests := []struct {
+		res  *http.Response
+		want time.Duration
+	}{
+		{res(200, ""), 0},
+		{res(429, "3"), 3 * time.Second},
+		{res(503, now.Add(5*time.Second).Format(http.TimeFormat)), 5 * time.Second},
+		{res(429, ""), time.Second},
+		{res(429, ""), 2 * time.Second},
+		{res(200, ""), 0},
+		{res(429, ""), time.Second},
+		{res(503, ""), 2 * time.Second},
+		{res(503, ""), 4 * time.Second},
+		{res(200, ""), 0},
+		{res(503, ""), time.Second},
+	}
+	for i, tt := range tests {
+		if got := w.throttled(tt.res, now); got != tt.want {
+			t.Errorf("%d: throttled = %v; want %v", i, got, tt.want)
+		}
+	}
+	// The backoff doubles up to maxBackoff.
+	var got time.Duration
+	for i := 0; i < 10; i++ {
+		got = w.throttled(res(429, ""), now)
+	}
+	if got != maxBackoff {
+		t.Errorf("throttled after 10 throttled responses = %v; want %v", got, maxBackoff)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []string
+	cb := &circuitBreaker{
+		threshold: 2,
+		cooldown:  time.Second,
+		onChange: func(from, to circuitState) {
+			transitions = append(transitions, from.String()+" -> "+to.String())
+		},
+	}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := cb.allow(now); !ok {
+			t.Fatalf("request %d rejected while closed", i)
+		}
+		cb.done(true, now)
+	}
+	if ok, wait := cb.allow(now); ok || wait != time.Second {
+		t.Errorf("allow() = %v, %v; want false, 1s", ok, wait)
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := cb.allow(now); !ok {
+		t.Fatal("probe rejected after cooldown")
+	}
+	if ok, _ := cb.allow(now); ok {
+		t.Error("second request allowed while probing")
+	}
+	cb.done(true, now)
+
+	now = now.Add(time.Second)
+	cb.allow(now)
+	cb.done(false, now)
+	if ok, _ := cb.allow(now); !ok {
+		t.Error("request rejected after successful probe")
+	}
+
+	want := []string{
+		"closed -> open",
+		"open -> half-open",
+		"half-open -> open",
+		"open -> half-open",
+		"half-open -> closed",
+	}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %q; want %q", transitions, want)
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:        req,
+		N:              2,
+		C:              1,
+		StreamStats:    true,
+		StallThreshold: 20 * time.Millisecond,
+		Writer:         ioutil.Discard,
+	}
+	w.Run()
+	s := w.Report().Streams
+	if s == nil {
+		t.Fatal("no stream stats")
+	}
+	if s.Streams != 2 || s.Chunks < 6 {
+		t.Errorf("streams = %d, chunks = %d; want 2, at least 6", s.Streams, s.Chunks)
+	}
+	if s.Stalled != 2 {
+		t.Errorf("stalled = %d; want 2", s.Stalled)
+	}
+	if s.Max < 0.02 {
+		t.Errorf("max gap = %v; want at least 20ms", s.Max)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("too short"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       3,
+		C:       1,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	r := w.Report()
+	if r.Truncated != 3 {
+		t.Errorf("truncated = %d; want 3", r.Truncated)
+	}
+	if len(r.ErrorDist) != 0 {
+		t.Errorf("errors = %v; want none", r.ErrorDist)
+	}
+}
+
+func TestUserAgents(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.UserAgent()]++
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:    req,
+		UserAgents: []string{"a/1", "b/2"},
+		N:          10,
+		C:          2,
+		Writer:     ioutil.Discard,
+	}
+	w.Run()
+	if seen["a/1"] != 5 || seen["b/2"] != 5 {
+		t.Errorf("user agents = %v; want 5 of each", seen)
+	}
+}
+
+func TestSign(t *testing.T) {
+	var mu sync.Mutex
+	var sigs []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sigs = append(sigs, r.Header.Get("X-Signature"))
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/path", nil)
+	req.Header.Set("X-B", "2")
+	req.Header.Set("X-A", "1")
+	var canonical string
+	var calls int32
+	w := &Work{
+		Request:     req,
+		RequestBody: []byte("body"),
+		N:           2,
+		C:           1,
+		Writer:      ioutil.Discard,
+		Sign: func(r *http.Request, body []byte) (http.Header, error) {
+			if atomic.AddInt32(&calls, 1) == 2 {
+				return nil, errors.New("no key")
+			}
+			canonical = string(canonicalRequest(r, body))
+			return http.Header{"X-Signature": {"sig"}}, nil
+		},
+	}
+	w.Run()
+	if len(sigs) != 1 || sigs[0] != "sig" {
+		t.Errorf("signatures = %q; want one \"sig\"", sigs)
+	}
+	want := "POST\n" + server.URL + "/path\nX-A: 1\nX-B: 2\n\nbody"
+	if canonical != want {
+		t.Errorf("canonical request = %q; want %q", canonical, want)
+	}
+	if got := w.Report().ErrorDist["no key"]; got != 1 {
+		t.Errorf("sign errors = %d; want 1", got)
+	}
+}
+
+func TestTLSResumption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, disable := range []bool{false, true} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:              req,
+			N:                    5,
+			C:                    1,
+			DisableKeepAlives:    true,
+			DisableTLSResumption: disable,
+			InsecureSkipVerify:   true,
+			Writer:               ioutil.Discard,
+		}
+		w.Run()
+		s := w.Report().TLS
+		if s == nil || s.Handshakes != 5 {
+			t.Fatalf("disable=%v: tls stats = %+v; want 5 handshakes", disable, s)
+		}
+		if disable && s.Resumed != 0 {
+			t.Errorf("resumed %d handshakes with resumption disabled", s.Resumed)
+		}
+		if !disable && s.Resumed == 0 {
+			t.Error("no handshake was resumed")
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		seen[r.Method+" "+r.URL.Path+" "+string(body)]++
+		mu.Unlock()
+		w.Write([]byte("created"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	get, _ := http.NewRequest("GET", server.URL+"/items", nil)
+	post, _ := http.NewRequest("POST", server.URL+"/cart", nil)
+	var out bytes.Buffer
+	var extracted int64
+	extract := func(res *http.Response, body []byte) {
+		if string(body) == "created" {
+			atomic.AddInt64(&extracted, 1)
+		}
+	}
+	w := &Work{
+		Groups: []*RequestGroup{
+			{Name: "browse", Request: get, ThinkTime: 10 * time.Millisecond},
+			{Name: "buy", Request: post, RequestBody: []byte("item"), Extract: extract},
+		},
+		N:         6,
+		C:         1,
+		Output:    "csv",
+		CSVFields: []string{"label"},
+		Writer:    &out,
+	}
+	start := time.Now()
+	w.Run()
+	if seen["GET /items "] != 3 || seen["POST /cart item"] != 3 {
+		t.Errorf("requests = %v; want 3 of each group", seen)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("run took %v; want at least the think time of 30ms", d)
+	}
+	labels := make(map[string]int)
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n")[2:] {
+		labels[l]++
+	}
+	if labels["browse"] != 3 || labels["buy"] != 3 {
+		t.Errorf("labels = %v; want 3 of each group", labels)
+	}
+	if extracted != 3 {
+		t.Errorf("extracted %d bodies; want the 3 of the buy group", extracted)
+	}
+}
+
+func TestThinkDist(t *testing.T) {
+	uniform := &RequestGroup{ThinkTime: 10 * time.Millisecond, ThinkDist: ThinkUniform, ThinkMax: 20 * time.Millisecond}
+	exp := &RequestGroup{ThinkTime: 10 * time.Millisecond, ThinkDist: ThinkExponential}
+	var sum time.Duration
+	spread := make(map[bool]int)
+	for i := 0; i < 10000; i++ {
+		d := uniform.think()
+		if d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("uniform think time = %v; want between 10ms and 20ms", d)
+		}
+		spread[d < 15*time.Millisecond]++
+		sum += exp.think()
+	}
+	if spread[true] < 4000 || spread[false] < 4000 {
+		t.Errorf("uniform think times below and above 15ms = %d, %d; want about 5000 each", spread[true], spread[false])
+	}
+	if mean := sum / 10000; mean < 9*time.Millisecond || mean > 11*time.Millisecond {
+		t.Errorf("mean exponential think time = %v; want about 10ms", mean)
+	}
+	if d := (&RequestGroup{ThinkTime: time.Second}).think(); d != time.Second {
+		t.Errorf("fixed think time = %v; want 1s", d)
+	}
+}
+
+func TestTLSVerifyTiming(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	server.TLS.Certificates[0].OCSPStaple = []byte("staple")
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:              req,
+		N:                    3,
+		C:                    1,
+		DisableKeepAlives:    true,
+		DisableTLSResumption: true,
+		TLSVerifyTiming:      true,
+		InsecureSkipVerify:   true,
+		Writer:               ioutil.Discard,
+	}
+	w.Run()
+	s := w.Report().TLS
+	if s == nil || s.Handshakes != 3 {
+		t.Fatalf("tls stats = %+v; want 3 handshakes", s)
+	}
+	if s.Stapled != 3 {
+		t.Errorf("stapled = %d; want 3", s.Stapled)
+	}
+	if s.Verified != 3 || s.AvgVerify <= 0 || s.MaxVerify < s.AvgVerify {
+		t.Errorf("verified = %d, average %v, max %v; want 3 timed verifications", s.Verified, s.AvgVerify, s.MaxVerify)
+	}
+}
+
+func TestIsolateGroups(t *testing.T) {
+	var slow, fast int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			atomic.AddInt64(&slow, 1)
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		atomic.AddInt64(&fast, 1)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	slowReq, _ := http.NewRequest("GET", server.URL+"/slow", nil)
+	fastReq, _ := http.NewRequest("GET", server.URL+"/fast", nil)
+	w := &Work{
+		Groups: []*RequestGroup{
+			{Name: "slow", Request: slowReq},
+			{Name: "fast", Request: fastReq},
+		},
+		IsolateGroups: true,
+		N:             1 << 20,
+		C:             2,
+		Writer:        ioutil.Discard,
+	}
+	w.Init()
+	time.AfterFunc(200*time.Millisecond, w.Stop)
+	w.Run()
+	if s, f := atomic.LoadInt64(&slow), atomic.LoadInt64(&fast); f < 10*s {
+		t.Errorf("slow %d, fast %d requests; want the fast group not to wait for the slow one", s, f)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + wsAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		brw.Flush()
+		for {
+			_, op, payload, err := wsReadFrame(brw)
+			if err != nil {
+				return
+			}
+			// Ping before echoing the message unmasked.
+			brw.Write([]byte{0x80 | wsPing, 0})
+			brw.Write(append([]byte{0x80 | op, byte(len(payload))}, payload...))
+			brw.Flush()
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	var out bytes.Buffer
+	w := &Work{
+		Request:     req,
+		RequestBody: []byte("hello"),
+		WebSocket:   true,
+		N:           10,
+		C:           2,
+		Output:      "csv",
+		CSVFields:   []string{"status", "bytes", "error"},
+		Writer:      &out,
+	}
+	w.Run()
+	rows := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(rows) != 12 {
+		t.Fatalf("got %d rows; want the schema, a header and 10 messages:\n%s", len(rows), out.String())
+	}
+	for _, row := range rows[2:] {
+		if row != "101,5," {
+			t.Errorf("row = %q; want 101,5,", row)
+		}
+	}
+}
+
+func TestAssignGroups(t *testing.T) {
+	search := &RequestGroup{Name: "search", C: 3, QPS: 10}
+	checkout := &RequestGroup{Name: "checkout", QPS: 1}
+	home := &RequestGroup{Name: "home", QPS: 2}
+	w := &Work{
+		Groups:        []*RequestGroup{search, checkout, home},
+		IsolateGroups: true,
+		C:             6,
+	}
+	var got []string
+	for _, g := range w.assignGroups() {
+		got = append(got, g.Name)
+	}
+	want := []string{"search", "search", "search", "checkout", "home", "checkout"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v; want %v", got, want)
+	}
+	if rate := w.targetRate(); rate != 34 {
+		t.Errorf("target rate = %v; want 34", rate)
+	}
+	w.IsolateGroups = false
+	if w.assignGroups() != nil || w.targetRate() != 0 {
+		t.Errorf("shared groups: groups %v, target rate %v; want none", w.assignGroups(), w.targetRate())
+	}
+}
+
+func TestOpenModel(t *testing.T) {
+	var inFlight, maxInFlight int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt64(&inFlight, -1)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		Rate:    200,
+		N:       20,
+		C:       1,
+		Writer:  ioutil.Discard,
+	}
+	start := time.Now()
+	w.Run()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("run took %v; want the requests not to wait for each other", d)
+	}
+	if m := atomic.LoadInt64(&maxInFlight); m < 5 {
+		t.Errorf("at most %d requests in flight; want at least 5", m)
+	}
+	if r := w.Report().Rate; r == nil || r.Target != 200 {
+		t.Errorf("rate stats = %+v; want a target of 200", r)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	var mu sync.Mutex
+	var offsets []time.Duration
+	start := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		offsets = append(offsets, time.Since(start))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:  req,
+		Schedule: []time.Duration{0, 0, 100 * time.Millisecond},
+		N:        10,
+		C:        2,
+		Writer:   ioutil.Discard,
+	}
+	w.Run()
+	if len(offsets) != 3 {
+		t.Fatalf("got %d requests; want one per offset of the schedule", len(offsets))
+	}
+	if last := offsets[2]; last < 100*time.Millisecond {
+		t.Errorf("last request at %v; want it at 100ms", last)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	var mu sync.Mutex
+	var bodies, ids []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		ids = append(ids, r.Header.Get("X-Request-Id"))
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	req.Header.Set("X-Request-Id", "{{uuid}}")
+	w := &Work{
+		Request:     req,
+		RequestBody: []byte("n={{seq}} r={{rand 3 5}}"),
+		Templates:   true,
+		N:           10,
+		C:           1,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if len(bodies) != 10 {
+		t.Fatalf("got %d requests; want 10", len(bodies))
+	}
+	seen := make(map[string]bool)
+	for i, b := range bodies {
+		var n, r int
+		if _, err := fmt.Sscanf(b, "n=%d r=%d", &n, &r); err != nil || n != i || r < 3 || r > 5 {
+			t.Errorf("body %d = %q; want n=%d and r between 3 and 5", i, b, i)
+		}
+		id := ids[i]
+		if len(id) != 36 || id[14] != '4' || seen[id] {
+			t.Errorf("request id %d = %q; want a new version 4 UUID", i, id)
+		}
+		seen[id] = true
+	}
+
+	for _, s := range []string{"{{nope}}", "{{rand 5 1}}", "{{rand x}}", "{{seq"} {
+		if err := CheckTemplate(s, TemplateNames{}); err == nil {
+			t.Errorf("CheckTemplate(%q) = nil; want an error", s)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		seen = append(seen, r.URL.Path+" "+r.URL.RawQuery+" "+r.Header.Get("X-Token")+" "+string(body))
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/users/{{.id}}?name={{.name}}", nil)
+	req.Header.Set("X-Token", "t{{.id}}")
+	w := &Work{
+		Request:     req,
+		RequestBody: []byte("hi {{.name}}"),
+		Templates:   true,
+		Data: &Data{
+			Columns: []string{"id", "name"},
+			Rows:    [][]string{{"1", "ann"}, {"2", "bob"}},
+		},
+		N:      4,
+		C:      1,
+		Writer: ioutil.Discard,
+	}
+	w.Run()
+	want := []string{
+		"/users/1 name=ann t1 hi ann",
+		"/users/2 name=bob t2 hi bob",
+		"/users/1 name=ann t1 hi ann",
+		"/users/2 name=bob t2 hi bob",
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("requests = %q; want %q", seen, want)
+	}
+	if err := CheckTemplate("{{.email}}", TemplateNames{Columns: []string{"id", "name"}}); err == nil {
+		t.Errorf("CheckTemplate of an unknown column = nil; want an error")
+	}
+}
+
+func TestGenerationErrors(t *testing.T) {
+	var sent int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&sent, 1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/users/{{.id}}", nil)
+	req.Header.Set("X-Name", "{{.name}}")
+	w := &Work{
+		Request:   req,
+		Templates: true,
+		Data: &Data{
+			Columns: []string{"id", "name"},
+			// The second row is short, the third has a name that is no
+			// valid header value.
+			Rows: [][]string{{"1", "ann"}, {"2"}, {"3", "b\nob"}, {"4", "dan"}},
+		},
+		N:      8,
+		C:      1,
+		Writer: ioutil.Discard,
+	}
+	w.Run()
+	g := w.Report().Generation
+	if sent != 4 || g == nil || g.Errors != 4 {
+		t.Fatalf("sent %d requests, generation errors %+v; want 4 and 4", sent, g)
+	}
+	want := []string{
+		`template: data row 2 has no value for {{.name}}`,
+		`template: invalid value "b\nob" of header X-Name`,
+	}
+	if !reflect.DeepEqual(g.Samples, want) {
+		t.Errorf("samples = %q; want %q", g.Samples, want)
+	}
+}
+
+func TestVerifyCompression(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != acceptEncoding {
+			t.Errorf("Accept-Encoding = %q; want %q", r.Header.Get("Accept-Encoding"), acceptEncoding)
+		}
+		var buf bytes.Buffer
+		switch r.URL.Path {
+		case "/gzip":
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte("hello"))
+			zw.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+		case "/deflate":
+			zw := zlib.NewWriter(&buf)
+			zw.Write([]byte("hello"))
+			zw.Close()
+			w.Header().Set("Content-Encoding", "deflate")
+		case "/broken":
+			buf.WriteString("hello")
+			w.Header().Set("Content-Encoding", "gzip")
+		case "/br":
+			buf.WriteString("hello")
+			w.Header().Set("Content-Encoding", "br")
+		default:
+			buf.WriteString("hello")
+		}
+		w.Write(buf.Bytes())
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	var groups []*RequestGroup
+	for _, name := range []string{"plain", "gzip", "deflate", "broken", "br"} {
+		req, _ := http.NewRequest("GET", server.URL+"/"+name, nil)
+		groups = append(groups, &RequestGroup{Name: name, Request: req})
+	}
+	var out bytes.Buffer
+	w := &Work{
+		Groups:            groups,
+		VerifyCompression: true,
+		N:                 5,
+		C:                 1,
+		Output:            "csv",
+		CSVFields:         []string{"label", "error_class", "bytes"},
+		Writer:            &out,
+	}
+	w.Run()
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")[2:]
+	want := []string{
+		"plain,,5",
+		"gzip,,5",
+		"deflate,,5",
+		"broken," + report.ErrorClassDecompression + ",0",
+		"br," + report.ErrorClassDecompression + ",5",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %q; want %q", got, want)
+	}
+}
+
+func TestExpectHeaders(t *testing.T) {
+	var count int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1)%2 == 0 {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS, HIT from proxy")
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		ExpectHeaders: []HeaderExpectation{
+			{Name: "x-cache", Value: regexp.MustCompile("^(?:HIT)$"), Text: "X-Cache: HIT"},
+		},
+		N:      4,
+		C:      1,
+		Writer: ioutil.Discard,
+	}
+	w.Run()
+	rep := w.Report()
+	want := map[string]int{"expectation failed: missing header X-Cache: HIT": 2}
+	if !reflect.DeepEqual(rep.ErrorDist, want) {
+		t.Errorf("errors = %v; want %v", rep.ErrorDist, want)
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	var count int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&count, 1) % 4 {
+		case 0:
+			w.Write([]byte(`{"ok":true,"items":[1]}`))
+		case 1:
+			w.Write([]byte(`{"ok":false,"error":"quota"}`))
+		case 2:
+			w.Write([]byte(`{"ok":true,"items":[]}`))
+		case 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	for _, pipeline := range []int{0, 4} {
+		atomic.StoreInt64(&count, 0)
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:            req,
+			ExpectStatus:       []int{200, 201},
+			ExpectBodyContains: []string{`"ok":true`},
+			ExpectBodyRegexps:  []*regexp.Regexp{regexp.MustCompile(`"items":\[.+\]`)},
+			Pipeline:           pipeline,
+			N:                  8,
+			C:                  1,
+			Writer:             ioutil.Discard,
+		}
+		w.Run()
+		rep := w.Report()
+		want := map[string]int{
+			report.AssertionStatus:       2,
+			report.AssertionBodyContains: 2,
+			report.AssertionBodyRegex:    2,
+		}
+		if !reflect.DeepEqual(rep.AssertionDist, want) || rep.StatusCodeDist[200] != 2 {
+			t.Errorf("pipeline=%d: assertions = %v, %d responses with 200; want %v, 2", pipeline, rep.AssertionDist, rep.StatusCodeDist[200], want)
+		}
+	}
+}
+
+func TestSaveErrors(t *testing.T) {
+	var count int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&count, 1) % 3 {
+		case 0:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		case 1:
+			w.Write([]byte(`{"ok":false}`))
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	req, _ := http.NewRequest("GET", server.URL+"/path?q=1", nil)
+	w := &Work{
+		Request:            req,
+		ExpectBodyContains: []string{"ok"},
+		SaveErrors:         dir,
+		MaxSaved:           5,
+		N:                  9,
+		C:                  1,
+		Writer:             ioutil.Discard,
+	}
+	w.Run()
+	if n, err := w.SavedErrors(); n != 5 || err != nil {
+		t.Fatalf("SavedErrors() = %d, %v; want 5 files", n, err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	want := []string{"000001-200.http", "000002-503.http", "000003-200.http", "000004-503.http", "000005-200.http"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("files = %v; want %v", names, want)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "000002-503.http"))
+	if s := string(b); !strings.HasPrefix(s, "# expectation failed") || !strings.Contains(s, "GET /path?q=1 HTTP/1.1\r\n") || !strings.Contains(s, "HTTP/1.1 503 Service Unavailable\r\n") || !strings.HasSuffix(s, "overloaded\n") {
+		t.Errorf("saved response = %q", s)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hey"},
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clients int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 && r.TLS.PeerCertificates[0].Subject.CommonName == "hey" {
+			atomic.AddInt64(&clients, 1)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs:      roots,
+		N:            4,
+		C:            2,
+		Writer:       ioutil.Discard,
+	}
+	w.Run()
+	if clients != 4 {
+		t.Errorf("%d requests presented the client certificate; want 4", clients)
+	}
+
+	// A server that is not signed by the RootCAs is rejected.
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	req, _ = http.NewRequest("GET", other.URL, nil)
+	w = &Work{Request: req, RootCAs: x509.NewCertPool(), N: 1, C: 1, Writer: ioutil.Discard}
+	w.Run()
+	if errs := w.Report().ErrorDist; len(errs) != 1 {
+		t.Errorf("errors = %v; want a certificate verification error", errs)
+	}
+}
+
+// writeClientCert writes a new self-signed client certificate with the
+// common name cn and its key to certFile and keyFile.
+func writeClientCert(t *testing.T, cn, certFile, keyFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+func TestCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writeClientCert(t, "gen1", certFile, keyFile)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var names []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		names = append(names, r.TLS.PeerCertificates[0].Subject.CommonName)
+		n := len(names)
+		mu.Unlock()
+		if n == 2 {
+			// Rotate the certificate, and wait for the reloader to
+			// check the files again.
+			writeClientCert(t, "gen2", certFile, keyFile)
+			time.Sleep(1100 * time.Millisecond)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:              req,
+		Certificates:         []tls.Certificate{cert},
+		CertFile:             certFile,
+		KeyFile:              keyFile,
+		InsecureSkipVerify:   true,
+		DisableKeepAlives:    true,
+		DisableTLSResumption: true,
+		N:                    4,
+		C:                    1,
+		Writer:               ioutil.Discard,
+	}
+	w.Run()
+	if want := []string{"gen1", "gen1", "gen2", "gen2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("the server saw the certificates %v; want %v", names, want)
+	}
+	s := w.Report()
+	want := []report.CertGeneration{{Generation: 1, Handshakes: 2}, {Generation: 2, Handshakes: 2}}
+	if s.TLS == nil || !reflect.DeepEqual(s.TLS.ClientCerts, want) {
+		t.Errorf("TLS stats = %+v; want client certificate generations %v", s.TLS, want)
+	}
+	if len(s.Events) != 1 || s.Events[0].Name != "client certificate" {
+		t.Errorf("events = %v; want one for the reload", s.Events)
+	}
+}
+
+func TestServerName(t *testing.T) {
+	names := make(chan string, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		names <- hello.ServerName
+		return nil, nil
+	}}
+	server.StartTLS()
+	defer server.Close()
+
+	// The certificate of the test server is valid for example.com.
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	tests := []struct {
+		roots    *x509.CertPool
+		name     string
+		insecure bool
+		errs     int
+	}{
+		{roots, "example.com", false, 0},
+		{roots, "example.org", false, 1},
+		{nil, "", false, 1},
+		{nil, "example.org", true, 0},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:            req,
+			RootCAs:            tt.roots,
+			ServerName:         tt.name,
+			InsecureSkipVerify: tt.insecure,
+			N:                  1,
+			C:                  1,
+			Writer:             ioutil.Discard,
+		}
+		w.Run()
+		if errs := w.Report().ErrorDist; len(errs) != tt.errs {
+			t.Errorf("%q: errors = %v; want %d", tt.name, errs, tt.errs)
+		}
+		if name := <-names; tt.name != "" && name != tt.name {
+			t.Errorf("SNI = %q; want %q", name, tt.name)
+		}
+	}
+}
+
+func TestConnDistribution(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, disable := range []bool{false, true} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:           req,
+			N:                 20,
+			C:                 2,
+			WorkerStats:       true,
+			DisableKeepAlives: disable,
+			Writer:            ioutil.Discard,
+		}
+		w.Run()
+		d := w.Report().Connections
+		if disable {
+			if d != nil {
+				t.Errorf("connections = %+v without keep-alives; want none", d)
+			}
+			continue
+		}
+		if d == nil || d.Connections < 1 || d.Connections > 2 || d.MeanRequests*float64(d.Connections) != 20 {
+			t.Errorf("connections = %+v; want 20 requests on at most 2 connections", d)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var hosts int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "hey.test:"+r.URL.Query().Get("port") {
+			atomic.AddInt64(&hosts, 1)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	req, _ := http.NewRequest("GET", "http://hey.test:"+u.Port()+"/?port="+u.Port(), nil)
+	w := &Work{
+		Request: req,
+		Resolve: map[string]string{"hey.test:" + u.Port(): u.Hostname()},
+		N:       4,
+		C:       2,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	if hosts != 4 {
+		t.Errorf("%d requests reached the server as hey.test; want 4, errors %v", hosts, w.Report().ErrorDist)
+	}
+}
+
+func TestCancelRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		query string
+		rate  float64
+		want  int
+	}{
+		{"?slow=1", 1, 4},
+		{"?slow=1", 0, 0},
+		{"", 1, 0}, // requests that complete in time are not canceled
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", server.URL+tt.query, nil)
+		w := &Work{
+			Request:     req,
+			N:           4,
+			C:           4,
+			CancelRate:  tt.rate,
+			CancelAfter: 20 * time.Millisecond,
+			Writer:      ioutil.Discard,
+		}
+		w.Run()
+		if got := w.Report().ErrorDist["canceled on purpose after 20ms"]; got != tt.want {
+			t.Errorf("%q at rate %v: canceled = %d; want %d, errors %v", tt.query, tt.rate, got, tt.want, w.Report().ErrorDist)
+		}
+	}
+}
+
+func TestFuzzRate(t *testing.T) {
+	var methods sync.Map
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods.Store(r.Method, true)
+	}))
+	server.Config.MaxHeaderBytes = 4 << 10
+	server.Start()
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		RequestBody: []byte("body"),
+		N:           200,
+		C:           4,
+		FuzzRate:    0.5,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	rep := w.Report()
+	var mutated int64
+	want := map[string]int{
+		"oversized-header":           http.StatusRequestHeaderFieldsTooLarge,
+		"invalid-header":             http.StatusBadRequest,
+		"invalid-content-length":     http.StatusBadRequest,
+		"conflicting-content-length": http.StatusBadRequest,
+		"unknown-method":             http.StatusOK,
+		"invalid-method":             http.StatusBadRequest,
+	}
+	for _, m := range rep.Mutations {
+		mutated += m.Requests
+		if code, ok := want[m.Mutation]; !ok || m.StatusCodeDist[code] != int(m.Requests) {
+			t.Errorf("%s: status codes %v, errors %v; want %d", m.Mutation, m.StatusCodeDist, m.ErrorDist, code)
+		}
+	}
+	if mutated == 0 || mutated+rep.NumRes != 200 || rep.StatusCodeDist[200] != int(rep.NumRes) {
+		t.Errorf("%d mutated and %d other requests, status codes %v; want 200 in total, the others successful", mutated, rep.NumRes, rep.StatusCodeDist)
+	}
+	if _, ok := methods.Load("FUZZ"); len(rep.Mutations) == len(want) && !ok {
+		t.Errorf("the server did not see the unknown method")
+	}
+}
+
+func TestHeaderOrder(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	heads := make(chan string, 10)
+	var conns int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&conns, 1)
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					var head []string
+					for {
+						l, err := br.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if l == "\r\n" {
+							break
+						}
+						head = append(head, strings.TrimSpace(l))
+					}
+					heads <- strings.Join(head, "|")
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				}
+			}()
+		}
+	}()
+
+	req, _ := http.NewRequest("GET", "http://"+ln.Addr().String()+"/path?q=1", nil)
+	req.Header.Set("X-b", "2")
+	req.Header.Set("x-A", "1")
+	req.Header.Set("User-Agent", "hey")
+	w := &Work{
+		Request:     req,
+		HeaderOrder: []string{"X-b", "HOST", "x-A"},
+		N:           3,
+		C:           1,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if errs := w.Report().ErrorDist; len(errs) > 0 {
+		t.Fatalf("errors = %v", errs)
+	}
+	want := "GET /path?q=1 HTTP/1.1|X-b: 2|HOST: " + ln.Addr().String() + "|x-A: 1|User-Agent: hey"
+	for i := 0; i < 3; i++ {
+		if got := <-heads; got != want {
+			t.Errorf("request %d = %q; want %q", i, got, want)
+		}
+	}
+	if conns != 1 {
+		t.Errorf("%d connections; want 1 reused by all requests", conns)
+	}
+}
+
+func TestParseMetrics(t *testing.T) {
+	const metrics = `# HELP process_cpu_seconds_total Total CPU time.
+# TYPE process_cpu_seconds_total counter
+process_cpu_seconds_total 12.5
+# TYPE queue_depth gauge
+queue_depth{queue="orders"} 3
+queue_depth{queue="mail",region="a}b"} 7 1600000000000
+other 1
+`
+	values, counters, err := parseMetrics(strings.NewReader(metrics), map[string]bool{"process_cpu_seconds_total": true, "queue_depth": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		"process_cpu_seconds_total":              12.5,
+		`queue_depth{queue="orders"}`:            3,
+		`queue_depth{queue="mail",region="a}b"}`: 7,
+	}
+	if !reflect.DeepEqual(values, want) || !reflect.DeepEqual(counters, map[string]bool{"process_cpu_seconds_total": true}) {
+		t.Errorf("parseMetrics() = %v, counters %v; want %v", values, counters, want)
+	}
+}
+
+func TestScrape(t *testing.T) {
+	var scrapes int64
+	metrics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&scrapes, 1)
+		fmt.Fprintf(w, "# TYPE requests_total counter\nrequests_total %d\nqueue_depth 4\n", n*100)
+	}))
+	defer metrics.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		Scrape: &Scrape{
+			URL:      metrics.URL,
+			Metrics:  []string{"requests_total", "queue_depth"},
+			Interval: 20 * time.Millisecond,
+		},
+		N:      40,
+		C:      1,
+		Writer: ioutil.Discard,
+	}
+	w.Run()
+	samples := w.Report().TargetMetrics
+	if len(samples) < 3 {
+		t.Fatalf("got %d target samples; want at least 3", len(samples))
+	}
+	if _, ok := samples[0].Values["rate(requests_total)"]; ok || samples[0].Values["queue_depth"] != 4 {
+		t.Errorf("first sample = %v; want only queue_depth 4", samples[0].Values)
+	}
+	if r := samples[1].Values["rate(requests_total)"]; r <= 0 || samples[1].Rps <= 0 || samples[1].P95 < 0.005 {
+		t.Errorf("second sample = %+v; want a positive rate and the client latency", samples[1])
+	}
+}
+
+func TestEnableCookies(t *testing.T) {
+	var sessions int64
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: strconv.FormatInt(atomic.AddInt64(&sessions, 1), 10)})
+			return
+		}
+		mu.Lock()
+		seen[c.Value]++
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	for _, rate := range []float64{0, 1000} {
+		atomic.StoreInt64(&sessions, 0)
+		seen = make(map[string]int)
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:       req,
+			EnableCookies: true,
+			Rate:          rate,
+			N:             12,
+			C:             3,
+			Writer:        ioutil.Discard,
+		}
+		w.Run()
+		// Every worker logs in once and keeps its session. In the open
+		// model requests of a worker may overlap, so it can log in more
+		// than once.
+		if n := atomic.LoadInt64(&sessions); n < 3 || rate == 0 && n != 3 {
+			t.Errorf("rate=%v: %d sessions; want one per worker", rate, n)
+		}
+		var reused int
+		for _, n := range seen {
+			reused += n
+		}
+		if reused != 12-int(atomic.LoadInt64(&sessions)) {
+			t.Errorf("rate=%v: %d requests with a session; want all but the logins", rate, reused)
+		}
+	}
+}
+
+func TestEmulateNetwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	const rtt = 40 * time.Millisecond
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		Network: &NetworkProfile{RTT: rtt},
+		N:       4,
+		C:       1,
+		Writer:  ioutil.Discard,
+	}
+	start := time.Now()
+	w.Run()
+	// Connecting takes a round trip, and so does every request.
+	if d := time.Since(start); d < 5*rtt {
+		t.Errorf("run took %v; want at least %v", d, 5*rtt)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	var logins, measured int64
+	var mu sync.Mutex
+	tokens := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			if r.FormValue("user") != "ann" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, "tok%d", atomic.AddInt64(&logins, 1))
+			return
+		}
+		atomic.AddInt64(&measured, 1)
+		mu.Lock()
+		tokens[r.Header.Get("Authorization")]++
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	newWork := func(user string) *Work {
+		login, _ := http.NewRequest("POST", server.URL+"/login", nil)
+		login.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Authorization", "Bearer {{setup token}}")
+		return &Work{
+			Request: req,
+			Setup: []SetupStep{{
+				Name:        "login",
+				Request:     login,
+				RequestBody: []byte("user=" + user),
+				Capture: map[string]func(*http.Response, []byte) (string, bool){
+					"token": func(_ *http.Response, body []byte) (string, bool) {
+						return string(body), len(body) > 0
+					},
+				},
+			}},
+			Templates: true,
+			N:         12,
+			C:         3,
+			Writer:    ioutil.Discard,
+		}
+	}
+
+	w := newWork("ann")
+	if err := w.RunSetup(); err != nil {
+		t.Fatalf("RunSetup() = %v", err)
+	}
+	w.Run()
+	// Every worker logs in once, before the run, and uses its token.
+	if n := atomic.LoadInt64(&logins); n != 3 {
+		t.Errorf("%d logins; want 3", n)
+	}
+	want := map[string]int{"Bearer tok1": 4, "Bearer tok2": 4, "Bearer tok3": 4}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("requests per token = %v; want %v", tokens, want)
+	}
+	if n := len(w.Report().Lats); n != 12 {
+		t.Errorf("%d requests reported; want 12", n)
+	}
+
+	atomic.StoreInt64(&measured, 0)
+	w = newWork("bob")
+	w.Run()
+	if err := w.RunSetup(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("RunSetup() = %v; want a 401 error", err)
+	}
+	if n := atomic.LoadInt64(&measured); n != 0 {
+		t.Errorf("%d requests after a failed setup; want 0", n)
+	}
+}
+
+func TestDNSChaos(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// Lookups of a host that does not exist succeed when they flap to
+	// the address of the server.
+	req, _ := http.NewRequest("GET", "http://hey.invalid:"+port, nil)
+	w := &Work{
+		Request:           req,
+		DNSChaos:          &DNSChaos{FlapRate: 1, Alternates: []string{"127.0.0.1"}},
+		DisableKeepAlives: true,
+		N:                 10,
+		C:                 2,
+		Writer:            ioutil.Discard,
+	}
+	w.Run()
+	if r := w.Report(); len(r.ErrorDist) != 0 || len(r.Lats) != 10 {
+		t.Errorf("flapping lookups: errors %v, %d successes; want 10 successes", r.ErrorDist, len(r.Lats))
+	}
+	if failed, flapped := w.DNSFaults(); failed != 0 || flapped != 10 {
+		t.Errorf("DNSFaults() = %d, %d; want 0, 10", failed, flapped)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:"+port, nil)
+	w = &Work{
+		Request:           req,
+		DNSChaos:          &DNSChaos{FailRate: 0.5},
+		DisableKeepAlives: true,
+		N:                 40,
+		C:                 2,
+		Writer:            ioutil.Discard,
+	}
+	w.Run()
+	var errs int
+	for msg, n := range w.Report().ErrorDist {
+		if !strings.Contains(msg, "injected lookup failure") {
+			t.Errorf("unexpected error %q", msg)
+		}
+		errs += n
+	}
+	if failed, flapped := w.DNSFaults(); int(failed) != errs || flapped != 0 || failed == 0 || failed == 40 {
+		t.Errorf("DNSFaults() = %d, %d with %d failed requests; want some failures and no flaps", failed, flapped, errs)
+	}
+}
+
+func TestCaptureChain(t *testing.T) {
+	var ids int64
+	var mu sync.Mutex
+	var created, fetched []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			id := strconv.FormatInt(atomic.AddInt64(&ids, 1), 10)
+			mu.Lock()
+			created = append(created, id)
+			mu.Unlock()
+			fmt.Fprint(w, id)
+			return
+		}
+		mu.Lock()
+		fetched = append(fetched, strings.TrimPrefix(r.URL.Path, "/items/"))
+		mu.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	create, _ := http.NewRequest("POST", server.URL+"/items", nil)
+	get, _ := http.NewRequest("GET", server.URL+"/items/{{capture id}}", nil)
+	w := &Work{
+		Groups: []*RequestGroup{
+			{
+				Name:    "create",
+				Request: create,
+				Capture: map[string]func(*http.Response, []byte) (string, bool){
+					"id": func(_ *http.Response, body []byte) (string, bool) {
+						return string(body), len(body) > 0
+					},
+				},
+			},
+			{Name: "get", Request: get},
+		},
+		Templates: true,
+		N:         12,
+		C:         3,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	// Every worker fetches the item it created right before.
+	sort.Strings(created)
+	sort.Strings(fetched)
+	if len(created) != 6 || !reflect.DeepEqual(created, fetched) {
+		t.Errorf("fetched items %v; want the created items %v", fetched, created)
+	}
+}
+
+func TestWeightedGroups(t *testing.T) {
+	var reads, writes int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			atomic.AddInt64(&writes, 1)
+		} else {
+			atomic.AddInt64(&reads, 1)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	read, _ := http.NewRequest("GET", server.URL, nil)
+	write, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{
+		Groups: []*RequestGroup{
+			{Name: "read", Request: read, Weight: 9},
+			// Weighs 1.
+			{Name: "write", Request: write},
+		},
+		N:      2000,
+		C:      4,
+		Writer: ioutil.Discard,
+	}
+	w.Run()
+	// The share of writes is binomial with a standard deviation of
+	// about 13 requests.
+	if r, wr := atomic.LoadInt64(&reads), atomic.LoadInt64(&writes); r+wr != 2000 || wr < 140 || wr > 260 {
+		t.Errorf("%d reads and %d writes; want about 1800 and 200", r, wr)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// RotatingFile is an io.WriteCloser that writes output to a file and
+// starts a new one when the current file grows beyond MaxSize bytes or
+// has been open for longer than MaxAge. Files are only rotated at line
+// boundaries, so no CSV row is split across two files.
+//
+// The first file is written to Path, later ones to Path.1, Path.2 and so
+// on. When Compress is set every file is gzipped and gets a ".gz" suffix.
+type RotatingFile struct {
+	// Path is the name of the first output file.
+	Path string
+
+	// MaxSize is the number of uncompressed bytes after which a new file
+	// is started. Zero disables size based rotation.
+	MaxSize int64
+
+	// MaxAge is the duration after which a new file is started.
+	// Zero disables time based rotation.
+	MaxAge time.Duration
+
+	// Compress enables gzip compression of the output files.
+	Compress bool
+
+	// KeepHeader repeats the first line of the output, with the
+	// comment lines starting with # before it, at the top of every
+	// rotated file, e.g. the CSV schema and header.
+	KeepHeader bool
+
+	f      *os.File
+	buf    *bufio.Writer
+	gz     *gzip.Writer
+	w      io.Writer
+	size   int64
+	opened time.Time
+	seq    int
+
+	header     []byte
+	headerDone bool
+}
+
+// Write writes p to the current file, rotating it as needed.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if r.w == nil {
+			if err := r.open(); err != nil {
+				return n - len(p), err
+			}
+		}
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i+1]
+		}
+		if r.KeepHeader && !r.headerDone {
+			r.header = append(r.header, chunk...)
+			if i >= 0 {
+				line := r.header[bytes.LastIndexByte(r.header[:len(r.header)-1], '\n')+1:]
+				r.headerDone = line[0] != '#'
+			}
+		}
+		if _, err := r.w.Write(chunk); err != nil {
+			return n - len(p), err
+		}
+		r.size += int64(len(chunk))
+		p = p[len(chunk):]
+		if i >= 0 && r.full() {
+			if err := r.close(); err != nil {
+				return n - len(p), err
+			}
+			r.seq++
+		}
+	}
+	return n, nil
+}
+
+// Open creates the first file, which is otherwise created by the first
+// Write, e.g. to report an invalid Path before a run.
+func (r *RotatingFile) Open() error {
+	if r.w != nil {
+		return nil
+	}
+	return r.open()
+}
+
+// Close flushes and closes the current file.
+func (r *RotatingFile) Close() error {
+	return r.close()
+}
+
+func (r *RotatingFile) full() bool {
+	if r.MaxSize > 0 && r.size >= r.MaxSize {
+		return true
+	}
+	return r.MaxAge > 0 && time.Since(r.opened) >= r.MaxAge
+}
+
+func (r *RotatingFile) name() string {
+	name := r.Path
+	if r.seq > 0 {
+		name = fmt.Sprintf("%s.%d", name, r.seq)
+	}
+	if r.Compress {
+		name += ".gz"
+	}
+	return name
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.Create(r.name())
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.buf = bufio.NewWriter(f)
+	r.w = r.buf
+	if r.Compress {
+		r.gz = gzip.NewWriter(r.buf)
+		r.w = r.gz
+	}
+	r.size = 0
+	r.opened = time.Now()
+	if r.seq > 0 && r.headerDone && len(r.header) > 0 {
+		if _, err := r.w.Write(r.header); err != nil {
+			return err
+		}
+		r.size += int64(len(r.header))
+	}
+	return nil
+}
+
+func (r *RotatingFile) close() error {
+	if r.f == nil {
+		return nil
+	}
+	var err error
+	if r.gz != nil {
+		err = r.gz.Close()
+	}
+	if ferr := r.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f, r.buf, r.gz, r.w = nil, nil, nil, nil
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.csv")
+	r := &RotatingFile{Path: path, MaxSize: 15, Compress: true, KeepHeader: true}
+	// Writes do not have to line up with line boundaries.
+	for _, s := range []string{"# v1\nhe", "ad\n1234", "5678\n", "abc", "\nxyz\n"} {
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path + ".gz":   "# v1\nhead\n12345678\n",
+		path + ".1.gz": "# v1\nhead\nabc\nxyz\n",
+	}
+	for name, content := range want {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(gz)
+		f.Close()
+		if string(got) != content {
+			t.Errorf("%v = %q; want %q", name, got, content)
+		}
+	}
+}
+
+func TestRotatingFileOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &RotatingFile{Path: filepath.Join(dir, "missing", "out.csv")}
+	if err := r.Open(); err == nil {
+		t.Error("Open in a missing directory = nil error; want an error")
+	}
+	path := filepath.Join(dir, "out.csv")
+	r = &RotatingFile{Path: path}
+	if err := r.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Open did not create the file: %v", err)
+	}
+	r.Write([]byte("a\n"))
+	// Opening again keeps the current file.
+	if err := r.Open(); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("b\n"))
+	r.Close()
+	if got, _ := ioutil.ReadFile(path); string(got) != "a\nb\n" {
+		t.Errorf("%v = %q; want %q", path, got, "a\nb\n")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// Scrape configures the scraping of the Prometheus metrics of the system
+// under test during the run, to report them along with the latency.
+type Scrape struct {
+	// URL is the metrics endpoint, e.g. http://host:9100/metrics.
+	URL string
+
+	// Metrics are the names of the metrics to keep, with all their
+	// series. Counters are reported as their rate per second.
+	Metrics []string
+
+	// Interval is the time between two scrapes.
+	Interval time.Duration
+}
+
+// scraper scrapes the metrics of a Scrape into the report of a run.
+type scraper struct {
+	*Scrape
+	client  *http.Client
+	names   map[string]bool
+	stop    chan struct{}
+	stopped chan struct{}
+
+	// prev are the counter values of the previous scrape at last.
+	prev map[string]float64
+	last time.Time
+}
+
+// startScraper scrapes s every interval until stop is called.
+func (b *Work) startScraper(s *Scrape) *scraper {
+	sc := &scraper{
+		Scrape:  s,
+		client:  &http.Client{Timeout: s.Interval},
+		names:   make(map[string]bool),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, name := range s.Metrics {
+		sc.names[name] = true
+	}
+	go func() {
+		defer close(sc.stopped)
+		t := time.NewTicker(s.Interval)
+		defer t.Stop()
+		failing := false
+		for {
+			select {
+			case <-t.C:
+			case <-sc.stop:
+				return
+			}
+			values, err := sc.scrape(time.Now())
+			if err != nil {
+				// Only report the first of consecutive failures.
+				if !failing {
+					b.report.AddEvent(report.Event{Offset: now() - b.start, Name: "scrape", Detail: err.Error()})
+				}
+				failing = true
+				continue
+			}
+			failing = false
+			b.report.AddTargetSample(report.TargetSample{Offset: now() - b.start, Values: values})
+		}
+	}()
+	return sc
+}
+
+func (sc *scraper) close() {
+	close(sc.stop)
+	<-sc.stopped
+}
+
+// scrape returns the values of the selected series at t, with counters
+// replaced by their rate since the previous scrape, named rate(series).
+func (sc *scraper) scrape(t time.Time) (map[string]float64, error) {
+	res, err := sc.client.Get(sc.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", sc.URL, res.Status)
+	}
+	values, counters, err := parseMetrics(res.Body, sc.names)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", sc.URL, err)
+	}
+	prev := make(map[string]float64)
+	for series := range counters {
+		v := values[series]
+		delete(values, series)
+		prev[series] = v
+		if p, ok := sc.prev[series]; ok && v >= p {
+			values["rate("+series+")"] = (v - p) / t.Sub(sc.last).Seconds()
+		}
+	}
+	sc.prev, sc.last = prev, t
+	return values, nil
+}
+
+// parseMetrics parses metrics in the Prometheus text format from r and
+// returns the values of the series of the metrics in names, keyed by
+// the series as written, e.g. `queue_depth{queue="orders"}`, and which
+// of them are counters.
+func parseMetrics(r io.Reader, names map[string]bool) (map[string]float64, map[string]bool, error) {
+	values := make(map[string]float64)
+	counters := make(map[string]bool)
+	types := make(map[string]string)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			// # TYPE name type
+			if f := strings.Fields(line); len(f) == 4 && f[1] == "TYPE" {
+				types[f[2]] = f[3]
+			}
+			continue
+		}
+		end := strings.IndexAny(line, "{ ")
+		if end < 0 {
+			continue
+		}
+		name := line[:end]
+		if !names[name] {
+			continue
+		}
+		if line[end] == '{' {
+			if i := strings.LastIndex(line, "}"); i > end {
+				end = i + 1
+			}
+		}
+		f := strings.Fields(line[end:])
+		if len(f) == 0 {
+			return nil, nil, fmt.Errorf("no value for %s", line)
+		}
+		v, err := strconv.ParseFloat(f[0], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value of %s: %v", line[:end], err)
+		}
+		series := line[:end]
+		values[series] = v
+		if types[name] == "counter" || types[strings.TrimSuffix(name, "_total")] == "counter" {
+			counters[series] = true
+		}
+	}
+	return values, counters, s.Err()
+}
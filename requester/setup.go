@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// SetupStep is a request every worker makes once before the run, e.g.
+// to log in. Its response is not part of the results.
+type SetupStep struct {
+	// Name identifies the step in errors.
+	Name string
+
+	// Request is the request to be made.
+	Request *http.Request
+
+	// RequestBody is the body of the request.
+	RequestBody []byte
+
+	// Capture maps the names of the values to keep from the response
+	// to the functions that extract them from the response and its body,
+	// e.g. a session token. The templates of the requests of the worker
+	// refer to them as {{setup name}}. A value that cannot be extracted
+	// fails the step.
+	Capture map[string]func(res *http.Response, body []byte) (string, bool)
+}
+
+// RunSetup makes the Setup steps for every worker, concurrently, and
+// returns the first error. Run calls it unless it was called before,
+// and makes no requests if it fails.
+func (b *Work) RunSetup() error {
+	b.Init()
+	b.setupOnce.Do(func() {
+		if len(b.Setup) == 0 {
+			return
+		}
+		b.workers = make([]*worker, b.C)
+		errs := make(chan error, b.C)
+		for i := range b.workers {
+			w := &worker{id: i}
+			b.workers[i] = w
+			go func() {
+				errs <- b.setUp(b.workerClient(b.client, w), w)
+			}()
+		}
+		for range b.workers {
+			if err := <-errs; err != nil && b.setupErr == nil {
+				b.setupErr = err
+			}
+		}
+	})
+	return b.setupErr
+}
+
+// setUp makes the setup steps for w with client and keeps the values
+// they capture. It stops at the first step that fails or gets a
+// response status other than 2xx.
+func (b *Work) setUp(client *http.Client, w *worker) error {
+	w.setup = make(map[string]string)
+	for _, s := range b.Setup {
+		req := cloneRequest(s.Request, s.RequestBody)
+		res, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("%s: %s %s: %s", s.Name, req.Method, req.URL, res.Status)
+		}
+		for name, capture := range s.Capture {
+			v, ok := capture(res, body)
+			if !ok {
+				return fmt.Errorf("%s: no value to capture for %q", s.Name, name)
+			}
+			w.setup[name] = v
+		}
+	}
+	return nil
+}
+
+// newWorker returns worker i of the run, as set up by RunSetup if there
+// are setup steps. groups, if set, assigns the workers their group.
+func (b *Work) newWorker(i int, groups []*RequestGroup) *worker {
+	w := &worker{id: i}
+	if i < len(b.workers) {
+		w = b.workers[i]
+	}
+	if groups != nil {
+		w.group = groups[i]
+	}
+	return w
+}
+
+// captures returns the names of the values the setup steps capture.
+func (b *Work) captures() []string {
+	var names []string
+	for _, s := range b.Setup {
+		for name := range s.Capture {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// CommandSigner returns a function for Work.Sign that runs an external
+// command for every request. The command line is split on white space,
+// no shell is involved.
+//
+// The canonical representation of the request is written to the
+// standard input of the command:
+//
+//	METHOD
+//	URL
+//	Header-Name: value
+//	...
+//	<empty line>
+//	body
+//
+// Headers are in canonical form and sorted by name, one line per value.
+// The command writes the headers to attach to its standard output, one
+// "Name: value" per line. A non-zero exit status fails the request.
+func CommandSigner(cmdline string) (func(*http.Request, []byte) (http.Header, error), error) {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty sign command")
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request, body []byte) (http.Header, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = bytes.NewReader(canonicalRequest(req, body))
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("sign command: %v: %s", err, msg)
+			}
+			return nil, fmt.Errorf("sign command: %v", err)
+		}
+		return parseHeaderLines(&stdout)
+	}, nil
+}
+
+// canonicalRequest returns the representation of req that is passed to
+// a sign command.
+func canonicalRequest(req *http.Request, body []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n%s\n", req.Method, req.URL)
+	if req.Host != "" && req.Host != req.URL.Host {
+		fmt.Fprintf(&buf, "Host: %s\n", req.Host)
+	}
+	names := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range req.Header[k] {
+			fmt.Fprintf(&buf, "%s: %s\n", k, v)
+		}
+	}
+	buf.WriteByte('\n')
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// parseHeaderLines parses "Name: value" lines, skipping empty ones.
+func parseHeaderLines(b *bytes.Buffer) (http.Header, error) {
+	h := make(http.Header)
+	sc := bufio.NewScanner(b)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("sign command: invalid header line %q", line)
+		}
+		h.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+	return h, sc.Err()
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"time"
+)
+
+// readChunks reads r until EOF and returns the number of bytes read and
+// the time between consecutive reads that returned data, starting from
+// the call. Over a streaming response, each read roughly corresponds to
+// a chunk arriving from the server.
+func readChunks(r io.Reader) (int64, []time.Duration, error) {
+	var (
+		read int64
+		gaps []time.Duration
+		buf  = make([]byte, 32*1024)
+		last = now()
+	)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			t := now()
+			gaps = append(gaps, t-last)
+			last = t
+			read += int64(n)
+		}
+		if err == io.EOF {
+			return read, gaps, nil
+		}
+		if err != nil {
+			return read, gaps, err
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A template is a text with placeholders that are expanded for every
+// request, see Work.Templates.
+type template struct {
+	parts []templatePart
+}
+
+// templatePart is either a literal text or a placeholder.
+type templatePart struct {
+	lit    string
+	expand func(ctx *templateContext, buf *bytes.Buffer) error
+}
+
+// templateContext holds the values placeholders of a request expand to.
+type templateContext struct {
+	seq uint64
+	// row is the data row of the request and rowNum its number,
+	// counting from 1.
+	row      []string
+	rowNum   int
+	setup    map[string]string
+	captured map[string]string
+}
+
+// TemplateNames are the names the placeholders of templates refer to.
+type TemplateNames struct {
+	// Columns are the data columns of {{.name}}.
+	Columns []string
+
+	// Setup are the values captured by the setup steps, {{setup name}}.
+	Setup []string
+
+	// Captures are the values captured by the request groups,
+	// {{capture name}}.
+	Captures []string
+}
+
+// CheckTemplate reports whether s is a valid template whose
+// placeholders refer to the given names, see Work.Templates.
+func CheckTemplate(s string, names TemplateNames) error {
+	_, err := parseTemplate(s, names)
+	return err
+}
+
+// parseTemplate parses s. It returns nil if s has no placeholders.
+func parseTemplate(s string, names TemplateNames) (*template, error) {
+	if !strings.Contains(s, "{{") {
+		return nil, nil
+	}
+	t := &template{}
+	for s != "" {
+		i := strings.Index(s, "{{")
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{lit: s})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, templatePart{lit: s[:i]})
+		}
+		j := strings.Index(s[i:], "}}")
+		if j < 0 {
+			return nil, fmt.Errorf("template: unclosed placeholder in %q", s)
+		}
+		expand, err := parsePlaceholder(strings.Fields(s[i+2:i+j]), names)
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, templatePart{expand: expand})
+		s = s[i+j+2:]
+	}
+	return t, nil
+}
+
+func parsePlaceholder(f []string, names TemplateNames) (func(*templateContext, *bytes.Buffer) error, error) {
+	if len(f) == 0 {
+		return nil, fmt.Errorf("template: empty placeholder")
+	}
+	switch {
+	case strings.HasPrefix(f[0], ".") && len(f) == 1:
+		for col, name := range names.Columns {
+			if name == f[0][1:] {
+				return func(ctx *templateContext, buf *bytes.Buffer) error {
+					if col >= len(ctx.row) {
+						return fmt.Errorf("template: data row %d has no value for {{%s}}", ctx.rowNum, f[0])
+					}
+					buf.WriteString(ctx.row[col])
+					return nil
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("template: no data column %q in {{%s}}", f[0][1:], f[0])
+	case f[0] == "setup" && len(f) == 2:
+		for _, name := range names.Setup {
+			if name == f[1] {
+				return func(ctx *templateContext, buf *bytes.Buffer) error {
+					v, ok := ctx.setup[name]
+					if !ok {
+						return fmt.Errorf("template: no value set up for {{setup %s}}", name)
+					}
+					buf.WriteString(v)
+					return nil
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("template: no setup capture %q in {{%s}}", f[1], strings.Join(f, " "))
+	case f[0] == "capture" && len(f) == 2:
+		for _, name := range names.Captures {
+			if name == f[1] {
+				return func(ctx *templateContext, buf *bytes.Buffer) error {
+					v, ok := ctx.captured[name]
+					if !ok {
+						return fmt.Errorf("template: no value captured for {{capture %s}} yet", name)
+					}
+					buf.WriteString(v)
+					return nil
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("template: no group capture %q in {{%s}}", f[1], strings.Join(f, " "))
+	case f[0] == "uuid" && len(f) == 1:
+		return func(_ *templateContext, buf *bytes.Buffer) error {
+			buf.WriteString(newUUID())
+			return nil
+		}, nil
+	case f[0] == "seq" && len(f) == 1:
+		return func(ctx *templateContext, buf *bytes.Buffer) error {
+			buf.WriteString(strconv.FormatUint(ctx.seq, 10))
+			return nil
+		}, nil
+	case f[0] == "timestamp" && len(f) == 1:
+		return func(_ *templateContext, buf *bytes.Buffer) error {
+			buf.WriteString(strconv.FormatInt(time.Now().Unix(), 10))
+			return nil
+		}, nil
+	case f[0] == "rand" && len(f) == 3:
+		lo, err1 := strconv.ParseInt(f[1], 10, 64)
+		hi, err2 := strconv.ParseInt(f[2], 10, 64)
+		if err1 != nil || err2 != nil || hi < lo {
+			return nil, fmt.Errorf("template: invalid range in {{%s}}", strings.Join(f, " "))
+		}
+		return func(_ *templateContext, buf *bytes.Buffer) error {
+			buf.WriteString(strconv.FormatInt(lo+mrand.Int63n(hi-lo+1), 10))
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf("template: unknown placeholder {{%s}}", strings.Join(f, " "))
+}
+
+// execute returns the expansion of t for ctx, or an error if a
+// placeholder has no value for ctx.
+func (t *template) execute(ctx *templateContext) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, p := range t.parts {
+		if p.expand == nil {
+			buf.WriteString(p.lit)
+		} else if err := p.expand(ctx, &buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,41 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
+
+// verifyChain verifies the certificate chain of cs against the system
+// roots, as a client that does not skip verification would, and returns
+// the time it took. Verification errors are ignored, the time is what
+// matters when comparing TLS termination configurations.
+func verifyChain(cs tls.ConnectionState) time.Duration {
+	if len(cs.PeerCertificates) == 0 {
+		return 0
+	}
+	start := time.Now()
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	cs.PeerCertificates[0].Verify(opts)
+	return time.Since(start)
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// worker holds the state of a single worker.
+type worker struct {
+	id int
+
+	// group, if set, is the only request group the worker makes
+	// requests of.
+	group *RequestGroup
+
+	// setup holds the values captured by the setup steps of the worker.
+	setup map[string]string
+
+	// jar is the cookie jar of the worker with EnableCookies.
+	jar http.CookieJar
+
+	// next is the index of the next group of a chain of groups.
+	next uint64
+
+	mu sync.Mutex
+	// backoff is the next backoff if the server throttles the worker
+	// without a Retry-After header.
+	backoff time.Duration
+	// captured holds the values captured from the responses of the
+	// worker, see RequestGroup.Capture.
+	captured map[string]string
+}
+
+// capture keeps the values capture extracts from res and its body.
+func (w *worker) capture(capture map[string]func(*http.Response, []byte) (string, bool), res *http.Response, body []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, extract := range capture {
+		if v, ok := extract(res, body); ok {
+			if w.captured == nil {
+				w.captured = make(map[string]string)
+			}
+			w.captured[name] = v
+		}
+	}
+}
+
+// values returns a copy of the captured values of the worker.
+func (w *worker) values() map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	values := make(map[string]string, len(w.captured))
+	for k, v := range w.captured {
+		values[k] = v
+	}
+	return values
+}
+
+// throttled returns how long the worker should back off after res.
+// It is zero unless the server responded with 429 Too Many Requests or
+// 503 Service Unavailable.
+func (w *worker) throttled(res *http.Response, now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		w.backoff = 0
+		return 0
+	}
+	if d, ok := parseRetryAfter(res.Header.Get("Retry-After"), now); ok {
+		return d
+	}
+	// Back off exponentially while the server keeps throttling.
+	if w.backoff == 0 {
+		w.backoff = minBackoff
+	} else if w.backoff < maxBackoff/2 {
+		w.backoff *= 2
+	} else {
+		w.backoff = maxBackoff
+	}
+	return w.backoff
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either
+// a number of seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
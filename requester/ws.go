@@ -0,0 +1,276 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// WebSocket opcodes, see RFC 6455 section 5.2.
+const (
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xa
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// errWSClosed is returned when the server closes the WebSocket.
+var errWSClosed = errors.New("websocket: closed by the server")
+
+// runWSWorker sends n messages for w over a WebSocket connection and
+// records the time until the next message from the server, the round
+// trip time. A new connection is opened when the previous one failed.
+func (b *Work) runWSWorker(n int, w *worker) {
+	var throttle <-chan time.Time
+	if b.QPS > 0 {
+		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond)
+	}
+
+	var conn net.Conn
+	var br *bufio.Reader
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		// Check if application is stopped. Do not send into a closed channel.
+		select {
+		case <-b.stopCh:
+			return
+		default:
+		}
+
+		var lag time.Duration
+		if b.QPS > 0 {
+			lag = time.Since(<-throttle)
+		}
+		start, s := time.Now(), now()
+		var connDuration time.Duration
+		if conn == nil {
+			c, r, err := b.wsConnect()
+			if err != nil {
+				b.record(&report.Result{Start: start, Offset: s, Duration: now() - s, Err: err, Worker: w.id, Lag: lag})
+				continue
+			}
+			conn, br = c, r
+			connDuration = now() - s
+		}
+		if b.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(time.Duration(b.Timeout) * time.Second))
+		}
+		ws := now()
+		msg, err := b.wsRoundTrip(conn, br)
+		res := &report.Result{
+			Start:         start,
+			Offset:        s,
+			Duration:      now() - ws,
+			ConnDuration:  connDuration,
+			Err:           err,
+			Worker:        w.id,
+			Lag:           lag,
+			BytesRead:     int64(len(msg)),
+			ContentLength: int64(len(msg)),
+		}
+		if err == nil {
+			res.StatusCode = http.StatusSwitchingProtocols
+		} else {
+			conn.Close()
+			conn = nil
+		}
+		b.record(res)
+	}
+}
+
+// wsConnect opens a connection to the request URL, a ws or wss URL, and
+// upgrades it to a WebSocket.
+func (b *Work) wsConnect() (net.Conn, *bufio.Reader, error) {
+	conn, err := b.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	if b.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(time.Duration(b.Timeout) * time.Second))
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := cloneRequest(b.Request, nil)
+	req.Method = "GET"
+	req.ContentLength = 0
+	req.Header.Del("Content-Type")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, nil, fmt.Errorf("websocket: handshake failed with status %s", res.Status)
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		conn.Close()
+		return nil, nil, errors.New("websocket: handshake failed, invalid Sec-WebSocket-Accept")
+	}
+	return conn, br, nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept value for key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsRoundTrip sends the request body as a message and returns the
+// next data message of the server. Pings are answered on the way.
+func (b *Work) wsRoundTrip(conn net.Conn, br *bufio.Reader) ([]byte, error) {
+	op := byte(wsBinary)
+	if utf8.Valid(b.RequestBody) {
+		op = wsText
+	}
+	if err := wsWriteFrame(conn, op, b.RequestBody); err != nil {
+		return nil, err
+	}
+	var msg []byte
+	for {
+		fin, op, payload, err := wsReadFrame(br)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := wsWriteFrame(conn, wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return nil, errWSClosed
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// wsWriteFrame writes a single, final frame. Frames sent by clients
+// must be masked.
+func wsWriteFrame(w io.Writer, op byte, payload []byte) error {
+	hdr := make([]byte, 2, 14)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	hdr[1] |= 0x80
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	hdr = append(hdr, mask[:]...)
+	frame := append(hdr, payload...)
+	for i := range payload {
+		frame[len(hdr)+i] ^= mask[i%4]
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// wsReadFrame reads a single frame.
+func wsReadFrame(r io.Reader) (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	masked := hdr[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	if n > maxWSMessage {
+		err = fmt.Errorf("websocket: frame of %d bytes is too large", n)
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// maxWSMessage limits the size of the frames read from the server.
+const maxWSMessage = 64 << 20
@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// parseResolve parses the values of -resolve, each "host:port:addr", into
+// a map from "host:port" to the IP address addr.
+func parseResolve(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	resolve := make(map[string]string)
+	for _, v := range values {
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("-resolve %q is not host:port:addr", v)
+		}
+		if port, err := strconv.Atoi(parts[1]); err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("-resolve %q has an invalid port", v)
+		}
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]"))
+		if ip == nil {
+			return nil, fmt.Errorf("-resolve %q has an invalid IP address", v)
+		}
+		resolve[net.JoinHostPort(parts[0], parts[1])] = ip.String()
+	}
+	return resolve, nil
+}
+
+// parseDNSChaos parses a -dns-chaos value, comma separated settings of
+// fail=<rate>, flap=<rate> and to=<ip>|<ip>..., the alternate addresses
+// flapping lookups return.
+func parseDNSChaos(s string) (*requester.DNSChaos, error) {
+	var c requester.DNSChaos
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid setting %q, want key=value", kv)
+		}
+		k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		var err error
+		switch k {
+		case "fail":
+			c.FailRate, err = parseRate(v)
+		case "flap":
+			c.FlapRate, err = parseRate(v)
+		case "to":
+			for _, a := range strings.Split(v, "|") {
+				ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(a, "["), "]"))
+				if ip == nil {
+					return nil, fmt.Errorf("invalid IP address %q", a)
+				}
+				c.Alternates = append(c.Alternates, ip.String())
+			}
+		default:
+			return nil, fmt.Errorf("unknown setting %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", k, err)
+		}
+	}
+	if c.FailRate+c.FlapRate > 1 {
+		return nil, fmt.Errorf("fail and flap add up to more than 100%%")
+	}
+	if c.FlapRate > 0 && len(c.Alternates) == 0 {
+		return nil, fmt.Errorf("flap requires the alternate addresses in to")
+	}
+	return &c, nil
+}
+
+// resolvedAddr returns the address to dial for addr, a "host:port", with
+// the host replaced by the address pinned by -resolve, if any.
+func resolvedAddr(resolve map[string]string, addr string) string {
+	if ip, ok := resolve[addr]; ok {
+		_, port, _ := net.SplitHostPort(addr)
+		return net.JoinHostPort(ip, port)
+	}
+	return addr
+}
+
+// resolvedDialer returns a DialContext function for http.Transport that
+// dials the addresses pinned by -resolve.
+func resolvedDialer(resolve map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(resolve) == 0 {
+		return nil
+	}
+	var d net.Dialer
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, network, resolvedAddr(resolve, addr))
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+	"github.com/rakyll/hey/requester/report"
+)
+
+// scenario is a set of named request groups, made in turn during a run,
+// as read from the JSON file of -scenario:
+//
+//	{"groups": [
+//	  {"name": "browse", "url": "https://shop/items", "think_time": "2s"},
+//	  {"name": "buy", "method": "POST", "url": "https://shop/cart",
+//	   "headers": {"Content-Type": "application/json"},
+//	   "body": "{\"item\": 1}"}
+//	]}
+//
+// Setup and teardown steps, with the same fields as groups, are made
+// once, in order, before and after the run and are not part of its
+// results. Worker setup steps are made by every worker before the run,
+// e.g. to log in, and may capture values from their responses for the
+// groups to use as {{setup name}}. If sequential is set, every worker
+// makes the groups in order, like a user going through a session.
+// SLOs are the thresholds of the classes of the groups, each evaluated
+// on the requests of its class alone. ServiceSLOs are the published
+// objectives of the service, which the report states the run's margin
+// against in a compliance table.
+type scenario struct {
+	Setup       []scenarioGroup        `json:"setup"`
+	WorkerSetup []scenarioGroup        `json:"worker_setup"`
+	Groups      []scenarioGroup        `json:"groups"`
+	Teardown    []scenarioGroup        `json:"teardown"`
+	Sequential  bool                   `json:"sequential"`
+	SLOs        map[string]scenarioSLO `json:"slos"`
+	ServiceSLOs []serviceSLO           `json:"service_slos"`
+
+	// classLimits and serviceSLOs are the parsed SLOs and ServiceSLOs.
+	classLimits map[string]thresholds
+	serviceSLOs []report.SLO
+}
+
+// serviceSLO is a published objective of the service: the target of
+// the metric p50, p90, p95 or p99, a duration, or error_rate, a rate,
+// over all requests or those of a class.
+type serviceSLO struct {
+	Name   string `json:"name"`
+	Class  string `json:"class"`
+	Metric string `json:"metric"`
+	Target string `json:"target"`
+}
+
+// scenarioSLO are the thresholds of a class of groups, like the
+// -fail-if-* flags: p95 and p99 are durations, e.g. 200ms, and
+// error_rate a rate, e.g. 0.1%.
+type scenarioSLO struct {
+	P95       string `json:"p95"`
+	P99       string `json:"p99"`
+	ErrorRate string `json:"error_rate"`
+}
+
+// scenarioGroup defines a request group. Method, headers and body
+// default to the values of the command line flags. Concurrency and QPS
+// give the group its own workers and rate limit per worker. Weight
+// makes the requests pick their group at random by weight.
+type scenarioGroup struct {
+	Name        string            `json:"name"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	BodyFile    string            `json:"body_file"`
+	ThinkTime   string            `json:"think_time"`
+	Concurrency int               `json:"concurrency"`
+	QPS         float64           `json:"qps"`
+	Weight      float64           `json:"weight"`
+
+	// Class is the priority class of the group, e.g. critical or
+	// best-effort, with its own SLOs.
+	Class string `json:"class"`
+
+	// Extract and CleanupURL record the resources the requests of the
+	// group create, see -cleanup-file.
+	Extract    *scenarioExtract `json:"extract"`
+	CleanupURL string           `json:"cleanup_url"`
+
+	// Metrics are the custom metrics recorded for the responses of the
+	// group.
+	Metrics []scenarioMetric `json:"metrics"`
+
+	// Capture maps names to the values a worker setup step keeps from
+	// its response.
+	Capture map[string]*scenarioExtract `json:"capture"`
+}
+
+// loadScenario reads and validates the named scenario file, which is
+// JSON or, if its name ends in .yaml or .yml, YAML.
+func loadScenario(name string) (*scenario, error) {
+	var s scenario
+	if err := decodeFile(name, &s); err != nil {
+		return nil, fmt.Errorf("scenario %v", err)
+	}
+	if len(s.Groups) == 0 {
+		return nil, fmt.Errorf("scenario %s: no groups", name)
+	}
+	for _, steps := range []struct {
+		kind string
+		list []scenarioGroup
+	}{{"setup step", s.Setup}, {"worker setup step", s.WorkerSetup}, {"group", s.Groups}, {"teardown step", s.Teardown}} {
+		for i, g := range steps.list {
+			if g.URL == "" {
+				return nil, fmt.Errorf("scenario %s: %s %d has no url", name, steps.kind, i+1)
+			}
+			if g.Body != "" && g.BodyFile != "" {
+				return nil, fmt.Errorf("scenario %s: %s %d sets both body and body_file", name, steps.kind, i+1)
+			}
+			if g.Concurrency < 0 || g.QPS < 0 || g.Weight < 0 {
+				return nil, fmt.Errorf("scenario %s: %s %d has a negative concurrency, qps or weight", name, steps.kind, i+1)
+			}
+			if e := g.Extract; e != nil && e.sources() != 1 {
+				return nil, fmt.Errorf("scenario %s: %s %d must extract from one of a header, a json field or a regex", name, steps.kind, i+1)
+			}
+			if len(g.Capture) > 0 && steps.kind != "worker setup step" && steps.kind != "group" {
+				return nil, fmt.Errorf("scenario %s: %s %d captures values, which only groups and worker setup steps can", name, steps.kind, i+1)
+			}
+			for c, e := range g.Capture {
+				if e == nil || e.sources() != 1 {
+					return nil, fmt.Errorf("scenario %s: %s %d must capture %s from one of a header, a json field or a regex", name, steps.kind, i+1, c)
+				}
+			}
+			extracts := g.captures()
+			if g.Extract != nil {
+				extracts = append(extracts, g.Extract)
+			}
+			for _, e := range extracts {
+				if err := e.compile(); err != nil {
+					return nil, fmt.Errorf("scenario %s: %s %d: %v", name, steps.kind, i+1, err)
+				}
+			}
+			for j := range g.Metrics {
+				if err := g.Metrics[j].check(); err != nil {
+					return nil, fmt.Errorf("scenario %s: %s %d: %v", name, steps.kind, i+1, err)
+				}
+			}
+			if g.Name == "" {
+				steps.list[i].Name = fmt.Sprintf("%s%d", strings.Fields(steps.kind)[0], i+1)
+			}
+		}
+	}
+	if s.weighted() && (s.isolated() || s.chained()) {
+		return nil, fmt.Errorf("scenario %s: groups with a weight cannot set concurrency or qps or capture values, or be sequential", name)
+	}
+	if err := s.parseSLOs(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %v", name, err)
+	}
+	return &s, nil
+}
+
+// parseSLOs sets the classLimits of s from its SLOs.
+func (s *scenario) parseSLOs() error {
+	classes := make(map[string]bool)
+	for _, g := range s.Groups {
+		classes[g.Class] = true
+	}
+	for class, slo := range s.SLOs {
+		if class == "" || !classes[class] {
+			return fmt.Errorf("slos of class %q, which no group has", class)
+		}
+		t := thresholds{errorRate: -1}
+		var err error
+		if slo.P95 != "" {
+			if t.p95, err = time.ParseDuration(slo.P95); err != nil {
+				return fmt.Errorf("slos of class %s: p95: %v", class, err)
+			}
+		}
+		if slo.P99 != "" {
+			if t.p99, err = time.ParseDuration(slo.P99); err != nil {
+				return fmt.Errorf("slos of class %s: p99: %v", class, err)
+			}
+		}
+		if slo.ErrorRate != "" {
+			if t.errorRate, err = parseRate(slo.ErrorRate); err != nil {
+				return fmt.Errorf("slos of class %s: error_rate: %v", class, err)
+			}
+		}
+		if s.classLimits == nil {
+			s.classLimits = make(map[string]thresholds)
+		}
+		s.classLimits[class] = t
+	}
+	for i, slo := range s.ServiceSLOs {
+		if slo.Class != "" && !classes[slo.Class] {
+			return fmt.Errorf("service slo %d of class %q, which no group has", i+1, slo.Class)
+		}
+		rs := report.SLO{Name: slo.Name, Class: slo.Class, Metric: slo.Metric}
+		if rs.Name == "" {
+			rs.Name = strings.TrimSpace(slo.Class + " " + slo.Metric)
+		}
+		switch slo.Metric {
+		case "p50", "p90", "p95", "p99":
+			d, err := time.ParseDuration(slo.Target)
+			if err != nil {
+				return fmt.Errorf("service slo %s: %v", rs.Name, err)
+			}
+			rs.Target = d.Seconds()
+		case "error_rate":
+			var err error
+			if rs.Target, err = parseRate(slo.Target); err != nil {
+				return fmt.Errorf("service slo %s: %v", rs.Name, err)
+			}
+		default:
+			return fmt.Errorf("service slo %d: unknown metric %q, want one of %s", i+1, slo.Metric, strings.Join(report.SLOMetrics, ", "))
+		}
+		s.serviceSLOs = append(s.serviceSLOs, rs)
+	}
+	return nil
+}
+
+// weighted reports whether a group of s has a weight.
+func (s *scenario) weighted() bool {
+	for _, g := range s.Groups {
+		if g.Weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// chained reports whether every worker makes the groups of s in order,
+// as s is sequential or a group captures values.
+func (s *scenario) chained() bool {
+	if s.Sequential {
+		return true
+	}
+	for _, g := range s.Groups {
+		if len(g.Capture) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// captures returns the extracts of the captures of g.
+func (g *scenarioGroup) captures() []*scenarioExtract {
+	var es []*scenarioExtract
+	for _, e := range g.Capture {
+		es = append(es, e)
+	}
+	return es
+}
+
+// isolated reports whether a group of s sets its own concurrency or
+// rate, which requires the groups to have their own workers.
+func (s *scenario) isolated() bool {
+	for _, g := range s.Groups {
+		if g.Concurrency > 0 || g.QPS > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// workers returns the number of workers the groups of s need: the sum
+// of their concurrency plus one for each group without one. all is set
+// if every group sets its concurrency.
+func (s *scenario) workers() (n int, all bool) {
+	all = true
+	for _, g := range s.Groups {
+		if g.Concurrency > 0 {
+			n += g.Concurrency
+		} else {
+			n++
+			all = false
+		}
+	}
+	return n, all
+}
+
+// extracts reports whether a group of s extracts created resources.
+func (s *scenario) extracts() bool {
+	for _, g := range s.Groups {
+		if g.Extract != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// requestGroups builds the request groups of s. base provides the
+// method, headers and host given on the command line and body the
+// request body.
+func (s *scenario) requestGroups(base *http.Request, body []byte) ([]*requester.RequestGroup, error) {
+	groups := make([]*requester.RequestGroup, 0, len(s.Groups))
+	for _, g := range s.Groups {
+		req, b, err := g.request(base, body)
+		if err != nil {
+			return nil, err
+		}
+		rg := &requester.RequestGroup{
+			Name:        g.Name,
+			Request:     req,
+			RequestBody: b,
+			C:           g.Concurrency,
+			QPS:         g.QPS,
+			Weight:      g.Weight,
+			Class:       g.Class,
+		}
+		if g.ThinkTime != "" {
+			if err := parseThinkTime(g.ThinkTime, rg); err != nil {
+				return nil, fmt.Errorf("group %s: think_time: %v", g.Name, err)
+			}
+		}
+		if len(g.Metrics) > 0 {
+			rg.Metrics = metricsFunc(g.Metrics)
+		}
+		for name, e := range g.Capture {
+			if rg.Capture == nil {
+				rg.Capture = make(map[string]func(*http.Response, []byte) (string, bool))
+			}
+			rg.Capture[name] = e.value
+		}
+		groups = append(groups, rg)
+	}
+	return groups, nil
+}
+
+// request builds the request of g and returns it with its body. base
+// provides the method, headers and host given on the command line and
+// body the request body.
+// parseThinkTime sets the think time of g from s: a duration, e.g. 2s,
+// for a fixed pause, uniform:1s-3s for pauses between 1s and 3s, or
+// exp:2s for exponentially distributed pauses with a mean of 2s.
+func parseThinkTime(s string, g *requester.RequestGroup) error {
+	dist, spec := requester.ThinkFixed, s
+	if i := strings.Index(s, ":"); i >= 0 {
+		switch s[:i] {
+		case "uniform":
+			dist = requester.ThinkUniform
+		case "exp":
+			dist = requester.ThinkExponential
+		default:
+			return fmt.Errorf("unknown distribution %q, want uniform or exp", s[:i])
+		}
+		spec = s[i+1:]
+	}
+	var lo, hi time.Duration
+	var err error
+	if dist == requester.ThinkUniform {
+		parts := strings.SplitN(spec, "-", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid uniform think time %q, want uniform:min-max", s)
+		}
+		if lo, err = time.ParseDuration(parts[0]); err != nil {
+			return err
+		}
+		if hi, err = time.ParseDuration(parts[1]); err != nil {
+			return err
+		}
+		if hi < lo {
+			return fmt.Errorf("invalid uniform think time %q, max is less than min", s)
+		}
+	} else if lo, err = time.ParseDuration(spec); err != nil {
+		return err
+	}
+	if lo < 0 {
+		return fmt.Errorf("negative think time %q", s)
+	}
+	g.ThinkTime, g.ThinkDist, g.ThinkMax = lo, dist, hi
+	return nil
+}
+
+func (g *scenarioGroup) request(base *http.Request, body []byte) (*http.Request, []byte, error) {
+	method := base.Method
+	if g.Method != "" {
+		method = canonicalMethod(g.Method)
+	}
+	req, err := http.NewRequest(method, g.URL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", g.Name, err)
+	}
+	if base.Host != base.URL.Host {
+		// Set by -host.
+		req.Host = base.Host
+	}
+	req.Header = base.Header.Clone()
+	for k, v := range g.Headers {
+		if v, err = expandSecrets(v); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", g.Name, err)
+		}
+		req.Header.Set(k, v)
+	}
+	if h := req.Header.Get("Host"); h != "" {
+		req.Host = h
+		req.Header.Del("Host")
+	}
+	b := body
+	switch {
+	case g.Body != "":
+		b = []byte(g.Body)
+	case g.BodyFile != "":
+		if b, err = ioutil.ReadFile(g.BodyFile); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", g.Name, err)
+		}
+	}
+	req.ContentLength = int64(len(b))
+	return req, b, nil
+}
+
+// runSteps makes the setup or teardown steps in order with client. It stops at the first one that fails or gets a response
+// status other than 2xx.
+func runSteps(client *http.Client, steps []scenarioGroup, base *http.Request, body []byte) error {
+	for _, g := range steps {
+		req, b, err := g.request(base, body)
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		res, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s: %v", g.Name, err)
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("%s: %s %s: %s", g.Name, req.Method, req.URL, res.Status)
+		}
+	}
+	return nil
+}
+
+// parseRatio parses a read to write ratio such as "90:10" and returns
+// it reduced, e.g. 9 and 1.
+func parseRatio(s string) (read, write int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) == 2 {
+		read, err = strconv.Atoi(parts[0])
+		if err == nil {
+			write, err = strconv.Atoi(parts[1])
+		}
+	}
+	if len(parts) != 2 || err != nil || read < 0 || write < 0 || read+write == 0 {
+		return 0, 0, fmt.Errorf("could not parse the provided ratio; input = %v", s)
+	}
+	d := gcd(read, write)
+	return read / d, write / d, nil
+}
+
+// rwGroups returns the groups of -rw-ratio: reads are GET requests
+// like req without a body, writes are req with body, made with method
+// or POST if it is GET. A group is listed as often as its share, so
+// that making the groups in turn follows the ratio.
+func rwGroups(req *http.Request, body []byte, method string, ratio string) ([]*requester.RequestGroup, error) {
+	reads, writes, err := parseRatio(ratio)
+	if err != nil {
+		return nil, err
+	}
+	read := *req
+	read.Method = "GET"
+	read.ContentLength = 0
+	read.Header = req.Header.Clone()
+	read.Header.Del("Content-Type")
+	write := *req
+	write.Method = method
+	if method == "GET" {
+		write.Method = "POST"
+	}
+	readGroup := &requester.RequestGroup{Name: "read", Request: &read}
+	writeGroup := &requester.RequestGroup{Name: "write", Request: &write, RequestBody: body}
+	groups := make([]*requester.RequestGroup, 0, reads+writes)
+	for i := 0; i < reads; i++ {
+		groups = append(groups, readGroup)
+	}
+	for i := 0; i < writes; i++ {
+		groups = append(groups, writeGroup)
+	}
+	return groups, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secretRegexp matches references to secrets in flag values. File and
+// Vault references extend up to the next white space.
+var secretRegexp = regexp.MustCompile(`@(env):([A-Za-z_][A-Za-z0-9_]*)|@(file|vault):(\S+)`)
+
+// expandSecrets replaces the secret references in s by the secrets they
+// refer to, so that credentials do not have to appear in the command
+// line:
+//
+//	@file:PATH          contents of the file, without trailing newlines
+//	@env:NAME           value of the environment variable
+//	@vault:PATH#FIELD   field of a Vault KV secret, read from VAULT_ADDR
+//	                    with VAULT_TOKEN or ~/.vault-token
+func expandSecrets(s string) (string, error) {
+	var err error
+	out := secretRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		m := secretRegexp.FindStringSubmatch(ref)
+		kind, arg := m[1]+m[3], m[2]+m[4]
+		var v string
+		switch kind {
+		case "file":
+			var b []byte
+			if b, err = ioutil.ReadFile(arg); err == nil {
+				v = strings.TrimRight(string(b), "\r\n")
+			}
+		case "env":
+			var ok bool
+			if v, ok = os.LookupEnv(arg); !ok {
+				err = fmt.Errorf("environment variable %s is not set", arg)
+			}
+		case "vault":
+			v, err = vaultSecret(arg)
+		}
+		return v
+	})
+	return out, err
+}
+
+// vaultSecret reads a field of a secret from a Vault KV secrets engine,
+// version 1 or 2. ref is of the form "PATH#FIELD", e.g.
+// "secret/data/app#token".
+func vaultSecret(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", fmt.Errorf("vault reference %q has no #field", ref)
+	}
+	path, field := ref[:i], ref[i+1:]
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			b, _ := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(b))
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN is not set")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: reading %s: %s", path, res.Status)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("vault: reading %s: %v", path, err)
+	}
+	data := secret.Data
+	// KV version 2 nests the secret in another data object.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("vault: secret %s has no field %q", path, field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serverUsage = `Usage: hey server [options...]
+
+Runs a configurable HTTP test target, to try out hey without an
+external service.
+
+Options:
+  -addr  Address to listen on. Default is :8080.
+  -latency  Time to wait before responding, e.g. 10ms. Default is 0.
+  -jitter  Maximum random time added to the latency. Default is 0.
+  -body-size  Size of the response body, e.g. 1KB. Default is 0.
+  -error-rate  Fraction of requests answered with -error-status,
+               e.g. 1% or 0.01. Default is 0.
+  -error-status  Status code of failed requests. Default is 500.
+`
+
+// testServer is the handler of "hey server".
+type testServer struct {
+	latency     time.Duration
+	jitter      time.Duration
+	body        []byte
+	errorRate   float64
+	errorStatus int
+
+	// rand returns a pseudo-random number in [0.0,1.0).
+	rand func() float64
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(ioutil.Discard, r.Body)
+	d := s.latency
+	if s.jitter > 0 {
+		d += time.Duration(s.rand() * float64(s.jitter))
+	}
+	if d > 0 {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if s.errorRate > 0 && s.rand() < s.errorRate {
+		http.Error(w, http.StatusText(s.errorStatus), s.errorStatus)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.body)))
+	w.Write(s.body)
+}
+
+// parseRate parses a fraction given as a percentage such as "1%" or as
+// a number such as "0.01".
+func parseRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	div := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, div = strings.TrimSuffix(s, "%"), 100
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 || v/div > 1 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return v / div, nil
+}
+
+// serverMain implements the "hey server" command.
+func serverMain(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, serverUsage)
+	}
+	addr := fs.String("addr", ":8080", "")
+	latency := fs.Duration("latency", 0, "")
+	jitter := fs.Duration("jitter", 0, "")
+	bodySize := fs.String("body-size", "0", "")
+	errorRate := fs.String("error-rate", "0", "")
+	errorStatus := fs.Int("error-status", http.StatusInternalServerError, "")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	size, err := parseSize(*bodySize)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	rate, err := parseRate(*errorRate)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	if *errorStatus < 100 || *errorStatus > 999 {
+		errAndExit("-error-status must be a valid HTTP status code.")
+	}
+	s := &testServer{
+		latency:     *latency,
+		jitter:      *jitter,
+		body:        bytes.Repeat([]byte("x"), int(size)),
+		errorRate:   rate,
+		errorStatus: *errorStatus,
+		rand:        rand.Float64,
+	}
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", *addr)
+	errAndExit(http.ListenAndServe(*addr, s).Error())
+}
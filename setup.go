@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// flagSetupStep returns the worker setup step of the -setup flags: a
+// request to url, a POST with body if it is set, with the given
+// "Name: value" headers and "name=header:<name>", "name=json:<path>" or
+// "name=regex:<regexp>" captures.
+func flagSetupStep(url, body string, headers, captures []string) (scenarioGroup, error) {
+	g := scenarioGroup{Name: "setup", Method: "GET", URL: url, Body: body}
+	if body != "" {
+		g.Method = "POST"
+	}
+	for _, h := range headers {
+		match, err := parseInputWithRegexp(h, headerRegexp)
+		if err != nil {
+			return g, err
+		}
+		if g.Headers == nil {
+			g.Headers = make(map[string]string)
+		}
+		g.Headers[match[1]] = match[2]
+	}
+	for _, c := range captures {
+		i := strings.Index(c, "=")
+		if i <= 0 {
+			return g, fmt.Errorf("invalid capture %q, want name=header:<name>, name=json:<path> or name=regex:<regexp>", c)
+		}
+		var e scenarioExtract
+		switch v := c[i+1:]; {
+		case strings.HasPrefix(v, "header:"):
+			e.Header = strings.TrimPrefix(v, "header:")
+		case strings.HasPrefix(v, "json:"):
+			e.JSON = strings.TrimPrefix(v, "json:")
+		case strings.HasPrefix(v, "regex:"):
+			e.Regex = strings.TrimPrefix(v, "regex:")
+		}
+		if e.sources() == 0 {
+			return g, fmt.Errorf("invalid capture %q, want name=header:<name>, name=json:<path> or name=regex:<regexp>", c)
+		}
+		if err := e.compile(); err != nil {
+			return g, fmt.Errorf("capture %s: %v", c[:i], err)
+		}
+		if g.Capture == nil {
+			g.Capture = make(map[string]*scenarioExtract)
+		}
+		g.Capture[c[:i]] = &e
+	}
+	return g, nil
+}
+
+// workerSetup builds the setup steps every worker makes from steps.
+// base provides the method, headers and host given on the command line
+// and body the request body. Setup requests are not templates, so
+// header values with placeholders are left out.
+func workerSetup(steps []scenarioGroup, base *http.Request, body []byte) ([]requester.SetupStep, error) {
+	var setup []requester.SetupStep
+	for _, g := range steps {
+		req, b, err := g.request(base, body)
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range req.Header {
+			for _, v := range vs {
+				if strings.Contains(v, "{{") {
+					req.Header.Del(k)
+					break
+				}
+			}
+		}
+		s := requester.SetupStep{Name: g.Name, Request: req, RequestBody: b}
+		for name, e := range g.Capture {
+			if s.Capture == nil {
+				s.Capture = make(map[string]func(*http.Response, []byte) (string, bool))
+			}
+			s.Capture[name] = e.value
+		}
+		setup = append(setup, s)
+	}
+	return setup, nil
+}
+
+// captureNames returns the names of the values steps capture.
+func captureNames(steps []requester.SetupStep) []string {
+	var names []string
+	for _, s := range steps {
+		for name := range s.Capture {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	gourl "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// target is a URL of the run and its share of the requests.
+type target struct {
+	url    string
+	weight int
+}
+
+// readTargets reads the targets of the named file, one per line as the
+// URL and an optional weight, e.g. "https://example.com/ 3". The weight
+// defaults to 1.
+func readTargets(name string) ([]target, error) {
+	lines, err := readLines(name)
+	if err != nil {
+		return nil, err
+	}
+	var targets []target
+	for _, l := range lines {
+		f := strings.Fields(l)
+		t := target{url: f[0], weight: 1}
+		if len(f) > 2 {
+			return nil, fmt.Errorf("%s: want a url and an optional weight; got %q", name, l)
+		}
+		if len(f) == 2 {
+			if t.weight, err = strconv.Atoi(f[1]); err != nil || t.weight < 1 {
+				return nil, fmt.Errorf("%s: weight of %s must be a positive integer", name, f[0])
+			}
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s contains no urls", name)
+	}
+	return targets, nil
+}
+
+// targetGroups returns a group for every target, with the request req
+// made to the target's URL. Results are labeled with the URL. A group is
+// listed as often as its share, so that making the groups in turn
+// follows the weights.
+func targetGroups(req *http.Request, body []byte, targets []target) ([]*requester.RequestGroup, error) {
+	d := 0
+	for _, t := range targets {
+		d = gcd(d, t.weight)
+	}
+	var groups []*requester.RequestGroup
+	for _, t := range targets {
+		u, err := gourl.Parse(t.url)
+		if err != nil {
+			return nil, err
+		}
+		r := *req
+		r.URL = u
+		if r.Host == req.URL.Host {
+			r.Host = u.Host
+		}
+		g := &requester.RequestGroup{Name: t.url, Request: &r, RequestBody: body}
+		for i := 0; i < t.weight/d; i++ {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
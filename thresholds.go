@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rakyll/hey/requester/report"
+)
+
+// exitThresholds is the exit status of a run that violated a threshold.
+const exitThresholds = 3
+
+// thresholds are the limits a run must stay within. The latencies are
+// zero and the error rate is negative if unset.
+type thresholds struct {
+	p95, p99  time.Duration
+	errorRate float64
+}
+
+// check returns a description of every threshold rep violates.
+func (t thresholds) check(rep report.Report) []string {
+	var violations []string
+	for _, p := range []struct {
+		pct   int
+		limit time.Duration
+	}{{95, t.p95}, {99, t.p99}} {
+		if p.limit <= 0 {
+			continue
+		}
+		if lat := rep.Percentile(p.pct); lat > p.limit.Seconds() {
+			violations = append(violations, fmt.Sprintf("p%d latency %4.4f secs exceeds %v (-fail-if-p%d)", p.pct, lat, p.limit, p.pct))
+		}
+	}
+	if t.errorRate >= 0 && rep.NumRes > 0 {
+		if rate := float64(rep.Failures()) / float64(rep.NumRes); rate > t.errorRate {
+			violations = append(violations, fmt.Sprintf("error rate %.2f%% exceeds %.2f%% (-fail-if-error-rate)", 100*rate, 100*t.errorRate))
+		}
+	}
+	return violations
+}
+
+// checkSLOs returns a description of every SLO of rep the run missed.
+func checkSLOs(rep report.Report) []string {
+	var violations []string
+	for _, res := range rep.SLOs {
+		switch {
+		case res.NoData:
+			violations = append(violations, fmt.Sprintf("SLO %s has no requests to measure it on", res.Name))
+		case res.Pass:
+		case res.Metric == "error_rate":
+			violations = append(violations, fmt.Sprintf("SLO %s missed: error rate %.2f%% exceeds %.2f%%", res.Name, 100*res.Actual, 100*res.Target))
+		default:
+			violations = append(violations, fmt.Sprintf("SLO %s missed: %s latency %4.4f secs exceeds %v", res.Name, res.Metric, res.Actual, time.Duration(res.Target*float64(time.Second))))
+		}
+	}
+	return violations
+}
+
+// checkClasses returns a description of every threshold in limits,
+// keyed by class, that the requests of its class in rep violate.
+func checkClasses(limits map[string]thresholds, rep report.Report) []string {
+	var violations []string
+	for _, c := range rep.Classes {
+		t, ok := limits[c.Class]
+		if !ok {
+			continue
+		}
+		for _, p := range []struct {
+			pct   int
+			lat   float64
+			limit time.Duration
+		}{{95, c.P95, t.p95}, {99, c.P99, t.p99}} {
+			if p.limit > 0 && p.lat > p.limit.Seconds() {
+				violations = append(violations, fmt.Sprintf("class %s: p%d latency %4.4f secs exceeds %v", c.Class, p.pct, p.lat, p.limit))
+			}
+		}
+		if rate := float64(c.Failures) / float64(c.Requests); t.errorRate >= 0 && rate > t.errorRate {
+			violations = append(violations, fmt.Sprintf("class %s: error rate %.2f%% exceeds %.2f%%", c.Class, 100*rate, 100*t.errorRate))
+		}
+	}
+	return violations
+}
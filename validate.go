@@ -0,0 +1,144 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rakyll/hey/requester"
+	"golang.org/x/net/http/httpguts"
+)
+
+// validateOnly is set by hey validate, which checks the options of a
+// run and prints its requests instead of making them.
+var validateOnly bool
+
+// validateRun checks the URLs and headers of the requests of a run,
+// the scenario setup and teardown steps, the worker setup steps and
+// the request groups, and writes them to out in that order. The
+// placeholders of the groups are expanded with the first row of data
+// and with <name> for the values that the setup steps and the groups
+// capture. It returns the first error.
+func validateRun(out io.Writer, scen *scenario, base *http.Request, body []byte, setup []requester.SetupStep, groups []*requester.RequestGroup, names requester.TemplateNames, data *requester.Data) error {
+	values := make(map[string]string)
+	for _, name := range append(append([]string(nil), names.Setup...), names.Captures...) {
+		values[name] = "<" + name + ">"
+	}
+	var steps, teardown []scenarioGroup
+	if scen != nil {
+		steps, teardown = scen.Setup, scen.Teardown
+	}
+	for _, g := range steps {
+		req, b, err := g.request(base, body)
+		if err != nil {
+			return fmt.Errorf("setup %v", err)
+		}
+		if err := writeRequest(out, "Setup "+g.Name, req, b); err != nil {
+			return err
+		}
+	}
+	for _, s := range setup {
+		if err := writeRequest(out, "Worker setup "+s.Name, s.Request, s.RequestBody); err != nil {
+			return err
+		}
+	}
+	for i, g := range groups {
+		title := "Group " + g.Name
+		if g.Name == "" {
+			title = "Request"
+			if len(groups) > 1 {
+				title = fmt.Sprintf("Request %d", i+1)
+			}
+		}
+		req, b, err := g.Render(names, data, values)
+		if err != nil {
+			return fmt.Errorf("%s: %v", title, err)
+		}
+		if err := writeRequest(out, title, req, b); err != nil {
+			return err
+		}
+	}
+	for _, g := range teardown {
+		req, b, err := g.request(base, body)
+		if err != nil {
+			return fmt.Errorf("teardown %v", err)
+		}
+		if err := writeRequest(out, "Teardown "+g.Name, req, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRequest checks the URL and the header of req and writes req
+// and its body to out under title.
+func writeRequest(out io.Writer, title string, req *http.Request, body []byte) error {
+	if err := checkURL(req.URL); err != nil {
+		return fmt.Errorf("%s: %v", title, err)
+	}
+	keys := make([]string, 0, len(req.Header))
+	for k, vs := range req.Header {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("%s: invalid header name %q", title, k)
+		}
+		for _, v := range vs {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return fmt.Errorf("%s: invalid value of header %s: %q", title, k, v)
+			}
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(out, "%s:\n  %s %s\n", title, req.Method, req.URL)
+	if req.Host != "" && req.Host != req.URL.Host {
+		fmt.Fprintf(out, "  Host: %s\n", req.Host)
+	}
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			fmt.Fprintf(out, "  %s: %s\n", k, v)
+		}
+	}
+	switch {
+	case len(body) > 0 && !utf8.Valid(body):
+		// e.g. a gRPC message.
+		fmt.Fprintf(out, "\n  <%d bytes>\n", len(body))
+	case len(body) > 0:
+		fmt.Fprintf(out, "\n  %s\n", strings.Replace(string(body), "\n", "\n  ", -1))
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+// checkURL returns an error unless u is an absolute URL hey can
+// make requests to.
+func checkURL(u *url.URL) error {
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+	case "":
+		return fmt.Errorf("URL %q has no scheme", u)
+	default:
+		return fmt.Errorf("URL %q has unsupported scheme %q", u, u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", u)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// decodeFile decodes the JSON file name into v. Files ending in .yaml or
+// .yml are read as YAML, see readYAML, and decoded as if they were the
+// equivalent JSON.
+func decodeFile(name string, v interface{}) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if isYAML(name) && !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		doc, err := readYAML(name, b)
+		if err != nil {
+			return err
+		}
+		if b, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// yamlLine is a line of a YAML document.
+type yamlLine struct {
+	n      int    // line number
+	indent int    // number of leading spaces
+	text   string // without the indentation and comment
+	raw    string
+}
+
+// yamlParser parses the block structure of a YAML document.
+type yamlParser struct {
+	name  string
+	lines []yamlLine
+	i     int
+}
+
+// readYAML reads the YAML document b into the values encoding/json decodes
+// JSON into: maps, slices, strings, json.Numbers, booleans and nil. It reads
+// block mappings and sequences, scalars as read by the -config reader,
+// [flow, lists], {"json": "objects"} and | literal blocks; anchors, tags
+// and multiple documents are not supported.
+func readYAML(name string, b []byte) (interface{}, error) {
+	p := &yamlParser{name: name}
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(l, "\r")
+		text := strings.TrimSpace(stripConfigComment(l))
+		if strings.HasPrefix(strings.TrimLeft(l, " "), "\t") {
+			return nil, fmt.Errorf("%s:%d: tabs are not allowed as indentation", name, i+1)
+		}
+		if text == "---" && i == 0 {
+			text = ""
+		}
+		p.lines = append(p.lines, yamlLine{n: i + 1, indent: len(l) - len(strings.TrimLeft(l, " ")), text: text, raw: l})
+	}
+	if !p.skip() {
+		return nil, nil
+	}
+	doc, err := p.block(p.lines[p.i].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skip() {
+		return nil, p.errorf("unexpected %q", p.lines[p.i].text)
+	}
+	return doc, nil
+}
+
+func (p *yamlParser) errorf(format string, v ...interface{}) error {
+	n := len(p.lines)
+	if p.i < len(p.lines) {
+		n = p.lines[p.i].n
+	}
+	return fmt.Errorf("%s:%d: %s", p.name, n, fmt.Sprintf(format, v...))
+}
+
+// skip skips blank lines and reports whether a line is left.
+func (p *yamlParser) skip() bool {
+	for p.i < len(p.lines) && p.lines[p.i].text == "" {
+		p.i++
+	}
+	return p.i < len(p.lines)
+}
+
+// block parses the mapping or sequence at the current line, which is
+// indented by indent.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	s := []interface{}{}
+	for p.skip() {
+		l := p.lines[p.i]
+		if l.indent < indent || l.indent == indent && !isYAMLItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		rest := strings.TrimSpace(l.text[1:])
+		var v interface{}
+		var err error
+		switch {
+		case rest == "":
+			// The item is the block that follows.
+			p.i++
+			if p.skip() && p.lines[p.i].indent > indent {
+				v, err = p.block(p.lines[p.i].indent)
+			}
+		case yamlKey(rest) >= 0:
+			// A mapping that starts on the line of the item.
+			col := indent + len(l.text) - len(rest)
+			p.lines[p.i].indent, p.lines[p.i].text = col, rest
+			v, err = p.mapping(col)
+		default:
+			p.i++
+			v, err = p.value(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.skip() {
+		l := p.lines[p.i]
+		if l.indent < indent || isYAMLItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		k := yamlKey(l.text)
+		if k < 0 {
+			return nil, p.errorf("want key: value; got %q", l.text)
+		}
+		key, err := parseConfigScalar(strings.TrimSpace(l.text[:k]))
+		if err != nil || key == "" {
+			return nil, p.errorf("invalid key %q", l.text[:k])
+		}
+		if _, ok := m[key]; ok {
+			return nil, p.errorf("duplicate key %s", key)
+		}
+		value := strings.TrimSpace(l.text[k+1:])
+		p.i++
+		var v interface{}
+		switch {
+		case value == "|" || value == "|-":
+			v = p.literal(indent, value == "|-")
+		case value != "":
+			v, err = p.value(value)
+		case p.skip() && (p.lines[p.i].indent > indent || p.lines[p.i].indent == indent && isYAMLItem(p.lines[p.i].text)):
+			// The value is the block that follows; a sequence may be
+			// indented as its key.
+			v, err = p.block(p.lines[p.i].indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// yamlKey returns the index of the colon that ends the key of text, or -1.
+func yamlKey(text string) int {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return i
+		case c == '[' || c == '{':
+			if i == 0 {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// literal reads the lines of a | block more indented than indent. Unless
+// strip is set, the block keeps a single final newline.
+func (p *yamlParser) literal(indent int, strip bool) string {
+	var lines []string
+	blockIndent := -1
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.lines[p.i]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if l.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = l.indent
+		}
+		if l.indent < blockIndent {
+			break
+		}
+		lines = append(lines, l.raw[blockIndent:])
+	}
+	s := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if !strip && s != "" {
+		s += "\n"
+	}
+	return s
+}
+
+// value parses the scalar, [flow, list] or JSON object of the current key
+// or item. Flow lists may span lines.
+func (p *yamlParser) value(v string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(v, "{"):
+		var m interface{}
+		d := json.NewDecoder(strings.NewReader(v))
+		d.UseNumber()
+		if err := d.Decode(&m); err != nil {
+			return nil, p.errorf("flow mappings must be JSON objects: %v", err)
+		}
+		return m, nil
+	case strings.HasPrefix(v, "["):
+		for !strings.HasSuffix(v, "]") && p.i < len(p.lines) {
+			v += " " + p.lines[p.i].text
+			p.i++
+		}
+		items, err := splitConfigList(v)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		s := make([]interface{}, len(items))
+		for i, item := range items {
+			if s[i], err = yamlScalar(item); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		}
+		return s, nil
+	}
+	s, err := yamlScalar(v)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return s, nil
+}
+
+// yamlScalar parses a quoted string, boolean, null, number or plain string.
+func yamlScalar(s string) (interface{}, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null", "~":
+		return nil, nil
+	}
+	if c := s[0]; (c == '-' || c >= '0' && c <= '9') && json.Valid([]byte(s)) {
+		return json.Number(s), nil
+	}
+	return parseConfigScalar(s)
+}